@@ -3,7 +3,6 @@ package api
 import (
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -106,33 +105,34 @@ func ArchiveDescriptionImages(versionID int, description string) (string, bool)
 	return description, changed
 }
 
-// ArchiveImages is a handler that iterates over all versions and archives their description images.
+// ArchiveImages is a handler that starts a background job archiving the
+// description images of every version.
 func ArchiveImages(c *gin.Context) {
+	startJob(c, JobTypeArchiveImages, archiveImages)
+}
+
+func archiveImages(run *JobRun) error {
 	var versions []models.Version
 	if err := database.DB.Find(&versions).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
-		return
+		return fmt.Errorf("failed to fetch versions: %w", err)
 	}
-
-	count := 0
-	updatedCount := 0
+	run.SetTotal(len(versions))
 
 	for _, v := range versions {
+		if run.Cancelled() {
+			return nil
+		}
 		newDesc, changed := ArchiveDescriptionImages(v.VersionID, v.Description)
 		if changed {
 			v.Description = newDesc
 			if err := database.DB.Save(&v).Error; err != nil {
-				log.Printf("Failed to save version %d: %v", v.VersionID, err)
-			} else {
-				updatedCount++
+				run.Errorf("Failed to save version %d: %v", v.VersionID, err)
+				continue
 			}
 		}
-		count++
+		run.Processed(changed)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":   "Archive complete",
-		"processed": count,
-		"updated":   updatedCount,
-	})
+	run.SetMessage("Archive complete")
+	return nil
 }
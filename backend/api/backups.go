@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"model-manager/backend/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobTypeBackup is the scheduled database backup.
+const JobTypeBackup = "backup"
+
+// backupSchedulerPoll is how often the scheduler compares the newest backup
+// with the configured interval.
+var backupSchedulerPoll = 10 * time.Minute
+
+var errRestoreBusy = errors.New("downloads or background jobs are running")
+
+// errRestoreWaiting refuses a restore while a request waits on a queued
+// download, whose job row the restore would replace.
+var errRestoreWaiting = errors.New("requests are waiting for queued downloads")
+
+// StartBackupScheduler takes a backup whenever the interval configured by the
+// backup_interval_hours setting has passed since the newest one in the backup
+// directory, then rotates the directory down to backup_retention files.
+func StartBackupScheduler() {
+	go func() {
+		for {
+			maybeScheduleBackup()
+			time.Sleep(backupSchedulerPoll)
+		}
+	}()
+}
+
+func maybeScheduleBackup() {
+	interval := database.GetBackupInterval()
+	if interval <= 0 {
+		return
+	}
+	backups, err := database.ListBackups(database.GetBackupPath())
+	if err != nil {
+		log.Printf("failed to list backups: %v", err)
+		return
+	}
+	if len(backups) > 0 && time.Since(backups[0].CreatedAt) < interval {
+		return
+	}
+	var running *JobRunningError
+	if _, err := Jobs.Start(JobTypeBackup, runBackup); err != nil && !errors.As(err, &running) {
+		log.Printf("failed to start scheduled backup: %v", err)
+	}
+}
+
+func runBackup(run *JobRun) error {
+	b, pruned, err := backupAndRotate()
+	if err != nil {
+		return err
+	}
+	run.SetMessage(fmt.Sprintf("Created %s, removed %d old backups", b.ID, len(pruned)))
+	return nil
+}
+
+func backupAndRotate() (database.Backup, []string, error) {
+	dir := database.GetBackupPath()
+	b, err := database.CreateBackup(database.DB, dir)
+	if err != nil {
+		return b, nil, err
+	}
+	pruned, err := database.PruneBackups(dir, database.GetBackupRetention())
+	return b, pruned, err
+}
+
+// ListBackups returns the backups in the backup directory, newest first.
+func ListBackups(c *gin.Context) {
+	backups, err := database.ListBackups(database.GetBackupPath())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list backups"})
+		return
+	}
+	c.JSON(http.StatusOK, backups)
+}
+
+// CreateBackup takes a backup now and rotates old ones like a scheduled run.
+func CreateBackup(c *gin.Context) {
+	b, _, err := backupAndRotate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, b)
+}
+
+// DownloadBackup sends the backup identified by :id as an attachment.
+func DownloadBackup(c *gin.Context) {
+	path, err := database.BackupFile(database.GetBackupPath(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return
+	}
+	c.FileAttachment(path, c.Param("id"))
+}
+
+// RestoreBackup replaces the live database with the backup identified by
+// :id. The current database is backed up first so the restore can itself be
+// undone. Restoring is refused while downloads or jobs are running, and no
+// new ones start until it finishes.
+func RestoreBackup(c *gin.Context) {
+	dir := database.GetBackupPath()
+	id := c.Param("id")
+	path, err := database.BackupFile(dir, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return
+	}
+	if err := database.ValidateBackup(path); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resume, err := pauseForRestore()
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot restore while " + err.Error()})
+		return
+	}
+	safety, err := database.CreateBackup(database.DB, dir)
+	if err != nil {
+		resume()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to back up the current database: " + err.Error()})
+		return
+	}
+	err = database.RestoreBackup(database.DB, path)
+	resume()
+	if err != nil {
+		log.Printf("restore of %s failed: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore backup: " + err.Error(), "safetyBackup": safety.ID})
+		return
+	}
+
+	// The restored tables may hold work that was in flight when the backup
+	// was taken; settle it the way a restart would.
+	if err := database.ResetAllPendingClientFiles(); err != nil {
+		log.Printf("Warning: Failed to reset pending client files after restore: %v", err)
+	}
+	if err := database.FailInterruptedJobs(); err != nil {
+		log.Printf("Warning: Failed to update interrupted jobs after restore: %v", err)
+	}
+	StartDownloadQueue()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Database restored", "restored": id, "safetyBackup": safety.ID})
+}
+
+// pauseForRestore holds the job manager and download queue so nothing starts
+// while the database is swapped. It fails if anything is already running or
+// a request is waiting for a download to finish. The returned function
+// releases them.
+func pauseForRestore() (func(), error) {
+	Jobs.mu.Lock()
+	Downloads.mu.Lock()
+	var err error
+	switch {
+	case len(Jobs.running) > 0 || len(Downloads.active) > 0:
+		err = errRestoreBusy
+	case len(Downloads.waiters) > 0:
+		err = errRestoreWaiting
+	}
+	if err != nil {
+		Downloads.mu.Unlock()
+		Jobs.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		Downloads.mu.Unlock()
+		Jobs.mu.Unlock()
+	}, nil
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	initTestDB(t)
+	dir := t.TempDir()
+	database.SetSettingValue("backup_path", dir)
+	m := models.Model{CivitID: 1, Name: "before", Weight: 1}
+	database.DB.Create(&m)
+
+	rec := tagRequest(t, CreateBackup, http.MethodPost, "/backups", nil, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", rec.Code, rec.Body.String())
+	}
+	var backup database.Backup
+	json.Unmarshal(rec.Body.Bytes(), &backup)
+
+	database.DB.Model(&m).Update("name", "after")
+	database.DB.Create(&models.Model{CivitID: 2, Name: "new", Weight: 1})
+
+	id := gin.Params{{Key: "id", Value: backup.ID}}
+	if rec := tagRequest(t, DownloadBackup, http.MethodGet, "/backups/x/download", id, nil); rec.Code != http.StatusOK || rec.Body.Len() != int(backup.Size) {
+		t.Errorf("download = %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+
+	// Nothing may be running during a restore.
+	Downloads.active[999] = &activeDownload{}
+	rec = tagRequest(t, RestoreBackup, http.MethodPost, "/backups/x/restore", id, nil)
+	delete(Downloads.active, 999)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("restore while downloading = %d", rec.Code)
+	}
+	Downloads.waiters[999] = []chan downloadResult{make(chan downloadResult, 1)}
+	rec = tagRequest(t, RestoreBackup, http.MethodPost, "/backups/x/restore", id, nil)
+	delete(Downloads.waiters, 999)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("restore while a sync waits for a download = %d", rec.Code)
+	}
+
+	rec = tagRequest(t, RestoreBackup, http.MethodPost, "/backups/x/restore", id, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore = %d %s", rec.Code, rec.Body.String())
+	}
+	var restored []models.Model
+	database.DB.Find(&restored)
+	if len(restored) != 1 || restored[0].Name != "before" {
+		t.Errorf("models after restore = %+v", restored)
+	}
+
+	var backups []database.Backup
+	json.Unmarshal(tagRequest(t, ListBackups, http.MethodGet, "/backups", nil, nil).Body.Bytes(), &backups)
+	if len(backups) != 2 || backups[1].ID != backup.ID {
+		t.Errorf("backups = %+v, want the safety copy before %s", backups, backup.ID)
+	}
+}
+
+func TestRestoreRejectsInvalidBackups(t *testing.T) {
+	initTestDB(t)
+	dir := t.TempDir()
+	database.SetSettingValue("backup_path", dir)
+	os.WriteFile(filepath.Join(dir, "models-garbage.db"), []byte("not a database"), 0o644)
+
+	for _, tc := range []struct {
+		id   string
+		code int
+	}{
+		{"models-garbage.db", http.StatusBadRequest},
+		{"models-missing.db", http.StatusNotFound},
+		{"../test.db", http.StatusNotFound},
+	} {
+		rec := tagRequest(t, RestoreBackup, http.MethodPost, "/backups/x/restore", gin.Params{{Key: "id", Value: tc.id}}, nil)
+		if rec.Code != tc.code {
+			t.Errorf("restore %s = %d, want %d", tc.id, rec.Code, tc.code)
+		}
+	}
+}
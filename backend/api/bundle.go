@@ -0,0 +1,464 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A library bundle is a zip archive holding:
+//
+//	manifest.json             BundleManifest
+//	library.json              LibraryExport, paths relative to the roots
+//	images/<path>             files under the image root
+//	thumbnails/<versionId>.webp
+//	models/<path>             model files, when requested
+//
+// Thumbnails are named by CivitAI version ID because their usual name
+// contains the local version ID, which differs between databases.
+const (
+	bundleFormat        = "model-manager-bundle"
+	bundleVersion       = 1
+	bundleManifestEntry = "manifest.json"
+	bundleLibraryEntry  = "library.json"
+)
+
+// BundleManifest describes the contents of a library bundle.
+type BundleManifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Models     int       `json:"models"`
+	Versions   int       `json:"versions"`
+	Files      int       `json:"files"`
+	ModelFiles bool      `json:"modelFiles"`
+	// Collection and Query record the filter the bundle was exported with.
+	Collection string `json:"collection,omitempty"`
+	Query      string `json:"query,omitempty"`
+}
+
+// BundleImportReport is the response of ImportBundle.
+type BundleImportReport struct {
+	ImportReport
+	Files ImportCounts `json:"files"`
+}
+
+// bundleFile is a file to add to a bundle: name inside the archive and path
+// on disk.
+type bundleFile struct {
+	name string
+	src  string
+}
+
+type bundleExporter struct {
+	imageRoot  string
+	modelRoot  string
+	modelFiles bool
+	files      []bundleFile
+	seen       map[string]bool
+}
+
+// ExportBundle streams a zip of the library with its images, thumbnails and
+// archived description images. ?files=1 adds the model files. ?collection=
+// (an ID) and ?q= (a library query) limit the bundle to matching versions;
+// an unfiltered bundle also carries the collections and settings.
+func ExportBundle(c *gin.Context) {
+	q := database.DB.Model(&models.Version{})
+	manifest := BundleManifest{Format: bundleFormat, Version: bundleVersion, CreatedAt: time.Now(), Query: c.Query("q")}
+	var scoped *models.Collection
+	if id := c.Query("collection"); id != "" {
+		var coll models.Collection
+		if err := database.DB.First(&coll, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+			return
+		}
+		var err error
+		if q, err = collectionMembers(q, coll); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		scoped = &coll
+		manifest.Collection = coll.Name
+	} else {
+		q = q.Joins("JOIN models ON models.id = versions.model_id")
+	}
+	var filter libraryFilter
+	if err := filter.addQuery(manifest.Query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var versionIDs []uint
+	if err := filter.apply(q).Pluck("versions.id", &versionIDs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to select versions"})
+		return
+	}
+
+	export, err := bundleLibrary(versionIDs, scoped, scoped == nil && filter.empty())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export library"})
+		return
+	}
+	ex := &bundleExporter{
+		imageRoot:  database.GetImagePath(),
+		modelRoot:  database.GetModelPath(),
+		modelFiles: c.Query("files") == "1",
+		seen:       make(map[string]bool),
+	}
+	ex.collect(&export)
+	manifest.Models = len(export.Models)
+	manifest.Versions = len(versionIDs)
+	manifest.Files = len(ex.files)
+	manifest.ModelFiles = ex.modelFiles
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"library-%s.zip\"", manifest.CreatedAt.Format("20060102-150405")))
+	c.Status(http.StatusOK)
+	if err := ex.write(c.Writer, manifest, export); err != nil {
+		// The status is already sent; a truncated archive fails to open.
+		log.Printf("bundle export failed: %v", err)
+	}
+}
+
+// bundleLibrary builds the export for the given versions. Collections are
+// narrowed to those versions; settings are only carried by a full bundle.
+func bundleLibrary(versionIDs []uint, scoped *models.Collection, full bool) (LibraryExport, error) {
+	export := LibraryExport{Models: []models.Model{}, Collections: []ExportedCollection{}, Settings: []ExportedSetting{}}
+	if len(versionIDs) == 0 {
+		return export, nil
+	}
+	if err := database.DB.
+		Where("id IN (?)", database.DB.Model(&models.Version{}).Select("model_id").Where("id IN ?", versionIDs)).
+		Preload("Versions", "id IN ?", versionIDs).Preload("Versions.Images").
+		Find(&export.Models).Error; err != nil {
+		return export, err
+	}
+	included := make(map[int]bool)
+	for _, m := range export.Models {
+		for _, v := range m.Versions {
+			included[v.VersionID] = true
+		}
+	}
+
+	var colls []models.Collection
+	q := database.DB.Order("name")
+	if scoped != nil {
+		q = q.Where("id = ?", scoped.ID)
+	}
+	if err := q.Find(&colls).Error; err != nil {
+		return export, err
+	}
+	for _, coll := range colls {
+		ec, err := exportCollection(coll)
+		if err != nil {
+			return export, err
+		}
+		members := ec.VersionIDs[:0]
+		for _, id := range ec.VersionIDs {
+			if included[id] {
+				members = append(members, id)
+			}
+		}
+		ec.VersionIDs = members
+		if full || ec.Filter != nil || len(members) > 0 {
+			export.Collections = append(export.Collections, ec)
+		}
+	}
+
+	if full {
+		settings, err := database.GetAllSettings()
+		if err != nil {
+			return export, err
+		}
+		for _, s := range settings {
+			if exportedSetting(s.Key) {
+				export.Settings = append(export.Settings, ExportedSetting{Key: s.Key, Value: s.Value})
+			}
+		}
+	}
+	return export, nil
+}
+
+// collect rewrites the paths in export relative to the roots and records
+// the files to add for them.
+func (ex *bundleExporter) collect(export *LibraryExport) {
+	for mi := range export.Models {
+		m := &export.Models[mi]
+		scope := fmt.Sprintf("external/m%d", m.CivitID)
+		m.ImagePath = ex.add("images", m.ImagePath, ex.imageRoot, scope, ResolveImagePath)
+		m.FilePath = ex.add("models", m.FilePath, ex.modelRoot, scope, ResolveModelPath)
+		for vi := range m.Versions {
+			v := &m.Versions[vi]
+			scope := fmt.Sprintf("external/v%d", v.VersionID)
+			v.ImagePath = ex.add("images", v.ImagePath, ex.imageRoot, scope, ResolveImagePath)
+			v.FilePath = ex.add("models", v.FilePath, ex.modelRoot, scope, ResolveModelPath)
+			for ii := range v.Images {
+				v.Images[ii].Path = ex.add("images", v.Images[ii].Path, ex.imageRoot, scope, ResolveImagePath)
+			}
+
+			thumb := ResolveImagePath(filepath.Join("thumbnails", fmt.Sprintf("v_%d.webp", v.ID)))
+			if info, err := os.Stat(thumb); err == nil && !info.IsDir() {
+				ex.files = append(ex.files, bundleFile{fmt.Sprintf("thumbnails/%d.webp", v.VersionID), thumb})
+			}
+			archive := ResolveImagePath(filepath.Join("archives", strconv.Itoa(v.VersionID)))
+			filepath.WalkDir(archive, func(p string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+				rel, _ := filepath.Rel(archive, p)
+				ex.addFile(path.Join("images/archives", strconv.Itoa(v.VersionID), filepath.ToSlash(rel)), p)
+				return nil
+			})
+		}
+	}
+}
+
+// add returns p relative to root, the form stored in the bundle, and records
+// the file under dir. Paths outside root are moved under scope. Model files
+// are only added when the bundle includes them, but their paths are
+// rewritten either way.
+func (ex *bundleExporter) add(dir, p, root, scope string, resolve func(string) string) string {
+	if p == "" {
+		return ""
+	}
+	rel := strings.ReplaceAll(p, "\\", "/")
+	if isAbsolutePath(rel) {
+		rel = MakeRelativePath(p, root)
+	}
+	if isAbsolutePath(rel) || !filepath.IsLocal(filepath.FromSlash(rel)) {
+		rel = path.Join(scope, path.Base(strings.ReplaceAll(p, "\\", "/")))
+	}
+	rel = path.Clean(rel)
+	if dir == "models" && !ex.modelFiles {
+		return rel
+	}
+	src := resolve(p)
+	if info, err := os.Stat(src); err == nil && !info.IsDir() {
+		ex.addFile(dir+"/"+rel, src)
+	}
+	return rel
+}
+
+func (ex *bundleExporter) addFile(name, src string) {
+	if !ex.seen[name] {
+		ex.seen[name] = true
+		ex.files = append(ex.files, bundleFile{name, src})
+	}
+}
+
+func (ex *bundleExporter) write(w io.Writer, manifest BundleManifest, export LibraryExport) error {
+	zw := zip.NewWriter(w)
+	for _, doc := range []struct {
+		name string
+		v    interface{}
+	}{{bundleManifestEntry, manifest}, {bundleLibraryEntry, export}} {
+		f, err := zw.Create(doc.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc.v); err != nil {
+			return err
+		}
+	}
+	for _, bf := range ex.files {
+		if err := addZipFile(zw, bf); err != nil {
+			log.Printf("bundle export: skipping %s: %v", bf.src, err)
+		}
+	}
+	return zw.Close()
+}
+
+// addZipFile stores a file without compression; images and model weights do
+// not compress, and storing keeps the export fast.
+func addZipFile(zw *zip.Writer, bf bundleFile) error {
+	src, err := os.Open(bf.src)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = bf.name
+	hdr.Method = zip.Store
+	dst, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// ImportBundle reads a bundle uploaded as the form field "file". The library
+// is merged like ImportDatabase, with the same ?strategy= and ?dryRun=1, and
+// the files are restored into the configured image and model roots. Existing
+// files are kept unless the strategy is overwrite.
+func ImportBundle(c *gin.Context) {
+	strategy, ok := importStrategy(c)
+	if !ok {
+		return
+	}
+	dryRun := c.Query("dryRun") == "1"
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+		return
+	}
+	defer file.Close()
+	zr, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a zip archive"})
+		return
+	}
+
+	var manifest BundleManifest
+	if err := readZipJSON(zr, bundleManifestEntry, &manifest); err != nil || manifest.Format != bundleFormat {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a library bundle"})
+		return
+	}
+	if manifest.Version > bundleVersion {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("bundle version %d is newer than this server supports", manifest.Version)})
+		return
+	}
+	var export LibraryExport
+	if err := readZipJSON(zr, bundleLibraryEntry, &export); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid library.json: " + err.Error()})
+		return
+	}
+
+	imported, err := importLibrary(export, strategy, dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "bundle import failed: " + err.Error()})
+		return
+	}
+	report := BundleImportReport{ImportReport: imported}
+	restoreBundleFiles(zr, strategy, dryRun, &report)
+	if !dryRun {
+		report.Message = "bundle import complete"
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func readZipJSON(zr *zip.Reader, name string, v interface{}) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+// bundleDestination maps an archive entry to the path it is restored to.
+func bundleDestination(name string) (string, error) {
+	dir, rel, _ := strings.Cut(name, "/")
+	local := filepath.FromSlash(rel)
+	if rel == "" || !filepath.IsLocal(local) {
+		return "", fmt.Errorf("unsafe path %q", name)
+	}
+	switch dir {
+	case "images":
+		return filepath.Join(database.GetImagePath(), local), nil
+	case "models":
+		return filepath.Join(database.GetModelPath(), local), nil
+	case "thumbnails":
+		civitID, err := strconv.Atoi(strings.TrimSuffix(rel, ".webp"))
+		if err != nil {
+			return "", fmt.Errorf("unexpected thumbnail %q", name)
+		}
+		var v models.Version
+		if err := database.DB.Where("version_id = ?", civitID).First(&v).Error; err != nil {
+			return "", nil
+		}
+		return ResolveImagePath(filepath.Join("thumbnails", fmt.Sprintf("v_%d.webp", v.ID))), nil
+	}
+	return "", fmt.Errorf("unexpected entry %q", name)
+}
+
+func restoreBundleFiles(zr *zip.Reader, strategy string, dryRun bool, report *BundleImportReport) {
+	for _, f := range zr.File {
+		if f.Name == bundleManifestEntry || f.Name == bundleLibraryEntry || f.FileInfo().IsDir() {
+			continue
+		}
+		dest, err := bundleDestination(f.Name)
+		if err == nil && dest == "" {
+			// A thumbnail of a version that was not imported.
+			report.Files.Skipped++
+			continue
+		}
+		if err == nil {
+			var existed bool
+			existed, err = restoreBundleFile(f, dest, strategy, dryRun)
+			switch {
+			case err != nil:
+			case existed && strategy != ImportOverwrite:
+				report.Files.Skipped++
+			case existed:
+				report.Files.Updated++
+			default:
+				report.Files.Created++
+			}
+		}
+		if err != nil {
+			report.Files.Failed++
+			if len(report.Errors) < maxImportErrors {
+				report.Errors = append(report.Errors, fmt.Sprintf("file %s: %v", f.Name, err))
+			}
+		}
+	}
+}
+
+// restoreBundleFile writes f to dest through a temporary file, unless dest
+// exists and the strategy keeps existing files. It reports whether dest
+// existed.
+func restoreBundleFile(f *zip.File, dest, strategy string, dryRun bool) (bool, error) {
+	_, err := os.Stat(dest)
+	existed := err == nil
+	if dryRun || (existed && strategy != ImportOverwrite) {
+		return existed, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return existed, err
+	}
+	src, err := f.Open()
+	if err != nil {
+		return existed, err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".bundle-*")
+	if err != nil {
+		return existed, err
+	}
+	_, err = io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return existed, err
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func importBundle(t *testing.T, query string, data []byte) BundleImportReport {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = uploadRequest(t, "/bundle/import"+query, data)
+	ImportBundle(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import = %d %s", w.Code, w.Body.String())
+	}
+	var report BundleImportReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	return report
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	initTestDB(t)
+	imageRoot, modelRoot := t.TempDir(), t.TempDir()
+	database.SetSettingValue("image_path", imageRoot)
+	database.SetSettingValue("model_path", modelRoot)
+
+	m := models.Model{CivitID: 1, Name: "M", Weight: 1}
+	database.DB.Create(&m)
+	// One absolute path left over from before MigratePaths.
+	v := models.Version{ModelID: m.ID, VersionID: 101, Name: "v1", ImagePath: "LORA/a.png",
+		FilePath: filepath.Join(modelRoot, "LORA", "a.safetensors")}
+	database.DB.Create(&v)
+	database.DB.Create(&models.VersionImage{VersionID: v.ID, Path: "LORA/a.png"})
+	other := models.Version{ModelID: m.ID, VersionID: 102, Name: "v2", ImagePath: "LORA/b.png"}
+	database.DB.Create(&other)
+	coll := models.Collection{Name: "Favs", Versions: []models.Version{v}}
+	database.DB.Create(&coll)
+	writeTestFile(t, filepath.Join(imageRoot, "LORA", "a.png"), "image")
+	writeTestFile(t, filepath.Join(imageRoot, "LORA", "b.png"), "other image")
+	writeTestFile(t, filepath.Join(imageRoot, "thumbnails", fmt.Sprintf("v_%d.webp", v.ID)), "thumb")
+	writeTestFile(t, filepath.Join(imageRoot, "archives", "101", "desc.jpg"), "archived")
+	writeTestFile(t, filepath.Join(modelRoot, "LORA", "a.safetensors"), "weights")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/bundle/export?files=1&collection="+strconv.Itoa(int(coll.ID)), nil)
+	ExportBundle(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export = %d %s", w.Code, w.Body.String())
+	}
+	bundle := w.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := []string{"images/LORA/a.png", "images/archives/101/desc.jpg", "library.json", "manifest.json",
+		"models/LORA/a.safetensors", "thumbnails/101.webp"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("entries = %v, want %v", names, want)
+	}
+
+	// Import into a fresh library with different roots.
+	initTestDB(t)
+	newImages, newModels := t.TempDir(), t.TempDir()
+	database.SetSettingValue("image_path", newImages)
+	database.SetSettingValue("model_path", newModels)
+
+	report := importBundle(t, "", bundle)
+	if report.Versions.Created != 1 || report.Collections.Created != 1 || report.Files.Created != 4 {
+		t.Fatalf("report = %+v", report)
+	}
+	var got models.Version
+	if err := database.DB.Where("version_id = ?", 101).First(&got).Error; err != nil {
+		t.Fatalf("version not imported: %v", err)
+	}
+	if got.FilePath != "LORA/a.safetensors" || got.ImagePath != "LORA/a.png" {
+		t.Errorf("paths = %q, %q", got.FilePath, got.ImagePath)
+	}
+	for path, content := range map[string]string{
+		ResolveModelPath(got.FilePath):                                           "weights",
+		ResolveImagePath(got.ImagePath):                                          "image",
+		filepath.Join(newImages, "thumbnails", fmt.Sprintf("v_%d.webp", got.ID)): "thumb",
+		filepath.Join(newImages, "archives", "101", "desc.jpg"):                  "archived",
+	} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v", path, data, err)
+		}
+	}
+	if err := database.DB.Where("version_id = ?", 102).First(&models.Version{}).Error; err == nil {
+		t.Error("a version outside the collection was imported")
+	}
+
+	report = importBundle(t, "", bundle)
+	if report.Versions.Skipped != 1 || report.Files.Skipped != 4 || report.Files.Created != 0 {
+		t.Errorf("second import = %+v", report)
+	}
+}
+
+func TestBundleImportRejectsUnsafePaths(t *testing.T) {
+	initTestDB(t)
+	imageRoot := t.TempDir()
+	database.SetSettingValue("image_path", imageRoot)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		bundleManifestEntry:     `{"format": "model-manager-bundle", "version": 1}`,
+		bundleLibraryEntry:      `{"models": []}`,
+		"images/../../evil.txt": "evil",
+		"images/ok.txt":         "ok",
+	} {
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	zw.Close()
+
+	report := importBundle(t, "", buf.Bytes())
+	if report.Files.Failed != 1 || report.Files.Created != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(filepath.Dir(imageRoot)), "evil.txt")); err == nil {
+		t.Error("entry escaped the image root")
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = uploadRequest(t, "/bundle/import", []byte("not a zip"))
+	ImportBundle(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("non-zip upload = %d", w.Code)
+	}
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// civitPageSize is the largest page the /models endpoint accepts.
+	civitPageSize = 100
+	// defaultSyncMaxItems caps an unfiltered sync, matching the single page
+	// the sync used to import.
+	defaultSyncMaxItems = 100
+)
+
+// civitCursor accepts the nextCursor metadata field as either a JSON string or
+// number.
+type civitCursor string
+
+func (c *civitCursor) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*c = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*c = civitCursor(s)
+		return nil
+	}
+	*c = civitCursor(data)
+	return nil
+}
+
+// SyncFilters narrows a catalog sync. Empty fields are not sent to CivitAI.
+type SyncFilters struct {
+	Types      []string `json:"types"`
+	BaseModels []string `json:"baseModels"`
+	Username   string   `json:"username"`
+	Tag        string   `json:"tag"`
+	Query      string   `json:"query"`
+	NSFW       *bool    `json:"nsfw"`
+	Sort       string   `json:"sort"`
+	// MaxItems stops the sync after this many models. Zero means
+	// defaultSyncMaxItems unless All is set.
+	MaxItems int `json:"maxItems"`
+	// All lifts the default cap so every matching model is synced.
+	All bool `json:"all"`
+}
+
+// limit returns the effective item cap, or 0 for none.
+func (f SyncFilters) limit() int {
+	if f.MaxItems > 0 {
+		return f.MaxItems
+	}
+	if f.All {
+		return 0
+	}
+	return defaultSyncMaxItems
+}
+
+// values encodes the filters as /models query parameters.
+func (f SyncFilters) values() url.Values {
+	q := url.Values{}
+	pageSize := civitPageSize
+	if n := f.limit(); n > 0 && n < pageSize {
+		pageSize = n
+	}
+	q.Set("limit", strconv.Itoa(pageSize))
+	for _, t := range f.Types {
+		q.Add("types", t)
+	}
+	for _, b := range f.BaseModels {
+		q.Add("baseModels", b)
+	}
+	if f.Username != "" {
+		q.Set("username", f.Username)
+	}
+	if f.Tag != "" {
+		q.Set("tag", f.Tag)
+	}
+	if f.Query != "" {
+		q.Set("query", f.Query)
+	}
+	if f.NSFW != nil {
+		q.Set("nsfw", strconv.FormatBool(*f.NSFW))
+	}
+	if f.Sort != "" {
+		q.Set("sort", f.Sort)
+	}
+	return q
+}
+
+// pageMarker prefixes the page number of a page-numbered next page, so it
+// can travel through EachModelPage like a cursor.
+const pageMarker = "page:"
+
+// errStopPaging can be returned by a page callback to end iteration early
+// without reporting an error.
+var errStopPaging = errors.New("stop paging")
+
+// ListModels fetches one page of the model catalog. An empty cursor requests
+// the first page; a cursor starting with pageMarker requests a page number.
+func (c *CivitClient) ListModels(ctx context.Context, filters SyncFilters, cursor string) (CivitModelPage, error) {
+	q := filters.values()
+	if n, ok := strings.CutPrefix(cursor, pageMarker); ok {
+		q.Set("page", n)
+	} else if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	var page CivitModelPage
+	err := c.getJSON(ctx, "/models", q, &page)
+	return page, err
+}
+
+// EachModelPage walks the catalog page by page, calling fn with each page's
+// models until the catalog or filters.MaxItems is exhausted. The final page
+// is trimmed so fn never sees more than the cap in total.
+func (c *CivitClient) EachModelPage(ctx context.Context, filters SyncFilters, fn func(page CivitModelPage) error) error {
+	limit := filters.limit()
+	seen := 0
+	cursor := ""
+	visited := map[string]bool{}
+	for {
+		page, err := c.ListModels(ctx, filters, cursor)
+		if err != nil {
+			return err
+		}
+		if len(page.Items) == 0 {
+			return nil
+		}
+		if limit > 0 && seen+len(page.Items) > limit {
+			page.Items = page.Items[:limit-seen]
+		}
+		seen += len(page.Items)
+		if err := fn(page); err != nil {
+			if errors.Is(err, errStopPaging) {
+				return nil
+			}
+			return err
+		}
+		if limit > 0 && seen >= limit {
+			return nil
+		}
+
+		next := nextCursor(page)
+		if next == "" || visited[next] {
+			return nil
+		}
+		visited[next] = true
+		cursor = next
+	}
+}
+
+// nextCursor returns the cursor for the page after page, falling back to the
+// cursor or page number embedded in metadata.nextPage. A page number is
+// returned with pageMarker in front.
+func nextCursor(page CivitModelPage) string {
+	if page.Metadata.NextCursor != "" {
+		return string(page.Metadata.NextCursor)
+	}
+	if page.Metadata.NextPage == "" {
+		return ""
+	}
+	u, err := url.Parse(page.Metadata.NextPage)
+	if err != nil {
+		return ""
+	}
+	if cur := u.Query().Get("cursor"); cur != "" {
+		return cur
+	}
+	if n := u.Query().Get("page"); n != "" {
+		return pageMarker + n
+	}
+	log.Printf("Catalog paging stopped: no cursor or page in nextPage %q", page.Metadata.NextPage)
+	return ""
+}
+
+// FetchCivitModels returns every catalog model matching filters, up to the
+// filters' item cap.
+func FetchCivitModels(apiKey string, filters SyncFilters) ([]CivitModel, error) {
+	var models []CivitModel
+	err := Civit.WithAPIKey(apiKey).EachModelPage(context.Background(), filters, func(page CivitModelPage) error {
+		models = append(models, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return models, fmt.Errorf("failed to fetch model list: %w", err)
+	}
+	return models, nil
+}
+
+// describe summarises the filters for job messages.
+func (f SyncFilters) describe() string {
+	var parts []string
+	if len(f.Types) > 0 {
+		parts = append(parts, "types="+strings.Join(f.Types, ","))
+	}
+	if len(f.BaseModels) > 0 {
+		parts = append(parts, "baseModels="+strings.Join(f.BaseModels, ","))
+	}
+	if f.Username != "" {
+		parts = append(parts, "username="+f.Username)
+	}
+	if f.Tag != "" {
+		parts = append(parts, "tag="+f.Tag)
+	}
+	if f.Query != "" {
+		parts = append(parts, "query="+f.Query)
+	}
+	if f.NSFW != nil {
+		parts = append(parts, "nsfw="+strconv.FormatBool(*f.NSFW))
+	}
+	if len(parts) == 0 {
+		return "all models"
+	}
+	return strings.Join(parts, " ")
+}
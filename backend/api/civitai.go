@@ -1,79 +1,320 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// FetchCivitModels calls the CivitAI REST API using the provided apiKey and
-// retrieves a paginated list of models. The function issues an outbound HTTP
-// request, unmarshals the JSON response, and returns the parsed models or an
-// error. Network I/O is the primary side effect.
-func FetchCivitModels(apiKey string) ([]CivitModel, error) {
-	var models []CivitModel
-	url := "https://civitai.com/api/v1/models?limit=100"
+const (
+	defaultCivitBaseURL = "https://civitai.com/api/v1"
+	civitRequestTimeout = 30 * time.Second
+	civitMaxRetries     = 3
+	civitBaseBackoff    = time.Second
+	civitMaxBackoff     = time.Minute
+	// civitRequestsPerSecond and civitBurst size the shared token bucket.
+	civitRequestsPerSecond = 2
+	civitBurst             = 5
+)
 
-	log.Printf("GET %s", url)
+// Errors wrapped by CivitError so callers can branch with errors.Is.
+var (
+	ErrCivitNotFound     = errors.New("civitai: not found")
+	ErrCivitUnauthorized = errors.New("civitai: unauthorized")
+	ErrCivitRateLimited  = errors.New("civitai: rate limited")
+	ErrCivitEarlyAccess  = errors.New("civitai: early access")
+)
 
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("Authorization", "Bearer "+apiKey)
+// CivitError describes a non-2xx response from the CivitAI API.
+type CivitError struct {
+	StatusCode int
+	URL        string
+	Message    string
+	// RetryAfter is the server's requested delay for rate limited responses.
+	RetryAfter time.Duration
+	kind       error
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		return models, fmt.Errorf("failed to fetch model list")
+func (e *CivitError) Error() string {
+	msg := fmt.Sprintf("civitai: GET %s: status %d", e.URL, e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	err = json.Unmarshal(body, &models)
-	return models, err
+	return msg
 }
 
-// FetchCivitModel retrieves details for a specific CivitAI model identified by
-// modelID. The apiKey is injected as a bearer token. A single HTTP request is
-// executed and the JSON body is unmarshaled into a CivitModel structure.
-func FetchCivitModel(apiKey string, modelID int) (CivitModel, error) {
-	var model CivitModel
-	url := fmt.Sprintf("https://civitai.com/api/v1/models/%d", modelID)
+func (e *CivitError) Unwrap() error { return e.kind }
+
+// CivitClient talks to the CivitAI REST API. Requests share a token bucket so
+// concurrent syncs stay under the API's rate limit, and 429/5xx responses are
+// retried with exponential backoff honouring Retry-After.
+type CivitClient struct {
+	// BaseURL is the API root, e.g. https://civitai.com/api/v1.
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	MaxRetries int
+	// BaseBackoff is the delay before the first retry; it doubles each time.
+	BaseBackoff time.Duration
 
-	log.Printf("GET %s", url)
+	limiter *tokenBucket
+}
 
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("Authorization", "Bearer "+apiKey)
+// Civit is the shared client. main replaces it when CIVITAI_BASE_URL is set.
+var Civit = NewCivitClient("")
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		return model, fmt.Errorf("failed to fetch model %d", modelID)
+// NewCivitClient returns a client for baseURL, or the public API when empty.
+func NewCivitClient(baseURL string) *CivitClient {
+	if baseURL == "" {
+		baseURL = defaultCivitBaseURL
 	}
-	defer resp.Body.Close()
+	return &CivitClient{
+		BaseURL:     strings.TrimRight(baseURL, "/"),
+		HTTPClient:  &http.Client{Timeout: civitRequestTimeout},
+		MaxRetries:  civitMaxRetries,
+		BaseBackoff: civitBaseBackoff,
+		limiter:     newTokenBucket(civitRequestsPerSecond, civitBurst),
+	}
+}
 
-	body, _ := io.ReadAll(resp.Body)
-	err = json.Unmarshal(body, &model)
+// WithAPIKey returns a copy of the client that authenticates with key. The
+// copy shares the rate limiter with the original.
+func (c *CivitClient) WithAPIKey(key string) *CivitClient {
+	cp := *c
+	cp.APIKey = key
+	return &cp
+}
+
+// GetModel fetches a single model with its version summaries.
+func (c *CivitClient) GetModel(ctx context.Context, modelID int) (CivitModel, error) {
+	var model CivitModel
+	err := c.getJSON(ctx, fmt.Sprintf("/models/%d", modelID), nil, &model)
 	return model, err
 }
 
-// FetchModelVersion fetches metadata for a specific model version from CivitAI
-// using the supplied apiKey and versionID. It performs an HTTP GET request and
-// decodes the JSON response into a VersionResponse value.
-func FetchModelVersion(apiKey string, versionID int) (VersionResponse, error) {
+// GetModelVersion fetches the full payload of a model version.
+func (c *CivitClient) GetModelVersion(ctx context.Context, versionID int) (VersionResponse, error) {
 	var version VersionResponse
-	url := fmt.Sprintf("https://civitai.com/api/v1/model-versions/%d", versionID)
+	err := c.getJSON(ctx, fmt.Sprintf("/model-versions/%d", versionID), nil, &version)
+	return version, err
+}
+
+// GetModelVersionByHash looks up the model version that owns a file with the
+// given hash. CivitAI accepts SHA256 as well as its shorter AutoV hashes.
+func (c *CivitClient) GetModelVersionByHash(ctx context.Context, hash string) (VersionResponse, error) {
+	var version VersionResponse
+	err := c.getJSON(ctx, "/model-versions/by-hash/"+url.PathEscape(hash), nil, &version)
+	return version, err
+}
+
+// getJSON performs a GET against path (relative to BaseURL) and decodes the
+// JSON response into out.
+func (c *CivitClient) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	body, err := c.get(ctx, u)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("civitai: decode %s: %w", u, err)
+	}
+	return nil
+}
 
-	log.Printf("GET %s", url)
+// get fetches u, retrying network errors, 429 and 5xx responses.
+func (c *CivitClient) get(ctx context.Context, u string) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		log.Printf("GET %s", u)
+		body, retryAfter, err := c.do(ctx, u)
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+		if !retryable(err) || attempt >= c.MaxRetries || ctx.Err() != nil {
+			return nil, lastErr
+		}
 
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("Authorization", "Bearer "+apiKey)
+		delay := c.BaseBackoff * time.Duration(math.Pow(2, float64(attempt)))
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if delay > civitMaxBackoff {
+			delay = civitMaxBackoff
+		}
+		log.Printf("civitai: retrying %s in %s: %v", u, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		return version, fmt.Errorf("failed to fetch version %d", versionID)
+func (c *CivitClient) do(ctx context.Context, u string) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-	err = json.Unmarshal(body, &version)
-	return version, err
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("civitai: read %s: %w", u, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return body, 0, nil
+	}
+
+	apiErr := &CivitError{StatusCode: resp.StatusCode, URL: u, Message: civitErrorMessage(body)}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		apiErr.kind = ErrCivitNotFound
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.kind = ErrCivitRateLimited
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		apiErr.kind = ErrCivitUnauthorized
+		if strings.Contains(strings.ToLower(apiErr.Message), "early access") {
+			apiErr.kind = ErrCivitEarlyAccess
+		}
+	}
+	return nil, apiErr.RetryAfter, apiErr
+}
+
+// retryable reports whether err is worth another attempt: transport errors,
+// rate limiting and server errors.
+func retryable(err error) bool {
+	var apiErr *CivitError
+	if !errors.As(err, &apiErr) {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+}
+
+// civitErrorMessage extracts the "error" or "message" field from an API error
+// body, falling back to a trimmed copy of the raw text.
+func civitErrorMessage(body []byte) string {
+	var payload struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		if payload.Error != "" {
+			return payload.Error
+		}
+		if payload.Message != "" {
+			return payload.Message
+		}
+	}
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+	return msg
+}
+
+// parseRetryAfter accepts either delay-seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// civitErrorStatus maps a CivitAI client error to the HTTP status and message
+// returned to the browser.
+func civitErrorStatus(err error, fallback string) (int, string) {
+	switch {
+	case errors.Is(err, ErrCivitNotFound):
+		return http.StatusNotFound, "Not found on CivitAI"
+	case errors.Is(err, ErrCivitEarlyAccess):
+		return http.StatusForbidden, "Early access on CivitAI"
+	case errors.Is(err, ErrCivitUnauthorized):
+		return http.StatusUnauthorized, "CivitAI rejected the API key"
+	case errors.Is(err, ErrCivitRateLimited):
+		return http.StatusTooManyRequests, "CivitAI rate limit reached, try again later"
+	}
+	return http.StatusInternalServerError, fallback
+}
+
+// tokenBucket is a small token-bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	burst  float64
+	rate   float64 // tokens per second
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{tokens: burst, burst: burst, rate: rate, last: time.Now()}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// FetchCivitModel retrieves details for a specific CivitAI model identified by
+// modelID using the shared client.
+func FetchCivitModel(apiKey string, modelID int) (CivitModel, error) {
+	return Civit.WithAPIKey(apiKey).GetModel(context.Background(), modelID)
+}
+
+// FetchModelVersion fetches metadata for a specific model version from CivitAI
+// using the shared client.
+func FetchModelVersion(apiKey string, versionID int) (VersionResponse, error) {
+	return Civit.WithAPIKey(apiKey).GetModelVersion(context.Background(), versionID)
 }
@@ -1,63 +1,138 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-type roundTripFunc func(*http.Request) (*http.Response, error)
-
-func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
-	return f(r)
-}
-
-func redirectToServer(srv *httptest.Server) func() {
-	u, _ := url.Parse(srv.URL)
-	orig := http.DefaultClient.Transport
-	http.DefaultClient.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
-		req.URL.Scheme = u.Scheme
-		req.URL.Host = u.Host
-		return http.DefaultTransport.RoundTrip(req)
-	})
-	return func() { http.DefaultClient.Transport = orig }
+// useCivitServer points the shared client at srv for the duration of the test
+// with retries that back off for only a millisecond.
+func useCivitServer(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	orig := Civit
+	Civit = NewCivitClient(srv.URL + "/api/v1")
+	Civit.BaseBackoff = time.Millisecond
+	t.Cleanup(func() { Civit = orig })
 }
 
 func TestFetchCivitModels(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
-		models := []CivitModel{{ID: 1, Name: "foo"}}
 		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.URL.Path != "/api/v1/models" {
 				t.Fatalf("unexpected path: %s", r.URL.Path)
 			}
-			if err := json.NewEncoder(w).Encode(models); err != nil {
-				t.Fatalf("encode: %v", err)
-			}
+			w.Write([]byte(`{"items":[{"id":1,"name":"foo"}],"metadata":{}}`))
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
-		got, err := FetchCivitModels("token")
+		got, err := FetchCivitModels("token", SyncFilters{})
 		if err != nil {
 			t.Fatalf("FetchCivitModels: %v", err)
 		}
-		if len(got) != 1 || got[0].ID != models[0].ID || got[0].Name != models[0].Name {
+		if len(got) != 1 || got[0].ID != 1 || got[0].Name != "foo" {
 			t.Fatalf("unexpected result: %+v", got)
 		}
 	})
 
+	t.Run("follows cursor with filters", func(t *testing.T) {
+		pages := map[string]string{
+			"":    `{"items":[{"id":1},{"id":2}],"metadata":{"nextCursor":"c2"}}`,
+			"c2":  `{"items":[{"id":3},{"id":4}],"metadata":{"nextPage":"https://civitai.com/api/v1/models?cursor=c3"}}`,
+			"c3":  `{"items":[{"id":5}],"metadata":{"nextCursor":123}}`,
+			"123": `{"items":[],"metadata":{}}`,
+		}
+		var cursors []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("username") != "artist" || q.Get("nsfw") != "false" ||
+				strings.Join(q["types"], ",") != "LORA" || strings.Join(q["baseModels"], ",") != "Illustrious" {
+				t.Errorf("query = %s", r.URL.RawQuery)
+			}
+			cursors = append(cursors, q.Get("cursor"))
+			w.Write([]byte(pages[q.Get("cursor")]))
+		}))
+		defer srv.Close()
+		useCivitServer(t, srv)
+
+		nsfw := false
+		got, err := FetchCivitModels("token", SyncFilters{
+			Types: []string{"LORA"}, BaseModels: []string{"Illustrious"}, Username: "artist", NSFW: &nsfw,
+		})
+		if err != nil {
+			t.Fatalf("FetchCivitModels: %v", err)
+		}
+		if len(got) != 5 {
+			t.Errorf("got %d models, want 5", len(got))
+		}
+		if strings.Join(cursors, "|") != "|c2|c3|123" {
+			t.Errorf("cursors = %q", cursors)
+		}
+	})
+
+	t.Run("max items", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			if r.URL.Query().Get("limit") != "3" {
+				t.Errorf("limit = %s, want 3", r.URL.Query().Get("limit"))
+			}
+			w.Write([]byte(`{"items":[{"id":1},{"id":2},{"id":3},{"id":4}],"metadata":{"nextCursor":"more"}}`))
+		}))
+		defer srv.Close()
+		useCivitServer(t, srv)
+
+		got, err := FetchCivitModels("token", SyncFilters{Tag: "anime", MaxItems: 3})
+		if err != nil {
+			t.Fatalf("FetchCivitModels: %v", err)
+		}
+		if len(got) != 3 || calls.Load() != 1 {
+			t.Errorf("got %d models in %d calls, want 3 in 1", len(got), calls.Load())
+		}
+	})
+
+	t.Run("follows page numbers", func(t *testing.T) {
+		pages := map[string]string{
+			"":  `{"items":[{"id":1}],"metadata":{"nextPage":"https://civitai.com/api/v1/models?limit=100&page=2"}}`,
+			"2": `{"items":[{"id":2}],"metadata":{"nextPage":"https://civitai.com/api/v1/models?limit=100&page=3"}}`,
+			"3": `{"items":[{"id":3}],"metadata":{}}`,
+		}
+		var requested []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("cursor") != "" {
+				t.Errorf("unexpected cursor %q", q.Get("cursor"))
+			}
+			requested = append(requested, q.Get("page"))
+			w.Write([]byte(pages[q.Get("page")]))
+		}))
+		defer srv.Close()
+		useCivitServer(t, srv)
+
+		got, err := FetchCivitModels("token", SyncFilters{})
+		if err != nil {
+			t.Fatalf("FetchCivitModels: %v", err)
+		}
+		if len(got) != 3 || strings.Join(requested, "|") != "|2|3" {
+			t.Errorf("got %d models from pages %q, want 3 from |2|3", len(got), requested)
+		}
+	})
+
 	t.Run("http error", func(t *testing.T) {
 		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
-		if _, err := FetchCivitModels("token"); err == nil {
+		if _, err := FetchCivitModels("token", SyncFilters{}); err == nil {
 			t.Fatal("expected error")
 		}
 	})
@@ -67,15 +142,33 @@ func TestFetchCivitModels(t *testing.T) {
 			w.Write([]byte("not json"))
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
-		if _, err := FetchCivitModels("token"); err == nil {
+		if _, err := FetchCivitModels("token", SyncFilters{}); err == nil {
 			t.Fatal("expected error")
 		}
 	})
 }
 
+func TestSyncFiltersLimit(t *testing.T) {
+	nsfw := false
+	for _, tc := range []struct {
+		name    string
+		filters SyncFilters
+		want    int
+	}{
+		{"no filters", SyncFilters{}, defaultSyncMaxItems},
+		{"filter only", SyncFilters{NSFW: &nsfw}, defaultSyncMaxItems},
+		{"types only", SyncFilters{Types: []string{"LORA"}}, defaultSyncMaxItems},
+		{"max items", SyncFilters{Types: []string{"LORA"}, MaxItems: 500}, 500},
+		{"all", SyncFilters{Types: []string{"LORA"}, All: true}, 0},
+	} {
+		if got := tc.filters.limit(); got != tc.want {
+			t.Errorf("%s: limit = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
 func TestFetchCivitModel(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		model := CivitModel{ID: 2, Name: "bar"}
@@ -86,8 +179,7 @@ func TestFetchCivitModel(t *testing.T) {
 			json.NewEncoder(w).Encode(model)
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
 		got, err := FetchCivitModel("token", 2)
 		if err != nil {
@@ -103,8 +195,7 @@ func TestFetchCivitModel(t *testing.T) {
 			w.WriteHeader(http.StatusNotFound)
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
 		if _, err := FetchCivitModel("token", 3); err == nil {
 			t.Fatal("expected error")
@@ -116,8 +207,7 @@ func TestFetchCivitModel(t *testing.T) {
 			w.Write([]byte("not json"))
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
 		if _, err := FetchCivitModel("token", 4); err == nil {
 			t.Fatal("expected error")
@@ -135,8 +225,7 @@ func TestFetchModelVersion(t *testing.T) {
 			json.NewEncoder(w).Encode(version)
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
 		got, err := FetchModelVersion("token", 5)
 		if err != nil {
@@ -152,8 +241,7 @@ func TestFetchModelVersion(t *testing.T) {
 			w.WriteHeader(http.StatusBadRequest)
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
 		if _, err := FetchModelVersion("token", 6); err == nil {
 			t.Fatal("expected error")
@@ -165,11 +253,113 @@ func TestFetchModelVersion(t *testing.T) {
 			w.Write([]byte("not json"))
 		}))
 		defer srv.Close()
-		cleanup := redirectToServer(srv)
-		defer cleanup()
+		useCivitServer(t, srv)
 
 		if _, err := FetchModelVersion("token", 7); err == nil {
 			t.Fatal("expected error")
 		}
 	})
 }
+
+func TestCivitClientRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(CivitModel{ID: 9})
+	}))
+	defer srv.Close()
+	useCivitServer(t, srv)
+
+	got, err := FetchCivitModel("token", 9)
+	if err != nil || got.ID != 9 {
+		t.Fatalf("FetchCivitModel = %+v, %v", got, err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+}
+
+func TestCivitClientTypedErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"not found", http.StatusNotFound, `{"error":"No model with id 1"}`, ErrCivitNotFound},
+		{"unauthorized", http.StatusUnauthorized, `{"error":"bad token"}`, ErrCivitUnauthorized},
+		{"early access", http.StatusForbidden, `{"message":"This model is in Early Access"}`, ErrCivitEarlyAccess},
+		{"rate limited", http.StatusTooManyRequests, ``, ErrCivitRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			useCivitServer(t, srv)
+
+			_, err := FetchModelVersion("token", 1)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			var apiErr *CivitError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Errorf("err = %#v", err)
+			}
+			wantCalls := int32(1)
+			if tt.status == http.StatusTooManyRequests {
+				wantCalls = int32(Civit.MaxRetries + 1)
+			}
+			if calls.Load() != wantCalls {
+				t.Errorf("calls = %d, want %d", calls.Load(), wantCalls)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("seconds = %s", got)
+	}
+	future := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got <= 0 || got > 10*time.Second {
+		t.Errorf("date = %s", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("invalid = %s", got)
+	}
+}
+
+func TestTokenBucketLimitsRate(t *testing.T) {
+	b := newTokenBucket(20, 2)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	// Two tokens are available immediately, the next two take 50ms each.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("4 waits took %s, want >= 100ms", elapsed)
+	}
+
+	slow := newTokenBucket(0.001, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := slow.Wait(ctx); err != nil {
+		t.Fatalf("first token should be free: %v", err)
+	}
+	if err := slow.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("empty bucket Wait err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"model-manager/backend/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// disconnectClient closes a client's open connection, if any, so it has to
+// authenticate again.
+func disconnectClient(clientID string) {
+	ClientsMutex.Lock()
+	clientConn := Clients[clientID]
+	ClientsMutex.Unlock()
+	if clientConn != nil && clientConn.Conn != nil {
+		clientConn.Conn.Close()
+	}
+}
+
+// RequireAdmin guards the routes that grant or take away client access or
+// change client settings, so a desktop client cannot approve itself. With ADMIN_TOKEN set, requests must
+// send it as "Authorization: Bearer <token>". Without it, only requests from
+// the server's own machine are let through.
+func RequireAdmin(c *gin.Context) {
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			log.Printf("Rejected admin request from %s to %s", c.ClientIP(), c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Admin token required"})
+			return
+		}
+		c.Next()
+		return
+	}
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		log.Printf("Rejected admin request from %s to %s", c.ClientIP(), c.Request.URL.Path)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Only allowed from the server's machine unless ADMIN_TOKEN is set"})
+		return
+	}
+	c.Next()
+}
+
+// pairingLimiter caps how many pairing requests one address may send, so the
+// open pairing route cannot be used to flood the client list.
+var pairingLimiter = newRateLimiter(5, time.Minute)
+
+// rateLimiter allows up to limit events per key within a sliding window.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// allow records an event for key and reports whether it is within the limit.
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for k, times := range l.events {
+		kept := times[:0]
+		for _, t := range times {
+			if now.Sub(t) < l.window {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			delete(l.events, k)
+		} else {
+			l.events[k] = kept
+		}
+	}
+	if len(l.events[key]) >= l.limit {
+		return false
+	}
+	l.events[key] = append(l.events[key], now)
+	return true
+}
+
+// sharedClientSecret returns the legacy CLIENT_SECRET, or "" when
+// REQUIRE_CLIENT_TOKENS turns it off and every client has to pair.
+func sharedClientSecret() string {
+	if required, _ := strconv.ParseBool(os.Getenv("REQUIRE_CLIENT_TOKENS")); required {
+		return ""
+	}
+	return os.Getenv("CLIENT_SECRET")
+}
+
+type pairingRequest struct {
+	ClientID string `json:"client_id" binding:"required"`
+	Name     string `json:"name"`
+	Secret   string `json:"pairing_secret"`
+}
+
+// RequestClientPairing lets a new desktop client ask for access. It responds
+// with a pairing secret the client then polls ClaimClientPairing with until
+// an admin approves the request. Requests are rate limited per address.
+func RequestClientPairing(c *gin.Context) {
+	if !pairingLimiter.allow(c.ClientIP()) {
+		log.Printf("Rate limited pairing requests from %s", c.ClientIP())
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many pairing requests"})
+		return
+	}
+	var req pairingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_id is required"})
+		return
+	}
+	secret, err := database.RequestClientPairing(req.ClientID, strings.TrimSpace(req.Name))
+	if errors.Is(err, database.ErrClientPaired) || errors.Is(err, database.ErrPairingPending) {
+		log.Printf("Rejected pairing request from %s for client %s: %v", c.ClientIP(), req.ClientID, err)
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record pairing request"})
+		return
+	}
+	log.Printf("Pairing requested by %s for client %s", c.ClientIP(), req.ClientID)
+	Events.Publish(EventClientPairingRequested, gin.H{"clientId": req.ClientID, "name": req.Name})
+	c.JSON(http.StatusAccepted, gin.H{"status": "pending", "pairing_secret": secret})
+}
+
+// ClaimClientPairing hands out the client's token once its pairing request
+// is approved. It responds 202 while the request is pending.
+func ClaimClientPairing(c *gin.Context) {
+	var req pairingRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_id and pairing_secret are required"})
+		return
+	}
+	token, err := database.ClaimClientToken(req.ClientID, req.Secret)
+	switch {
+	case errors.Is(err, database.ErrPairingRejected):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, database.ErrNoPairingRequest):
+		log.Printf("Invalid pairing claim from %s for client %s", c.ClientIP(), req.ClientID)
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim token"})
+	case token == "":
+		c.JSON(http.StatusAccepted, gin.H{"status": "pending"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": "approved", "token": token})
+	}
+}
+
+// ApproveClient approves a client's pending pairing request.
+func ApproveClient(c *gin.Context) {
+	if err := database.ApproveClientPairing(c.Param("id")); errors.Is(err, database.ErrNoPairingRequest) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending pairing request"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve client"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "approved"})
+}
+
+// RevokeClient revokes a client's token, or rejects its pairing request, and
+// closes its connection.
+func RevokeClient(c *gin.Context) {
+	clientID := c.Param("id")
+	if err := database.RevokeClientAccess(clientID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke client"})
+		return
+	}
+	disconnectClient(clientID)
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// RotateClientToken issues a new token for a client, registering it first if
+// needed. The old token stops working and the client is disconnected. The
+// token is only shown in this response.
+func RotateClientToken(c *gin.Context) {
+	clientID := c.Param("id")
+	token, err := database.IssueClientToken(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+	disconnectClient(clientID)
+	c.JSON(http.StatusCreated, gin.H{"clientId": clientID, "token": token})
+}
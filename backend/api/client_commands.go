@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errNotQueueable = errors.New("only downloads and deletes can be queued")
+
+// queueDispatch stores a dispatch for a client that could not be reached.
+func queueDispatch(req DispatchRequest) (models.ClientCommand, error) {
+	if req.Action != "download" && req.Action != "delete" {
+		return models.ClientCommand{}, errNotQueueable
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return models.ClientCommand{}, err
+	}
+	cmd, err := database.EnqueueClientCommand(req.ClientID, req.ModelVersionID, req.Action, payload)
+	if err != nil {
+		return cmd, err
+	}
+	log.Printf("Queued %s of model %d for offline client %s (command %d)", req.Action, req.ModelVersionID, req.ClientID, cmd.ID)
+	return cmd, nil
+}
+
+// expireClientCommands drops queued commands past their expiry and tells
+// browsers about the pending installs that went with them.
+func expireClientCommands() {
+	expired, err := database.ExpireClientCommands()
+	if err != nil {
+		log.Printf("Error expiring client commands: %v", err)
+		return
+	}
+	for _, cmd := range expired {
+		log.Printf("Queued %s of model %d for client %s expired", cmd.Action, cmd.ModelVersionID, cmd.ClientID)
+		if cmd.Action == "download" {
+			publishClientFileStatus(cmd.ClientID, cmd.ModelVersionID, "deleted")
+		}
+	}
+}
+
+// replayClientCommands sends the commands queued for a client, oldest
+// first. It stops at the first failed send; the rest stay queued for the
+// next connection. Clients that acknowledge commands have them marked sent
+// on the acknowledgement.
+func replayClientCommands(clientID string) {
+	expireClientCommands()
+	cmds, err := database.ListClientCommands(clientID, false)
+	if err != nil {
+		log.Printf("Error loading queued commands for client %s: %v", clientID, err)
+		return
+	}
+	for _, cmd := range cmds {
+		var req DispatchRequest
+		if err := json.Unmarshal([]byte(cmd.Payload), &req); err != nil {
+			log.Printf("Error decoding command %d: %v", cmd.ID, err)
+			continue
+		}
+		if err := sendClientCommand(clientID, req, cmd.ID); err != nil {
+			log.Printf("Replaying command %d to client %s failed: %v", cmd.ID, clientID, err)
+			return
+		}
+		log.Printf("Replayed queued %s of model %d to client %s", cmd.Action, cmd.ModelVersionID, clientID)
+	}
+}
+
+// ListClientCommands returns the commands queued for a client, oldest first.
+// With all=1 sent, cancelled and expired commands are included.
+func ListClientCommands(c *gin.Context) {
+	expireClientCommands()
+	cmds, err := database.ListClientCommands(c.Param("id"), c.Query("all") == "1")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list commands"})
+		return
+	}
+	c.JSON(http.StatusOK, cmds)
+}
+
+// CancelClientCommand cancels a queued command. Cancelling a download also
+// clears the version's pending status on the client.
+func CancelClientCommand(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("commandId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid command ID"})
+		return
+	}
+	cmd, err := database.CancelClientCommand(c.Param("id"), uint(id))
+	if errors.Is(err, database.ErrCommandNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel command"})
+		return
+	}
+	if cmd.Action == "download" {
+		publishClientFileStatus(cmd.ClientID, cmd.ModelVersionID, "deleted")
+	}
+	c.JSON(http.StatusOK, cmd)
+}
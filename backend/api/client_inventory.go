@@ -0,0 +1,200 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InventoryFile is one file in a client's "inventory" message.
+type InventoryFile struct {
+	Path   string `json:"path"` // relative to the client's root
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// InventoryResult summarises a reconciled inventory.
+type InventoryResult struct {
+	Matched    int    `json:"matched"`
+	Incomplete int    `json:"incomplete"`
+	Misplaced  int    `json:"misplaced"`
+	Unknown    int    `json:"unknown"`
+	Installed  []uint `json:"installed"` // versions newly marked installed
+	Removed    []uint `json:"removed"`   // versions no longer on the client
+	// Failed are installed versions whose file on the client no longer
+	// matches the server's copy.
+	Failed []InventoryFailure `json:"failed"`
+}
+
+// InventoryFailure is an installed version marked failed by a reconcile.
+type InventoryFailure struct {
+	ModelVersionID uint   `json:"modelVersionId"`
+	Reason         string `json:"reason"`
+}
+
+// inventoryKey normalises a client path for matching. Clients may run on
+// case-insensitive file systems, so the key is lowercase.
+func inventoryKey(p string) string {
+	return strings.ToLower(strings.TrimLeft(strings.ReplaceAll(p, "\\", "/"), "/"))
+}
+
+// versionFileSize returns the size of the version's file on the server, or
+// 0 if it cannot be read.
+func versionFileSize(version models.Version) int64 {
+	info, err := os.Stat(ResolveModelPath(version.FilePath))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// reconcileInventory compares the files a client reported with the library
+// and its ClientFile records. A file at a version's expected path counts as
+// installed if its size (and hash, when both sides have one) match the
+// server's copy. Installed versions missing from the inventory are removed,
+// and those whose file differs are marked failed with the reason; pending
+// downloads that have not finished stay pending.
+func reconcileInventory(clientID string, reported []InventoryFile) (InventoryResult, error) {
+	result := InventoryResult{Installed: []uint{}, Removed: []uint{}, Failed: []InventoryFailure{}}
+	client, err := database.GetClient(clientID)
+	if err != nil {
+		return result, err
+	}
+	var versions []models.Version
+	if err := database.DB.Preload("ParentModel").Where("file_path <> ''").Find(&versions).Error; err != nil {
+		return result, err
+	}
+	byPath := make(map[string]models.Version, len(versions))
+	byHash := make(map[string]models.Version, len(versions))
+	for _, v := range versions {
+		byPath[inventoryKey(clientRelativePath(v, client.Settings))] = v
+		if v.SHA256 != "" {
+			byHash[strings.ToUpper(v.SHA256)] = v
+		}
+	}
+
+	present := make(map[uint]bool)
+	mismatched := make(map[uint]string)
+	files := make([]models.ClientInventoryFile, 0, len(reported))
+	for _, f := range reported {
+		file := models.ClientInventoryFile{
+			ClientID: clientID,
+			Path:     strings.TrimLeft(strings.ReplaceAll(f.Path, "\\", "/"), "/"),
+			Size:     f.Size,
+			SHA256:   strings.ToUpper(f.SHA256),
+			Status:   models.InventoryUnknown,
+		}
+		if v, ok := byPath[inventoryKey(f.Path)]; ok {
+			file.ModelVersionID = v.ID
+			size := versionFileSize(v)
+			hashDiffers := file.SHA256 != "" && v.SHA256 != "" && !strings.EqualFold(file.SHA256, v.SHA256)
+			if (size > 0 && size != f.Size) || hashDiffers {
+				file.Status = models.InventoryIncomplete
+				result.Incomplete++
+				if hashDiffers {
+					mismatched[v.ID] = "File on the client has a different SHA-256 than the server's copy"
+				} else {
+					mismatched[v.ID] = fmt.Sprintf("File on the client is %d bytes, expected %d", f.Size, size)
+				}
+			} else {
+				file.Status = models.InventoryMatched
+				present[v.ID] = true
+				result.Matched++
+			}
+		} else if v, ok := byHash[file.SHA256]; ok && file.SHA256 != "" {
+			file.ModelVersionID = v.ID
+			file.Status = models.InventoryMisplaced
+			result.Misplaced++
+		} else {
+			result.Unknown++
+		}
+		files = append(files, file)
+	}
+
+	var records []models.ClientFile
+	if err := database.DB.Where("client_id = ?", clientID).Find(&records).Error; err != nil {
+		return result, err
+	}
+	recorded := make(map[uint]string, len(records))
+	for _, cf := range records {
+		recorded[cf.ModelVersionID] = cf.Status
+		if cf.Status != "installed" || present[cf.ModelVersionID] {
+			continue
+		}
+		if reason, ok := mismatched[cf.ModelVersionID]; ok {
+			result.Failed = append(result.Failed, InventoryFailure{ModelVersionID: cf.ModelVersionID, Reason: reason})
+		} else {
+			result.Removed = append(result.Removed, cf.ModelVersionID)
+		}
+	}
+	for _, f := range files {
+		if f.Status == models.InventoryMatched && recorded[f.ModelVersionID] != "installed" {
+			result.Installed = append(result.Installed, f.ModelVersionID)
+			recorded[f.ModelVersionID] = "installed"
+		}
+	}
+
+	failed := make(map[uint]string, len(result.Failed))
+	for _, f := range result.Failed {
+		failed[f.ModelVersionID] = f.Reason
+	}
+	if err := database.SaveClientInventory(clientID, files, result.Installed, result.Removed, failed); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// handleInventory reconciles an "inventory" message and tells browsers what
+// changed.
+func handleInventory(clientID string, reported []InventoryFile) {
+	result, err := reconcileInventory(clientID, reported)
+	if err != nil {
+		log.Printf("Error reconciling inventory of client %s: %v", clientID, err)
+		return
+	}
+	for _, id := range result.Installed {
+		publishClientFileStatus(clientID, id, "installed")
+	}
+	for _, id := range result.Removed {
+		publishClientFileStatus(clientID, id, "deleted")
+	}
+	for _, f := range result.Failed {
+		publishClientFileFailed(clientID, f.ModelVersionID, f.Reason)
+	}
+	log.Printf("Reconciled inventory of client %s: %d matched, %d installed, %d removed, %d failed, %d unknown",
+		clientID, result.Matched, len(result.Installed), len(result.Removed), len(result.Failed), result.Unknown)
+	Events.Publish(EventClientInventory, gin.H{"clientId": clientID, "result": result})
+}
+
+// RequestClientInventory asks a connected client to report its files again.
+func RequestClientInventory(c *gin.Context) {
+	clientID := c.Param("id")
+	err := SendToClient(clientID, DispatchRequest{Action: "inventory", ClientID: clientID})
+	if errors.Is(err, errUnsupportedCommand) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Client not connected", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": "requested"})
+}
+
+// GetClientInventory returns the files a client last reported. ?status=
+// limits the list, e.g. to unknown files that can be identified or imported.
+func GetClientInventory(c *gin.Context) {
+	files, err := database.ListClientInventory(c.Param("id"), c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load inventory"})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
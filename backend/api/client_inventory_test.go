@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func TestReconcileInventory(t *testing.T) {
+	initTestDB(t)
+	root := t.TempDir()
+	database.SetSettingValue("model_path", root)
+	model := models.Model{Name: "Lib", Type: "LORA"}
+	database.DB.Create(&model)
+	var versions []models.Version
+	for i, name := range []string{"kept.safetensors", "gone.safetensors", "manual.safetensors", "partial.safetensors", "moved.safetensors", "broken.safetensors"} {
+		// File paths are stored relative to the model root.
+		path := filepath.Join("loras", name)
+		writeTestFile(t, filepath.Join(root, path), "0123456789")
+		v := models.Version{ModelID: model.ID, VersionID: 100 + i, Name: name, FilePath: path, SHA256: "HASH" + name}
+		database.DB.Create(&v)
+		versions = append(versions, v)
+	}
+	kept, gone, manual, partial, moved, broken := versions[0], versions[1], versions[2], versions[3], versions[4], versions[5]
+	database.DB.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: kept.ID, Status: "installed"})
+	database.DB.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: gone.ID, Status: "installed"})
+	database.DB.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: partial.ID, Status: "pending"})
+	database.DB.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: broken.ID, Status: "installed"})
+
+	handleClientMessage(ClientMessage{Type: "inventory", ClientID: "desk", Files: []InventoryFile{
+		{Path: "LORA/kept.safetensors", Size: 10},
+		{Path: `lora\Manual.safetensors`, Size: 10},
+		{Path: "LORA/partial.safetensors", Size: 4},
+		{Path: "old/moved.safetensors", Size: 10, SHA256: "hashmoved.safetensors"},
+		{Path: "other/stray.ckpt", Size: 99},
+		{Path: "LORA/broken.safetensors", Size: 7},
+	}})
+
+	status := func(id uint) string {
+		var cf models.ClientFile
+		database.DB.Where("client_id = ? AND model_version_id = ?", "desk", id).Limit(1).Find(&cf)
+		return cf.Status
+	}
+	for _, tc := range []struct {
+		version models.Version
+		want    string
+	}{{kept, "installed"}, {gone, ""}, {manual, "installed"}, {partial, "pending"}, {moved, ""}, {broken, "failed"}} {
+		if got := status(tc.version.ID); got != tc.want {
+			t.Errorf("%s: status = %q, want %q", tc.version.Name, got, tc.want)
+		}
+	}
+	// An installed copy that no longer matches keeps its record, with why.
+	var failed models.ClientFile
+	database.DB.Where("client_id = ? AND model_version_id = ?", "desk", broken.ID).First(&failed)
+	if failed.Error != "File on the client is 7 bytes, expected 10" {
+		t.Errorf("failure reason = %q", failed.Error)
+	}
+
+	w := clientRequest(t, GetClientInventory, http.MethodGet, "desk", nil)
+	var files []models.ClientInventoryFile
+	json.Unmarshal(w.Body.Bytes(), &files)
+	got := map[string]string{}
+	for _, f := range files {
+		got[f.Path] = f.Status
+	}
+	want := map[string]string{
+		"LORA/kept.safetensors":    models.InventoryMatched,
+		"lora/Manual.safetensors":  models.InventoryMatched,
+		"LORA/partial.safetensors": models.InventoryIncomplete,
+		"old/moved.safetensors":    models.InventoryMisplaced,
+		"other/stray.ckpt":         models.InventoryUnknown,
+		"LORA/broken.safetensors":  models.InventoryIncomplete,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("inventory = %s", w.Body.String())
+	}
+	for path, s := range want {
+		if got[path] != s {
+			t.Errorf("%s: status = %q, want %q", path, got[path], s)
+		}
+	}
+	if client, _ := database.GetClient("desk"); client.InventoryAt == nil {
+		t.Error("inventoryAt not set")
+	}
+
+	// Reporting again replaces the stored inventory.
+	handleClientMessage(ClientMessage{Type: "inventory", ClientID: "desk", Files: []InventoryFile{{Path: "other/stray.ckpt", Size: 99}}})
+	files, _ = database.ListClientInventory("desk", models.InventoryUnknown)
+	if all, _ := database.ListClientInventory("desk", ""); len(all) != 1 || len(files) != 1 {
+		t.Errorf("inventory after second report = %+v", all)
+	}
+	if status(kept.ID) != "" || status(partial.ID) != "pending" {
+		t.Errorf("statuses after second report: kept=%q partial=%q", status(kept.ID), status(partial.ID))
+	}
+}
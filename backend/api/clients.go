@@ -0,0 +1,267 @@
+package api
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientPresenceLimit is how many past connections GetClient returns.
+const clientPresenceLimit = 20
+
+// ClientSummary is a client with the counts of its ClientFile records.
+type ClientSummary struct {
+	models.Client
+	InstalledCount int64 `json:"installedCount"`
+	PendingCount   int64 `json:"pendingCount"`
+	QueuedCount    int64 `json:"queuedCount"`
+}
+
+// ClientDetail adds the client's latest connections to its summary.
+type ClientDetail struct {
+	ClientSummary
+	Presence []models.ClientPresence `json:"presence"`
+}
+
+// clientConnected reports whether a client has an open WebSocket.
+func clientConnected(clientID string) bool {
+	ClientsMutex.Lock()
+	defer ClientsMutex.Unlock()
+	return Clients[clientID] != nil
+}
+
+// clientFileCounts returns the number of ClientFile records per client and
+// status.
+func clientFileCounts(clientID string) (map[string]map[string]int64, error) {
+	var rows []struct {
+		ClientID string
+		Status   string
+		Count    int64
+	}
+	q := database.DB.Model(&models.ClientFile{}).Select("client_id, status, COUNT(*) AS count").Group("client_id, status")
+	if clientID != "" {
+		q = q.Where("client_id = ?", clientID)
+	}
+	if err := q.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[string]map[string]int64)
+	for _, r := range rows {
+		if counts[r.ClientID] == nil {
+			counts[r.ClientID] = make(map[string]int64)
+		}
+		counts[r.ClientID][r.Status] = r.Count
+	}
+	return counts, nil
+}
+
+// queuedCommandCounts returns the number of queued commands per client.
+func queuedCommandCounts(clientID string) (map[string]int64, error) {
+	var rows []struct {
+		ClientID string
+		Count    int64
+	}
+	q := database.DB.Model(&models.ClientCommand{}).Select("client_id, COUNT(*) AS count").
+		Where("status = ?", models.ClientCommandQueued).Group("client_id")
+	if clientID != "" {
+		q = q.Where("client_id = ?", clientID)
+	}
+	if err := q.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	for _, r := range rows {
+		counts[r.ClientID] = r.Count
+	}
+	return counts, nil
+}
+
+func summarizeClient(client models.Client, counts map[string]int64, queued int64) ClientSummary {
+	if client.Name == "" {
+		client.Name = client.ClientID
+	}
+	// The open connections are authoritative; the stored flag can lag behind
+	// a crash.
+	client.Connected = clientConnected(client.ClientID)
+	return ClientSummary{Client: client, InstalledCount: counts["installed"], PendingCount: counts["pending"], QueuedCount: queued}
+}
+
+// ListClients returns every known client, connected or not, with its file
+// counts. Clients that have files recorded but never connected since the
+// registry was added are listed too.
+func ListClients(c *gin.Context) {
+	var clients []models.Client
+	if err := database.DB.Find(&clients).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list clients"})
+		return
+	}
+	counts, err := clientFileCounts("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count client files"})
+		return
+	}
+	queued, err := queuedCommandCounts("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count queued commands"})
+		return
+	}
+
+	known := make(map[string]bool, len(clients))
+	for _, client := range clients {
+		known[client.ClientID] = true
+	}
+	for id := range counts {
+		if !known[id] {
+			known[id] = true
+			clients = append(clients, models.Client{ClientID: id})
+		}
+	}
+	for id := range queued {
+		if !known[id] {
+			known[id] = true
+			clients = append(clients, models.Client{ClientID: id})
+		}
+	}
+
+	summaries := make([]ClientSummary, 0, len(clients))
+	for _, client := range clients {
+		summaries = append(summaries, summarizeClient(client, counts[client.ClientID], queued[client.ClientID]))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Connected != summaries[j].Connected {
+			return summaries[i].Connected
+		}
+		return summaries[i].ClientID < summaries[j].ClientID
+	})
+	c.JSON(http.StatusOK, summaries)
+}
+
+// GetClient returns one client with its file counts and latest connections.
+func GetClient(c *gin.Context) {
+	clientID := c.Param("id")
+	client, err := database.GetClient(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load client"})
+		return
+	}
+	counts, err := clientFileCounts(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count client files"})
+		return
+	}
+	queued, err := queuedCommandCounts(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count queued commands"})
+		return
+	}
+	if client.ID == 0 && counts[clientID] == nil && queued[clientID] == 0 && !clientConnected(clientID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+	presence, err := database.ClientPresenceHistory(clientID, clientPresenceLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load client history"})
+		return
+	}
+	c.JSON(http.StatusOK, ClientDetail{ClientSummary: summarizeClient(client, counts[clientID], queued[clientID]), Presence: presence})
+}
+
+// UpdateClient changes the name and settings of a client. Clients can be
+// configured before they first connect.
+func UpdateClient(c *gin.Context) {
+	var req struct {
+		Name     *string                `json:"name"`
+		Settings *models.ClientSettings `json:"settings"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	clientID := c.Param("id")
+	client, err := database.GetClient(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load client"})
+		return
+	}
+	if req.Name != nil {
+		client.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Settings != nil {
+		switch req.Settings.FolderLayout {
+		case "", models.FolderLayoutType, models.FolderLayoutBaseModel, models.FolderLayoutFlat:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown folder layout"})
+			return
+		}
+		client.Settings = *req.Settings
+	}
+
+	client, err = database.UpdateClientConfig(clientID, client.Name, client.Settings)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update client"})
+		return
+	}
+	counts, err := clientFileCounts(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count client files"})
+		return
+	}
+	queued, err := queuedCommandCounts(clientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count queued commands"})
+		return
+	}
+	c.JSON(http.StatusOK, summarizeClient(client, counts[clientID], queued[clientID]))
+}
+
+// versionModelType is the model type used for client folders and the
+// allowed-types setting.
+func versionModelType(version models.Version) string {
+	if version.ParentModel.Type != "" {
+		return version.ParentModel.Type
+	}
+	return version.Type
+}
+
+// clientAllowsType reports whether a client's settings accept the version.
+func clientAllowsType(settings models.ClientSettings, version models.Version) bool {
+	if len(settings.AllowedTypes) == 0 {
+		return true
+	}
+	modelType := versionModelType(version)
+	for _, t := range settings.AllowedTypes {
+		if strings.EqualFold(t, modelType) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientRelativePath is where a version's file lives below a client's root,
+// following the client's folder layout. Missing types and base models fall
+// back to "Other".
+func clientRelativePath(version models.Version, settings models.ClientSettings) string {
+	subdir := versionModelType(version)
+	if subdir == "" {
+		subdir = "Other"
+	}
+	filename := filepath.Base(version.FilePath)
+	switch settings.FolderLayout {
+	case models.FolderLayoutFlat:
+		return filename
+	case models.FolderLayoutBaseModel:
+		baseModel := strings.NewReplacer("/", "-", "\\", "-").Replace(version.BaseModel)
+		if baseModel == "" {
+			baseModel = "Other"
+		}
+		return filepath.ToSlash(filepath.Join(subdir, baseModel, filename))
+	default:
+		return filepath.ToSlash(filepath.Join(subdir, filename))
+	}
+}
@@ -0,0 +1,468 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func clientRequest(t *testing.T, handler gin.HandlerFunc, method, id string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	if id != "" {
+		c.Params = gin.Params{{Key: "id", Value: id}}
+	}
+	c.Request = httptest.NewRequest(method, "/clients/"+id, &buf)
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+func TestClientRegistry(t *testing.T) {
+	initTestDB(t)
+	presenceID, err := database.ClientConnected("desk", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleClientMessage(ClientMessage{Type: "status", ClientID: "desk", Name: "Desk", Version: "1.1.0",
+		RootPath: `C:\Models`, FreeDiskBytes: 1 << 30})
+	database.DB.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: 1, Status: "installed"})
+	database.DB.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: 2, Status: "pending"})
+	// A client only known from files recorded before the registry existed.
+	database.DB.Create(&models.ClientFile{ClientID: "old", ModelVersionID: 1, Status: "installed"})
+
+	ClientsMutex.Lock()
+	Clients["desk"] = &ClientConnection{}
+	ClientsMutex.Unlock()
+	t.Cleanup(func() {
+		ClientsMutex.Lock()
+		delete(Clients, "desk")
+		ClientsMutex.Unlock()
+	})
+
+	w := clientRequest(t, ListClients, http.MethodGet, "", nil)
+	var list []ClientSummary
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 2 || list[0].ClientID != "desk" || !list[0].Connected || list[1].Connected {
+		t.Fatalf("clients = %s", w.Body.String())
+	}
+	desk := list[0]
+	if desk.Name != "Desk" || desk.Version != "1.1.0" || desk.FreeDiskBytes != 1<<30 ||
+		desk.InstalledCount != 1 || desk.PendingCount != 1 || desk.LastSeenAt == nil {
+		t.Errorf("desk = %+v", desk)
+	}
+	if list[1].ClientID != "old" || list[1].InstalledCount != 1 {
+		t.Errorf("old = %+v", list[1])
+	}
+
+	if err := database.ClientDisconnected("desk", presenceID); err != nil {
+		t.Fatal(err)
+	}
+	w = clientRequest(t, GetClient, http.MethodGet, "desk", nil)
+	var detail ClientDetail
+	json.Unmarshal(w.Body.Bytes(), &detail)
+	if w.Code != http.StatusOK || len(detail.Presence) != 1 || detail.Presence[0].DisconnectedAt == nil ||
+		detail.Presence[0].RemoteAddr != "10.0.0.2" {
+		t.Errorf("detail = %d %s", w.Code, w.Body.String())
+	}
+	if w := clientRequest(t, GetClient, http.MethodGet, "nobody", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown client = %d", w.Code)
+	}
+}
+
+func TestUpdateClientSettings(t *testing.T) {
+	initTestDB(t)
+	w := clientRequest(t, UpdateClient, http.MethodPut, "new", gin.H{"settings": gin.H{"folderLayout": "sideways"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad layout = %d", w.Code)
+	}
+	w = clientRequest(t, UpdateClient, http.MethodPut, "new", gin.H{"name": "Laptop",
+		"settings": gin.H{"folderLayout": "base-model", "allowedTypes": []string{"LORA"}}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update = %d %s", w.Code, w.Body.String())
+	}
+	client, _ := database.GetClient("new")
+	if client.ID == 0 || client.Name != "Laptop" || client.Settings.FolderLayout != models.FolderLayoutBaseModel {
+		t.Fatalf("stored client = %+v", client)
+	}
+
+	m := models.Model{CivitID: 1, Name: "M", Type: "Checkpoint", Weight: 1}
+	database.DB.Create(&m)
+	v := models.Version{ModelID: m.ID, VersionID: 101, BaseModel: "SDXL 1.0", FilePath: "Checkpoint/m.safetensors"}
+	database.DB.Create(&v)
+
+	body, _ := json.Marshal(DispatchRequest{Action: "download", ModelVersionID: v.ID, ClientID: "new"})
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/remote/dispatch", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	DispatchRemote(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("disallowed type = %d %s", w.Code, w.Body.String())
+	}
+
+	v.ParentModel = m
+	for layout, want := range map[string]string{
+		"":                           "Checkpoint/m.safetensors",
+		models.FolderLayoutBaseModel: "Checkpoint/SDXL 1.0/m.safetensors",
+		models.FolderLayoutFlat:      "m.safetensors",
+	} {
+		if got := clientRelativePath(v, models.ClientSettings{FolderLayout: layout}); got != want {
+			t.Errorf("layout %q = %q, want %q", layout, got, want)
+		}
+	}
+}
+
+func TestClientPairingAndWebSocketAuth(t *testing.T) {
+	initTestDB(t)
+	t.Setenv("CLIENT_SECRET", "")
+
+	w := clientRequest(t, RequestClientPairing, http.MethodPost, "", gin.H{"client_id": "laptop", "name": "Laptop"})
+	var paired struct {
+		Secret string `json:"pairing_secret"`
+		Token  string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &paired)
+	if w.Code != http.StatusAccepted || paired.Secret == "" {
+		t.Fatalf("pair = %d %s", w.Code, w.Body.String())
+	}
+	claim := gin.H{"client_id": "laptop", "pairing_secret": paired.Secret}
+	if w := clientRequest(t, ClaimClientPairing, http.MethodPost, "", claim); w.Code != http.StatusAccepted {
+		t.Errorf("claim while pending = %d", w.Code)
+	}
+	if w := clientRequest(t, ApproveClient, http.MethodPost, "laptop", nil); w.Code != http.StatusOK {
+		t.Fatalf("approve = %d %s", w.Code, w.Body.String())
+	}
+	w = clientRequest(t, ClaimClientPairing, http.MethodPost, "", claim)
+	json.Unmarshal(w.Body.Bytes(), &paired)
+	if w.Code != http.StatusOK || paired.Token == "" {
+		t.Fatalf("claim = %d %s", w.Code, w.Body.String())
+	}
+
+	r := gin.New()
+	r.GET("/ws", HandleWebSocket)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	dial := func(clientID, key string) (*websocket.Conn, int) {
+		header := http.Header{"Authorization": {key}, "X-Client-ID": {clientID}}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			if resp == nil {
+				t.Fatalf("dial: %v", err)
+			}
+			return nil, resp.StatusCode
+		}
+		return conn, http.StatusSwitchingProtocols
+	}
+
+	if _, code := dial("laptop", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong key = %d", code)
+	}
+	if _, code := dial("desk", paired.Token); code != http.StatusUnauthorized {
+		t.Errorf("token for another client = %d", code)
+	}
+	// REQUIRE_CLIENT_TOKENS turns the legacy shared secret off.
+	t.Setenv("CLIENT_SECRET", "shared")
+	t.Setenv("REQUIRE_CLIENT_TOKENS", "true")
+	if _, code := dial("desk", "shared"); code != http.StatusUnauthorized {
+		t.Errorf("shared secret with tokens required = %d", code)
+	}
+	conn, code := dial("laptop", paired.Token)
+	if code != http.StatusSwitchingProtocols {
+		t.Fatalf("token = %d", code)
+	}
+	conn.Close()
+	// Let the server record the disconnect before the database goes away.
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if client, _ := database.GetClient("laptop"); !client.Connected {
+			break
+		}
+	}
+}
+
+func TestPairingClientCannotApproveItself(t *testing.T) {
+	initTestDB(t)
+	t.Setenv("ADMIN_TOKEN", "")
+	r := gin.New()
+	r.POST("/api/clients/pair", RequestClientPairing)
+	r.POST("/api/clients/:id/approve", RequireAdmin, ApproveClient)
+	r.POST("/api/clients/:id/token", RequireAdmin, RotateClientToken)
+	r.PUT("/api/clients/:id", RequireAdmin, UpdateClient)
+	send := func(path, remoteAddr, auth string, body interface{}) *httptest.ResponseRecorder {
+		method := http.MethodPost
+		if path == "/api/clients/laptop" {
+			method = http.MethodPut
+		}
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(body)
+		req := httptest.NewRequest(method, path, &buf)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Content-Type", "application/json")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	const laptop = "192.0.2.10:50000"
+	w := send("/api/clients/pair", laptop, "", gin.H{"client_id": "laptop"})
+	var paired struct {
+		Secret string `json:"pairing_secret"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &paired)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("pair = %d %s", w.Code, w.Body.String())
+	}
+	if w := send("/api/clients/laptop/approve", laptop, paired.Secret, nil); w.Code != http.StatusForbidden {
+		t.Errorf("self-approval = %d %s", w.Code, w.Body.String())
+	}
+	if w := send("/api/clients/laptop/token", laptop, "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("self-issued token = %d %s", w.Code, w.Body.String())
+	}
+	if w := send("/api/clients/laptop", laptop, "", gin.H{"settings": gin.H{"allowedTypes": []string{"LORA"}}}); w.Code != http.StatusForbidden {
+		t.Errorf("self-configured settings = %d %s", w.Code, w.Body.String())
+	}
+	// Nobody can replace the pending request with their own secret.
+	if w := send("/api/clients/pair", "192.0.2.66:50000", "", gin.H{"client_id": "laptop"}); w.Code != http.StatusConflict {
+		t.Errorf("second pairing request = %d %s", w.Code, w.Body.String())
+	}
+	if client, _ := database.GetClient("laptop"); client.AccessStatus != models.ClientAccessPending {
+		t.Fatalf("access after self-approval = %q", client.AccessStatus)
+	}
+
+	// With ADMIN_TOKEN set, the token is required from every address.
+	t.Setenv("ADMIN_TOKEN", "admin")
+	if w := send("/api/clients/laptop/approve", "127.0.0.1:50000", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("approval without token = %d", w.Code)
+	}
+	if w := send("/api/clients/laptop/approve", laptop, "Bearer admin", nil); w.Code != http.StatusOK {
+		t.Errorf("approval with token = %d %s", w.Code, w.Body.String())
+	}
+
+	t.Setenv("ADMIN_TOKEN", "")
+	if w := send("/api/clients/laptop/token", "127.0.0.1:50000", "", nil); w.Code != http.StatusCreated {
+		t.Errorf("token from the server's machine = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPairingRequestsAreRateLimited(t *testing.T) {
+	initTestDB(t)
+	limiter := pairingLimiter
+	pairingLimiter = newRateLimiter(2, time.Minute)
+	t.Cleanup(func() { pairingLimiter = limiter })
+
+	for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusTooManyRequests} {
+		body := gin.H{"client_id": "flood-" + strconv.Itoa(i)}
+		if w := clientRequest(t, RequestClientPairing, http.MethodPost, "", body); w.Code != want {
+			t.Errorf("request %d = %d, want %d", i, w.Code, want)
+		}
+	}
+	if client, _ := database.GetClient("flood-2"); client.ID != 0 {
+		t.Error("rate limited request was recorded")
+	}
+}
+
+func TestDispatchURLPerLayout(t *testing.T) {
+	initTestDB(t)
+	root := t.TempDir()
+	database.SetSettingValue("model_path", root)
+	writeTestFile(t, filepath.Join(root, "checkpoints", "m.safetensors"), "weights")
+	model := models.Model{Name: "M", Type: "Checkpoint"}
+	database.DB.Create(&model)
+	version := models.Version{ModelID: model.ID, Name: "v1", BaseModel: "SDXL 1.0", FilePath: "checkpoints/m.safetensors"}
+	database.DB.Create(&version)
+
+	for layout, want := range map[string]string{
+		models.FolderLayoutType:      "Checkpoint/m.safetensors",
+		models.FolderLayoutBaseModel: "Checkpoint/SDXL 1.0/m.safetensors",
+		models.FolderLayoutFlat:      "m.safetensors",
+	} {
+		clientID := "desk-" + layout
+		clientRequest(t, UpdateClient, http.MethodPut, clientID, gin.H{"settings": gin.H{"folderLayout": layout}})
+		w := clientRequest(t, DispatchRemote, http.MethodPost, "", gin.H{"action": "download", "client_id": clientID, "model_version_id": version.ID})
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s: dispatch = %d %s", layout, w.Code, w.Body.String())
+		}
+		queued, _ := database.ListClientCommands(clientID, false)
+		if len(queued) != 1 {
+			t.Fatalf("%s: queued = %+v", layout, queued)
+		}
+		var req DispatchRequest
+		json.Unmarshal([]byte(queued[0].Payload), &req)
+		if req.Filename != want {
+			t.Errorf("%s: filename = %q, want %q", layout, req.Filename, want)
+		}
+		// The URL is served from the model root whatever the client layout.
+		served := ResolveModelPath(strings.TrimPrefix(req.URL, "/downloads/"))
+		if _, err := os.Stat(served); err != nil {
+			t.Errorf("%s: url %q does not resolve to a server file: %v", layout, req.URL, err)
+		}
+	}
+}
+
+func TestDispatchQueuesForOfflineClient(t *testing.T) {
+	initTestDB(t)
+	t.Setenv("CLIENT_SECRET", "shared")
+	model := models.Model{Name: "Queued", Type: "LORA"}
+	database.DB.Create(&model)
+	version := models.Version{ModelID: model.ID, Name: "v1", FilePath: "/models/lora/queued.safetensors"}
+	database.DB.Create(&version)
+
+	w := clientRequest(t, DispatchRemote, http.MethodPost, "", gin.H{"action": "download", "client_id": "desk", "model_version_id": version.ID})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("dispatch = %d %s", w.Code, w.Body.String())
+	}
+	var cf models.ClientFile
+	database.DB.Where("client_id = ? AND model_version_id = ?", "desk", version.ID).First(&cf)
+	if cf.Status != "pending" {
+		t.Errorf("client file status = %q", cf.Status)
+	}
+	w = clientRequest(t, ListClients, http.MethodGet, "", nil)
+	var list []ClientSummary
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ClientID != "desk" || list[0].QueuedCount != 1 {
+		t.Fatalf("clients = %s", w.Body.String())
+	}
+
+	r := gin.New()
+	r.GET("/ws", HandleWebSocket)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	header := http.Header{"Authorization": {"shared"}, "X-Client-ID": {"desk"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	// Like the desktop client before protocol version 2, report status first.
+	conn.WriteJSON(ClientMessage{Type: "status", Name: "Desk"})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var replayed DispatchRequest
+	if err := conn.ReadJSON(&replayed); err != nil {
+		t.Fatalf("read replay: %v", err)
+	}
+	if replayed.Action != "download" || replayed.ModelVersionID != version.ID || replayed.URL == "" {
+		t.Errorf("replayed = %+v", replayed)
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if queued, _ := database.ListClientCommands("desk", false); len(queued) == 0 {
+			break
+		}
+	}
+	if queued, _ := database.ListClientCommands("desk", false); len(queued) != 0 {
+		t.Errorf("command still queued after replay: %+v", queued)
+	}
+	conn.Close()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if client, _ := database.GetClient("desk"); !client.Connected {
+			break
+		}
+	}
+}
+
+func TestDispatchSendFailures(t *testing.T) {
+	initTestDB(t)
+	model := models.Model{Name: "Sent", Type: "LORA"}
+	database.DB.Create(&model)
+	version := models.Version{ModelID: model.ID, Name: "v1", FilePath: "/models/lora/sent.safetensors"}
+	database.DB.Create(&version)
+	register := func(clientID string, conn *ClientConnection) {
+		ClientsMutex.Lock()
+		Clients[clientID] = conn
+		ClientsMutex.Unlock()
+		t.Cleanup(func() {
+			ClientsMutex.Lock()
+			delete(Clients, clientID)
+			ClientsMutex.Unlock()
+		})
+	}
+	pending := func(clientID string) int64 {
+		var n int64
+		database.DB.Model(&models.ClientFile{}).Where("client_id = ? AND status = ?", clientID, "pending").Count(&n)
+		return n
+	}
+
+	// A command the connected client does not support is a bad request.
+	modern := &ClientConnection{clientID: "modern"}
+	modern.settle(ProtocolVersion, nil)
+	register("modern", modern)
+	w := clientRequest(t, DispatchRemote, http.MethodPost, "", gin.H{"action": "sidecar", "client_id": "modern", "model_version_id": version.ID})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported command = %d %s", w.Code, w.Body.String())
+	}
+
+	// A write that fails on a live connection is queued for replay.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			defer conn.Close()
+			conn.ReadMessage()
+		}
+	}))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Close()
+	register("broken", &ClientConnection{Conn: conn, clientID: "broken"})
+	w = clientRequest(t, DispatchRemote, http.MethodPost, "", gin.H{"action": "download", "client_id": "broken", "model_version_id": version.ID})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("failed write = %d %s", w.Code, w.Body.String())
+	}
+	if queued, _ := database.ListClientCommands("broken", false); len(queued) != 1 || pending("broken") != 1 {
+		t.Errorf("after failed write: %d queued, %d pending", len(queued), pending("broken"))
+	}
+}
+
+func TestCancelClientCommand(t *testing.T) {
+	initTestDB(t)
+	cmd, err := database.EnqueueClientCommand("desk", 7, "download", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: 7, Status: "pending"})
+
+	cancel := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: "desk"}, {Key: "commandId", Value: id}}
+		c.Request = httptest.NewRequest(http.MethodDelete, "/clients/desk/commands/"+id, nil)
+		CancelClientCommand(c)
+		return w
+	}
+	if w := cancel("999"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown command = %d", w.Code)
+	}
+	if w := cancel(strconv.Itoa(int(cmd.ID))); w.Code != http.StatusOK {
+		t.Fatalf("cancel = %d %s", w.Code, w.Body.String())
+	}
+	var count int64
+	database.DB.Model(&models.ClientFile{}).Where("client_id = ?", "desk").Count(&count)
+	if count != 0 {
+		t.Error("cancelled download left a pending install")
+	}
+	w := clientRequest(t, ListClientCommands, http.MethodGet, "desk", nil)
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("queued after cancel = %s", w.Body.String())
+	}
+}
@@ -1,9 +1,9 @@
 package api
 
 import (
-	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -14,7 +14,9 @@ import (
 	"gorm.io/gorm"
 )
 
-// GetCollections returns a list of collections, optionally filtered by name
+// GetCollections returns a list of collections, optionally filtered by name.
+// Smart collections come with their versionCount only; GetCollection loads
+// their members.
 func GetCollections(c *gin.Context) {
 	search := c.Query("search")
 	collections := make([]models.Collection, 0)
@@ -29,6 +31,7 @@ func GetCollections(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch collections"})
 		return
 	}
+	countSmartVersions(collections)
 
 	c.JSON(http.StatusOK, collections)
 }
@@ -41,24 +44,34 @@ func GetCollection(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
 		return
 	}
+	loadSmartVersions(&collection)
 	c.JSON(http.StatusOK, collection)
 }
 
-// CreateCollection creates a new collection
+// CreateCollection creates a new collection. A filter makes it a smart
+// collection.
 func CreateCollection(c *gin.Context) {
 	var input struct {
-		Name        string `json:"name" binding:"required"`
-		Description string `json:"description"`
+		Name        string                   `json:"name" binding:"required"`
+		Description string                   `json:"description"`
+		Filter      *models.CollectionFilter `json:"filter"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.Filter != nil {
+		if _, err := smartCollectionFilter(input.Filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	collection := models.Collection{
 		Name:        input.Name,
 		Description: input.Description,
+		Filter:      input.Filter,
 	}
 
 	if err := database.DB.Create(&collection).Error; err != nil {
@@ -69,7 +82,8 @@ func CreateCollection(c *gin.Context) {
 	c.JSON(http.StatusOK, collection)
 }
 
-// UpdateCollection updates an existing collection
+// UpdateCollection updates an existing collection. The filter of a smart
+// collection may be replaced; static collections cannot be given one.
 func UpdateCollection(c *gin.Context) {
 	id := c.Param("id")
 	var collection models.Collection
@@ -80,14 +94,26 @@ func UpdateCollection(c *gin.Context) {
 	}
 
 	var input struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
+		Name        string                   `json:"name"`
+		Description string                   `json:"description"`
+		Filter      *models.CollectionFilter `json:"filter"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.Filter != nil {
+		if collection.Filter == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Static collections cannot be given a filter"})
+			return
+		}
+		if _, err := smartCollectionFilter(input.Filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		collection.Filter = input.Filter
+	}
 
 	collection.Name = input.Name
 	collection.Description = input.Description
@@ -133,6 +159,11 @@ func AddVersionToCollection(c *gin.Context) {
 		return
 	}
 
+	if collection.Filter != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": errSmartCollection.Error()})
+		return
+	}
+
 	var version models.Version
 	if err := database.DB.First(&version, input.VersionID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
@@ -158,6 +189,11 @@ func RemoveVersionFromCollection(c *gin.Context) {
 		return
 	}
 
+	if collection.Filter != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": errSmartCollection.Error()})
+		return
+	}
+
 	var version models.Version
 	if err := database.DB.First(&version, versionID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
@@ -172,7 +208,8 @@ func RemoveVersionFromCollection(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Removed from collection"})
 }
 
-// GetCollectionVersions returns versions in a collection, with full filtering support (similar to GetModels)
+// GetCollectionVersions returns versions in a collection, with full filtering support (similar to GetModels).
+// Members of a smart collection are the versions matching its filter at request time.
 func GetCollectionVersions(c *gin.Context) {
 	collectionID := c.Param("id")
 
@@ -194,90 +231,49 @@ func GetCollectionVersions(c *gin.Context) {
 	}
 
 	search := c.Query("search")
-	baseModel := c.Query("baseModel")
-	modelType := c.Query("modelType")
-	onlySafe, onlyNSFW := resolveNSFWFilter(c)
-	tags := c.Query("tags")
-	synced := c.Query("synced") == "1"
+	filter, err := filterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Start query on Versions joined with Models
 	// We need Versions that are in the collection
+	inCollection := func(q *gorm.DB) *gorm.DB {
+		q, _ = collectionMembers(q, collection)
+		return q
+	}
+	if _, err := collectionMembers(database.DB, collection); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
 
 	versions := make([]models.Version, 0)
-	q := database.DB.Model(&models.Version{}).
-		Joins("JOIN collection_versions ON collection_versions.version_id = versions.id").
-		Where("collection_versions.collection_id = ?", collectionID).
+	q := inCollection(database.DB.Model(&models.Version{})).
 		Preload("ParentModel").
 		Preload("Images").
 		Preload("Collections")
 
 	// Apply filters to the versions or parent model
+	ranked := false
 	if search != "" {
-		like := "%" + strings.ToLower(search) + "%"
-		q = q.Joins("JOIN models ON models.id = versions.model_id").
-			Where("LOWER(models.name) LIKE ? OR LOWER(versions.name) LIKE ? OR LOWER(versions.trained_words) LIKE ?", like, like, like)
-	}
-
-	if baseModel != "" {
-		q = q.Where("versions.base_model = ?", baseModel)
-	}
-	if modelType != "" {
-		q = q.Where("versions.type = ?", modelType)
-	}
-	if onlySafe {
-		q = q.Where("versions.nsfw = 0")
-	} else if onlyNSFW {
-		q = q.Where("versions.nsfw = 1")
-	}
-	if tags != "" {
-		for _, t := range strings.Split(tags, ",") {
-			t = strings.TrimSpace(strings.ToLower(t))
-			if t != "" {
-				q = q.Where("LOWER(versions.tags) LIKE ?", "%"+t+"%")
-			}
-		}
-	}
-	if synced {
-		q = q.Joins("JOIN client_files ON client_files.model_version_id = versions.id").Where("client_files.status = ?", "installed")
+		q, ranked = applySearch(q, search)
 	}
+	q = filter.apply(q)
 
 	// Count total results before pagination
 	var total int64
-	countQ := database.DB.Model(&models.Version{}).
-		Joins("JOIN collection_versions ON collection_versions.version_id = versions.id").
-		Where("collection_versions.collection_id = ?", collectionID)
-
-	// Apply same filters for count
+	countQ := inCollection(database.DB.Model(&models.Version{}))
 	if search != "" {
-		like := "%" + strings.ToLower(search) + "%"
-		countQ = countQ.Joins("JOIN models ON models.id = versions.model_id").
-			Where("LOWER(models.name) LIKE ? OR LOWER(versions.name) LIKE ? OR LOWER(versions.trained_words) LIKE ?", like, like, like)
-	}
-	if baseModel != "" {
-		countQ = countQ.Where("versions.base_model = ?", baseModel)
-	}
-	if modelType != "" {
-		countQ = countQ.Where("versions.type = ?", modelType)
-	}
-	if onlySafe {
-		countQ = countQ.Where("versions.nsfw = 0")
-	} else if onlyNSFW {
-		countQ = countQ.Where("versions.nsfw = 1")
-	}
-	if tags != "" {
-		for _, t := range strings.Split(tags, ",") {
-			t = strings.TrimSpace(strings.ToLower(t))
-			if t != "" {
-				countQ = countQ.Where("LOWER(versions.tags) LIKE ?", "%"+t+"%")
-			}
-		}
-	}
-	if synced {
-		countQ = countQ.Joins("JOIN client_files ON client_files.model_version_id = versions.id").Where("client_files.status = ?", "installed")
+		countQ, _ = applySearch(countQ, search)
 	}
+	countQ = filter.apply(countQ)
 	countQ.Count(&total)
 	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
 
+	if ranked {
+		q = q.Order("search_hits.rank")
+	}
 	q.Order("versions.id DESC").Limit(limit).Offset((page - 1) * limit).Find(&versions)
 
 	// Populate ClientStatus for versions
@@ -325,6 +321,10 @@ func GetVersionCollections(c *gin.Context) {
 	if version.Collections == nil {
 		version.Collections = make([]models.Collection, 0)
 	}
+	version.Collections = append(version.Collections, smartCollectionsContaining(version.ID)...)
+	sort.SliceStable(version.Collections, func(i, j int) bool {
+		return strings.ToLower(version.Collections[i].Name) < strings.ToLower(version.Collections[j].Name)
+	})
 	c.JSON(http.StatusOK, version.Collections)
 }
 
@@ -336,6 +336,11 @@ func BulkAddVersions(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid collection ID"})
 		return
 	}
+	var collection models.Collection
+	if database.DB.First(&collection, collectionID).Error == nil && collection.Filter != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": errSmartCollection.Error()})
+		return
+	}
 
 	var input struct {
 		Query              string `json:"query"`
@@ -343,6 +348,9 @@ func BulkAddVersions(c *gin.Context) {
 		SearchModelName    bool   `json:"searchModelName"`
 		SearchTrainedWords bool   `json:"searchTrainedWords"`
 		ExactMatch         bool   `json:"exactMatch"`
+		// Filter is a library query (see parseLibraryQuery). When set, the
+		// other fields are ignored.
+		Filter string `json:"filter"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -350,102 +358,41 @@ func BulkAddVersions(c *gin.Context) {
 		return
 	}
 
-	log.Printf("[BulkAdd] Received: Query='%s', Tags=%v, Name=%v, Words=%v, Exact=%v", input.Query, input.SearchTags, input.SearchModelName, input.SearchTrainedWords, input.ExactMatch)
-
-	queryStr := strings.ToLower(strings.TrimSpace(input.Query))
-	if queryStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query cannot be empty"})
-		return
-	}
+	log.Printf("[BulkAdd] Received: Query='%s', Tags=%v, Name=%v, Words=%v, Exact=%v, Filter='%s'", input.Query, input.SearchTags, input.SearchModelName, input.SearchTrainedWords, input.ExactMatch, input.Filter)
 
-	if !input.SearchTags && !input.SearchModelName && !input.SearchTrainedWords {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one search criteria must be selected"})
-		return
-	}
-
-	// Build the WHERE clause
-	var conditions []string
-	var args []interface{}
-
-	// Helper to generate SQL for a specific column
-	addCondition := func(col string, isList bool) {
-		if input.ExactMatch {
-			if isList {
-				// Comma separated list (Tags, TrainedWords)
-				// We need to match the token exactly, bounded by start/end or commas.
-				// We support optional spaces after commas.
-				// 1. Exact match: col = 'q'
-				// 2. Start: col LIKE 'q,%' OR col LIKE 'q, %'
-				// 3. End: col LIKE '%,q' OR col LIKE '%, q'
-				// 4. Middle: col LIKE '%,q,%' OR col LIKE '%, q,%' OR col LIKE '%,q, %' OR col LIKE '%, q, %'
-
-				// Simplified approach:
-				// "q"
-				// "q,%"
-				// "%,q"
-				// "%,q,%"
-				// "%, q"
-				// "%, q,%"
-
-				cond := fmt.Sprintf(`(
-					LOWER(%s) = ? 
-					OR LOWER(%s) LIKE ? 
-					OR LOWER(%s) LIKE ? 
-					OR LOWER(%s) LIKE ? 
-					OR LOWER(%s) LIKE ? 
-					OR LOWER(%s) LIKE ?
-				)`, col, col, col, col, col, col)
-
-				conditions = append(conditions, cond)
-				args = append(args,
-					queryStr,
-					queryStr+",%",
-					"%, "+queryStr,
-					"%, "+queryStr+",%",
-					"%,"+queryStr,      // No space after comma (end)
-					"%,"+queryStr+",%", // No space after comma (middle)
-				)
-			} else {
-				// Space separated (Model Name)
-				cond := fmt.Sprintf("(LOWER(%s) = ? OR LOWER(%s) LIKE ? OR LOWER(%s) LIKE ? OR LOWER(%s) LIKE ?)", col, col, col, col)
-				conditions = append(conditions, cond)
-				args = append(args, queryStr, queryStr+" %", "% "+queryStr, "% "+queryStr+" %")
-			}
-		} else {
-			// Partial match
-			conditions = append(conditions, fmt.Sprintf("LOWER(%s) LIKE ?", col))
-			args = append(args, "%"+queryStr+"%")
+	var filter libraryFilter
+	if strings.TrimSpace(input.Filter) != "" {
+		if err := filter.addQuery(input.Filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		queryStr := strings.ToLower(strings.TrimSpace(input.Query))
+		if queryStr == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Search query cannot be empty"})
+			return
 		}
-	}
-
-	if input.SearchTags {
-		addCondition("versions.tags", true)
-		addCondition("models.tags", true)
-	}
-
-	if input.SearchModelName {
-		addCondition("models.name", false)
-		addCondition("versions.name", false)
-	}
 
-	if input.SearchTrainedWords {
-		addCondition("versions.trained_words", true)
+		if !input.SearchTags && !input.SearchModelName && !input.SearchTrainedWords {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one search criteria must be selected"})
+			return
+		}
+		filter.add(bulkAddCond(queryStr, input.SearchTags, input.SearchModelName, input.SearchTrainedWords, input.ExactMatch))
 	}
 
-	whereClause := strings.Join(conditions, " OR ")
-
+	where := filter.where()
 	query := `
 		INSERT OR IGNORE INTO collection_versions (collection_id, version_id)
 		SELECT ?, versions.id
 		FROM versions
 		JOIN models ON models.id = versions.model_id
-		WHERE ` + whereClause
+		WHERE versions.deleted_at IS NULL AND ` + where.SQL
 
 	// Prepend collectionID to args
-	finalArgs := append([]interface{}{collectionID}, args...)
+	finalArgs := append([]interface{}{collectionID}, where.Args...)
 
 	log.Printf("[BulkAdd] Executing Query: %s", query)
-	log.Printf("[BulkAdd] Args: %v", args) // Don't log collectionID for brevity
+	log.Printf("[BulkAdd] Args: %v", where.Args) // Don't log collectionID for brevity
 
 	result := database.DB.Exec(query, finalArgs...)
 	if result.Error != nil {
@@ -457,3 +404,37 @@ func BulkAddVersions(c *gin.Context) {
 	log.Printf("[BulkAdd] Rows Affected: %d", result.RowsAffected)
 	c.JSON(http.StatusOK, gin.H{"added": result.RowsAffected})
 }
+
+// bulkAddCond matches q against the selected columns. Tags and trained words
+// must equal q in exact mode; model and version names must contain q as a
+// whole word.
+func bulkAddCond(q string, tags, names, words, exact bool) sqlCond {
+	var conds []sqlCond
+	add := func(c sqlCond, _ error) {
+		conds = append(conds, c)
+	}
+	if tags {
+		if exact {
+			add(tagCond("", q))
+		} else {
+			add(tagCond("", "*"+q+"*"))
+		}
+	}
+	if names {
+		if exact {
+			for _, pattern := range []string{q, q + " *", "* " + q, "* " + q + " *"} {
+				add(textCond("", pattern, false, "models.name", "versions.name"))
+			}
+		} else {
+			add(textCond("", q, true, "models.name", "versions.name"))
+		}
+	}
+	if words {
+		if exact {
+			add(listCond("", q, "versions.trained_words"))
+		} else {
+			add(textCond("", q, true, "versions.trained_words"))
+		}
+	}
+	return joinConds(" OR ", conds...)
+}
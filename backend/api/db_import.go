@@ -1,22 +1,85 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"reflect"
+	"slices"
 
 	"model-manager/backend/database"
 	"model-manager/backend/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Merge strategies for records that already exist, chosen with ?strategy=.
+const (
+	ImportSkip      = "skip"
+	ImportOverwrite = "overwrite"
+	ImportFill      = "fill"
+)
+
+// maxImportErrors caps the error lines returned by an import.
+const maxImportErrors = 100
+
+var errImportDryRun = errors.New("dry run")
+
+// modelMergeFields and versionMergeFields are the columns an import may
+// change on an existing record. Tags go through the tag tables, and file
+// check results describe the local disk, so neither is listed.
+var (
+	modelMergeFields = []string{
+		"Name", "Type", "Tags", "Nsfw", "Description", "ImagePath", "FilePath", "ImageWidth", "ImageHeight", "Weight",
+	}
+	versionMergeFields = []string{
+		"Name", "BaseModel", "EarlyAccessTimeFrame", "SizeKB", "TrainedWords", "Nsfw", "Type", "Description", "Mode",
+		"ModelURL", "CivitCreatedAt", "CivitUpdatedAt", "SHA256", "DownloadURL", "ImagePath", "FilePath",
+	}
+)
+
+// ImportCounts tallies what an import did to one kind of record.
+type ImportCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// ImportReport is the response of ImportDatabase.
+type ImportReport struct {
+	Message     string       `json:"message"`
+	Strategy    string       `json:"strategy"`
+	DryRun      bool         `json:"dryRun"`
+	Models      ImportCounts `json:"models"`
+	Versions    ImportCounts `json:"versions"`
+	Collections ImportCounts `json:"collections"`
+	Settings    ImportCounts `json:"settings"`
+	Errors      []string     `json:"errors,omitempty"`
+}
+
 // ImportDatabase ingests a multipart form upload named "file" that contains a
-// JSON export generated by ExportModels. It iterates over each serialized model,
-// resets identifiers, recreates the records in the database, and returns a JSON
-// status. The handler writes new model, version, and image rows and therefore
-// mutates persistent storage as a side effect.
+// JSON export generated by ExportModels, or the bare model array written by
+// older versions. Models and versions are matched on their CivitAI IDs,
+// collections on name and settings on key; ?strategy= decides what happens
+// to a match: "skip" (default) leaves it alone, "overwrite" replaces its
+// fields with the imported ones, "fill" only sets fields that are empty.
+// Records deleted locally are skipped. The import runs in one transaction
+// and each record in a savepoint, so a failing record is counted and rolled
+// back without affecting the others. With ?dryRun=1 the transaction is
+// rolled back and the report previews what the import would do.
 func ImportDatabase(c *gin.Context) {
+	strategy, ok := importStrategy(c)
+	if !ok {
+		return
+	}
+	dryRun := c.Query("dryRun") == "1"
+
 	file, _, err := c.Request.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
@@ -30,25 +93,378 @@ func ImportDatabase(c *gin.Context) {
 		return
 	}
 
-	var modelsList []models.Model
-	if err := json.Unmarshal(data, &modelsList); err != nil {
+	export, err := parseLibraryExport(data)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
 
-	for _, m := range modelsList {
-		// reset IDs so new ones are assigned
-		m.ID = 0
-		for vi := range m.Versions {
-			m.Versions[vi].ID = 0
-			m.Versions[vi].ModelID = 0
-			for ii := range m.Versions[vi].Images {
-				m.Versions[vi].Images[ii].ID = 0
-				m.Versions[vi].Images[ii].VersionID = 0
+	report, err := importLibrary(export, strategy, dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database import failed: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// importStrategy reads and validates the ?strategy= parameter, answering 400
+// when it is invalid.
+func importStrategy(c *gin.Context) (string, bool) {
+	strategy := c.DefaultQuery("strategy", ImportSkip)
+	if strategy != ImportSkip && strategy != ImportOverwrite && strategy != ImportFill {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "strategy must be skip, overwrite or fill"})
+		return "", false
+	}
+	return strategy, true
+}
+
+// importLibrary merges export into the database in one transaction, which a
+// dry run rolls back.
+func importLibrary(export LibraryExport, strategy string, dryRun bool) (ImportReport, error) {
+	report := ImportReport{Strategy: strategy, DryRun: dryRun}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		imp := &libraryImporter{tx: tx, strategy: strategy, report: &report}
+		imp.run(export)
+		if dryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errImportDryRun) {
+		return report, err
+	}
+	if dryRun {
+		report.Message = "dry run, no changes were made"
+	} else {
+		report.Message = "database import complete"
+	}
+	return report, nil
+}
+
+func parseLibraryExport(data []byte) (LibraryExport, error) {
+	var export LibraryExport
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		err := json.Unmarshal(data, &export.Models)
+		return export, err
+	}
+	err := json.Unmarshal(data, &export)
+	return export, err
+}
+
+type libraryImporter struct {
+	tx       *gorm.DB
+	strategy string
+	report   *ImportReport
+}
+
+func (imp *libraryImporter) run(export LibraryExport) {
+	for _, m := range export.Models {
+		imp.record(imp.tx, &imp.report.Models, fmt.Sprintf("model %d (%s)", m.CivitID, m.Name), func(tx *gorm.DB) (importOutcome, error) {
+			return imp.importModel(tx, m)
+		})
+	}
+	for _, coll := range export.Collections {
+		imp.record(imp.tx, &imp.report.Collections, fmt.Sprintf("collection %q", coll.Name), func(tx *gorm.DB) (importOutcome, error) {
+			return imp.importCollection(tx, coll)
+		})
+	}
+	for _, s := range export.Settings {
+		imp.record(imp.tx, &imp.report.Settings, fmt.Sprintf("setting %q", s.Key), func(tx *gorm.DB) (importOutcome, error) {
+			return imp.importSetting(tx, s)
+		})
+	}
+}
+
+type importOutcome int
+
+const (
+	importSkipped importOutcome = iota
+	importCreated
+	importUpdated
+)
+
+// record runs fn in a savepoint of tx and counts its outcome.
+func (imp *libraryImporter) record(tx *gorm.DB, counts *ImportCounts, what string, fn func(tx *gorm.DB) (importOutcome, error)) {
+	var outcome importOutcome
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		var err error
+		outcome, err = fn(tx)
+		return err
+	})
+	if err != nil {
+		counts.Failed++
+		if len(imp.report.Errors) < maxImportErrors {
+			imp.report.Errors = append(imp.report.Errors, fmt.Sprintf("%s: %v", what, err))
+		}
+		return
+	}
+	switch outcome {
+	case importCreated:
+		counts.Created++
+	case importUpdated:
+		counts.Updated++
+	default:
+		counts.Skipped++
+	}
+}
+
+// mergeFields returns the fields of src the strategy copies onto dst: those
+// that differ for overwrite, those empty on dst for fill, none for skip.
+// dst and src are pointers to the same struct type.
+func mergeFields(strategy string, dst, src interface{}, fields []string) []string {
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src).Elem()
+	var changed []string
+	for _, name := range fields {
+		df, sf := d.FieldByName(name), s.FieldByName(name)
+		switch strategy {
+		case ImportOverwrite:
+			if reflect.DeepEqual(df.Interface(), sf.Interface()) {
+				continue
+			}
+		case ImportFill:
+			if !df.IsZero() || sf.IsZero() {
+				continue
+			}
+		default:
+			continue
+		}
+		changed = append(changed, name)
+	}
+	return changed
+}
+
+func (imp *libraryImporter) importModel(tx *gorm.DB, m models.Model) (importOutcome, error) {
+	versions := m.Versions
+	m.Model = gorm.Model{CreatedAt: m.CreatedAt, UpdatedAt: m.UpdatedAt}
+	m.Versions = nil
+
+	var existing models.Model
+	if err := tx.Unscoped().Where("civit_id = ?", m.CivitID).Limit(1).Find(&existing).Error; err != nil {
+		return importSkipped, err
+	}
+	outcome := importSkipped
+	switch {
+	case existing.ID == 0:
+		if err := tx.Create(&m).Error; err != nil {
+			return outcome, err
+		}
+		existing = m
+		outcome = importCreated
+	case existing.DeletedAt.Valid:
+		imp.report.Versions.Skipped += len(versions)
+		return importSkipped, nil
+	default:
+		if fields := mergeFields(imp.strategy, &existing, &m, modelMergeFields); len(fields) > 0 {
+			if err := tx.Model(&existing).Select(fields).Updates(&m).Error; err != nil {
+				return outcome, err
+			}
+			outcome = importUpdated
+		}
+	}
+
+	for _, v := range versions {
+		imp.record(tx, &imp.report.Versions, fmt.Sprintf("version %d (%s)", v.VersionID, v.Name), func(tx *gorm.DB) (importOutcome, error) {
+			return imp.importVersion(tx, existing.ID, v)
+		})
+	}
+	return outcome, nil
+}
+
+func (imp *libraryImporter) importVersion(tx *gorm.DB, modelID uint, v models.Version) (importOutcome, error) {
+	images := v.Images
+	v.Model = gorm.Model{CreatedAt: v.CreatedAt, UpdatedAt: v.UpdatedAt}
+	v.ModelID = modelID
+	v.ParentModel = models.Model{}
+	v.Images = nil
+	v.Collections = nil
+
+	var existing models.Version
+	if err := tx.Unscoped().Where("version_id = ?", v.VersionID).Limit(1).Find(&existing).Error; err != nil {
+		return importSkipped, err
+	}
+	if existing.ID == 0 {
+		if err := tx.Omit(clause.Associations).Create(&v).Error; err != nil {
+			return importSkipped, err
+		}
+		if _, err := importImages(tx, v.ID, images); err != nil {
+			return importSkipped, err
+		}
+		return importCreated, database.SetSyncedTags(tx, v.ID, database.SplitTags(v.Tags))
+	}
+	if existing.DeletedAt.Valid || imp.strategy == ImportSkip {
+		return importSkipped, nil
+	}
+
+	outcome := importSkipped
+	if fields := mergeFields(imp.strategy, &existing, &v, versionMergeFields); len(fields) > 0 {
+		if err := tx.Model(&existing).Select(fields).Updates(&v).Error; err != nil {
+			return outcome, err
+		}
+		outcome = importUpdated
+	}
+	added, err := importImages(tx, existing.ID, images)
+	if err != nil {
+		return outcome, err
+	}
+	if added > 0 {
+		outcome = importUpdated
+	}
+
+	// Imported tags count as synced, so the user's own edits survive an
+	// overwrite. Only a change in the resulting list counts as an update.
+	incoming := database.SplitTags(v.Tags)
+	before := database.SplitTags(existing.Tags)
+	if (imp.strategy == ImportOverwrite && !slices.Equal(before, incoming)) ||
+		(imp.strategy == ImportFill && len(before) == 0 && len(incoming) > 0) {
+		if err := database.SetSyncedTags(tx, existing.ID, incoming); err != nil {
+			return outcome, err
+		}
+		after, err := database.VersionTagNames(tx, existing.ID)
+		if err != nil {
+			return outcome, err
+		}
+		if !slices.Equal(before, after) {
+			outcome = importUpdated
+		}
+	}
+	return outcome, nil
+}
+
+// importImages adds the images whose path the version does not have yet and
+// returns how many it added.
+func importImages(tx *gorm.DB, versionID uint, images []models.VersionImage) (int, error) {
+	var paths []string
+	if err := tx.Model(&models.VersionImage{}).Where("version_id = ?", versionID).Pluck("path", &paths).Error; err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, img := range images {
+		if slices.Contains(paths, img.Path) {
+			continue
+		}
+		img.Model = gorm.Model{CreatedAt: img.CreatedAt, UpdatedAt: img.UpdatedAt}
+		img.VersionID = versionID
+		if err := tx.Create(&img).Error; err != nil {
+			return added, err
+		}
+		paths = append(paths, img.Path)
+		added++
+	}
+	return added, nil
+}
+
+func (imp *libraryImporter) importCollection(tx *gorm.DB, ec ExportedCollection) (importOutcome, error) {
+	if ec.Name == "" {
+		return importSkipped, errors.New("collection has no name")
+	}
+	if ec.Filter != nil {
+		if _, err := smartCollectionFilter(ec.Filter); err != nil {
+			return importSkipped, err
+		}
+	}
+	var members []uint
+	if len(ec.VersionIDs) > 0 {
+		if err := tx.Model(&models.Version{}).Where("version_id IN ?", ec.VersionIDs).Order("id").Pluck("id", &members).Error; err != nil {
+			return importSkipped, err
+		}
+	}
+
+	var existing models.Collection
+	if err := tx.Where("name = ?", ec.Name).Limit(1).Find(&existing).Error; err != nil {
+		return importSkipped, err
+	}
+	if existing.ID == 0 {
+		coll := models.Collection{Name: ec.Name, Description: ec.Description, Filter: ec.Filter}
+		if err := tx.Create(&coll).Error; err != nil {
+			return importSkipped, err
+		}
+		if coll.Filter == nil {
+			return importCreated, addCollectionMembers(tx, coll.ID, members)
+		}
+		return importCreated, nil
+	}
+
+	var current []uint
+	if err := tx.Table("collection_versions").Where("collection_id = ?", existing.ID).Order("version_id").
+		Pluck("version_id", &current).Error; err != nil {
+		return importSkipped, err
+	}
+	updated := existing
+	want := current
+	switch imp.strategy {
+	case ImportOverwrite:
+		updated.Description = ec.Description
+		updated.Filter = ec.Filter
+		want = nil
+		if ec.Filter == nil {
+			want = members
+		}
+	case ImportFill:
+		if updated.Description == "" {
+			updated.Description = ec.Description
+		}
+		// Only an empty static collection can take a filter or members
+		// without changing what it already holds.
+		if updated.Filter == nil && len(current) == 0 {
+			updated.Filter = ec.Filter
+			if ec.Filter == nil {
+				want = members
 			}
 		}
-		database.DB.Create(&m)
+	default:
+		return importSkipped, nil
 	}
+	fieldsChanged := updated.Description != existing.Description || !reflect.DeepEqual(updated.Filter, existing.Filter)
+	membersChanged := !slices.Equal(current, want)
+	if !fieldsChanged && !membersChanged {
+		return importSkipped, nil
+	}
+	if fieldsChanged {
+		if err := tx.Omit(clause.Associations).Save(&updated).Error; err != nil {
+			return importSkipped, err
+		}
+	}
+	if membersChanged {
+		if err := tx.Exec("DELETE FROM collection_versions WHERE collection_id = ?", existing.ID).Error; err != nil {
+			return importSkipped, err
+		}
+		if err := addCollectionMembers(tx, existing.ID, want); err != nil {
+			return importSkipped, err
+		}
+	}
+	return importUpdated, nil
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "database import complete"})
+func addCollectionMembers(tx *gorm.DB, collectionID uint, versionIDs []uint) error {
+	for _, id := range versionIDs {
+		if err := tx.Exec("INSERT OR IGNORE INTO collection_versions (collection_id, version_id) VALUES (?, ?)", collectionID, id).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *libraryImporter) importSetting(tx *gorm.DB, s ExportedSetting) (importOutcome, error) {
+	if s.Key == "" || !exportedSetting(s.Key) {
+		return importSkipped, nil
+	}
+	var existing models.Setting
+	if err := tx.Unscoped().Where(&models.Setting{Key: s.Key}).Limit(1).Find(&existing).Error; err != nil {
+		return importSkipped, err
+	}
+	switch {
+	case existing.ID == 0:
+		return importCreated, tx.Create(&models.Setting{Key: s.Key, Value: s.Value}).Error
+	case existing.DeletedAt.Valid:
+		// A deleted setting is unset, so any strategy may bring it back.
+		existing.DeletedAt = gorm.DeletedAt{}
+		existing.Value = s.Value
+		return importCreated, tx.Unscoped().Save(&existing).Error
+	case imp.strategy == ImportOverwrite && existing.Value != s.Value,
+		imp.strategy == ImportFill && existing.Value == "" && s.Value != "":
+		return importUpdated, tx.Model(&existing).Update("value", s.Value).Error
+	}
+	return importSkipped, nil
 }
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func importDatabase(t *testing.T, query string, body interface{}) ImportReport {
+	t.Helper()
+	buf, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = uploadRequest(t, "/import-db"+query, buf)
+	ImportDatabase(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import%s = %d %s", query, w.Code, w.Body.String())
+	}
+	var report ImportReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	return report
+}
+
+func TestImportDatabaseMerge(t *testing.T) {
+	initTestDB(t)
+	m := models.Model{CivitID: 1, Name: "A", Weight: 1}
+	database.DB.Create(&m)
+	v := models.Version{ModelID: m.ID, VersionID: 101, Name: "v1"}
+	database.DB.Create(&v)
+	database.SetSyncedTags(database.DB, v.ID, []string{"anime"})
+	database.DB.Create(&models.VersionImage{VersionID: v.ID, Path: "a.png"})
+	favs := models.Collection{Name: "Favs", Versions: []models.Version{v}}
+	database.DB.Create(&favs)
+	database.DB.Create(&models.Collection{Name: "SDXL", Filter: &models.CollectionFilter{BaseModel: "SDXL 1.0"}})
+	database.SetSettingValue("download_concurrency", "3")
+	database.SetSettingValue("civitai_api_key", "secret")
+	database.SetSettingValue("model_path", "/srv/models")
+
+	export, err := buildLibraryExport()
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if len(export.Collections) != 2 || len(export.Collections[0].VersionIDs) != 1 || export.Collections[1].Filter == nil {
+		t.Fatalf("exported collections = %+v", export.Collections)
+	}
+	for _, s := range export.Settings {
+		if s.Key == "civitai_api_key" || s.Key == "model_path" {
+			t.Fatalf("%s must not be exported", s.Key)
+		}
+	}
+
+	// Importing an export into the same library changes nothing.
+	report := importDatabase(t, "", export)
+	if report.Models != (ImportCounts{Skipped: 1}) || report.Versions != (ImportCounts{Skipped: 1}) ||
+		report.Collections != (ImportCounts{Skipped: 2}) || report.Settings.Created+report.Settings.Updated != 0 {
+		t.Fatalf("re-import = %+v", report)
+	}
+
+	export.Models[0].Name = "A2"
+	export.Models[0].Description = "desc"
+	ev := &export.Models[0].Versions[0]
+	ev.Name = "v-new"
+	ev.Tags = "anime,style"
+	ev.Images = append(ev.Images, models.VersionImage{Path: "b.png"})
+	export.Models = append(export.Models, models.Model{CivitID: 2, Name: "B", Weight: 1,
+		Versions: []models.Version{{VersionID: 201, Name: "b1", Tags: "character"}}})
+	export.Collections[0].VersionIDs = append(export.Collections[0].VersionIDs, 201)
+	export.Collections = append(export.Collections, ExportedCollection{Description: "no name"})
+	for i := range export.Settings {
+		if export.Settings[i].Key == "download_concurrency" {
+			export.Settings[i].Value = "5"
+		}
+	}
+	// Files written by older versions may still carry local paths.
+	export.Settings = append(export.Settings, ExportedSetting{Key: "model_path", Value: "/elsewhere"})
+
+	modelCount := func() int64 {
+		var n int64
+		database.DB.Model(&models.Model{}).Count(&n)
+		return n
+	}
+	report = importDatabase(t, "?strategy=overwrite&dryRun=1", export)
+	if !report.DryRun || report.Models != (ImportCounts{Created: 1, Updated: 1}) || report.Versions != (ImportCounts{Created: 1, Updated: 1}) {
+		t.Errorf("dry run = %+v", report)
+	}
+	if modelCount() != 1 {
+		t.Fatal("dry run changed the database")
+	}
+
+	// fill only sets what is empty locally.
+	report = importDatabase(t, "?strategy=fill", export)
+	if report.Collections.Failed != 1 || len(report.Errors) != 1 {
+		t.Errorf("fill report = %+v", report)
+	}
+	var got models.Model
+	database.DB.Preload("Versions.Images").First(&got, m.ID)
+	if got.Name != "A" || got.Description != "desc" || got.Versions[0].Name != "v1" ||
+		got.Versions[0].Tags != "anime" || len(got.Versions[0].Images) != 2 {
+		t.Errorf("after fill = %+v", got)
+	}
+	if modelCount() != 2 || database.GetSettingValue("download_concurrency") != "3" {
+		t.Errorf("fill: %d models, concurrency %s", modelCount(), database.GetSettingValue("download_concurrency"))
+	}
+
+	// overwrite replaces fields, synced tags, members and settings.
+	report = importDatabase(t, "?strategy=overwrite", export)
+	if report.Models != (ImportCounts{Updated: 1, Skipped: 1}) || report.Versions != (ImportCounts{Updated: 1, Skipped: 1}) {
+		t.Errorf("overwrite report = %+v", report)
+	}
+	got = models.Model{}
+	database.DB.Preload("Versions").First(&got, m.ID)
+	if got.Name != "A2" || got.Versions[0].Name != "v-new" || got.Versions[0].Tags != "anime,style" {
+		t.Errorf("after overwrite = %+v", got)
+	}
+	var members int64
+	database.DB.Table("collection_versions").Where("collection_id = ?", favs.ID).Count(&members)
+	if members != 2 || database.GetSettingValue("download_concurrency") != "5" {
+		t.Errorf("overwrite: %d members, concurrency %s", members, database.GetSettingValue("download_concurrency"))
+	}
+	if database.GetSettingValue("model_path") != "/srv/models" {
+		t.Errorf("model_path imported as %q", database.GetSettingValue("model_path"))
+	}
+}
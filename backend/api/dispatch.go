@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,7 +15,7 @@ import (
 )
 
 type DispatchRequest struct {
-	Action         string `json:"action"` // "download", "delete"
+	Action         string `json:"action"` // "download", "delete", "sidecar"
 	URL            string `json:"url"`
 	Filename       string `json:"filename"`
 	Subdirectory   string `json:"subdirectory"`
@@ -49,6 +50,16 @@ func DispatchRemote(c *gin.Context) {
 		return
 	}
 
+	client, err := database.GetClient(req.ClientID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load client"})
+		return
+	}
+	if req.Action == "download" && !clientAllowsType(client.Settings, version) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Model type not allowed on this client"})
+		return
+	}
+
 	// Update DB state
 	switch req.Action {
 	case "download":
@@ -62,21 +73,14 @@ func DispatchRemote(c *gin.Context) {
 			}
 		}
 		cf.Status = "pending"
+		cf.BytesDone, cf.BytesTotal, cf.Error = 0, 0, ""
 		database.DB.Save(&cf)
+		publishClientFileStatus(req.ClientID, req.ModelVersionID, cf.Status)
 
-		// Construct clean relative path for URL
-		// We use ParentModel.Type as subdirectory if available, or Version.Type
-		subdir := version.ParentModel.Type
-		if subdir == "" {
-			subdir = version.Type
-		}
-		if subdir == "" {
-			subdir = "Other" // Fallback
-		}
-
-		filename := filepath.Base(version.FilePath)
-		relativePath := filepath.ToSlash(filepath.Join(subdir, filename))
-		req.URL = "/downloads/" + relativePath
+		// The URL names the file on the server, Filename where the client keeps it
+		req.URL = "/downloads/" + filepath.ToSlash(version.FilePath)
+		req.Filename = clientRelativePath(version, client.Settings)
+		req.Subdirectory = ""
 
 		// Check for thumbnail
 		if version.ParentModel.ID > 0 {
@@ -87,7 +91,7 @@ func DispatchRemote(c *gin.Context) {
 			}
 		}
 
-		log.Printf("Dispatching download for model %d to client %s (local_url=%s)", req.ModelVersionID, req.ClientID, req.URL)
+		log.Printf("Dispatching download for model %d to client %s (local_url=%s, filename=%s)", req.ModelVersionID, req.ClientID, req.URL, req.Filename)
 
 	case "delete":
 		// Maybe set to "removing"? Or just let client confirm deletion?
@@ -98,34 +102,48 @@ func DispatchRemote(c *gin.Context) {
 		// "Action is delete: Update ClientFile record to remove the entry"
 		database.DB.Delete(&models.ClientFile{}, "client_id = ? AND model_version_id = ?", req.ClientID, req.ModelVersionID)
 
-		// Use filepath from DB to ensure correct file is deleted
-		subdir := version.ParentModel.Type
-		if subdir == "" {
-			subdir = version.Type
-		}
-		if subdir == "" {
-			subdir = "Other"
-		}
-		filename := filepath.Base(version.FilePath)
 		// Client expects filename to be the relative path
-		req.Filename = filepath.ToSlash(filepath.Join(subdir, filename))
+		req.Filename = clientRelativePath(version, client.Settings)
 		req.Subdirectory = ""
 
 		log.Printf("Dispatching delete for model %d to client %s", req.ModelVersionID, req.ClientID)
 	}
 
-	// Send to WebSocket
-	err := SendToClient(req.ClientID, req)
-	if err != nil {
-		// If sending fails, rollback the pending status to avoid stuck model
-		if req.Action == "download" {
-			database.DB.Delete(&models.ClientFile{}, "client_id = ? AND model_version_id = ? AND status = ?", req.ClientID, req.ModelVersionID, "pending")
-			log.Printf("Rolled back pending status for model %d due to connection error", req.ModelVersionID)
-		}
+	// Send to WebSocket, or queue the command until the client reconnects
+	err = SendToClient(req.ClientID, req)
+	var notConnected *ClientNotFoundError
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"status": "dispatched"})
+		return
+	case errors.Is(err, errUnsupportedCommand):
+		rollbackDispatch(req)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case !errors.As(err, &notConnected):
+		// The write failed on a live connection, which will not recover;
+		// close it so the client reconnects and gets the command replayed.
+		log.Printf("Failed to send %s to client %s, queueing it for replay: %v", req.Action, req.ClientID, err)
+		disconnectClient(req.ClientID)
+	}
 
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Client not connected", "details": err.Error()})
+	cmd, qerr := queueDispatch(req)
+	if qerr == nil {
+		c.JSON(http.StatusAccepted, gin.H{"status": "queued", "command": cmd, "details": err.Error()})
 		return
 	}
+	log.Printf("Failed to queue %s for client %s: %v", req.Action, req.ClientID, qerr)
+	rollbackDispatch(req)
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Client not connected", "details": err.Error()})
+}
 
-	c.JSON(http.StatusOK, gin.H{"status": "dispatched"})
+// rollbackDispatch removes the pending record of a download that could be
+// neither sent nor queued, so the version does not look stuck.
+func rollbackDispatch(req DispatchRequest) {
+	if req.Action != "download" {
+		return
+	}
+	database.DB.Delete(&models.ClientFile{}, "client_id = ? AND model_version_id = ? AND status = ?", req.ClientID, req.ModelVersionID, "pending")
+	publishClientFileStatus(req.ClientID, req.ModelVersionID, "deleted")
+	log.Printf("Rolled back pending status for model %d", req.ModelVersionID)
 }
@@ -0,0 +1,605 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// progressPersistInterval throttles how often running jobs write their byte
+// counters back to the database.
+const progressPersistInterval = 2 * time.Second
+
+// maxDownloadAttempts bounds how many times a job resumes after a dropped
+// connection before it is marked failed. The partial file is kept either way.
+const maxDownloadAttempts = 3
+
+// resumeRetryDelay is the base back-off between resume attempts.
+var resumeRetryDelay = 2 * time.Second
+
+var (
+	errDownloadNotFound  = errors.New("download job not found")
+	errDownloadNotActive = errors.New("download job is not in a state that allows this action")
+	// errDownloadPaused is returned to callers waiting on a job that was
+	// paused. The job stays in the queue and finishes once resumed.
+	errDownloadPaused = errors.New("download job was paused")
+)
+
+// DownloadRequest describes a model file that should be fetched through the
+// download queue. VersionID and Name are informational and let the queue
+// attach the file to its version if the server restarts mid-download.
+type DownloadRequest struct {
+	URL       string
+	DestDir   string
+	Filename  string
+	Name      string
+	VersionID int
+	// SHA256, when set, is compared with the digest of the downloaded bytes.
+	SHA256 string
+}
+
+// HashMismatchError is returned when a downloaded file does not match the
+// SHA256 reported by CivitAI. The file has already been moved to the trash.
+type HashMismatchError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("SHA256 mismatch for %s: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+type downloadResult struct {
+	path string
+	size int64
+	err  error
+}
+
+// activeDownload tracks the in-memory state of a running job.
+type activeDownload struct {
+	cancel     context.CancelFunc
+	stopStatus string
+	startedAt  time.Time
+	downloaded atomic.Int64
+	total      atomic.Int64
+}
+
+// DownloadQueue schedules model downloads persisted as DownloadJob rows. At
+// most database.GetDownloadConcurrency() jobs run at once; the rest wait in
+// position order. Callers that need the result block in Download until the
+// job reaches a terminal state.
+type DownloadQueue struct {
+	mu      sync.Mutex
+	active  map[uint]*activeDownload
+	waiters map[uint][]chan downloadResult
+}
+
+// Downloads is the process-wide download queue.
+var Downloads = NewDownloadQueue()
+
+// NewDownloadQueue returns an empty queue. Jobs are read from and written to
+// database.DB.
+func NewDownloadQueue() *DownloadQueue {
+	return &DownloadQueue{
+		active:  make(map[uint]*activeDownload),
+		waiters: make(map[uint][]chan downloadResult),
+	}
+}
+
+// StartDownloadQueue restores jobs interrupted by a previous shutdown and
+// starts processing whatever is still queued.
+func StartDownloadQueue() {
+	if err := database.RequeueInterruptedDownloads(); err != nil {
+		log.Printf("Warning: Failed to requeue interrupted downloads: %v", err)
+	}
+	Downloads.schedule()
+}
+
+// Enqueue persists a new job at the end of the queue and starts it if a slot
+// is free.
+func (q *DownloadQueue) Enqueue(req DownloadRequest) (models.DownloadJob, error) {
+	q.mu.Lock()
+	job, err := q.insert(req)
+	q.mu.Unlock()
+	if err != nil {
+		return job, err
+	}
+	q.schedule()
+	return job, nil
+}
+
+// Download enqueues req and waits for it to finish. A cancelled job returns
+// context.Canceled and a paused one errDownloadPaused, so callers can tell
+// them apart from a failed transfer.
+func (q *DownloadQueue) Download(req DownloadRequest) (string, int64, error) {
+	// Register the waiter before any scheduler can see the new row.
+	ch := make(chan downloadResult, 1)
+	q.mu.Lock()
+	job, err := q.insert(req)
+	if err != nil {
+		q.mu.Unlock()
+		return "", 0, err
+	}
+	q.waiters[job.ID] = append(q.waiters[job.ID], ch)
+	q.mu.Unlock()
+
+	q.schedule()
+	res := <-ch
+	return res.path, res.size, res.err
+}
+
+// insert adds a job after the last one. Callers hold q.mu so concurrent
+// inserts do not take the same position.
+func (q *DownloadQueue) insert(req DownloadRequest) (models.DownloadJob, error) {
+	var maxPos int
+	database.DB.Model(&models.DownloadJob{}).Select("COALESCE(MAX(position), 0)").Scan(&maxPos)
+
+	name := req.Name
+	if name == "" {
+		name = req.Filename
+	}
+	job := models.DownloadJob{
+		URL:       req.URL,
+		DestDir:   req.DestDir,
+		Filename:  req.Filename,
+		Name:      name,
+		VersionID: req.VersionID,
+		Status:    models.DownloadStatusQueued,
+		Position:  maxPos + 1,
+
+		ExpectedSHA256: strings.ToLower(req.SHA256),
+	}
+	if err := database.DB.Create(&job).Error; err != nil {
+		return job, err
+	}
+	return job, nil
+}
+
+// schedule starts queued jobs until the concurrency limit is reached.
+func (q *DownloadQueue) schedule() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := database.GetDownloadConcurrency()
+	for len(q.active) < limit {
+		var job models.DownloadJob
+		res := database.DB.Where("status = ?", models.DownloadStatusQueued).
+			Order("position ASC, id ASC").Limit(1).Find(&job)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return
+		}
+
+		now := time.Now()
+		job.Status = models.DownloadStatusRunning
+		job.StartedAt = &now
+		job.Error = ""
+		if err := database.DB.Save(&job).Error; err != nil {
+			log.Printf("failed to start download job %d: %v", job.ID, err)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ad := &activeDownload{cancel: cancel, startedAt: now}
+		q.active[job.ID] = ad
+		Events.Publish(EventDownloadStarted, job)
+		go q.run(ctx, job, ad)
+	}
+}
+
+func (q *DownloadQueue) run(ctx context.Context, job models.DownloadJob, ad *activeDownload) {
+	lastPersist := time.Now()
+	var lastEvent time.Time
+	onProgress := func(downloaded, total int64) {
+		ad.downloaded.Store(downloaded)
+		ad.total.Store(total)
+		if time.Since(lastEvent) >= progressEventInterval {
+			lastEvent = time.Now()
+			Events.Publish(EventDownloadProgress, DownloadProgressEvent{
+				ID:         job.ID,
+				VersionID:  job.VersionID,
+				Downloaded: downloaded,
+				Total:      total,
+				Progress:   percent(downloaded, total),
+			})
+		}
+		if time.Since(lastPersist) >= progressPersistInterval {
+			lastPersist = time.Now()
+			database.DB.Model(&models.DownloadJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+				"downloaded": downloaded,
+				"total":      total,
+				"progress":   percent(downloaded, total),
+			})
+		}
+	}
+
+	var path, sum string
+	var size int64
+	var err error
+	for attempt := 1; ; attempt++ {
+		job.Attempts++
+		path, size, sum, err = fetchToFile(ctx, job.URL, job.DestDir, job.Filename, onProgress)
+		if err == nil || ctx.Err() != nil || attempt >= maxDownloadAttempts {
+			break
+		}
+		log.Printf("download job %d attempt %d failed, resuming: %v", job.ID, attempt, err)
+		select {
+		case <-time.After(resumeRetryDelay * time.Duration(attempt)):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		job.SHA256 = sum
+		if job.ExpectedSHA256 != "" && !strings.EqualFold(sum, job.ExpectedSHA256) {
+			err = &HashMismatchError{Path: path, Expected: job.ExpectedSHA256, Actual: sum}
+			quarantineFile(path)
+			path = ""
+		}
+	}
+
+	q.mu.Lock()
+	delete(q.active, job.ID)
+	stopStatus := ad.stopStatus
+	q.mu.Unlock()
+	ad.cancel()
+
+	job.Downloaded = ad.downloaded.Load()
+	job.Total = ad.total.Load()
+	job.Progress = percent(job.Downloaded, job.Total)
+
+	var mismatch *HashMismatchError
+	switch {
+	case errors.As(err, &mismatch):
+		job.Status = models.DownloadStatusFailed
+		job.Error = err.Error()
+		log.Printf("download job %d failed verification: %v", job.ID, err)
+	case err == nil:
+		job.Status = models.DownloadStatusCompleted
+		job.FilePath = path
+		job.Downloaded = size
+		job.Progress = 100
+	case stopStatus == models.DownloadStatusPaused:
+		job.Status = models.DownloadStatusPaused
+	case stopStatus == models.DownloadStatusCancelled || errors.Is(err, context.Canceled):
+		job.Status = models.DownloadStatusCancelled
+		removePartialDownload(job)
+		err = context.Canceled
+	default:
+		job.Status = models.DownloadStatusFailed
+		job.Error = err.Error()
+		log.Printf("download job %d failed: %v", job.ID, err)
+	}
+
+	if job.Status != models.DownloadStatusPaused {
+		now := time.Now()
+		job.FinishedAt = &now
+	}
+	if saveErr := database.DB.Save(&job).Error; saveErr != nil {
+		log.Printf("failed to save download job %d: %v", job.ID, saveErr)
+	}
+	Events.Publish(downloadStatusEvents[job.Status], job)
+
+	if job.Status == models.DownloadStatusPaused {
+		q.release(job.ID, errDownloadPaused)
+	} else {
+		q.finish(job, downloadResult{path: path, size: size, err: err})
+	}
+	q.schedule()
+}
+
+// DownloadProgressEvent is the payload of download.progress events.
+type DownloadProgressEvent struct {
+	ID         uint  `json:"id"`
+	VersionID  int   `json:"versionId"`
+	Downloaded int64 `json:"downloaded"`
+	Total      int64 `json:"total"`
+	Progress   int64 `json:"progress"`
+}
+
+// downloadStatusEvents maps a job's final status to the event announcing it.
+var downloadStatusEvents = map[string]string{
+	models.DownloadStatusCompleted: EventDownloadCompleted,
+	models.DownloadStatusFailed:    EventDownloadFailed,
+	models.DownloadStatusCancelled: EventDownloadCancelled,
+	models.DownloadStatusPaused:    EventDownloadPaused,
+}
+
+// finish hands the result to anyone waiting on the job. Jobs restored after a
+// restart have no waiter, so their file is attached to the version directly.
+func (q *DownloadQueue) finish(job models.DownloadJob, res downloadResult) {
+	q.mu.Lock()
+	chans := q.waiters[job.ID]
+	delete(q.waiters, job.ID)
+	q.mu.Unlock()
+
+	for _, ch := range chans {
+		ch <- res
+	}
+	if len(chans) == 0 && res.err == nil && job.VersionID != 0 {
+		attachDownloadedFile(job)
+	}
+}
+
+// release stops anyone waiting on a job that has not finished.
+func (q *DownloadQueue) release(id uint, err error) {
+	q.mu.Lock()
+	chans := q.waiters[id]
+	delete(q.waiters, id)
+	q.mu.Unlock()
+
+	for _, ch := range chans {
+		ch <- downloadResult{err: err}
+	}
+}
+
+// attachDownloadedFile links a file finished without a waiting caller to the
+// version it was requested for, when that version has no file yet.
+func attachDownloadedFile(job models.DownloadJob) {
+	rel := MakeRelativePath(job.FilePath, database.GetModelPath())
+	res := database.DB.Model(&models.Version{}).
+		Where("version_id = ? AND (file_path = '' OR file_path IS NULL)", job.VersionID).
+		Update("file_path", rel)
+	if res.Error != nil {
+		log.Printf("failed to attach download job %d to version %d: %v", job.ID, job.VersionID, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		log.Printf("download job %d finished without a waiting version record: %s", job.ID, job.FilePath)
+	}
+}
+
+// quarantineFile moves a file that failed verification to the trash, or
+// deletes it when the trash is unavailable so it is never used as a model.
+func quarantineFile(path string) {
+	if err := moveToTrash(path); err != nil {
+		log.Printf("failed to move %s to trash, removing it: %v", path, err)
+		os.Remove(path)
+	}
+}
+
+func removePartialDownload(job models.DownloadJob) {
+	removePartialFile(filepath.Join(job.DestDir, job.Filename))
+}
+
+// Cancel stops a queued, paused or running job and removes its partial file.
+func (q *DownloadQueue) Cancel(id uint) error {
+	q.mu.Lock()
+	if ad, ok := q.active[id]; ok {
+		ad.stopStatus = models.DownloadStatusCancelled
+		q.mu.Unlock()
+		ad.cancel()
+		return nil
+	}
+	q.mu.Unlock()
+
+	var job models.DownloadJob
+	if err := database.DB.First(&job, id).Error; err != nil {
+		return errDownloadNotFound
+	}
+	if job.Status != models.DownloadStatusQueued && job.Status != models.DownloadStatusPaused {
+		return errDownloadNotActive
+	}
+	now := time.Now()
+	job.Status = models.DownloadStatusCancelled
+	job.FinishedAt = &now
+	if err := database.DB.Save(&job).Error; err != nil {
+		return err
+	}
+	removePartialDownload(job)
+	Events.Publish(EventDownloadCancelled, job)
+	q.finish(job, downloadResult{err: context.Canceled})
+	return nil
+}
+
+// CancelAll cancels every running job and reports how many were stopped.
+func (q *DownloadQueue) CancelAll() int {
+	q.mu.Lock()
+	ids := make([]uint, 0, len(q.active))
+	for id := range q.active {
+		ids = append(ids, id)
+	}
+	q.mu.Unlock()
+
+	for _, id := range ids {
+		q.Cancel(id)
+	}
+	return len(ids)
+}
+
+// Pause stops a running job or holds a queued one without discarding it.
+func (q *DownloadQueue) Pause(id uint) error {
+	q.mu.Lock()
+	if ad, ok := q.active[id]; ok {
+		ad.stopStatus = models.DownloadStatusPaused
+		q.mu.Unlock()
+		ad.cancel()
+		return nil
+	}
+	q.mu.Unlock()
+
+	res := database.DB.Model(&models.DownloadJob{}).
+		Where("id = ? AND status = ?", id, models.DownloadStatusQueued).
+		Update("status", models.DownloadStatusPaused)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return q.missingOr(id, errDownloadNotActive)
+	}
+	q.release(id, errDownloadPaused)
+	return nil
+}
+
+// Resume puts a paused job back into the queue.
+func (q *DownloadQueue) Resume(id uint) error {
+	res := database.DB.Model(&models.DownloadJob{}).
+		Where("id = ? AND status = ?", id, models.DownloadStatusPaused).
+		Update("status", models.DownloadStatusQueued)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return q.missingOr(id, errDownloadNotActive)
+	}
+	q.schedule()
+	return nil
+}
+
+// Retry requeues a failed job. Bytes already on disk are reused when the
+// server supports range requests.
+func (q *DownloadQueue) Retry(id uint) error {
+	res := database.DB.Model(&models.DownloadJob{}).
+		Where("id = ? AND status = ?", id, models.DownloadStatusFailed).
+		Updates(map[string]interface{}{"status": models.DownloadStatusQueued, "error": "", "finished_at": nil})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return q.missingOr(id, errDownloadNotActive)
+	}
+	q.schedule()
+	return nil
+}
+
+// Move places a queued or paused job at the given zero-based index among the
+// waiting jobs and renumbers the rest.
+func (q *DownloadQueue) Move(id uint, index int) error {
+	var waiting []models.DownloadJob
+	if err := database.DB.Where("status IN ?", []string{models.DownloadStatusQueued, models.DownloadStatusPaused}).
+		Order("position ASC, id ASC").Find(&waiting).Error; err != nil {
+		return err
+	}
+
+	from := -1
+	for i, j := range waiting {
+		if j.ID == id {
+			from = i
+			break
+		}
+	}
+	if from == -1 {
+		return q.missingOr(id, errDownloadNotActive)
+	}
+
+	job := waiting[from]
+	waiting = append(waiting[:from], waiting[from+1:]...)
+	if index < 0 {
+		index = 0
+	}
+	if index > len(waiting) {
+		index = len(waiting)
+	}
+	waiting = append(waiting[:index], append([]models.DownloadJob{job}, waiting[index:]...)...)
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for i, j := range waiting {
+			if err := tx.Model(&models.DownloadJob{}).Where("id = ?", j.ID).Update("position", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	q.schedule()
+	return nil
+}
+
+// missingOr returns errDownloadNotFound when id does not exist, otherwise err.
+func (q *DownloadQueue) missingOr(id uint, err error) error {
+	var count int64
+	database.DB.Model(&models.DownloadJob{}).Where("id = ?", id).Count(&count)
+	if count == 0 {
+		return errDownloadNotFound
+	}
+	return err
+}
+
+// Jobs lists persisted jobs, optionally restricted to a status, with live
+// progress filled in for running jobs.
+func (q *DownloadQueue) Jobs(status string) ([]models.DownloadJob, error) {
+	jobs := make([]models.DownloadJob, 0)
+	db := database.DB.Model(&models.DownloadJob{})
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if err := db.Order("position ASC, id ASC").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	for i := range jobs {
+		q.overlay(&jobs[i])
+	}
+	return jobs, nil
+}
+
+// Job returns a single job with live progress applied.
+func (q *DownloadQueue) Job(id uint) (models.DownloadJob, error) {
+	var job models.DownloadJob
+	if err := database.DB.First(&job, id).Error; err != nil {
+		return job, errDownloadNotFound
+	}
+	q.overlay(&job)
+	return job, nil
+}
+
+func (q *DownloadQueue) overlay(job *models.DownloadJob) {
+	q.mu.Lock()
+	ad, ok := q.active[job.ID]
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+	job.Downloaded = ad.downloaded.Load()
+	job.Total = ad.total.Load()
+	job.Progress = percent(job.Downloaded, job.Total)
+}
+
+// LatestProgress reports the progress of the most recently started running
+// job, or 0 when nothing is downloading.
+func (q *DownloadQueue) LatestProgress() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var latest *activeDownload
+	for _, ad := range q.active {
+		if latest == nil || ad.startedAt.After(latest.startedAt) {
+			latest = ad
+		}
+	}
+	if latest == nil {
+		return 0
+	}
+	return percent(latest.downloaded.Load(), latest.total.Load())
+}
+
+// ClearFinished deletes completed, failed and cancelled jobs from the history.
+func (q *DownloadQueue) ClearFinished() (int64, error) {
+	res := database.DB.Unscoped().
+		Where("status IN ?", []string{models.DownloadStatusCompleted, models.DownloadStatusFailed, models.DownloadStatusCancelled}).
+		Delete(&models.DownloadJob{})
+	return res.RowsAffected, res.Error
+}
+
+func percent(done, total int64) int64 {
+	if total <= 0 {
+		return 0
+	}
+	return done * 100 / total
+}
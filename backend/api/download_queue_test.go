@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+// newBlockingServer serves a body in two halves and waits for release (or the
+// client going away) between them, so tests can observe running jobs.
+func newBlockingServer(t *testing.T, body []byte) (*httptest.Server, chan struct{}) {
+	t.Helper()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		half := len(body) / 2
+		w.Write(body[:half])
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write(body[half:])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, release
+}
+
+func waitForJobStatus(t *testing.T, id uint, status string) models.DownloadJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := Downloads.Job(id)
+		if err == nil && job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d status = %q, want %q", id, job.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDownloadQueueConcurrencyLimit(t *testing.T) {
+	initTestDB(t)
+	database.SetSettingValue("download_concurrency", "1")
+	srv, release := newBlockingServer(t, []byte("0123456789"))
+	dir := t.TempDir()
+
+	first, err := Downloads.Enqueue(DownloadRequest{URL: srv.URL, DestDir: dir, Filename: "a.bin"})
+	if err != nil {
+		t.Fatalf("enqueue first: %v", err)
+	}
+	second, err := Downloads.Enqueue(DownloadRequest{URL: srv.URL, DestDir: dir, Filename: "b.bin"})
+	if err != nil {
+		t.Fatalf("enqueue second: %v", err)
+	}
+
+	waitForJobStatus(t, first.ID, models.DownloadStatusRunning)
+	if job, _ := Downloads.Job(second.ID); job.Status != models.DownloadStatusQueued {
+		t.Fatalf("second job status = %q, want queued while first runs", job.Status)
+	}
+
+	close(release)
+	waitForJobStatus(t, first.ID, models.DownloadStatusCompleted)
+	done := waitForJobStatus(t, second.ID, models.DownloadStatusCompleted)
+	if done.Progress != 100 || done.FilePath != filepath.Join(dir, "b.bin") {
+		t.Errorf("second job = %+v", done)
+	}
+}
+
+func TestDownloadQueueCancelRunning(t *testing.T) {
+	initTestDB(t)
+	srv, _ := newBlockingServer(t, []byte("0123456789"))
+	dir := t.TempDir()
+
+	type result struct {
+		path string
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		path, _, err := Downloads.Download(DownloadRequest{URL: srv.URL, DestDir: dir, Filename: "c.bin", VersionID: 7})
+		resCh <- result{path, err}
+	}()
+
+	var job models.DownloadJob
+	deadline := time.Now().Add(5 * time.Second)
+	for job.ID == 0 {
+		database.DB.Where("version_id = ?", 7).Limit(1).Find(&job)
+		if time.Now().After(deadline) {
+			t.Fatal("job was not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForJobStatus(t, job.ID, models.DownloadStatusRunning)
+
+	if err := Downloads.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	res := <-resCh
+	if !errors.Is(res.err, context.Canceled) {
+		t.Fatalf("Download err = %v, want context.Canceled", res.err)
+	}
+	waitForJobStatus(t, job.ID, models.DownloadStatusCancelled)
+	if _, err := os.Stat(filepath.Join(dir, "c.bin")); !os.IsNotExist(err) {
+		t.Errorf("partial file still present: %v", err)
+	}
+}
+
+func TestDownloadQueuePauseResume(t *testing.T) {
+	initTestDB(t)
+	srv, release := newBlockingServer(t, []byte("0123456789"))
+	dir := t.TempDir()
+
+	job, err := Downloads.Enqueue(DownloadRequest{URL: srv.URL, DestDir: dir, Filename: "d.bin"})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	waitForJobStatus(t, job.ID, models.DownloadStatusRunning)
+
+	if err := Downloads.Pause(job.ID); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	waitForJobStatus(t, job.ID, models.DownloadStatusPaused)
+	if err := Downloads.Pause(job.ID); !errors.Is(err, errDownloadNotActive) {
+		t.Errorf("second Pause err = %v, want errDownloadNotActive", err)
+	}
+
+	close(release)
+	if err := Downloads.Resume(job.ID); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	waitForJobStatus(t, job.ID, models.DownloadStatusCompleted)
+	data, err := os.ReadFile(filepath.Join(dir, "d.bin"))
+	if err != nil || string(data) != "0123456789" {
+		t.Fatalf("file = %q, %v", data, err)
+	}
+}
+
+func TestDownloadQueueMove(t *testing.T) {
+	initTestDB(t)
+	for i, name := range []string{"a", "b", "c"} {
+		database.DB.Create(&models.DownloadJob{Name: name, Status: models.DownloadStatusPaused, Position: i + 1})
+	}
+
+	var c models.DownloadJob
+	database.DB.Where("name = ?", "c").First(&c)
+	if err := Downloads.Move(c.ID, 0); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+
+	jobs, err := Downloads.Jobs("")
+	if err != nil {
+		t.Fatalf("Jobs: %v", err)
+	}
+	var order string
+	for _, j := range jobs {
+		order += j.Name
+	}
+	if order != "cab" {
+		t.Errorf("order = %q, want cab", order)
+	}
+
+	if err := Downloads.Move(9999, 0); !errors.Is(err, errDownloadNotFound) {
+		t.Errorf("Move missing err = %v, want errDownloadNotFound", err)
+	}
+}
+
+func TestStartDownloadQueueRestoresInterruptedJobs(t *testing.T) {
+	initTestDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("model-bytes"))
+	}))
+	defer srv.Close()
+	dir := filepath.Join(t.TempDir(), "downloads")
+	database.SetSettingValue("model_path", dir)
+
+	m := models.Model{CivitID: 1, Name: "m", Weight: 1}
+	database.DB.Create(&m)
+	database.DB.Create(&models.Version{ModelID: m.ID, VersionID: 55, Name: "v"})
+	job := models.DownloadJob{URL: srv.URL, DestDir: dir, Filename: "e.bin", VersionID: 55, Status: models.DownloadStatusRunning, Position: 1}
+	database.DB.Create(&job)
+
+	StartDownloadQueue()
+	waitForJobStatus(t, job.ID, models.DownloadStatusCompleted)
+
+	// The file is attached right after the job row is saved.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var v models.Version
+		database.DB.Where("version_id = ?", 55).First(&v)
+		if v.FilePath == "e.bin" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("version file path = %q, want e.bin", v.FilePath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDownloadQueuePauseReleasesWaiter(t *testing.T) {
+	initTestDB(t)
+	srv, release := newBlockingServer(t, []byte("0123456789"))
+	defer close(release)
+	dir := t.TempDir()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := Downloads.Download(DownloadRequest{URL: srv.URL, DestDir: dir, Filename: "w.bin"})
+		done <- err
+	}()
+	var job models.DownloadJob
+	deadline := time.Now().Add(5 * time.Second)
+	for job.ID == 0 && time.Now().Before(deadline) {
+		jobs, _ := Downloads.Jobs(models.DownloadStatusRunning)
+		if len(jobs) == 1 {
+			job = jobs[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.ID == 0 {
+		t.Fatal("job never started")
+	}
+
+	if err := Downloads.Pause(job.ID); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, errDownloadPaused) {
+			t.Errorf("Download err = %v, want errDownloadPaused", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Download still blocked after pause")
+	}
+	waitForJobStatus(t, job.ID, models.DownloadStatusPaused)
+}
+
+func TestDownloadQueueConcurrentEnqueuePositions(t *testing.T) {
+	initTestDB(t)
+	database.SetSettingValue("download_concurrency", "1")
+	srv, release := newBlockingServer(t, []byte("0123456789"))
+	defer close(release)
+	dir := t.TempDir()
+
+	const n = 10
+	jobs := make(chan models.DownloadJob, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			job, err := Downloads.Enqueue(DownloadRequest{URL: srv.URL, DestDir: dir, Filename: strconv.Itoa(i) + ".bin"})
+			if err != nil {
+				t.Errorf("enqueue: %v", err)
+			}
+			jobs <- job
+		}(i)
+	}
+	seen := map[int]bool{}
+	for i := 0; i < n; i++ {
+		job := <-jobs
+		if seen[job.Position] {
+			t.Errorf("position %d taken twice", job.Position)
+		}
+		seen[job.Position] = true
+		defer func(id uint) {
+			Downloads.Cancel(id)
+			waitForJobStatus(t, id, models.DownloadStatusCancelled)
+		}(job.ID)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
@@ -14,130 +15,189 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
-)
-
-var CurrentDownloadProgress int64
-
-var (
-	downloadMu            sync.Mutex
-	currentDownloadCancel context.CancelFunc
-	currentDownloadPath   string
-	currentDownloadToken  *struct{}
 )
 
 // DownloadFile streams the content at url into destDir/filename. The caller
 // must supply a destination directory and filename; the handler ensures the
-// directory exists, injects the CivitAI token when available, updates the
-// package-level CurrentDownloadProgress, and returns the absolute path and
-// number of bytes written. It performs filesystem writes as a side effect.
+// directory exists, injects the CivitAI token when available, and returns the
+// absolute path and number of bytes written. Downloads into the model library
+// are routed through the Downloads queue so they get a job ID, progress and
+// cancellation; other files (images, archives) are fetched directly.
 func DownloadFile(url, destDir, filename string) (string, int64, error) {
-	apiToken := getCivitaiAPIKey()
-	log.Printf("Downloading %s", url)
-
-	fullPath := filepath.Join(destDir, filename)
-	absPath, err := filepath.Abs(fullPath)
+	if isModelDownloadDir(destDir) {
+		return Downloads.Download(DownloadRequest{URL: url, DestDir: destDir, Filename: filename})
+	}
+	path, size, _, err := fetchToFile(context.Background(), url, destDir, filename, nil)
 	if err != nil {
-		return "", 0, err
+		// Only queued model downloads are worth resuming later.
+		removePartialFile(filepath.Join(destDir, filename))
 	}
+	return path, size, err
+}
 
-	// Determine if this is a model download by checking if it matches the configured model path
+// isModelDownloadDir reports whether destDir lives under the configured model
+// path, falling back to a "downloads" name check when resolution is odd.
+func isModelDownloadDir(destDir string) bool {
 	modelPath, _ := filepath.Abs(database.GetModelPath())
 	destAbs, _ := filepath.Abs(destDir)
-	// Simple check: is destAbs inside modelPath?
-	// or just check if it contains the model path root
-	isModelDownload := strings.HasPrefix(strings.ToLower(destAbs), strings.ToLower(modelPath))
+	if strings.HasPrefix(strings.ToLower(destAbs), strings.ToLower(modelPath)) {
+		return true
+	}
+	return strings.Contains(strings.ToLower(destDir), "downloads")
+}
 
-	// Fallback to "downloads" check if path resolution fails or is weird
-	if !isModelDownload {
-		isModelDownload = strings.Contains(strings.ToLower(destDir), "downloads")
+// fetchToFile performs the HTTP transfer for DownloadFile and the download
+// queue. Bytes are written to "<filename>.part" and renamed into place once the
+// body is complete. When a partial file from an earlier attempt exists and the
+// server supports ranges, the transfer resumes with a Range request guarded by
+// If-Range; a server that ignores the range or reports a changed resource
+// causes a clean restart from byte zero. onProgress, when set, receives the
+// bytes on disk so far and the expected total (0 if unknown) after every chunk.
+// The returned digest is the hex SHA-256 of the complete file, computed while
+// streaming so large checkpoints are not read twice.
+func fetchToFile(ctx context.Context, url, destDir, filename string, onProgress func(downloaded, total int64)) (string, int64, string, error) {
+	apiToken := getCivitaiAPIKey()
+
+	absPath, err := filepath.Abs(filepath.Join(destDir, filename))
+	if err != nil {
+		return "", 0, "", err
+	}
+	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return "", 0, "", err
 	}
+	partPath := absPath + partSuffix
 
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if isModelDownload {
-		ctx, cancel = context.WithCancel(context.Background())
+	offset, meta := resumableOffset(partPath, url)
+	if offset > 0 {
+		log.Printf("Resuming %s at byte %d", url, offset)
+	} else {
+		log.Printf("Downloading %s", url)
 	}
-	defer func() {
-		if cancel != nil {
-			cancel()
-		}
-	}()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", 0, err
-	}
-	var downloadToken *struct{}
-	if isModelDownload {
-		downloadToken = &struct{}{}
-		downloadMu.Lock()
-		currentDownloadCancel = cancel
-		currentDownloadPath = absPath
-		currentDownloadToken = downloadToken
-		CurrentDownloadProgress = 0
-		downloadMu.Unlock()
+		return "", 0, "", err
 	}
 	if apiToken != "" {
 		req.Header.Add("Authorization", "Bearer "+apiToken)
 	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator := meta.validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", 0, err
+		return "", 0, "", err
 	}
 	defer resp.Body.Close()
 
-	os.MkdirAll(destDir, os.ModePerm)
+	var total int64
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			// The server answered a different range than requested; the
+			// partial bytes cannot be trusted, so start over.
+			removePartialFile(absPath)
+			resp.Body.Close()
+			return fetchToFile(ctx, url, destDir, filename, onProgress)
+		}
+		total = size
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// Either the partial file already holds every byte or it is larger
+		// than the current resource. Only the first case can be finished.
+		if _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && size == offset {
+			sum, err := FileHash(partPath)
+			if err != nil {
+				return "", 0, "", err
+			}
+			if err := os.Rename(partPath, absPath); err != nil {
+				return "", 0, "", err
+			}
+			os.Remove(partPath + partMetaSuffix)
+			return absPath, offset, sum, nil
+		}
+		removePartialFile(absPath)
+		resp.Body.Close()
+		return fetchToFile(ctx, url, destDir, filename, onProgress)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// Full body: either a fresh download or the server declined to resume.
+		if offset > 0 {
+			log.Printf("Server ignored range for %s; restarting download", url)
+		}
+		offset = 0
+		total = resp.ContentLength
+		flags |= os.O_TRUNC
+		writePartMeta(partPath, partMeta{
+			URL:          url,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			AcceptRanges: strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes"),
+		})
+	default:
+		return "", 0, "", fmt.Errorf("unexpected status %s downloading %s", resp.Status, url)
+	}
+	if total < 0 {
+		total = 0
+	}
 
-	out, err := os.Create(absPath)
-	if err != nil {
-		return "", 0, err
+	hasher := sha256.New()
+	if offset > 0 {
+		// Seed the digest with the bytes kept from the earlier attempt.
+		if err := hashInto(hasher, partPath, offset); err != nil {
+			return "", 0, "", err
+		}
 	}
-	defer out.Close()
 
-	if isModelDownload {
-		defer func() {
-			downloadMu.Lock()
-			if currentDownloadToken == downloadToken {
-				currentDownloadCancel = nil
-				currentDownloadPath = ""
-				currentDownloadToken = nil
-			}
-			downloadMu.Unlock()
-			if cancel != nil {
-				cancel()
-			}
-		}()
+	out, err := os.OpenFile(partPath, flags, 0o644)
+	if err != nil {
+		return "", 0, "", err
 	}
+	w := io.MultiWriter(out, hasher)
 
-	total := resp.ContentLength
 	buf := make([]byte, 32*1024)
-	var downloaded int64
+	downloaded := offset
+	if onProgress != nil {
+		onProgress(downloaded, total)
+	}
 	for {
 		n, err := resp.Body.Read(buf)
 		if n > 0 {
-			if _, werr := out.Write(buf[:n]); werr != nil {
-				return "", 0, werr
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				out.Close()
+				return "", 0, "", werr
 			}
 			downloaded += int64(n)
-			if isModelDownload && total > 0 {
-				CurrentDownloadProgress = downloaded * 100 / total
+			if onProgress != nil {
+				onProgress(downloaded, total)
 			}
 		}
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return "", 0, err
+			out.Close()
+			return "", 0, "", err
 		}
 	}
+	if err := out.Close(); err != nil {
+		return "", 0, "", err
+	}
+	if total > 0 && downloaded < total {
+		return "", 0, "", fmt.Errorf("download of %s ended early: %d of %d bytes", url, downloaded, total)
+	}
 
-	if isModelDownload {
-		CurrentDownloadProgress = 100
+	if err := os.Rename(partPath, absPath); err != nil {
+		return "", 0, "", err
 	}
+	os.Remove(partPath + partMetaSuffix)
 
-	return absPath, downloaded, nil
+	return absPath, downloaded, hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
 // GetImageDimensions opens the image at path and returns its width and height
@@ -174,6 +234,17 @@ func FileHash(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
+// hashInto feeds the first n bytes of the file at path into h.
+func hashInto(h io.Writer, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(h, f, n)
+	return err
+}
+
 // isVideoURL returns true if the provided URL points to a video file.
 // It checks the file extension against a list of common video formats
 // and is used to skip downloading preview videos from CivitAI.
@@ -189,29 +260,3 @@ func isVideoURL(u string) bool {
 	}
 	return false
 }
-
-// CancelActiveDownload cancels the current model download if one is in progress.
-// It stops the HTTP request, resets the progress indicator, and removes any
-// partially written file from disk.
-func CancelActiveDownload() (bool, error) {
-	downloadMu.Lock()
-	cancel := currentDownloadCancel
-	path := currentDownloadPath
-	currentDownloadCancel = nil
-	currentDownloadPath = ""
-	currentDownloadToken = nil
-	downloadMu.Unlock()
-
-	if cancel == nil {
-		return false, nil
-	}
-
-	cancel()
-	CurrentDownloadProgress = 0
-	if path != "" {
-		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-			return true, err
-		}
-	}
-	return true, nil
-}
@@ -2,8 +2,10 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"image"
 	"image/png"
 	"net/http"
@@ -11,9 +13,14 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"bou.ke/monkey"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
 )
 
 func TestDownloadFileAndHelpers(t *testing.T) {
@@ -41,6 +48,7 @@ func TestDownloadFileAndHelpers(t *testing.T) {
 	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
+	initTestDB(t)
 	patch := monkey.Patch(getCivitaiAPIKey, func() string { return "token" })
 	defer patch.Unpatch()
 
@@ -60,8 +68,12 @@ func TestDownloadFileAndHelpers(t *testing.T) {
 	if !bytes.Equal(data, fileContent) {
 		t.Errorf("downloaded content mismatch: %q != %q", data, fileContent)
 	}
-	if CurrentDownloadProgress != 100 {
-		t.Errorf("progress = %d, want 100", CurrentDownloadProgress)
+	var job models.DownloadJob
+	if err := database.DB.Where("filename = ?", "test.txt").First(&job).Error; err != nil {
+		t.Fatalf("download job not recorded: %v", err)
+	}
+	if job.Status != models.DownloadStatusCompleted || job.Progress != 100 {
+		t.Errorf("job = %s/%d, want completed/100", job.Status, job.Progress)
 	}
 	if fileAuth != "Bearer token" {
 		t.Errorf("authorization header = %q", fileAuth)
@@ -111,3 +123,200 @@ func TestIsVideoURL(t *testing.T) {
 		}
 	}
 }
+
+// newRangeServer serves body with http.ServeContent, which honours Range and
+// If-Range, and records the Range header of every request.
+func newRangeServer(t *testing.T, body []byte, etag string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "model.safetensors", time.Time{}, bytes.NewReader(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &ranges
+}
+
+func seedPartial(t *testing.T, dir, filename string, data []byte, meta partMeta) {
+	t.Helper()
+	part := filepath.Join(dir, filename) + partSuffix
+	if err := os.WriteFile(part, data, 0o644); err != nil {
+		t.Fatalf("write part: %v", err)
+	}
+	if err := writePartMeta(part, meta); err != nil {
+		t.Fatalf("write meta: %v", err)
+	}
+}
+
+func TestFetchToFileResumesPartial(t *testing.T) {
+	initTestDB(t)
+	body := []byte("0123456789abcdefghij")
+	srv, ranges := newRangeServer(t, body, `"v1"`)
+	dir := t.TempDir()
+	seedPartial(t, dir, "m.bin", body[:8], partMeta{URL: srv.URL, ETag: `"v1"`, AcceptRanges: true})
+
+	var lastTotal int64
+	path, size, sum, err := fetchToFile(context.Background(), srv.URL, dir, "m.bin", func(_, total int64) { lastTotal = total })
+	if err != nil {
+		t.Fatalf("fetchToFile: %v", err)
+	}
+	if got := (*ranges)[0]; got != "bytes=8-" {
+		t.Errorf("Range header = %q, want bytes=8-", got)
+	}
+	// The digest must cover the bytes already on disk, not just the tail.
+	want := sha256.Sum256(body)
+	if sum != hex.EncodeToString(want[:]) {
+		t.Errorf("sha256 = %s, want %x", sum, want)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, body) || size != int64(len(body)) || lastTotal != int64(len(body)) {
+		t.Errorf("content = %q size = %d total = %d", data, size, lastTotal)
+	}
+	if _, err := os.Stat(path + partSuffix); !os.IsNotExist(err) {
+		t.Errorf("part file left behind: %v", err)
+	}
+	if _, err := os.Stat(path + partSuffix + partMetaSuffix); !os.IsNotExist(err) {
+		t.Errorf("meta file left behind: %v", err)
+	}
+}
+
+func TestFetchToFileRestartsWhenResourceChanged(t *testing.T) {
+	initTestDB(t)
+	body := []byte("new-content-entirely")
+	srv, _ := newRangeServer(t, body, `"v2"`)
+	dir := t.TempDir()
+	seedPartial(t, dir, "m.bin", []byte("old-par"), partMeta{URL: srv.URL, ETag: `"v1"`, AcceptRanges: true})
+
+	path, _, _, err := fetchToFile(context.Background(), srv.URL, dir, "m.bin", nil)
+	if err != nil {
+		t.Fatalf("fetchToFile: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, body) {
+		t.Errorf("content = %q, want %q", data, body)
+	}
+}
+
+func TestFetchToFileServerWithoutRanges(t *testing.T) {
+	initTestDB(t)
+	body := []byte("full-body-every-time")
+	var gotRange string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write(body)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	seedPartial(t, dir, "m.bin", body[:4], partMeta{URL: srv.URL, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"})
+
+	path, _, _, err := fetchToFile(context.Background(), srv.URL, dir, "m.bin", nil)
+	if err != nil {
+		t.Fatalf("fetchToFile: %v", err)
+	}
+	if gotRange != "bytes=4-" {
+		t.Errorf("Range header = %q, want bytes=4-", gotRange)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, body) {
+		t.Errorf("content = %q, want %q", data, body)
+	}
+}
+
+func TestFetchToFileCompletePartial(t *testing.T) {
+	initTestDB(t)
+	body := []byte("0123456789")
+	srv, _ := newRangeServer(t, body, `"v1"`)
+	dir := t.TempDir()
+	seedPartial(t, dir, "m.bin", body, partMeta{URL: srv.URL, ETag: `"v1"`, AcceptRanges: true})
+
+	path, size, _, err := fetchToFile(context.Background(), srv.URL, dir, "m.bin", nil)
+	if err != nil {
+		t.Fatalf("fetchToFile: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, body) || size != int64(len(body)) {
+		t.Errorf("content = %q size = %d", data, size)
+	}
+}
+
+func TestFetchToFileHTTPError(t *testing.T) {
+	initTestDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+
+	if _, _, _, err := fetchToFile(context.Background(), srv.URL, dir, "m.bin", nil); err == nil {
+		t.Fatal("expected error for 403 response")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "m.bin")); !os.IsNotExist(err) {
+		t.Errorf("error body written to destination: %v", err)
+	}
+}
+
+func TestDownloadQueueResumesDroppedConnection(t *testing.T) {
+	initTestDB(t)
+	orig := resumeRetryDelay
+	resumeRetryDelay = 0
+	defer func() { resumeRetryDelay = orig }()
+
+	body := []byte("0123456789abcdefghij")
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if len(requests) == 1 {
+			// Promise the full body, send half, then drop the connection.
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body[:10])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "model.safetensors", time.Time{}, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+
+	path, size, err := Downloads.Download(DownloadRequest{URL: srv.URL, DestDir: dir, Filename: "r.bin"})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, body) || size != int64(len(body)) {
+		t.Errorf("content = %q size = %d", data, size)
+	}
+	if len(requests) != 2 || requests[1] != "bytes=10-" {
+		t.Errorf("requests = %q, want second request to resume at byte 10", requests)
+	}
+}
+
+func TestDownloadQueueRejectsHashMismatch(t *testing.T) {
+	initTestDB(t)
+	t.Setenv("HOME", t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered"))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+
+	_, _, err := Downloads.Download(DownloadRequest{URL: srv.URL, DestDir: dir, Filename: "h.bin", VersionID: 77, SHA256: strings.Repeat("ab", 32)})
+	var mismatch *HashMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Download err = %v, want HashMismatchError", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "h.bin")); !os.IsNotExist(err) {
+		t.Errorf("mismatched file left in library: %v", err)
+	}
+
+	var job models.DownloadJob
+	database.DB.Where("version_id = ?", 77).First(&job)
+	if job.Status != models.DownloadStatusFailed || job.Attempts != 1 || job.SHA256 != mismatch.Actual {
+		t.Errorf("job = %+v", job)
+	}
+}
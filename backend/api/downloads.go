@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListDownloads returns every job in the download queue in queue order. The
+// optional status query parameter restricts the list to one state (queued,
+// running, paused, completed, failed, cancelled).
+func ListDownloads(c *gin.Context) {
+	jobs, err := Downloads.Jobs(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load downloads"})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetDownload returns the job identified by the :id path parameter including
+// live byte counters when it is running.
+func GetDownload(c *gin.Context) {
+	id, ok := downloadIDParam(c)
+	if !ok {
+		return
+	}
+	job, err := Downloads.Job(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Download not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelDownloadJob cancels the job identified by :id and removes its partial
+// file. Any sync waiting on the job receives a cancellation error.
+func CancelDownloadJob(c *gin.Context) {
+	id, ok := downloadIDParam(c)
+	if !ok {
+		return
+	}
+	if err := Downloads.Cancel(id); err != nil {
+		respondDownloadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Download cancelled"})
+}
+
+// PauseDownloadJob pauses a running or queued job. The waiting sync keeps
+// waiting until the job is resumed or cancelled.
+func PauseDownloadJob(c *gin.Context) {
+	id, ok := downloadIDParam(c)
+	if !ok {
+		return
+	}
+	if err := Downloads.Pause(id); err != nil {
+		respondDownloadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Download paused"})
+}
+
+// ResumeDownloadJob returns a paused job to the queue.
+func ResumeDownloadJob(c *gin.Context) {
+	id, ok := downloadIDParam(c)
+	if !ok {
+		return
+	}
+	if err := Downloads.Resume(id); err != nil {
+		respondDownloadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Download resumed"})
+}
+
+// RetryDownloadJob requeues a failed job, resuming from its partial file
+// when possible.
+func RetryDownloadJob(c *gin.Context) {
+	id, ok := downloadIDParam(c)
+	if !ok {
+		return
+	}
+	if err := Downloads.Retry(id); err != nil {
+		respondDownloadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Download requeued"})
+}
+
+// MoveDownloadJob reorders a waiting job. The JSON body must contain a
+// zero-based "position" within the queued and paused jobs.
+func MoveDownloadJob(c *gin.Context) {
+	id, ok := downloadIDParam(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Position *int `json:"position" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position is required"})
+		return
+	}
+	if err := Downloads.Move(id, *input.Position); err != nil {
+		respondDownloadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Download moved"})
+}
+
+// ClearDownloads removes completed, failed and cancelled jobs from the queue
+// history.
+func ClearDownloads(c *gin.Context) {
+	removed, err := Downloads.ClearFinished()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear downloads"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": removed})
+}
+
+func downloadIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid download ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondDownloadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errDownloadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Download not found"})
+	case errors.Is(err, errDownloadNotActive):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
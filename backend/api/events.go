@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Event types published on the browser event stream.
+const (
+	EventDownloadStarted   = "download.started"
+	EventDownloadProgress  = "download.progress"
+	EventDownloadCompleted = "download.completed"
+	EventDownloadFailed    = "download.failed"
+	EventDownloadCancelled = "download.cancelled"
+	EventDownloadPaused    = "download.paused"
+
+	EventSyncProgress       = "sync.progress"
+	EventThumbnailsProgress = "thumbnails.progress"
+
+	EventClientConnected        = "client.connected"
+	EventClientDisconnected     = "client.disconnected"
+	EventClientFileStatus       = "clientfile.status"
+	EventClientFileProgress     = "clientfile.progress"
+	EventClientPairingRequested = "client.pairing_requested"
+	EventClientAuthFailed       = "client.auth_failed"
+	EventClientInventory        = "client.inventory"
+)
+
+const (
+	// eventBufferSize is how many events a slow subscriber may fall behind
+	// before further events are dropped for it.
+	eventBufferSize = 64
+	// progressEventInterval throttles download.progress events per job.
+	progressEventInterval  = 500 * time.Millisecond
+	eventKeepAliveInterval = 30 * time.Second
+)
+
+// Event is a single message on the browser event stream.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// EventBus fans out events to every subscribed browser. Publishing never
+// blocks: subscribers that are not keeping up miss events instead.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Events is the process-wide browser event bus.
+var Events = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe registers a new listener. The returned function must be called to
+// unsubscribe; it closes the channel.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends an event of the given type to all current subscribers.
+func (b *EventBus) Publish(eventType string, data interface{}) {
+	ev := Event{Type: eventType, Time: time.Now(), Data: data}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// StreamEvents serves the event bus as Server-Sent Events. Each message uses
+// the event type as the SSE event name and the JSON encoded Event as data.
+func StreamEvents(c *gin.Context) {
+	events, unsubscribe := Events.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			c.SSEvent(ev.Type, ev)
+			c.Writer.Flush()
+		case <-keepAlive.C:
+			c.Writer.WriteString(": keep-alive\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
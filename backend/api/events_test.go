@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEventBusDropsForSlowSubscribers(t *testing.T) {
+	bus := NewEventBus()
+	slow, unsubSlow := bus.Subscribe()
+	defer unsubSlow()
+
+	for i := 0; i < eventBufferSize+10; i++ {
+		bus.Publish("test", i)
+	}
+	if got := len(slow); got != eventBufferSize {
+		t.Errorf("buffered = %d, want %d", got, eventBufferSize)
+	}
+
+	unsubSlow()
+	unsubSlow()                  // safe to call twice
+	bus.Publish("test", "after") // must not panic on closed channel
+}
+
+func TestStreamEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/events", StreamEvents)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// The handler subscribes before flushing headers, so this is delivered.
+	Events.Publish(EventClientConnected, gin.H{"clientId": "pc1"})
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+
+	var name, data string
+	timeout := time.After(5 * time.Second)
+	for data == "" {
+		select {
+		case line := <-lines:
+			if v, ok := strings.CutPrefix(line, "event:"); ok {
+				name = v
+			}
+			if v, ok := strings.CutPrefix(line, "data:"); ok {
+				data = v
+			}
+		case <-timeout:
+			t.Fatal("no event received")
+		}
+	}
+	if name != EventClientConnected {
+		t.Errorf("event name = %q", name)
+	}
+	var ev struct {
+		Type string            `json:"type"`
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		t.Fatalf("decode %q: %v", data, err)
+	}
+	if ev.Type != EventClientConnected || ev.Data["clientId"] != "pc1" {
+		t.Errorf("event = %+v", ev)
+	}
+}
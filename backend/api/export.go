@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
 	"model-manager/backend/database"
 	"model-manager/backend/models"
@@ -9,16 +10,99 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// LibraryExport is the JSON document written by ExportModels and read by
+// ImportDatabase. Older exports were a bare array of models, which
+// ImportDatabase still accepts.
+type LibraryExport struct {
+	Models      []models.Model       `json:"models"`
+	Collections []ExportedCollection `json:"collections"`
+	Settings    []ExportedSetting    `json:"settings"`
+}
+
+// ExportedCollection refers to its members by CivitAI version ID, which is
+// stable across databases. Smart collections export only their filter.
+type ExportedCollection struct {
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Filter      *models.CollectionFilter `json:"filter,omitempty"`
+	VersionIDs  []int                    `json:"versionIds,omitempty"`
+}
+
+type ExportedSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
 // ExportModels serves the entire model catalog as a downloadable JSON payload.
 // It expects no parameters on the incoming request, queries the database for
-// models with nested versions/images, and writes the JSON export with
-// Content-Disposition headers. The handler is read-only with respect to the
-// database but streams the response body to the client.
+// models with nested versions/images, collections and settings, and writes
+// the JSON export with Content-Disposition headers. The handler is read-only
+// with respect to the database but streams the response body to the client.
 func ExportModels(c *gin.Context) {
-	var modelsList []models.Model
-	database.DB.Preload("Versions").Preload("Versions.Images").Find(&modelsList)
+	export, err := buildLibraryExport()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export library"})
+		return
+	}
 
 	c.Header("Content-Type", "application/json")
 	c.Header("Content-Disposition", "attachment; filename=\"model_export.json\"")
-	c.JSON(http.StatusOK, modelsList)
+	c.JSON(http.StatusOK, export)
+}
+
+func buildLibraryExport() (LibraryExport, error) {
+	export := LibraryExport{Models: []models.Model{}, Collections: []ExportedCollection{}, Settings: []ExportedSetting{}}
+	if err := database.DB.Preload("Versions").Preload("Versions.Images").Find(&export.Models).Error; err != nil {
+		return export, err
+	}
+
+	var colls []models.Collection
+	if err := database.DB.Order("name").Find(&colls).Error; err != nil {
+		return export, err
+	}
+	for _, coll := range colls {
+		ec, err := exportCollection(coll)
+		if err != nil {
+			return export, err
+		}
+		export.Collections = append(export.Collections, ec)
+	}
+
+	settings, err := database.GetAllSettings()
+	if err != nil {
+		return export, err
+	}
+	for _, s := range settings {
+		if !exportedSetting(s.Key) {
+			continue
+		}
+		export.Settings = append(export.Settings, ExportedSetting{Key: s.Key, Value: s.Value})
+	}
+	return export, nil
+}
+
+func exportCollection(coll models.Collection) (ExportedCollection, error) {
+	ec := ExportedCollection{Name: coll.Name, Description: coll.Description, Filter: coll.Filter}
+	if coll.Filter != nil {
+		return ec, nil
+	}
+	err := database.DB.Model(&models.Version{}).
+		Joins("JOIN collection_versions ON collection_versions.version_id = versions.id").
+		Where("collection_versions.collection_id = ?", coll.ID).
+		Order("versions.version_id").
+		Pluck("versions.version_id", &ec.VersionIDs).Error
+	return ec, err
+}
+
+// exportedSetting reports whether a setting travels with an export. The
+// CivitAI key is a credential, migration markers describe the source
+// database, not the library, and machine settings name local paths.
+func exportedSetting(key string) bool {
+	return key != "civitai_api_key" && !strings.HasPrefix(key, "migration:") && !machineSetting(key)
+}
+
+// machineSetting reports whether a setting names a location on this machine.
+// Imports and bundles keep the paths configured where they are imported.
+func machineSetting(key string) bool {
+	return key == "model_path" || key == "image_path" || key == "backup_path"
 }
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errNoFileMetadata = errors.New("file format has no readable metadata")
+
+// storeFileMetadata reads the metadata embedded in the model file at path and
+// saves it for the version, replacing any earlier entry. Only safetensors
+// files carry a readable header.
+func storeFileMetadata(versionID uint, path string) (map[string]string, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".safetensors") {
+		return nil, errNoFileMetadata
+	}
+	header, err := ReadSafetensorsHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(header.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry models.FileMetadata
+	database.DB.Where("version_id = ?", versionID).Limit(1).Find(&entry)
+	entry.VersionID = versionID
+	entry.Format = "safetensors"
+	entry.TensorCount = header.TensorCount
+	entry.Metadata = string(data)
+	if err := database.DB.Save(&entry).Error; err != nil {
+		return nil, err
+	}
+	return header.Metadata, nil
+}
+
+// recordFileMetadata stores the metadata of a freshly saved model file,
+// logging instead of failing since the metadata is optional.
+func recordFileMetadata(versionID uint, path string) map[string]string {
+	if path == "" {
+		return nil
+	}
+	meta, err := storeFileMetadata(versionID, path)
+	if err != nil && !errors.Is(err, errNoFileMetadata) {
+		log.Printf("failed to read metadata of %s: %v", path, err)
+	}
+	return meta
+}
+
+// GetVersionFileMetadata returns the metadata embedded in the model file of
+// the version given by the :id path parameter. Files imported before metadata
+// was stored are read on first request.
+func GetVersionFileMetadata(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version ID"})
+		return
+	}
+	var version models.Version
+	if err := database.DB.First(&version, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+
+	var entry models.FileMetadata
+	database.DB.Where("version_id = ?", version.ID).Limit(1).Find(&entry)
+	if entry.ID == 0 {
+		if version.FilePath == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version has no file"})
+			return
+		}
+		path := ResolveModelPath(version.FilePath)
+		if _, err := os.Stat(path); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Model file not found"})
+			return
+		}
+		if _, err := storeFileMetadata(version.ID, path); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to read file metadata: %v", err)})
+			return
+		}
+		database.DB.Where("version_id = ?", version.ID).Limit(1).Find(&entry)
+	}
+
+	meta := map[string]string{}
+	json.Unmarshal([]byte(entry.Metadata), &meta)
+	c.JSON(http.StatusOK, gin.H{
+		"versionId":    version.ID,
+		"format":       entry.Format,
+		"tensorCount":  entry.TensorCount,
+		"metadata":     meta,
+		"baseModel":    metadataBaseModel(meta),
+		"trainedWords": metadataTrainedWords(meta),
+	})
+}
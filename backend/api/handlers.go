@@ -10,9 +10,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"model-manager/backend/database"
@@ -47,9 +49,13 @@ func resolveNSFWFilter(c *gin.Context) (onlySafe bool, onlyNSFW bool) {
 }
 
 // GetModels returns a paginated list of models, optionally filtered by query
-// parameters. Supported query params include page, limit, search, baseModel,
-// modelType, nsfwFilter, tags, and includeVersions. The handler performs read-only
-// database queries and responds with JSON containing the matching models.
+// parameters. Supported query params include page, limit, cursor, sort, order,
+// search, q (see parseLibraryQuery), baseModel, modelType, nsfwFilter, tags,
+// synced, and includeVersions. Searches are ranked by relevance when the
+// full-text index is available. When a full page is returned, the
+// X-Next-Cursor header carries a cursor for keyset pagination of the next one.
+// The handler performs read-only database queries and responds with JSON
+// containing the matching models.
 func GetModels(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
@@ -61,71 +67,36 @@ func GetModels(c *gin.Context) {
 	}
 
 	search := c.Query("search")
-	baseModel := c.Query("baseModel")
-	modelType := c.Query("modelType")
-	onlySafe, onlyNSFW := resolveNSFWFilter(c)
-	tags := c.Query("tags")
-	synced := c.Query("synced") == "1"
+	filter, err := filterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	order, err := modelOrderFromRequest(c, search != "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	var modelsList []models.Model
 	q := database.DB.Model(&models.Model{})
 
 	// Filter models by versions when filters are provided or when searching
-	needJoin := search != "" || baseModel != "" || modelType != "" || onlySafe || onlyNSFW || tags != "" || synced
+	needJoin := search != "" || !filter.empty()
 	if needJoin {
 		q = q.Joins("JOIN versions ON versions.model_id = models.id")
 	}
 
+	ranked := false
 	if search != "" {
-		like := "%" + strings.ToLower(search) + "%"
-		q = q.Where("LOWER(models.name) LIKE ? OR LOWER(versions.name) LIKE ? OR LOWER(versions.trained_words) LIKE ?", like, like, like)
-	}
-	if baseModel != "" {
-		q = q.Where("versions.base_model = ?", baseModel)
-	}
-	if modelType != "" {
-		q = q.Where("versions.type = ?", modelType)
-	}
-	if onlySafe {
-		q = q.Where("versions.nsfw = 0")
-	} else if onlyNSFW {
-		q = q.Where("versions.nsfw = 1")
-	}
-	if tags != "" {
-		for _, t := range strings.Split(tags, ",") {
-			t = strings.TrimSpace(strings.ToLower(t))
-			if t != "" {
-				q = q.Where("LOWER(versions.tags) LIKE ?", "%"+t+"%")
-			}
-		}
-	}
-	if synced {
-		q = q.Joins("JOIN client_files ON client_files.model_version_id = versions.id").Where("client_files.status = ?", "installed")
+		q, ranked = applySearch(q, search)
 	}
+	q = filter.apply(q)
 
 	if c.DefaultQuery("includeVersions", "1") == "1" {
 		q = q.Preload("Versions", func(db *gorm.DB) *gorm.DB {
-			if baseModel != "" {
-				db = db.Where("base_model = ?", baseModel)
-			}
-			if modelType != "" {
-				db = db.Where("type = ?", modelType)
-			}
-			if onlySafe {
-				db = db.Where("nsfw = 0")
-			} else if onlyNSFW {
-				db = db.Where("nsfw = 1")
-			}
-			if tags != "" {
-				for _, t := range strings.Split(tags, ",") {
-					t = strings.TrimSpace(strings.ToLower(t))
-					if t != "" {
-						db = db.Where("LOWER(tags) LIKE ?", "%"+t+"%")
-					}
-				}
-			}
-			if synced {
-				db = db.Joins("JOIN client_files ON client_files.model_version_id = versions.id").Where("client_files.status = ?", "installed")
+			if !filter.empty() {
+				db = filter.apply(db.Select("versions.*").Joins("JOIN models ON models.id = versions.model_id"))
 			}
 			return db.Preload("Collections").Order("versions.id DESC")
 		})
@@ -135,54 +106,58 @@ func GetModels(c *gin.Context) {
 		q = q.Group("models.id")
 	}
 
-	q.Order("models.id DESC").Limit(limit).Offset((page - 1) * limit).Find(&modelsList)
+	q = order.apply(q, ranked).Limit(limit)
+	if cursor := c.Query("cursor"); cursor != "" {
+		cur, err := decodeModelCursor(cursor)
+		if err == nil {
+			q, err = order.after(q, cur)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		q = q.Offset((page - 1) * limit)
+	}
+	q.Find(&modelsList)
 	populateClientStatus(modelsList)
+	next := ""
+	if len(modelsList) == limit && order.Sort != "relevance" {
+		if cur, err := order.cursorFor(modelsList[len(modelsList)-1].ID); err == nil {
+			next = cur
+			c.Header("X-Next-Cursor", next)
+		}
+	}
+	// Cursor paging, started with an empty cursor, gets the next cursor in
+	// the body as well; plain requests keep the bare list.
+	if _, paging := c.GetQuery("cursor"); paging {
+		c.JSON(http.StatusOK, gin.H{"models": modelsList, "nextCursor": next})
+		return
+	}
 	c.JSON(http.StatusOK, modelsList)
 }
 
 // GetModelsCount mirrors GetModels filtering logic but returns only the total
-// count. It honors the search, baseModel, modelType, nsfwFilter, and tags query
-// parameters and does not modify any database records.
+// count. It honors the same search and filter query parameters and does not
+// modify any database records.
 func GetModelsCount(c *gin.Context) {
 	search := c.Query("search")
-	baseModel := c.Query("baseModel")
-	modelType := c.Query("modelType")
-	onlySafe, onlyNSFW := resolveNSFWFilter(c)
-	tags := c.Query("tags")
-	synced := c.Query("synced") == "1"
+	filter, err := filterFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	var count int64
 	q := database.DB.Model(&models.Model{})
-	needJoin := search != "" || baseModel != "" || modelType != "" || onlySafe || onlyNSFW || tags != "" || synced
+	needJoin := search != "" || !filter.empty()
 	if needJoin {
 		q = q.Joins("JOIN versions ON versions.model_id = models.id")
 	}
 	if search != "" {
-		like := "%" + strings.ToLower(search) + "%"
-		q = q.Where("LOWER(models.name) LIKE ? OR LOWER(versions.name) LIKE ? OR LOWER(versions.trained_words) LIKE ?", like, like, like)
-	}
-	if baseModel != "" {
-		q = q.Where("versions.base_model = ?", baseModel)
-	}
-	if modelType != "" {
-		q = q.Where("versions.type = ?", modelType)
-	}
-	if onlySafe {
-		q = q.Where("versions.nsfw = 0")
-	} else if onlyNSFW {
-		q = q.Where("versions.nsfw = 1")
-	}
-	if tags != "" {
-		for _, t := range strings.Split(tags, ",") {
-			t = strings.TrimSpace(strings.ToLower(t))
-			if t != "" {
-				q = q.Where("LOWER(versions.tags) LIKE ?", "%"+t+"%")
-			}
-		}
-	}
-	if synced {
-		q = q.Joins("JOIN client_files ON client_files.model_version_id = versions.id").Where("client_files.status = ?", "installed")
+		q, _ = applySearch(q, search)
 	}
+	q = filter.apply(q)
 	if needJoin {
 		q = q.Group("models.id")
 	}
@@ -227,20 +202,53 @@ func GetModel(c *gin.Context) {
 	c.JSON(http.StatusOK, model)
 }
 
-// SyncCivitModels pulls the latest models from CivitAI using the configured API
-// token. The handler accepts no parameters, fetches the remote catalog, and
-// calls processModels which creates database records and downloads assets to
-// disk as a side effect. A JSON status message is returned when syncing
-// completes.
+// SyncCivitModels pulls models from the CivitAI catalog using the configured
+// API token. An optional JSON body of SyncFilters narrows the catalog (types,
+// baseModels, username, tag, query, nsfw, sort) and caps it with maxItems,
+// defaultSyncMaxItems unless all is set.
+// The handler starts a background job that walks every catalog page and
+// calls syncModels, which creates database records and downloads assets to
+// disk as a side effect. The response carries the job ID to follow via
+// /api/jobs/:id.
 func SyncCivitModels(c *gin.Context) {
-	apiKey := getCivitaiAPIKey()
-	items, err := FetchCivitModels(apiKey)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to fetch models"})
+	var filters SyncFilters
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&filters); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sync filters"})
+			return
+		}
+	}
+	if filters.MaxItems < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxItems must not be negative"})
 		return
 	}
-	processModels(items, apiKey)
-	c.JSON(200, gin.H{"message": "Models synced successfully."})
+
+	startJob(c, JobTypeSync, func(run *JobRun) error {
+		apiKey := getCivitaiAPIKey()
+		limit := filters.limit()
+		seen := 0
+		err := Civit.WithAPIKey(apiKey).EachModelPage(run.Context(), filters, func(page CivitModelPage) error {
+			seen += len(page.Items)
+			total := page.Metadata.TotalItems
+			if total < seen {
+				total = seen
+			}
+			if limit > 0 && total > limit {
+				total = limit
+			}
+			run.SetTotal(total)
+			syncModels(run, page.Items, apiKey)
+			if run.Cancelled() {
+				return errStopPaging
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fetch models: %w", err)
+		}
+		run.SetMessage(fmt.Sprintf("Synced %d models (%s)", seen, filters.describe()))
+		return nil
+	})
 }
 
 // SyncCivitModelByID refreshes a single CivitAI model identified by the :id
@@ -257,21 +265,12 @@ func SyncCivitModelByID(c *gin.Context) {
 		return
 	}
 
-	url := fmt.Sprintf("https://civitai.com/api/v1/models/%d", modelID)
-	log.Printf("GET %s", url)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("Authorization", "Bearer "+apiKey)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		c.JSON(500, gin.H{"error": "Failed to fetch model"})
+	model, err := FetchCivitModel(apiKey, modelID)
+	if err != nil {
+		status, message := civitErrorStatus(err, "Failed to fetch model")
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
-	defer resp.Body.Close()
-
-	var model CivitModel
-	body, _ := io.ReadAll(resp.Body)
-	json.Unmarshal(body, &model)
 
 	processModels([]CivitModel{model}, apiKey)
 	c.JSON(200, gin.H{"message": "Model synced successfully", "modelId": modelID})
@@ -293,7 +292,8 @@ func GetModelVersions(c *gin.Context) {
 
 	model, err := FetchCivitModel(apiKey, id)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to fetch model"})
+		status, message := civitErrorStatus(err, "Failed to fetch model")
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
 
@@ -391,8 +391,7 @@ func SyncVersionByID(c *gin.Context) {
 
 	verData, err := fetchVersionDetails(apiKey, id, fallbackModelID)
 	if err != nil {
-		status := http.StatusInternalServerError
-		message := "Failed to fetch version"
+		status, message := civitErrorStatus(err, "Failed to fetch version")
 		if errors.Is(err, errVersionSummaryNotFound) {
 			status = http.StatusNotFound
 			message = "Version not found"
@@ -411,36 +410,12 @@ func SyncVersionByID(c *gin.Context) {
 
 	modelData, _ := FetchCivitModel(apiKey, verData.ModelID)
 
-	var model models.Model
-	database.DB.Unscoped().Where("civit_id = ?", verData.ModelID).Find(&model)
-	if model.ID == 0 {
-		model = models.Model{
-			CivitID: modelData.ID,
-			Name:    modelData.Name,
-			Type:    modelData.Type,
-			Weight:  1,
-		}
-		database.DB.Create(&model)
-	} else {
-		updated := false
-		if model.Type == "" {
-			// ensure type is populated for older records
-			model.Type = modelData.Type
-			updated = true
-		}
-		if model.Weight <= 0 {
-			model.Weight = 1
-			updated = true
-		}
-		if updated {
-			database.DB.Save(&model)
-		}
-	}
+	model := ensureCivitModel(verData.ModelID, modelData)
 
-	var filePath, imagePath string
+	var filePath string
 	var size int64
-	var imgW, imgH int
-	var fileSHA string
+	var fileSHA, fileStatus string
+	var fileCheckedAt *time.Time
 	var downloadURL string
 	var selectedFile ModelFile
 	modelType := model.Type
@@ -460,34 +435,119 @@ func SyncVersionByID(c *gin.Context) {
 				fileName = fmt.Sprintf("%s_%d%s", base, verData.ID, ext)
 			}
 			var err error
-			filePath, size, err = DownloadFile(downloadURL, destDir, fileName)
-			if err != nil {
+			filePath, size, err = Downloads.Download(DownloadRequest{
+				URL:       downloadURL,
+				DestDir:   destDir,
+				Filename:  fileName,
+				Name:      fmt.Sprintf("%s - %s", modelData.Name, verData.Name),
+				VersionID: verData.ID,
+				SHA256:    selectedFile.Hashes.SHA256,
+			})
+			var mismatch *HashMismatchError
+			if errors.As(err, &mismatch) {
+				// Keep the version so the failure is visible; the file is in the trash.
+				log.Printf("version %d: %v", verData.ID, err)
+				fileStatus = models.FileStatusHashMismatch
+			} else if err != nil {
 				if errors.Is(err, context.Canceled) {
 					c.JSON(http.StatusConflict, gin.H{"error": "Download cancelled"})
+				} else if errors.Is(err, errDownloadPaused) {
+					c.JSON(http.StatusConflict, gin.H{"error": "Download paused"})
 				} else {
 					log.Printf("failed to download file: %v", err)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download file"})
 				}
 				return
-			}
-			if size < 110 {
+			} else if size < 110 {
 				if filePath != "" {
 					moveToTrash(filePath)
 				}
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Downloaded file too small"})
 				return
+			} else if selectedFile.Hashes.SHA256 != "" {
+				fileStatus = models.FileStatusVerified
 			}
+			now := time.Now()
+			fileCheckedAt = &now
 		}
 		fileSHA = selectedFile.Hashes.SHA256
 	}
 
+	saveSyncedVersion(apiKey, &model, modelData, verData, syncedFile{
+		File:        selectedFile,
+		Path:        filePath,
+		SHA256:      fileSHA,
+		DownloadURL: downloadURL,
+		Status:      fileStatus,
+		CheckedAt:   fileCheckedAt,
+	})
+
+	if fileStatus == models.FileStatusHashMismatch {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Downloaded file failed SHA256 verification", "versionId": verData.ID})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Version synced", "versionId": verData.ID})
+}
+
+// ensureCivitModel returns the local model for the CivitAI model civitID,
+// creating it from modelData when missing and back-filling older records.
+func ensureCivitModel(civitID int, modelData CivitModel) models.Model {
+	var model models.Model
+	database.DB.Unscoped().Where("civit_id = ?", civitID).Find(&model)
+	if model.ID == 0 {
+		model = models.Model{
+			CivitID: modelData.ID,
+			Name:    modelData.Name,
+			Type:    modelData.Type,
+			Weight:  1,
+		}
+		database.DB.Create(&model)
+	} else {
+		updated := false
+		if model.Type == "" {
+			// ensure type is populated for older records
+			model.Type = modelData.Type
+			updated = true
+		}
+		if model.Weight <= 0 {
+			model.Weight = 1
+			updated = true
+		}
+		if updated {
+			database.DB.Save(&model)
+		}
+	}
+	return model
+}
+
+// syncedFile describes the model file attached to a version being imported.
+type syncedFile struct {
+	File        ModelFile
+	Path        string
+	SHA256      string
+	DownloadURL string
+	Status      string
+	CheckedAt   *time.Time
+}
+
+// saveSyncedVersion creates the Version row for verData under model,
+// downloads its preview images, generates the version thumbnail and fills in
+// the model's image and file when they are still empty.
+func saveSyncedVersion(apiKey string, model *models.Model, modelData CivitModel, verData VersionResponse, file syncedFile) models.Version {
+	modelType := model.Type
+	if modelType == "" {
+		modelType = modelData.Type
+	}
+	var imagePath string
+	var imgW, imgH int
+
 	versionRecord := models.Version{
 		ModelID:              model.ID,
 		VersionID:            verData.ID,
 		Name:                 verData.Name,
 		BaseModel:            verData.BaseModel,
 		EarlyAccessTimeFrame: verData.EarlyAccessTimeFrame,
-		SizeKB:               selectedFile.SizeKB,
+		SizeKB:               file.File.SizeKB,
 		TrainedWords:         strings.Join(verData.TrainedWords, ","),
 		Nsfw:                 modelData.Nsfw,
 		Type:                 modelData.Type,
@@ -497,15 +557,22 @@ func SyncVersionByID(c *gin.Context) {
 		ModelURL:             fmt.Sprintf("https://civitai.com/models/%d?modelVersionId=%d", verData.ModelID, verData.ID),
 		CivitCreatedAt:       verData.Created,
 		CivitUpdatedAt:       verData.Updated,
-		SHA256:               fileSHA,
-		DownloadURL:          downloadURL,
-		FilePath:             MakeRelativePath(filePath, database.GetModelPath()),
+		SHA256:               file.SHA256,
+		DownloadURL:          file.DownloadURL,
+		FilePath:             MakeRelativePath(file.Path, database.GetModelPath()),
+		FileStatus:           file.Status,
+		FileCheckedAt:        file.CheckedAt,
 	}
 	// Archive images in description
 	if newDesc, changed := ArchiveDescriptionImages(verData.ID, versionRecord.Description); changed {
 		versionRecord.Description = newDesc
 	}
 	database.DB.Create(&versionRecord)
+	if err := database.SetSyncedTags(database.DB, versionRecord.ID, modelData.Tags); err != nil {
+		log.Printf("version %d: failed to store tags: %v", verData.ID, err)
+	}
+	clearAvailableUpdate(verData.ID)
+	recordFileMetadata(versionRecord.ID, file.Path)
 
 	images := collectVersionImages(apiKey, verData)
 	for idx, img := range images {
@@ -553,17 +620,17 @@ func SyncVersionByID(c *gin.Context) {
 		model.ImageWidth = imgW
 		model.ImageHeight = imgH
 	}
-	if model.FilePath == "" && filePath != "" {
-		model.FilePath = MakeRelativePath(filePath, database.GetModelPath())
+	if model.FilePath == "" && file.Path != "" {
+		model.FilePath = MakeRelativePath(file.Path, database.GetModelPath())
 	}
-	database.DB.Save(&model)
+	database.DB.Save(model)
 
 	// Ensure thumbnail exists
 	if model.ID > 0 && model.ImagePath != "" {
 		// No longer generating model thumbnails (id.webp), only version thumbnails (v_id.webp)
 	}
 
-	c.JSON(200, gin.H{"message": "Version synced", "versionId": verData.ID})
+	return versionRecord
 }
 
 func processModel(item CivitModel, apiKey string) {
@@ -609,7 +676,8 @@ func processModel(item CivitModel, apiKey string) {
 		var filePath, imagePath string
 		var size int64
 		var imgW, imgH int
-		var fileSHA string
+		var fileSHA, fileStatus string
+		var fileCheckedAt *time.Time
 		var downloadURL string
 		var selectedFile ModelFile
 		if len(verData.ModelFiles) > 0 {
@@ -624,22 +692,40 @@ func processModel(item CivitModel, apiKey string) {
 				fileName = fmt.Sprintf("%s_%d%s", base, verData.ID, ext)
 			}
 			var err error
-			filePath, size, err = DownloadFile(downloadURL, destDir, fileName)
-			if err != nil {
+			filePath, size, err = Downloads.Download(DownloadRequest{
+				URL:       downloadURL,
+				DestDir:   destDir,
+				Filename:  fileName,
+				Name:      fmt.Sprintf("%s - %s", item.Name, verData.Name),
+				VersionID: verData.ID,
+				SHA256:    selectedFile.Hashes.SHA256,
+			})
+			var mismatch *HashMismatchError
+			if errors.As(err, &mismatch) {
+				log.Printf("version %d: %v", verData.ID, err)
+				fileStatus = models.FileStatusHashMismatch
+			} else if err != nil {
 				if errors.Is(err, context.Canceled) {
 					log.Printf("download cancelled for version %d", verData.ID)
 					return
 				}
+				if errors.Is(err, errDownloadPaused) {
+					log.Printf("download paused for version %d, skipping it", verData.ID)
+					continue
+				}
 				log.Printf("failed to download file: %v", err)
 				continue
-			}
-			if size < 110 {
+			} else if size < 110 {
 				if filePath != "" {
 					moveToTrash(filePath)
 				}
 				log.Printf("downloaded %s is too small", fileName)
 				continue
+			} else if selectedFile.Hashes.SHA256 != "" {
+				fileStatus = models.FileStatusVerified
 			}
+			now := time.Now()
+			fileCheckedAt = &now
 			fileSHA = selectedFile.Hashes.SHA256
 		}
 
@@ -662,12 +748,19 @@ func processModel(item CivitModel, apiKey string) {
 			SHA256:               fileSHA,
 			DownloadURL:          downloadURL,
 			FilePath:             MakeRelativePath(filePath, database.GetModelPath()),
+			FileStatus:           fileStatus,
+			FileCheckedAt:        fileCheckedAt,
 		}
 		// Archive images in description
 		if newDesc, changed := ArchiveDescriptionImages(verData.ID, versionRec.Description); changed {
 			versionRec.Description = newDesc
 		}
 		database.DB.Create(&versionRec)
+		if err := database.SetSyncedTags(database.DB, versionRec.ID, item.Tags); err != nil {
+			log.Printf("version %d: failed to store tags: %v", verData.ID, err)
+		}
+		clearAvailableUpdate(verData.ID)
+		recordFileMetadata(versionRec.ID, filePath)
 
 		images := collectVersionImages(apiKey, verData)
 		for idx, img := range images {
@@ -728,16 +821,46 @@ func processModel(item CivitModel, apiKey string) {
 	}
 }
 
+// SyncProgressEvent is the payload of sync.progress events. One is published
+// when a model starts syncing and one when it finishes.
+type SyncProgressEvent struct {
+	ModelID int    `json:"modelId"`
+	Name    string `json:"name"`
+	Status  string `json:"status"` // "started" or "completed"
+	Done    int    `json:"done"`
+	Total   int    `json:"total"`
+}
+
 func processModels(items []CivitModel, apiKey string) {
+	syncModels(nil, items, apiKey)
+}
+
+// syncModels processes items with limited parallelism, reporting each model to
+// run (which may be nil) and stopping early when the job is cancelled.
+func syncModels(run *JobRun, items []CivitModel, apiKey string) {
 	sem := make(chan struct{}, 4)
 	var wg sync.WaitGroup
+	var done atomic.Int64
 	for _, item := range items {
 		itemCopy := item
 		sem <- struct{}{}
+		if run.Cancelled() {
+			<-sem
+			break
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			Events.Publish(EventSyncProgress, SyncProgressEvent{
+				ModelID: itemCopy.ID, Name: itemCopy.Name, Status: "started",
+				Done: int(done.Load()), Total: len(items),
+			})
 			processModel(itemCopy, apiKey)
+			run.Processed(true)
+			Events.Publish(EventSyncProgress, SyncProgressEvent{
+				ModelID: itemCopy.ID, Name: itemCopy.Name, Status: "completed",
+				Done: int(done.Add(1)), Total: len(items),
+			})
 			<-sem
 		}()
 	}
@@ -783,6 +906,8 @@ func DeleteModel(c *gin.Context) {
 			}
 		}
 		database.DB.Where("version_id = ?", v.ID).Delete(&models.VersionImage{})
+		database.DB.Unscoped().Where("version_id = ?", v.ID).Delete(&models.FileMetadata{})
+		database.DB.Where("version_id = ?", v.ID).Delete(&models.VersionTag{})
 
 		// Remove archived images directory
 		archiveDir := filepath.Join(database.GetImagePath(), "archives", fmt.Sprintf("%d", v.VersionID))
@@ -875,6 +1000,8 @@ func DeleteVersion(c *gin.Context) {
 	}
 
 	database.DB.Where("version_id = ?", version.ID).Delete(&models.VersionImage{})
+	database.DB.Unscoped().Where("version_id = ?", version.ID).Delete(&models.FileMetadata{})
+	database.DB.Where("version_id = ?", version.ID).Delete(&models.VersionTag{})
 
 	database.DB.Unscoped().Delete(&models.Version{}, version.ID)
 
@@ -954,6 +1081,8 @@ func UpdateModel(c *gin.Context) {
 		return
 	}
 
+	added, removed := tagChanges(database.SplitTags(model.Tags), database.SplitTags(input.Tags))
+
 	model.CivitID = input.CivitID
 	model.Name = input.Name
 	model.Type = input.Type
@@ -973,6 +1102,10 @@ func UpdateModel(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update model"})
 		return
 	}
+	if err := applyModelTagChanges(model.ID, added, removed); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+		return
+	}
 
 	// Update thumbnail if image path changed
 	if model.ImagePath != "" {
@@ -1022,7 +1155,6 @@ func UpdateVersion(c *gin.Context) {
 	version.TrainedWords = input.TrainedWords
 	version.Nsfw = input.Nsfw
 	version.Type = input.Type
-	version.Tags = input.Tags
 	version.Description = input.Description
 	version.Mode = input.Mode
 	version.ModelURL = input.ModelURL
@@ -1038,6 +1170,15 @@ func UpdateVersion(c *gin.Context) {
 		return
 	}
 
+	// Tags edited here are user tags, kept apart from the synced ones.
+	if !slices.Equal(database.SplitTags(input.Tags), database.SplitTags(version.Tags)) {
+		if err := database.SetUserTags(database.DB, version.ID, database.SplitTags(input.Tags)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+			return
+		}
+		database.DB.Model(&version).Select("tags").First(&version)
+	}
+
 	c.JSON(http.StatusOK, version)
 }
 
@@ -1215,6 +1356,16 @@ func UploadVersionFile(c *gin.Context) {
 		if model.FilePath == "" {
 			model.FilePath = version.FilePath
 		}
+		// Manual uploads have no CivitAI data; fall back to the file's own
+		// training metadata.
+		if meta := recordFileMetadata(version.ID, absPath); meta != nil {
+			if version.BaseModel == "" {
+				version.BaseModel = metadataBaseModel(meta)
+			}
+			if version.TrainedWords == "" {
+				version.TrainedWords = strings.Join(metadataTrainedWords(meta), ",")
+			}
+		}
 	}
 
 	database.DB.Save(&version)
@@ -1394,9 +1545,11 @@ func populateClientStatus(modelsList []models.Model) {
 // ResetPendingStatus deletes all ClientFile records with 'pending' status.
 // This is used as a manual tool to clear stuck models.
 func ResetPendingStatus(c *gin.Context) {
-	if err := database.ResetAllPendingClientFiles(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset pending status"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"message": "Reset successful"})
+	startJob(c, JobTypeResetPending, func(run *JobRun) error {
+		if err := database.ResetAllPendingClientFiles(); err != nil {
+			return fmt.Errorf("failed to reset pending status: %w", err)
+		}
+		run.SetMessage("Reset successful")
+		return nil
+	})
 }
@@ -29,6 +29,9 @@ func setupTestDB(t *testing.T) {
 	if err := db.AutoMigrate(&models.Model{}, &models.Version{}, &models.VersionImage{}); err != nil {
 		t.Fatalf("migrate: %v", err)
 	}
+	if err := database.SetupTagTables(db); err != nil {
+		t.Fatalf("migrate tags: %v", err)
+	}
 	database.DB = db
 }
 
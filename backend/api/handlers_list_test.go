@@ -48,6 +48,15 @@ func setupListDB(t *testing.T) {
 	if err := database.DB.Create(&models.Version{ModelID: m4.ID, VersionID: 44, Name: "DeltaVer", BaseModel: "SD2", Type: "lora", Nsfw: false, Tags: "tag3", TrainedWords: "delta-key"}).Error; err != nil {
 		t.Fatalf("create version4: %v", err)
 	}
+
+	// Link the tags as a sync would.
+	var versions []models.Version
+	database.DB.Find(&versions)
+	for _, v := range versions {
+		if err := database.SetSyncedTags(database.DB, v.ID, database.SplitTags(v.Tags)); err != nil {
+			t.Fatalf("tag version %d: %v", v.VersionID, err)
+		}
+	}
 }
 
 func newTestRouter() *gin.Engine {
@@ -77,6 +86,8 @@ func TestGetModelsAndCountFilters(t *testing.T) {
 		{"hideNsfwLegacy", "?hideNsfw=1", []string{"Delta", "Gamma", "Alpha"}},
 		{"tags", "?tags=tag3", []string{"Delta", "Beta"}},
 		{"multiTags", "?tags=tag1,tag2", []string{"Gamma"}},
+		{"tagsMatchWholeNames", "?tags=tag", []string{}},
+		{"tagsSameAsQuery", "?q=tag:tag3", []string{"Delta", "Beta"}},
 		{"combo", "?baseModel=SD2&modelType=checkpoint", []string{"Gamma"}},
 		{"comboNone", "?baseModel=SD1&modelType=lora&nsfwFilter=no", []string{}},
 	}
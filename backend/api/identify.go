@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobTypeIdentifyOrphans is the background job that looks up orphaned files
+// on CivitAI by hash.
+const JobTypeIdentifyOrphans = "identify-orphans"
+
+// Outcomes recorded for each file by the identify job.
+const (
+	IdentifyMatched  = "matched"  // new model/version records created for the file
+	IdentifyAttached = "attached" // an existing version without a file now points at it
+	IdentifyExists   = "exists"   // the version is already in the library with a file
+	IdentifyUnknown  = "unknown"  // CivitAI does not know the hash
+	IdentifyError    = "error"
+)
+
+// OrphanIdentification is the result for one file, stored in the job result.
+type OrphanIdentification struct {
+	Path         string `json:"path"`
+	SHA256       string `json:"sha256,omitempty"`
+	Status       string `json:"status"`
+	CivitModelID int    `json:"civitModelId,omitempty"`
+	VersionID    int    `json:"versionId,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// IdentifyOrphanedFiles hashes orphaned model files and imports the ones
+// CivitAI recognises, keeping the files where they are. An optional JSON body
+// {"paths": [...]} limits the job to some of the current orphans.
+func IdentifyOrphanedFiles(c *gin.Context) {
+	var req struct {
+		Paths []string `json:"paths"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	orphans := findOrphanedFiles()
+	paths := orphans
+	if len(req.Paths) > 0 {
+		known := make(map[string]bool, len(orphans))
+		for _, p := range orphans {
+			known[pathKey(p)] = true
+		}
+		paths = nil
+		for _, p := range req.Paths {
+			abs, err := filepath.Abs(p)
+			if err != nil || !known[pathKey(abs)] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Not an orphaned file", "path": p})
+				return
+			}
+			paths = append(paths, abs)
+		}
+	}
+
+	startJob(c, JobTypeIdentifyOrphans, func(run *JobRun) error {
+		return identifyOrphans(run, paths)
+	})
+}
+
+func pathKey(p string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToLower(p)
+	}
+	return p
+}
+
+// identifyOrphans runs identifyOrphan for each path and stores the outcomes
+// as the job result.
+func identifyOrphans(run *JobRun, paths []string) error {
+	run.SetTotal(len(paths))
+	apiKey := getCivitaiAPIKey()
+	client := Civit.WithAPIKey(apiKey)
+
+	results := make([]OrphanIdentification, 0, len(paths))
+	matched := 0
+	for _, p := range paths {
+		if run.Cancelled() {
+			break
+		}
+		res := identifyOrphan(run.Context(), client, apiKey, p)
+		results = append(results, res)
+		switch res.Status {
+		case IdentifyError:
+			run.Errorf("identify %s: %s", p, res.Error)
+		case IdentifyMatched, IdentifyAttached:
+			matched++
+			run.Processed(true)
+		default:
+			run.Processed(false)
+		}
+	}
+	run.SetResult(results)
+	run.SetMessage(fmt.Sprintf("Identified %d of %d files", matched, len(paths)))
+	return nil
+}
+
+// identifyOrphan hashes the file at path, looks the hash up on CivitAI and
+// records a match in the library without moving or re-downloading the file.
+func identifyOrphan(ctx context.Context, client *CivitClient, apiKey, path string) OrphanIdentification {
+	res := OrphanIdentification{Path: path}
+	fail := func(err error) OrphanIdentification {
+		res.Status = IdentifyError
+		res.Error = err.Error()
+		return res
+	}
+
+	sum, err := cachedFileHash(path)
+	if err != nil {
+		return fail(err)
+	}
+	res.SHA256 = sum
+
+	verData, err := client.GetModelVersionByHash(ctx, sum)
+	if errors.Is(err, ErrCivitNotFound) {
+		res.Status = IdentifyUnknown
+		return res
+	} else if err != nil {
+		return fail(err)
+	}
+	res.CivitModelID = verData.ModelID
+	res.VersionID = verData.ID
+	res.Name = verData.Name
+
+	file := selectModelFile(verData.ModelFiles)
+	for _, f := range verData.ModelFiles {
+		if strings.EqualFold(f.Hashes.SHA256, sum) {
+			file = f
+			break
+		}
+	}
+	now := time.Now()
+	relPath := MakeRelativePath(path, database.GetModelPath())
+
+	var existing models.Version
+	database.DB.Unscoped().Where("version_id = ?", verData.ID).Find(&existing)
+	if existing.ID > 0 {
+		if existing.DeletedAt.Valid || existing.FilePath != "" {
+			res.Status = IdentifyExists
+			return res
+		}
+		err := database.DB.Model(&existing).Updates(map[string]interface{}{
+			"file_path":       relPath,
+			"sha256":          sum,
+			"file_status":     models.FileStatusVerified,
+			"file_checked_at": &now,
+		}).Error
+		if err != nil {
+			return fail(err)
+		}
+		database.DB.Model(&models.Model{}).
+			Where("id = ? AND (file_path = '' OR file_path IS NULL)", existing.ModelID).
+			Update("file_path", relPath)
+		res.Status = IdentifyAttached
+		return res
+	}
+
+	modelData, err := client.GetModel(ctx, verData.ModelID)
+	if err != nil {
+		return fail(err)
+	}
+	model := ensureCivitModel(verData.ModelID, modelData)
+	saveSyncedVersion(apiKey, &model, modelData, verData, syncedFile{
+		File:        file,
+		Path:        path,
+		SHA256:      sum,
+		DownloadURL: file.DownloadURL,
+		Status:      models.FileStatusVerified,
+		CheckedAt:   &now,
+	})
+	res.Status = IdentifyMatched
+	return res
+}
+
+// cachedFileHash returns the SHA256 of the file at path, reusing the stored
+// hash while the file's size and modification time are unchanged.
+func cachedFileHash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	var entry models.FileHash
+	database.DB.Where("path = ?", path).Limit(1).Find(&entry)
+	if entry.ID > 0 && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() {
+		return entry.SHA256, nil
+	}
+
+	sum, err := FileHash(path)
+	if err != nil {
+		return "", err
+	}
+	entry.Path = path
+	entry.Size = info.Size()
+	entry.ModTime = info.ModTime().UnixNano()
+	entry.SHA256 = sum
+	if err := database.DB.Save(&entry).Error; err != nil {
+		return "", err
+	}
+	return sum, nil
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestIdentifyOrphans(t *testing.T) {
+	initTestDB(t)
+	root := t.TempDir()
+	database.SetSettingValue("model_path", root)
+
+	known := []byte("known model")
+	attach := []byte("attach model")
+	write := func(name string, data []byte) string {
+		p := filepath.Join(root, name)
+		os.WriteFile(p, data, 0o644)
+		return p
+	}
+	knownPath := write("known.safetensors", known)
+	attachPath := write("attach.safetensors", attach)
+	unknownPath := write("mystery.safetensors", []byte("nobody knows"))
+
+	existing := models.Model{CivitID: 20, Name: "existing", Weight: 1}
+	database.DB.Create(&existing)
+	database.DB.Create(&models.Version{ModelID: existing.ID, VersionID: 200})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/model-versions/by-hash/" + sha256Hex(known):
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id": 100, "modelId": 10, "name": "v1", "baseModel": "SDXL 1.0",
+				"files": []map[string]interface{}{
+					{"name": "other.pt", "hashes": map[string]string{"SHA256": "00"}},
+					{"name": "known.safetensors", "sizeKB": 1, "hashes": map[string]string{"SHA256": strings.ToUpper(sha256Hex(known))}},
+				},
+			})
+		case "/api/v1/model-versions/by-hash/" + sha256Hex(attach):
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 200, "modelId": 20, "name": "v2"})
+		case "/api/v1/models/10":
+			json.NewEncoder(w).Encode(CivitModel{ID: 10, Name: "Known", Type: "LORA"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	useCivitServer(t, srv)
+
+	if err := identifyOrphans(nil, findOrphanedFiles()); err != nil {
+		t.Fatalf("identifyOrphans: %v", err)
+	}
+
+	var model models.Model
+	database.DB.Where("civit_id = ?", 10).First(&model)
+	if model.Name != "Known" || model.FilePath != "known.safetensors" {
+		t.Errorf("model = %+v", model)
+	}
+	var v models.Version
+	database.DB.Where("version_id = ?", 100).First(&v)
+	if v.ModelID != model.ID || v.FilePath != "known.safetensors" || v.SHA256 != sha256Hex(known) ||
+		v.FileStatus != models.FileStatusVerified || v.BaseModel != "SDXL 1.0" {
+		t.Errorf("version = %+v", v)
+	}
+	if _, err := os.Stat(knownPath); err != nil {
+		t.Errorf("identified file should stay in place: %v", err)
+	}
+
+	var attached models.Version
+	database.DB.Where("version_id = ?", 200).First(&attached)
+	if attached.FilePath != "attach.safetensors" || attached.FileStatus != models.FileStatusVerified {
+		t.Errorf("attached version = %+v", attached)
+	}
+	database.DB.First(&existing, existing.ID)
+	if existing.FilePath != "attach.safetensors" {
+		t.Errorf("existing model file = %q", existing.FilePath)
+	}
+
+	if orphans := findOrphanedFiles(); len(orphans) != 1 || orphans[0] != unknownPath {
+		t.Errorf("orphans after identify = %v, want only %s", orphans, unknownPath)
+	}
+
+	res := identifyOrphan(t.Context(), Civit, "", attachPath)
+	if res.Status != IdentifyExists || res.VersionID != 200 {
+		t.Errorf("second identify = %+v", res)
+	}
+	res = identifyOrphan(t.Context(), Civit, "", unknownPath)
+	if res.Status != IdentifyUnknown {
+		t.Errorf("unknown identify = %+v", res)
+	}
+}
+
+func TestCachedFileHash(t *testing.T) {
+	initTestDB(t)
+	p := filepath.Join(t.TempDir(), "a.safetensors")
+	os.WriteFile(p, []byte("first"), 0o644)
+
+	sum, err := cachedFileHash(p)
+	if err != nil || sum != sha256Hex([]byte("first")) {
+		t.Fatalf("cachedFileHash = %q, %v", sum, err)
+	}
+	// A cached entry is trusted while size and mtime match.
+	database.DB.Model(&models.FileHash{}).Where("path = ?", p).Update("sha256", "cached")
+	if sum, _ := cachedFileHash(p); sum != "cached" {
+		t.Errorf("cachedFileHash = %q, want cached value", sum)
+	}
+
+	os.WriteFile(p, []byte("second!"), 0o644)
+	os.Chtimes(p, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if sum, _ := cachedFileHash(p); sum != sha256Hex([]byte("second!")) {
+		t.Errorf("cachedFileHash after change = %q", sum)
+	}
+	var count int64
+	database.DB.Model(&models.FileHash{}).Count(&count)
+	if count != 1 {
+		t.Errorf("cache rows = %d, want 1", count)
+	}
+}
@@ -230,6 +230,9 @@ func ImportModels(c *gin.Context) {
 			failures = append(failures, fmt.Sprintf("%s: %v", r.Name, err))
 			continue
 		}
+		if err = database.SetSyncedTags(database.DB, ver.ID, r.Groups); err != nil {
+			log.Printf("failed to store tags for %s: %v", r.Name, err)
+		}
 
 		updated := false
 		if model.ImagePath == "" && imagePath != "" {
@@ -202,10 +202,11 @@ func TestExportModels(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Fatalf("status = %d", w.Code)
 	}
-	var got []models.Model
-	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+	var export LibraryExport
+	if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil {
 		t.Fatalf("unmarshal: %v", err)
 	}
+	got := export.Models
 	if len(got) != 1 || len(got[0].Versions) != 1 || len(got[0].Versions[0].Images) != 1 {
 		t.Fatalf("export mismatch: %v", got)
 	}
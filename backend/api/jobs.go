@@ -0,0 +1,357 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Background job types.
+const (
+	JobTypeSync               = "sync"
+	JobTypeMigratePaths       = "migrate-paths"
+	JobTypeArchiveImages      = "archive-images"
+	JobTypeResetPending       = "reset-pending"
+	JobTypeGenerateThumbnails = "generate-thumbnails"
+	JobTypeVerifyHashes       = "verify-hashes"
+)
+
+// Events published for background jobs. The payload is the models.Job row.
+const (
+	EventJobStarted  = "job.started"
+	EventJobProgress = "job.progress"
+	EventJobFinished = "job.finished"
+)
+
+const (
+	// jobPersistInterval limits how often running counters are written.
+	jobPersistInterval = time.Second
+	// maxJobErrorLog caps the number of error lines kept per job.
+	maxJobErrorLog = 200
+)
+
+var (
+	errJobNotFound   = errors.New("job not found")
+	errJobNotRunning = errors.New("job is not running")
+)
+
+// JobRunningError is returned when a job of the same type is already running.
+type JobRunningError struct {
+	Job models.Job
+}
+
+func (e *JobRunningError) Error() string {
+	return fmt.Sprintf("%s job %d is already running", e.Job.Type, e.Job.ID)
+}
+
+// JobFunc does the work of a background job. It should stop early when
+// run.Context() is cancelled; returning an error marks the job failed.
+type JobFunc func(run *JobRun) error
+
+// JobRun is handed to a JobFunc to report progress. All methods are safe for
+// concurrent use and are no-ops on a nil *JobRun, so shared code can report
+// progress whether or not it runs as a job.
+type JobRun struct {
+	ctx         context.Context
+	mu          sync.Mutex
+	job         models.Job
+	errorLog    []string
+	lastPersist time.Time
+}
+
+// JobManager runs background jobs and tracks their state in the jobs table.
+type JobManager struct {
+	mu      sync.Mutex
+	running map[uint]context.CancelFunc
+	types   map[string]uint
+}
+
+// Jobs is the process-wide background job manager.
+var Jobs = NewJobManager()
+
+func NewJobManager() *JobManager {
+	return &JobManager{
+		running: make(map[uint]context.CancelFunc),
+		types:   make(map[string]uint),
+	}
+}
+
+// Start records a new job of the given type and runs fn in the background.
+// Only one job of each type may run at a time.
+func (m *JobManager) Start(jobType string, fn JobFunc) (models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id, ok := m.types[jobType]; ok {
+		var existing models.Job
+		database.DB.First(&existing, id)
+		return existing, &JobRunningError{Job: existing}
+	}
+
+	now := time.Now()
+	job := models.Job{Type: jobType, Status: models.JobStatusRunning, StartedAt: &now}
+	if err := database.DB.Create(&job).Error; err != nil {
+		return job, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.running[job.ID] = cancel
+	m.types[jobType] = job.ID
+	run := &JobRun{ctx: ctx, job: job, lastPersist: now}
+	Events.Publish(EventJobStarted, job)
+
+	go m.run(run, fn)
+	return job, nil
+}
+
+func (m *JobManager) run(run *JobRun, fn JobFunc) {
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job panicked: %v", r)
+			}
+		}()
+		err = fn(run)
+	}()
+
+	run.mu.Lock()
+	now := time.Now()
+	run.job.FinishedAt = &now
+	switch {
+	case run.ctx.Err() != nil:
+		run.job.Status = models.JobStatusCancelled
+	case err != nil:
+		run.job.Status = models.JobStatusFailed
+		run.job.Message = err.Error()
+	default:
+		run.job.Status = models.JobStatusCompleted
+	}
+	run.job.ErrorLog = strings.Join(run.errorLog, "\n")
+	job := run.job
+	run.mu.Unlock()
+
+	if err != nil {
+		log.Printf("%s job %d failed: %v", job.Type, job.ID, err)
+	}
+	// Save under the manager lock so a finished job is never both stored as
+	// running and unregistered, or the reverse.
+	m.mu.Lock()
+	if saveErr := database.DB.Save(&job).Error; saveErr != nil {
+		log.Printf("failed to save job %d: %v", job.ID, saveErr)
+	}
+	if cancel, ok := m.running[job.ID]; ok {
+		cancel()
+		delete(m.running, job.ID)
+	}
+	delete(m.types, job.Type)
+	m.mu.Unlock()
+
+	Events.Publish(EventJobFinished, job)
+}
+
+// Cancel asks a running job to stop. The job records its final state once its
+// function returns.
+func (m *JobManager) Cancel(id uint) error {
+	m.mu.Lock()
+	cancel, ok := m.running[id]
+	m.mu.Unlock()
+	if ok {
+		cancel()
+		return nil
+	}
+	var job models.Job
+	if err := database.DB.First(&job, id).Error; err != nil {
+		return errJobNotFound
+	}
+	return errJobNotRunning
+}
+
+// List returns jobs newest first, optionally filtered by type and status.
+func (m *JobManager) List(jobType, status string, limit int) ([]models.Job, error) {
+	q := database.DB.Order("id DESC")
+	if jobType != "" {
+		q = q.Where("type = ?", jobType)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	var jobs []models.Job
+	err := q.Find(&jobs).Error
+	return jobs, err
+}
+
+// Get returns a single job.
+func (m *JobManager) Get(id uint) (models.Job, error) {
+	var job models.Job
+	if err := database.DB.First(&job, id).Error; err != nil {
+		return job, errJobNotFound
+	}
+	return job, nil
+}
+
+// Context is cancelled when the job is cancelled.
+func (r *JobRun) Context() context.Context {
+	if r == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// Cancelled reports whether the job has been asked to stop.
+func (r *JobRun) Cancelled() bool {
+	return r != nil && r.ctx.Err() != nil
+}
+
+// SetTotal records how many items the job expects to process.
+func (r *JobRun) SetTotal(n int) {
+	r.update(func(j *models.Job) { j.Total = n })
+}
+
+// Processed counts one item handled, and updated additionally counts it as
+// changed.
+func (r *JobRun) Processed(updated bool) {
+	r.update(func(j *models.Job) {
+		j.Processed++
+		if updated {
+			j.Updated++
+		}
+	})
+}
+
+// Errorf counts a failed item and appends the message to the job's error log.
+func (r *JobRun) Errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if len(r.errorLog) < maxJobErrorLog {
+		r.errorLog = append(r.errorLog, msg)
+	}
+	r.mu.Unlock()
+	r.update(func(j *models.Job) { j.Errors++ })
+}
+
+// SetMessage sets the human readable summary shown for the job.
+func (r *JobRun) SetMessage(msg string) {
+	r.update(func(j *models.Job) { j.Message = msg })
+}
+
+// SetResult stores v as the job's JSON result.
+func (r *JobRun) SetResult(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		r.Errorf("failed to encode job result: %v", err)
+		return
+	}
+	r.update(func(j *models.Job) { j.Result = string(data) })
+}
+
+func (r *JobRun) update(fn func(*models.Job)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	fn(&r.job)
+	if time.Since(r.lastPersist) < jobPersistInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastPersist = time.Now()
+	r.job.ErrorLog = strings.Join(r.errorLog, "\n")
+	job := r.job
+	r.mu.Unlock()
+
+	database.DB.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"total":     job.Total,
+		"processed": job.Processed,
+		"updated":   job.Updated,
+		"errors":    job.Errors,
+		"message":   job.Message,
+		"error_log": job.ErrorLog,
+	})
+	Events.Publish(EventJobProgress, job)
+}
+
+// ListJobs returns background jobs newest first. Optional query parameters:
+// type, status and limit (default 50).
+func ListJobs(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	jobs, err := Jobs.List(c.Query("type"), c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetJob returns the job identified by the :id path parameter.
+func GetJob(c *gin.Context) {
+	id, ok := jobIDParam(c)
+	if !ok {
+		return
+	}
+	job, err := Jobs.Get(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob asks the job identified by :id to stop.
+func CancelJob(c *gin.Context) {
+	id, ok := jobIDParam(c)
+	if !ok {
+		return
+	}
+	switch err := Jobs.Cancel(id); {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": "Job cancellation requested"})
+	case errors.Is(err, errJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	}
+}
+
+// startJob starts fn as a background job and responds with 202 and the job,
+// or 409 with the running job when one of the same type is in progress.
+func startJob(c *gin.Context, jobType string, fn JobFunc) {
+	job, err := Jobs.Start(jobType, fn)
+	var running *JobRunningError
+	switch {
+	case errors.As(err, &running):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "job": running.Job})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start job"})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Job started", "jobId": job.ID, "job": job})
+	}
+}
+
+func jobIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
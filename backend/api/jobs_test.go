@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func waitForJob(t *testing.T, id uint) models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := Jobs.Get(id)
+		if err == nil && job.Status != models.JobStatusRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d still running", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobManagerRecordsCounters(t *testing.T) {
+	initTestDB(t)
+	job, err := Jobs.Start("test-counters", func(run *JobRun) error {
+		run.SetTotal(3)
+		run.Processed(true)
+		run.Processed(false)
+		run.Errorf("item %d broke", 3)
+		run.SetResult(map[string]int{"answer": 42})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	done := waitForJob(t, job.ID)
+	if done.Status != models.JobStatusCompleted || done.Total != 3 || done.Processed != 2 ||
+		done.Updated != 1 || done.Errors != 1 || done.ErrorLog != "item 3 broke" ||
+		done.Result != `{"answer":42}` || done.FinishedAt == nil {
+		t.Errorf("job = %+v", done)
+	}
+}
+
+func TestJobManagerOneJobPerType(t *testing.T) {
+	initTestDB(t)
+	release := make(chan struct{})
+	first, err := Jobs.Start("test-exclusive", func(run *JobRun) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	_, err = Jobs.Start("test-exclusive", func(run *JobRun) error { return nil })
+	var running *JobRunningError
+	if !errors.As(err, &running) || running.Job.ID != first.ID {
+		t.Fatalf("second Start err = %v, want JobRunningError for job %d", err, first.ID)
+	}
+
+	close(release)
+	waitForJob(t, first.ID)
+	second, err := Jobs.Start("test-exclusive", func(run *JobRun) error { return nil })
+	if err != nil {
+		t.Fatalf("Start after finish: %v", err)
+	}
+	waitForJob(t, second.ID)
+}
+
+func TestJobManagerCancelAndFailure(t *testing.T) {
+	initTestDB(t)
+	job, _ := Jobs.Start("test-cancel", func(run *JobRun) error {
+		<-run.Context().Done()
+		return nil
+	})
+	if err := Jobs.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if got := waitForJob(t, job.ID); got.Status != models.JobStatusCancelled {
+		t.Errorf("status = %q, want cancelled", got.Status)
+	}
+	if err := Jobs.Cancel(job.ID); !errors.Is(err, errJobNotRunning) {
+		t.Errorf("second Cancel err = %v, want errJobNotRunning", err)
+	}
+	if err := Jobs.Cancel(9999); !errors.Is(err, errJobNotFound) {
+		t.Errorf("Cancel missing err = %v, want errJobNotFound", err)
+	}
+
+	failed, _ := Jobs.Start("test-panic", func(run *JobRun) error { panic("boom") })
+	if got := waitForJob(t, failed.ID); got.Status != models.JobStatusFailed || got.Message != "job panicked: boom" {
+		t.Errorf("panicking job = %+v", got)
+	}
+}
+
+func TestArchiveImagesStartsJob(t *testing.T) {
+	initTestDB(t)
+	database.DB.Create(&models.Version{VersionID: 1, Description: "no images"})
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/tools/archive-images", nil)
+	ArchiveImages(c)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	jobs, _ := Jobs.List(JobTypeArchiveImages, "", 1)
+	if len(jobs) != 1 {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	done := waitForJob(t, jobs[0].ID)
+	if done.Status != models.JobStatusCompleted || done.Processed != 1 || done.Updated != 0 {
+		t.Errorf("job = %+v", done)
+	}
+
+	rec = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(rec)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(done.ID))}}
+	GetJob(c)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GetJob status = %d", rec.Code)
+	}
+}
+
+func TestFailInterruptedJobs(t *testing.T) {
+	initTestDB(t)
+	job := models.Job{Type: JobTypeSync, Status: models.JobStatusRunning}
+	database.DB.Create(&job)
+	if err := database.FailInterruptedJobs(); err != nil {
+		t.Fatalf("FailInterruptedJobs: %v", err)
+	}
+	got, _ := Jobs.Get(job.ID)
+	if got.Status != models.JobStatusFailed {
+		t.Errorf("status = %q, want failed", got.Status)
+	}
+}
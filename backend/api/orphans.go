@@ -18,6 +18,12 @@ import (
 // GetOrphanedFiles scans the backend/downloads directory tree and returns any
 // SAFETensors/PT files not referenced by models or versions in the database.
 func GetOrphanedFiles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"orphans": findOrphanedFiles()})
+}
+
+// findOrphanedFiles walks the model library and returns the absolute paths of
+// model files that no model or version references.
+func findOrphanedFiles() []string {
 	// 1. Resolve the root library path first
 	root := database.GetModelPath()
 	absRoot, err := filepath.Abs(root)
@@ -127,5 +133,5 @@ func GetOrphanedFiles(c *gin.Context) {
 
 	filepath.WalkDir(absRoot, walkFn)
 
-	c.JSON(http.StatusOK, gin.H{"orphans": orphans})
+	return orphans
 }
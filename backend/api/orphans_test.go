@@ -13,7 +13,9 @@ import (
 	"model-manager/backend/models"
 )
 
-// setupOrphansTest prepares a temporary downloads directory and in-memory database.
+// setupOrphansTest prepares a temporary downloads directory and in-memory
+// database. It returns the temporary directory; the library is its downloads
+// subdirectory and c.pt is the only file not referenced by a test.
 func setupOrphansTest(t *testing.T) string {
 	t.Helper()
 
@@ -22,46 +24,31 @@ func setupOrphansTest(t *testing.T) string {
 	t.Setenv("MODELS_DB_PATH", dbPath)
 	database.ConnectDatabase()
 
-	// Switch to repository root so the handler's relative path is correct
-	wd, err := os.Getwd()
+	root, err := filepath.EvalSymlinks(t.TempDir())
 	if err != nil {
-		t.Fatalf("getwd: %v", err)
+		t.Fatalf("resolve temp dir: %v", err)
 	}
-	if err := os.Chdir("../.."); err != nil {
-		t.Fatalf("chdir: %v", err)
-	}
-	t.Cleanup(func() {
-		os.Chdir(wd)
-		os.RemoveAll("backend/downloads")
-		os.RemoveAll("backend/target")
-	})
-
-	os.RemoveAll("backend/downloads")
-	os.RemoveAll("backend/target")
-
-	if err := os.MkdirAll("backend/downloads/sub", 0o755); err != nil {
+	if err := os.MkdirAll(filepath.Join(root, "downloads", "sub"), 0o755); err != nil {
 		t.Fatalf("mkdir: %v", err)
 	}
-	files := []string{
-		"backend/downloads/a.pt",
-		"backend/downloads/b.pt",
-		"backend/downloads/sub/c.pt",
-	}
-	for _, f := range files {
-		if err := os.WriteFile(f, []byte("test"), 0o644); err != nil {
+	for _, f := range []string{"a.pt", "b.pt", "sub/c.pt"} {
+		if err := os.WriteFile(filepath.Join(root, "downloads", f), []byte("test"), 0o644); err != nil {
 			t.Fatalf("write file %s: %v", f, err)
 		}
 	}
-	return "backend/downloads/sub/c.pt"
+	if err := database.SetSettingValue("model_path", filepath.Join(root, "downloads")); err != nil {
+		t.Fatalf("set model_path: %v", err)
+	}
+	return root
 }
 
 func TestGetOrphanedFiles(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	orphan := setupOrphansTest(t)
+	root := setupOrphansTest(t)
 
 	// Insert referenced files into DB
-	absA, _ := filepath.Abs("backend/downloads/a.pt")
-	absB, _ := filepath.Abs("backend/downloads/b.pt")
+	absA := filepath.Join(root, "downloads", "a.pt")
+	absB := filepath.Join(root, "downloads", "b.pt")
 	m := models.Model{CivitID: 1, Name: "m1", FilePath: absA, Weight: 1}
 	if err := database.DB.Create(&m).Error; err != nil {
 		t.Fatalf("create model: %v", err)
@@ -87,7 +74,7 @@ func TestGetOrphanedFiles(t *testing.T) {
 	if len(resp.Orphans) != 1 {
 		t.Fatalf("got %d orphans, want 1", len(resp.Orphans))
 	}
-	absOrphan, _ := filepath.Abs(orphan)
+	absOrphan := filepath.Join(root, "downloads", "sub", "c.pt")
 	if resp.Orphans[0] != absOrphan {
 		t.Errorf("orphan = %s, want %s", resp.Orphans[0], absOrphan)
 	}
@@ -95,11 +82,11 @@ func TestGetOrphanedFiles(t *testing.T) {
 
 func TestGetOrphanedFilesNone(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	setupOrphansTest(t)
+	root := setupOrphansTest(t)
 
-	absA, _ := filepath.Abs("backend/downloads/a.pt")
-	absB, _ := filepath.Abs("backend/downloads/b.pt")
-	absC, _ := filepath.Abs("backend/downloads/sub/c.pt")
+	absA := filepath.Join(root, "downloads", "a.pt")
+	absB := filepath.Join(root, "downloads", "b.pt")
+	absC := filepath.Join(root, "downloads", "sub", "c.pt")
 
 	m := models.Model{CivitID: 1, Name: "m1", FilePath: absA, Weight: 1}
 	if err := database.DB.Create(&m).Error; err != nil {
@@ -134,11 +121,11 @@ func TestGetOrphanedFilesNone(t *testing.T) {
 
 func TestGetOrphanedFilesSymlinkDir(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	setupOrphansTest(t)
+	root := setupOrphansTest(t)
 
-	absA, _ := filepath.Abs("backend/downloads/a.pt")
-	absB, _ := filepath.Abs("backend/downloads/b.pt")
-	absC, _ := filepath.Abs("backend/downloads/sub/c.pt")
+	absA := filepath.Join(root, "downloads", "a.pt")
+	absB := filepath.Join(root, "downloads", "b.pt")
+	absC := filepath.Join(root, "downloads", "sub", "c.pt")
 
 	m := models.Model{CivitID: 1, Name: "m1", FilePath: absA, Weight: 1}
 	if err := database.DB.Create(&m).Error; err != nil {
@@ -152,13 +139,13 @@ func TestGetOrphanedFilesSymlinkDir(t *testing.T) {
 		t.Fatalf("create versions: %v", err)
 	}
 
-	if err := os.MkdirAll("backend/target", 0o755); err != nil {
+	if err := os.MkdirAll(filepath.Join(root, "target"), 0o755); err != nil {
 		t.Fatalf("mkdir target: %v", err)
 	}
-	if err := os.WriteFile("backend/target/orphan.pt", []byte("test"), 0o644); err != nil {
+	if err := os.WriteFile(filepath.Join(root, "target", "orphan.pt"), []byte("test"), 0o644); err != nil {
 		t.Fatalf("write orphan: %v", err)
 	}
-	if err := os.Symlink("../target", "backend/downloads/link"); err != nil {
+	if err := os.Symlink("../target", filepath.Join(root, "downloads", "link")); err != nil {
 		t.Fatalf("symlink: %v", err)
 	}
 
@@ -179,7 +166,7 @@ func TestGetOrphanedFilesSymlinkDir(t *testing.T) {
 	if len(resp.Orphans) != 1 {
 		t.Fatalf("got %d orphans, want 1", len(resp.Orphans))
 	}
-	absOrphan, _ := filepath.Abs("backend/target/orphan.pt")
+	absOrphan := filepath.Join(root, "target", "orphan.pt")
 	if resp.Orphans[0] != absOrphan {
 		t.Errorf("orphan = %s, want %s", resp.Orphans[0], absOrphan)
 	}
@@ -187,15 +174,14 @@ func TestGetOrphanedFilesSymlinkDir(t *testing.T) {
 
 func TestGetOrphanedFilesDBSymlinkPath(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	orphan := setupOrphansTest(t)
+	root := setupOrphansTest(t)
 
-	if err := os.Symlink("downloads", "backend/link"); err != nil {
+	if err := os.Symlink("downloads", filepath.Join(root, "link")); err != nil {
 		t.Fatalf("symlink: %v", err)
 	}
-	t.Cleanup(func() { os.Remove("backend/link") })
 
-	absSymlinkA, _ := filepath.Abs("backend/link/a.pt")
-	absB, _ := filepath.Abs("backend/downloads/b.pt")
+	absSymlinkA := filepath.Join(root, "link", "a.pt")
+	absB := filepath.Join(root, "downloads", "b.pt")
 
 	m := models.Model{CivitID: 1, Name: "m1", FilePath: absSymlinkA, Weight: 1}
 	if err := database.DB.Create(&m).Error; err != nil {
@@ -222,7 +208,7 @@ func TestGetOrphanedFilesDBSymlinkPath(t *testing.T) {
 	if len(resp.Orphans) != 1 {
 		t.Fatalf("got %d orphans, want 1", len(resp.Orphans))
 	}
-	absOrphan, _ := filepath.Abs(orphan)
+	absOrphan := filepath.Join(root, "downloads", "sub", "c.pt")
 	if resp.Orphans[0] != absOrphan {
 		t.Errorf("orphan = %s, want %s", resp.Orphans[0], absOrphan)
 	}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// partSuffix marks a file that is still being downloaded.
+	partSuffix = ".part"
+	// partMetaSuffix marks the sidecar holding the validators for a .part file.
+	partMetaSuffix = ".meta"
+)
+
+// partMeta records what the server said about a resource when a .part file
+// was started so a later attempt can decide whether resuming is safe.
+type partMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	AcceptRanges bool   `json:"acceptRanges"`
+}
+
+// validator returns the value for an If-Range header, preferring a strong ETag
+// over Last-Modified. Weak ETags are not allowed in If-Range.
+func (m partMeta) validator() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// resumableOffset returns the size of an existing partial file for url when
+// the earlier response indicated the server can resume it, or 0 otherwise.
+func resumableOffset(partPath, url string) (int64, partMeta) {
+	info, err := os.Stat(partPath)
+	if err != nil || info.Size() == 0 {
+		return 0, partMeta{}
+	}
+	meta, err := readPartMeta(partPath)
+	if err != nil || meta.URL != url {
+		return 0, partMeta{}
+	}
+	if !meta.AcceptRanges && meta.validator() == "" {
+		return 0, partMeta{}
+	}
+	return info.Size(), meta
+}
+
+func readPartMeta(partPath string) (partMeta, error) {
+	var meta partMeta
+	data, err := os.ReadFile(partPath + partMetaSuffix)
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+func writePartMeta(partPath string, meta partMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(partPath+partMetaSuffix, data, 0o644)
+}
+
+// removePartialFile deletes the .part file and its sidecar for the final
+// destination path.
+func removePartialFile(path string) {
+	os.Remove(path + partSuffix)
+	os.Remove(path + partSuffix + partMetaSuffix)
+}
+
+// parseContentRange parses "bytes start-end/size" and returns start and size.
+// The unsatisfied form "bytes */size" yields start -1.
+func parseContentRange(header string) (start, size int64, ok bool) {
+	rest, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rng, sizeStr, found := strings.Cut(rest, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		size = 0
+	}
+	if rng == "*" {
+		return -1, size, err == nil
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
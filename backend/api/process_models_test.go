@@ -1,11 +1,15 @@
 package api
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"bou.ke/monkey"
+	"github.com/gin-gonic/gin"
 )
 
 func TestProcessModelsConcurrency(t *testing.T) {
@@ -76,3 +80,17 @@ func TestProcessModelsConcurrency(t *testing.T) {
 		t.Fatalf("expected concurrency limit to reach 4, got %d", got)
 	}
 }
+
+func TestSyncCivitModelsRejectsBadFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, body := range []string{`{"types": "LORA"}`, `{"maxItems": -1}`} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/sync", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SyncCivitModels(c)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("body %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
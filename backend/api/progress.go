@@ -6,23 +6,18 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// GetDownloadProgress reports the global download progress percentage for
-// long-running model downloads. The endpoint accepts no parameters and simply
-// reads the package-level CurrentDownloadProgress value to return JSON.
+// GetDownloadProgress reports the progress percentage of the most recently
+// started model download. It is kept for older frontends; new code should use
+// GET /api/downloads, which reports every job separately.
 func GetDownloadProgress(c *gin.Context) {
-	c.JSON(200, gin.H{"progress": CurrentDownloadProgress})
+	c.JSON(200, gin.H{"progress": Downloads.LatestProgress()})
 }
 
-// CancelDownload stops the active model download, if any, and cleans up any
+// CancelDownload stops every running model download and cleans up their
 // partially written files. It responds with a success message regardless of
 // whether a download was in progress so the action remains idempotent.
 func CancelDownload(c *gin.Context) {
-	cancelled, err := CancelActiveDownload()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel download"})
-		return
-	}
-	if cancelled {
+	if Downloads.CancelAll() > 0 {
 		c.JSON(http.StatusOK, gin.H{"message": "Download cancelled"})
 		return
 	}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"model-manager/backend/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersion is the WebSocket protocol version the server speaks.
+// Version 1 is the untyped protocol of older clients: commands are bare
+// DispatchRequest objects and replies bare ClientMessage objects. From
+// version 2 on every message is wrapped in an Envelope.
+const ProtocolVersion = 2
+
+// Capabilities a client can announce in its hello. Only those the server
+// supports as well are used.
+const (
+	CapabilityAck       = "ack"       // acknowledges every command it receives
+	CapabilityProgress  = "progress"  // reports download progress
+	CapabilitySidecar   = "sidecar"   // writes sidecar files
+	CapabilityInventory = "inventory" // reports its files on request
+)
+
+var serverCapabilities = []string{CapabilityAck, CapabilityProgress, CapabilitySidecar, CapabilityInventory}
+
+// Envelope is the frame of every version 2 message. ID identifies a message
+// so replies can name it in ReplyTo.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	ReplyTo string          `json:"reply_to,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// HelloPayload opens a version 2 session.
+type HelloPayload struct {
+	Protocol     int      `json:"protocol"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// WelcomePayload answers a hello with what was agreed.
+type WelcomePayload struct {
+	Protocol         int      `json:"protocol"`
+	Capabilities     []string `json:"capabilities"`
+	HeartbeatSeconds int      `json:"heartbeat_seconds"`
+}
+
+var (
+	// handshakeTimeout is how long commands wait for a new connection's
+	// first message before the client is taken to speak version 1.
+	handshakeTimeout = 2 * time.Second
+	// The server pings every heartbeatInterval and drops connections that
+	// send nothing, not even a pong, for heartbeatTimeout.
+	heartbeatInterval = 30 * time.Second
+	heartbeatTimeout  = 90 * time.Second
+)
+
+var errUnsupportedCommand = errors.New("client does not support this command")
+
+// sentCommand is a command waiting for the client's reply.
+type sentCommand struct {
+	Action         string
+	ModelVersionID uint
+	CommandID      uint // the queued ClientCommand, 0 if sent directly
+}
+
+func newMessageID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// settle records the protocol a connection speaks. It reports whether this
+// was the connection's first message.
+func (c *ClientConnection) settle(protocol int, capabilities []string) bool {
+	c.state.Lock()
+	defer c.state.Unlock()
+	return c.settleLocked(protocol, capabilities)
+}
+
+func (c *ClientConnection) settleLocked(protocol int, capabilities []string) bool {
+	c.protocol = protocol
+	c.capabilities = make(map[string]bool, len(capabilities))
+	for _, capability := range capabilities {
+		c.capabilities[capability] = true
+	}
+	first := !c.settled
+	if first {
+		c.settled = true
+		if c.negotiated != nil {
+			close(c.negotiated)
+		}
+	}
+	return first
+}
+
+// settleLegacy takes the client to speak version 1 and records that, unless
+// its protocol is settled already.
+func (c *ClientConnection) settleLegacy() {
+	c.state.Lock()
+	defer c.state.Unlock()
+	if c.settled || !c.settleLocked(1, nil) {
+		return
+	}
+	if err := database.UpdateClientProtocol(c.clientID, 1, []string{}); err != nil {
+		log.Printf("Error recording protocol of client %s: %v", c.clientID, err)
+	}
+}
+
+// awaitHandshake waits until the client's first message arrived. A client
+// that sends nothing within the handshake timeout speaks version 1.
+func (c *ClientConnection) awaitHandshake() {
+	if c.negotiated == nil {
+		return
+	}
+	select {
+	case <-c.negotiated:
+	case <-time.After(handshakeTimeout):
+		c.settleLegacy()
+	}
+}
+
+// protocolInfo returns the agreed protocol version and whether the client
+// has a capability. Version 1 clients have none.
+func (c *ClientConnection) protocolInfo(capability string) (int, bool) {
+	c.state.Lock()
+	defer c.state.Unlock()
+	return c.protocol, c.capabilities[capability]
+}
+
+// supports reports whether the client understands a command. Version 1
+// clients silently ignore what they do not know, so everything is sent.
+func (c *ClientConnection) supports(action string) bool {
+	switch action {
+	case "download", "delete":
+		return true
+	case "sidecar", "inventory":
+		protocol, ok := c.protocolInfo(action)
+		return protocol < 2 || ok
+	}
+	protocol, _ := c.protocolInfo("")
+	return protocol < 2
+}
+
+// writeEnvelope sends a version 2 message.
+func (c *ClientConnection) writeEnvelope(msgType, replyTo string, payload interface{}) error {
+	env := Envelope{V: ProtocolVersion, Type: msgType, ID: newMessageID(), ReplyTo: replyTo}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		env.Payload = data
+	}
+	return c.WriteJSON(env)
+}
+
+// sendCommand sends req in the protocol the client speaks. commandID names
+// the queued ClientCommand being replayed; it is marked sent once the client
+// acknowledges it, or right away for clients that do not acknowledge.
+func (c *ClientConnection) sendCommand(req DispatchRequest, commandID uint) error {
+	c.awaitHandshake()
+	if !c.supports(req.Action) {
+		return errUnsupportedCommand
+	}
+	protocol, acks := c.protocolInfo(CapabilityAck)
+	if protocol < 2 {
+		if err := c.WriteJSON(req); err != nil {
+			return err
+		}
+	} else {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		env := Envelope{V: ProtocolVersion, Type: req.Action, ID: newMessageID(), Payload: data}
+		c.state.Lock()
+		if c.sent == nil {
+			c.sent = make(map[string]sentCommand)
+		}
+		c.sent[env.ID] = sentCommand{Action: req.Action, ModelVersionID: req.ModelVersionID, CommandID: commandID}
+		c.state.Unlock()
+		if err := c.WriteJSON(env); err != nil {
+			c.takeSent(env.ID, true)
+			return err
+		}
+	}
+	if commandID != 0 && (protocol < 2 || !acks) {
+		return database.MarkClientCommandSent(commandID)
+	}
+	return nil
+}
+
+// takeSent looks up the command a reply refers to, forgetting it if the
+// reply is final.
+func (c *ClientConnection) takeSent(id string, final bool) (sentCommand, bool) {
+	c.state.Lock()
+	defer c.state.Unlock()
+	cmd, ok := c.sent[id]
+	if ok && final {
+		delete(c.sent, id)
+	}
+	return cmd, ok
+}
+
+// handleMessage decodes a message from the client in either protocol and
+// hands it to handleClientMessage.
+func (c *ClientConnection) handleMessage(clientID string, data []byte) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		log.Printf("Error parsing message from %s: %v", clientID, err)
+		return
+	}
+	if env.V < 2 {
+		c.settleLegacy()
+		var msg ClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Printf("Error parsing message from %s: %v", clientID, err)
+			return
+		}
+		msg.ClientID = clientID
+		handleClientMessage(msg)
+		return
+	}
+
+	switch env.Type {
+	case "hello":
+		c.handleHello(clientID, env)
+		return
+	case "ping":
+		if err := c.writeEnvelope("pong", env.ID, nil); err != nil {
+			log.Printf("Error answering ping of client %s: %v", clientID, err)
+		}
+		database.TouchClient(clientID)
+		return
+	case "ack":
+		// Only downloads and deletes get a reply later on
+		cmd, ok := c.takeSent(env.ReplyTo, false)
+		if !ok {
+			return
+		}
+		if cmd.Action != "download" && cmd.Action != "delete" {
+			c.takeSent(env.ReplyTo, true)
+		}
+		if cmd.CommandID != 0 {
+			if err := database.MarkClientCommandSent(cmd.CommandID); err != nil {
+				log.Printf("Error marking command %d sent: %v", cmd.CommandID, err)
+			}
+		}
+		database.TouchClient(clientID)
+		return
+	}
+
+	var msg ClientMessage
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			log.Printf("Error parsing %s payload from %s: %v", env.Type, clientID, err)
+			return
+		}
+	}
+	msg.Type = env.Type
+	msg.ClientID = clientID
+	if env.ReplyTo != "" {
+		final := msg.Type != "progress"
+		if cmd, ok := c.takeSent(env.ReplyTo, final); ok {
+			if msg.ModelVersionID == 0 {
+				msg.ModelVersionID = cmd.ModelVersionID
+			}
+			if msg.Action == "" {
+				msg.Action = cmd.Action
+			}
+		}
+	}
+	handleClientMessage(msg)
+}
+
+// handleHello agrees on a protocol version and capabilities and answers with
+// a welcome.
+func (c *ClientConnection) handleHello(clientID string, env Envelope) {
+	var hello HelloPayload
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, &hello); err != nil {
+			log.Printf("Error parsing hello from %s: %v", clientID, err)
+			return
+		}
+	}
+	protocol := hello.Protocol
+	if protocol == 0 || protocol > ProtocolVersion {
+		protocol = ProtocolVersion
+	}
+	offered := make(map[string]bool, len(hello.Capabilities))
+	for _, capability := range hello.Capabilities {
+		offered[capability] = true
+	}
+	agreed := []string{}
+	for _, capability := range serverCapabilities {
+		if offered[capability] {
+			agreed = append(agreed, capability)
+		}
+	}
+
+	if err := database.UpdateClientProtocol(clientID, protocol, agreed); err != nil {
+		log.Printf("Error recording protocol of client %s: %v", clientID, err)
+	}
+	// Commands wait for settle, so the welcome is the first thing sent.
+	welcome := WelcomePayload{Protocol: protocol, Capabilities: agreed, HeartbeatSeconds: int(heartbeatInterval / time.Second)}
+	if err := c.writeEnvelope("welcome", env.ID, welcome); err != nil {
+		log.Printf("Error answering hello of client %s: %v", clientID, err)
+	}
+	if !c.settle(protocol, agreed) {
+		// The handshake may have timed out meanwhile and recorded version 1.
+		if err := database.UpdateClientProtocol(clientID, protocol, agreed); err != nil {
+			log.Printf("Error recording protocol of client %s: %v", clientID, err)
+		}
+	}
+	log.Printf("Client %s speaks protocol %d with %v", clientID, protocol, agreed)
+}
+
+// heartbeat pings the client until done is closed. A failed ping closes the
+// connection; a client that stops answering runs into the read deadline.
+func (c *ClientConnection) heartbeat(clientID string, done <-chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				log.Printf("Ping to client %s failed: %v", clientID, err)
+				c.Conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// publishClientFileFailed announces a failed client download with the
+// client's reason.
+func publishClientFileFailed(clientID string, versionID uint, reason string) {
+	Events.Publish(EventClientFileStatus, gin.H{
+		"clientId":       clientID,
+		"modelVersionId": versionID,
+		"status":         "failed",
+		"error":          reason,
+	})
+}
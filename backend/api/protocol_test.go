@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+// dialTestClient connects to a test server as clientID with the shared secret.
+func dialTestClient(t *testing.T, clientID string) *websocket.Conn {
+	t.Helper()
+	t.Setenv("CLIENT_SECRET", "shared")
+	r := gin.New()
+	r.GET("/ws", HandleWebSocket)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	header := http.Header{"Authorization": {"shared"}, "X-Client-ID": {clientID}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		// Let the server record the disconnect before the database goes away.
+		waitFor(t, func() bool {
+			client, _ := database.GetClient(clientID)
+			return !client.Connected
+		})
+	})
+	return conn
+}
+
+func waitFor(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func sendEnvelope(t *testing.T, conn *websocket.Conn, msgType, id, replyTo string, payload interface{}) {
+	t.Helper()
+	env := Envelope{V: ProtocolVersion, Type: msgType, ID: id, ReplyTo: replyTo}
+	if payload != nil {
+		env.Payload, _ = json.Marshal(payload)
+	}
+	if err := conn.WriteJSON(env); err != nil {
+		t.Fatalf("write %s: %v", msgType, err)
+	}
+}
+
+func readEnvelope(t *testing.T, conn *websocket.Conn) Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var env Envelope
+	if err := conn.ReadJSON(&env); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return env
+}
+
+func TestProtocolHandshakeAndReplies(t *testing.T) {
+	initTestDB(t)
+	model := models.Model{Name: "Proto", Type: "LORA"}
+	database.DB.Create(&model)
+	version := models.Version{ModelID: model.ID, Name: "v1", FilePath: "/models/lora/proto.safetensors"}
+	database.DB.Create(&version)
+	// Queued while the client was offline; replayed after the handshake.
+	w := clientRequest(t, DispatchRemote, http.MethodPost, "", gin.H{"action": "download", "client_id": "desk", "model_version_id": version.ID})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("dispatch = %d %s", w.Code, w.Body.String())
+	}
+
+	conn := dialTestClient(t, "desk")
+	sendEnvelope(t, conn, "hello", "h1", "", HelloPayload{Protocol: 3, Capabilities: []string{"ack", "progress", "teleport"}})
+	welcome := readEnvelope(t, conn)
+	var agreed WelcomePayload
+	json.Unmarshal(welcome.Payload, &agreed)
+	if welcome.Type != "welcome" || welcome.ReplyTo != "h1" || agreed.Protocol != ProtocolVersion ||
+		strings.Join(agreed.Capabilities, ",") != "ack,progress" || agreed.HeartbeatSeconds == 0 {
+		t.Fatalf("welcome = %+v %s", welcome, welcome.Payload)
+	}
+	if client, _ := database.GetClient("desk"); client.Protocol != ProtocolVersion || len(client.Capabilities) != 2 {
+		t.Errorf("stored protocol = %d %v", client.Protocol, client.Capabilities)
+	}
+
+	cmd := readEnvelope(t, conn)
+	var req DispatchRequest
+	json.Unmarshal(cmd.Payload, &req)
+	if cmd.Type != "download" || cmd.ID == "" || req.ModelVersionID != version.ID {
+		t.Fatalf("command = %+v %s", cmd, cmd.Payload)
+	}
+	// Replayed commands stay queued until the client acknowledges them.
+	if queued, _ := database.ListClientCommands("desk", false); len(queued) != 1 {
+		t.Errorf("queued before ack = %d", len(queued))
+	}
+	sendEnvelope(t, conn, "ack", "a1", cmd.ID, nil)
+	if !waitFor(t, func() bool { queued, _ := database.ListClientCommands("desk", false); return len(queued) == 0 }) {
+		t.Error("command still queued after ack")
+	}
+
+	clientFile := func() models.ClientFile {
+		var cf models.ClientFile
+		database.DB.Where("client_id = ? AND model_version_id = ?", "desk", version.ID).Limit(1).Find(&cf)
+		return cf
+	}
+	// Replies only name the command; the server fills in the version.
+	sendEnvelope(t, conn, "progress", "p1", cmd.ID, gin.H{"bytes_done": 50, "bytes_total": 200})
+	if !waitFor(t, func() bool { return clientFile().BytesDone == 50 }) {
+		t.Errorf("progress not recorded: %+v", clientFile())
+	}
+	sendEnvelope(t, conn, "error", "e1", cmd.ID, gin.H{"reason": "disk full"})
+	if !waitFor(t, func() bool { return clientFile().Status == "failed" }) {
+		t.Fatalf("error not recorded: %+v", clientFile())
+	}
+	if cf := clientFile(); cf.Error != "disk full" || cf.BytesTotal != 200 {
+		t.Errorf("failed client file = %+v", cf)
+	}
+
+	sendEnvelope(t, conn, "ping", "ping1", "", nil)
+	if pong := readEnvelope(t, conn); pong.Type != "pong" || pong.ReplyTo != "ping1" {
+		t.Errorf("pong = %+v", pong)
+	}
+
+	// The client did not announce inventory support.
+	if w := clientRequest(t, RequestClientInventory, http.MethodPost, "desk", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("inventory request = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHeartbeatDropsSilentClient(t *testing.T) {
+	initTestDB(t)
+	timeout := heartbeatTimeout
+	heartbeatTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { heartbeatTimeout = timeout })
+
+	conn := dialTestClient(t, "quiet")
+	if !waitFor(t, func() bool { client, _ := database.GetClient("quiet"); return client.Connected }) {
+		t.Fatal("client never connected")
+	}
+	// The client neither sends nor reads, so no pong reaches the server.
+	if !waitFor(t, func() bool { client, _ := database.GetClient("quiet"); return !client.Connected }) {
+		t.Fatal("silent client was not dropped")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("connection still open")
+	}
+}
+
+func TestReconnectSurvivesOldSocketClosing(t *testing.T) {
+	initTestDB(t)
+	database.DB.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: 1, Status: "pending"})
+	presences := func() []models.ClientPresence {
+		var rows []models.ClientPresence
+		database.DB.Where("client_id = ?", "desk").Order("id").Find(&rows)
+		return rows
+	}
+
+	old := dialTestClient(t, "desk")
+	if !waitFor(t, func() bool { return len(presences()) == 1 }) {
+		t.Fatal("first connection not recorded")
+	}
+	dialTestClient(t, "desk")
+	if !waitFor(t, func() bool { return len(presences()) == 2 }) {
+		t.Fatal("second connection not recorded")
+	}
+
+	// The old socket goes away only after the client reconnected.
+	old.Close()
+	if !waitFor(t, func() bool { return presences()[0].DisconnectedAt != nil }) {
+		t.Fatal("old presence not closed")
+	}
+	ClientsMutex.Lock()
+	_, registered := Clients["desk"]
+	ClientsMutex.Unlock()
+	client, _ := database.GetClient("desk")
+	var file models.ClientFile
+	database.DB.Where("client_id = ?", "desk").First(&file)
+	if !registered || !client.Connected || presences()[1].DisconnectedAt != nil || file.Status != "pending" {
+		t.Errorf("new connection disturbed: registered %v, connected %v, presences %+v, file %s",
+			registered, client.Connected, presences(), file.Status)
+	}
+}
+
+func TestHandshakeTimeoutSettlesVersion1(t *testing.T) {
+	initTestDB(t)
+	timeout := handshakeTimeout
+	handshakeTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { handshakeTimeout = timeout })
+
+	conn := dialTestClient(t, "old")
+	if !waitFor(t, func() bool { client, _ := database.GetClient("old"); return client.Connected }) {
+		t.Fatal("client never connected")
+	}
+	// The client says nothing, so the command goes out bare once the
+	// handshake times out.
+	if err := SendToClient("old", DispatchRequest{Action: "delete", Filename: "LORA/x.safetensors", ModelVersionID: 3}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var req DispatchRequest
+	if err := conn.ReadJSON(&req); err != nil || req.Action != "delete" || req.ModelVersionID != 3 {
+		t.Fatalf("command = %+v, %v", req, err)
+	}
+	if client, _ := database.GetClient("old"); client.Protocol != 1 {
+		t.Errorf("stored protocol = %d, want 1", client.Protocol)
+	}
+}
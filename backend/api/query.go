@@ -0,0 +1,578 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"model-manager/backend/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Library queries filter versions (joined with their models) with a small
+// query language:
+//
+//	type:LORA base:"SDXL 1.0" (tag:anime OR tag:style) -tag:realistic
+//	size:<200MB added:>2025-01-01 client:desktop1 dragon "line art"
+//
+// Terms are ANDed unless joined with OR; parentheses group, and a leading -
+// or NOT negates. Bare words and quoted phrases are full-text search terms.
+// A * in a field value is a wildcard.
+
+// sqlCond is a compiled SQL condition with its bind arguments. Conditions
+// refer to the versions and models tables.
+type sqlCond struct {
+	SQL  string
+	Args []interface{}
+}
+
+// QueryError reports a malformed library query.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// queryFields compiles a field:value term. op is one of "", "=", "<", "<=",
+// ">" or ">=".
+var queryFields = map[string]func(op, value string) (sqlCond, error){
+	"type": func(op, value string) (sqlCond, error) {
+		return textCond(op, value, false, "versions.type")
+	},
+	"base": func(op, value string) (sqlCond, error) {
+		return textCond(op, value, false, "versions.base_model")
+	},
+	"name": func(op, value string) (sqlCond, error) {
+		return textCond(op, value, true, "models.name", "versions.name")
+	},
+	"tag": tagCond,
+	"word": func(op, value string) (sqlCond, error) {
+		return listCond(op, value, "versions.trained_words")
+	},
+	"nsfw": func(op, value string) (sqlCond, error) {
+		b, err := boolValue(op, value)
+		return sqlCond{"versions.nsfw = ?", []interface{}{b}}, err
+	},
+	"size": func(op, value string) (sqlCond, error) {
+		kb, err := sizeKB(value)
+		if err != nil {
+			return sqlCond{}, err
+		}
+		return sqlCond{"versions.size_kb " + compareOp(op) + " ?", []interface{}{kb}}, nil
+	},
+	"added": func(op, value string) (sqlCond, error) {
+		return dateCond(op, value, "versions.created_at")
+	},
+	"updated": func(op, value string) (sqlCond, error) {
+		return dateCond(op, value, "versions.civit_updated_at")
+	},
+	"client": func(op, value string) (sqlCond, error) {
+		if op != "" && op != "=" {
+			return sqlCond{}, fmt.Errorf("client does not support %s", op)
+		}
+		return sqlCond{installedOnClientSQL + " AND client_files.client_id = ?)", []interface{}{value}}, nil
+	},
+	"synced": func(op, value string) (sqlCond, error) {
+		b, err := boolValue(op, value)
+		if !b {
+			return sqlCond{"NOT " + installedOnClientSQL + ")", nil}, err
+		}
+		return sqlCond{installedOnClientSQL + ")", nil}, err
+	},
+}
+
+// installedOnClientSQL is an unterminated EXISTS so callers can add
+// conditions on client_files before closing it.
+const installedOnClientSQL = "EXISTS (SELECT 1 FROM client_files WHERE client_files.model_version_id = versions.id AND client_files.status = 'installed' AND client_files.deleted_at IS NULL"
+
+// fieldCond compiles one field:value term.
+func fieldCond(field, op, value string) (sqlCond, error) {
+	compile, ok := queryFields[strings.ToLower(field)]
+	if !ok {
+		return sqlCond{}, fmt.Errorf("unknown field %q", field)
+	}
+	if value == "" {
+		return sqlCond{}, fmt.Errorf("missing value for %s", field)
+	}
+	return compile(op, value)
+}
+
+func compareOp(op string) string {
+	if op == "" {
+		return "="
+	}
+	return op
+}
+
+// likeEscaper escapes LIKE metacharacters for use with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePattern escapes LIKE metacharacters in value and turns * into %. It
+// reports whether value contained a wildcard.
+func likePattern(value string) (string, bool) {
+	escaped := likeEscaper.Replace(strings.ToLower(value))
+	return strings.ReplaceAll(escaped, "*", "%"), strings.Contains(value, "*")
+}
+
+// textCond matches value against any of cols case-insensitively. Without a
+// wildcard the value must equal the column, or just be contained in it when
+// contains is set.
+func textCond(op, value string, contains bool, cols ...string) (sqlCond, error) {
+	if op != "" && op != "=" {
+		return sqlCond{}, fmt.Errorf("text fields do not support %s", op)
+	}
+	pattern, wildcard := likePattern(value)
+	if !wildcard && contains && op == "" {
+		pattern, wildcard = "%"+pattern+"%", true
+	}
+	var parts []string
+	var args []interface{}
+	for _, col := range cols {
+		if wildcard {
+			parts = append(parts, "LOWER("+col+`) LIKE ? ESCAPE '\'`)
+			args = append(args, pattern)
+		} else {
+			parts = append(parts, "LOWER("+col+") = ?")
+			args = append(args, strings.ToLower(value))
+		}
+	}
+	return sqlCond{"(" + strings.Join(parts, " OR ") + ")", args}, nil
+}
+
+// listCond matches value against the entries of comma separated list
+// columns such as tags. Entries must match whole, wildcards aside.
+func listCond(op, value string, cols ...string) (sqlCond, error) {
+	if op != "" && op != "=" {
+		return sqlCond{}, fmt.Errorf("list fields do not support %s", op)
+	}
+	pattern, _ := likePattern(strings.TrimSpace(value))
+	var parts []string
+	var args []interface{}
+	for _, col := range cols {
+		parts = append(parts, "(',' || REPLACE(LOWER(COALESCE("+col+", '')), ', ', ',') || ',') LIKE ? ESCAPE '\\'")
+		args = append(args, "%,"+pattern+",%")
+	}
+	return sqlCond{"(" + strings.Join(parts, " OR ") + ")", args}, nil
+}
+
+// tagCond matches versions tagged with value, where * is a wildcard.
+func tagCond(op, value string) (sqlCond, error) {
+	if op != "" && op != "=" {
+		return sqlCond{}, fmt.Errorf("list fields do not support %s", op)
+	}
+	pattern, _ := likePattern(database.NormalizeTag(value))
+	return sqlCond{
+		"EXISTS (SELECT 1 FROM " + database.EffectiveTagsView + " evt JOIN tags ON tags.id = evt.tag_id WHERE evt.version_id = versions.id AND tags.name LIKE ? ESCAPE '\\')",
+		[]interface{}{pattern},
+	}, nil
+}
+
+func boolValue(op, value string) (bool, error) {
+	if op != "" && op != "=" {
+		return false, fmt.Errorf("boolean fields do not support %s", op)
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "1":
+		return true, nil
+	case "false", "no", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected true or false, got %q", value)
+}
+
+// sizeKB parses a size such as 200MB, 1.5GB or 512KB into kilobytes. Bare
+// numbers are megabytes.
+func sizeKB(value string) (float64, error) {
+	v := strings.ToUpper(strings.TrimSpace(value))
+	mult := 1024.0
+	for _, u := range []struct {
+		suffix string
+		mult   float64
+	}{{"GB", 1024 * 1024}, {"MB", 1024}, {"KB", 1}, {"G", 1024 * 1024}, {"M", 1024}, {"K", 1}} {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSuffix(v, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * mult, nil
+}
+
+// dateCond compares the calendar date of col with a YYYY-MM-DD value.
+func dateCond(op, value, col string) (sqlCond, error) {
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		return sqlCond{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", value)
+	}
+	return sqlCond{"date(" + col + ") " + compareOp(op) + " ?", []interface{}{value}}, nil
+}
+
+type queryTokenKind int
+
+const (
+	tokTerm queryTokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type queryToken struct {
+	kind  queryTokenKind
+	pos   int
+	field string
+	op    string
+	value string
+	// phrase marks a quoted free-text term.
+	phrase bool
+}
+
+// lexQuery splits a library query into tokens.
+func lexQuery(s string) ([]queryToken, error) {
+	rs := []rune(s)
+	var toks []queryToken
+	isDelim := func(r rune) bool { return unicode.IsSpace(r) || r == '(' || r == ')' }
+	readQuoted := func(i int) (string, int, error) {
+		start := i
+		i++
+		for i < len(rs) && rs[i] != '"' {
+			i++
+		}
+		if i >= len(rs) {
+			return "", i, &QueryError{start, "unterminated quote"}
+		}
+		return string(rs[start+1 : i]), i + 1, nil
+	}
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, queryToken{kind: tokLParen, pos: i})
+			i++
+		case r == ')':
+			toks = append(toks, queryToken{kind: tokRParen, pos: i})
+			i++
+		case r == '-' && i+1 < len(rs) && !isDelim(rs[i+1]):
+			toks = append(toks, queryToken{kind: tokNot, pos: i})
+			i++
+		case r == '"':
+			text, next, err := readQuoted(i)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, queryToken{kind: tokTerm, pos: i, value: text, phrase: true})
+			i = next
+		default:
+			start := i
+			for i < len(rs) && !isDelim(rs[i]) && rs[i] != ':' && rs[i] != '"' {
+				i++
+			}
+			word := string(rs[start:i])
+			if i < len(rs) && rs[i] == ':' && isFieldName(word) {
+				i++
+				tok := queryToken{kind: tokTerm, pos: start, field: word}
+				for _, op := range []string{"<=", ">=", "<", ">", "="} {
+					if strings.HasPrefix(string(rs[i:]), op) {
+						tok.op = op
+						i += len(op)
+						break
+					}
+				}
+				if i < len(rs) && rs[i] == '"' {
+					text, next, err := readQuoted(i)
+					if err != nil {
+						return nil, err
+					}
+					tok.value, i = text, next
+				} else {
+					vs := i
+					for i < len(rs) && !isDelim(rs[i]) {
+						i++
+					}
+					tok.value = string(rs[vs:i])
+				}
+				toks = append(toks, tok)
+				continue
+			}
+			// Not a field: the rest of the word is plain text.
+			for i < len(rs) && !isDelim(rs[i]) {
+				i++
+			}
+			word = string(rs[start:i])
+			switch word {
+			case "AND":
+				toks = append(toks, queryToken{kind: tokAnd, pos: start})
+			case "OR":
+				toks = append(toks, queryToken{kind: tokOr, pos: start})
+			case "NOT":
+				toks = append(toks, queryToken{kind: tokNot, pos: start})
+			default:
+				toks = append(toks, queryToken{kind: tokTerm, pos: start, value: word})
+			}
+		}
+	}
+	return toks, nil
+}
+
+func isFieldName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// queryParser compiles tokens by recursive descent:
+//
+//	or    = and { "OR" and }
+//	and   = unary { ["AND"] unary }
+//	unary = ("-" | "NOT") unary | "(" or ")" | term
+type queryParser struct {
+	toks []queryToken
+	pos  int
+	end  int
+}
+
+// parseLibraryQuery compiles a library query. ok is false for a blank query.
+func parseLibraryQuery(s string) (cond sqlCond, ok bool, err error) {
+	toks, err := lexQuery(s)
+	if err != nil || len(toks) == 0 {
+		return sqlCond{}, false, err
+	}
+	p := &queryParser{toks: toks, end: len([]rune(s))}
+	cond, err = p.parseOr()
+	if err != nil {
+		return sqlCond{}, false, err
+	}
+	if t := p.peek(); t != nil {
+		return sqlCond{}, false, &QueryError{t.pos, "unexpected " + tokenText(t)}
+	}
+	return cond, true, nil
+}
+
+func (p *queryParser) peek() *queryToken {
+	if p.pos < len(p.toks) {
+		return &p.toks[p.pos]
+	}
+	return nil
+}
+
+func (p *queryParser) parseOr() (sqlCond, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for t := p.peek(); t != nil && t.kind == tokOr; t = p.peek() {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		left = joinConds(" OR ", left, right)
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (sqlCond, error) {
+	var conds []sqlCond
+	for {
+		t := p.peek()
+		if t == nil || t.kind == tokOr || t.kind == tokRParen {
+			break
+		}
+		if t.kind == tokAnd {
+			if len(conds) == 0 {
+				return sqlCond{}, &QueryError{t.pos, "AND needs a term on both sides"}
+			}
+			p.pos++
+		}
+		c, err := p.parseUnary()
+		if err != nil {
+			return c, err
+		}
+		conds = append(conds, c)
+	}
+	if len(conds) == 0 {
+		pos := p.end
+		if t := p.peek(); t != nil {
+			pos = t.pos
+		}
+		return sqlCond{}, &QueryError{pos, "expected a term"}
+	}
+	return joinConds(" AND ", conds...), nil
+}
+
+func (p *queryParser) parseUnary() (sqlCond, error) {
+	t := p.peek()
+	if t == nil {
+		return sqlCond{}, &QueryError{p.end, "expected a term"}
+	}
+	switch t.kind {
+	case tokNot:
+		p.pos++
+		c, err := p.parseUnary()
+		if err != nil {
+			return c, err
+		}
+		return sqlCond{"NOT " + c.SQL, c.Args}, nil
+	case tokLParen:
+		p.pos++
+		c, err := p.parseOr()
+		if err != nil {
+			return c, err
+		}
+		if closing := p.peek(); closing == nil || closing.kind != tokRParen {
+			return sqlCond{}, &QueryError{t.pos, "unclosed parenthesis"}
+		}
+		p.pos++
+		return c, nil
+	case tokTerm:
+		p.pos++
+		if t.field == "" {
+			term := strings.ToLower(strings.Join(strings.Fields(t.value), " "))
+			if term == "" {
+				return sqlCond{}, &QueryError{t.pos, "empty phrase"}
+			}
+			return searchTermCond(searchTerm{Text: term, Phrase: t.phrase}), nil
+		}
+		c, err := fieldCond(t.field, t.op, t.value)
+		if err != nil {
+			return c, &QueryError{t.pos, err.Error()}
+		}
+		return c, nil
+	}
+	return sqlCond{}, &QueryError{t.pos, "unexpected " + tokenText(t)}
+}
+
+func tokenText(t *queryToken) string {
+	switch t.kind {
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	}
+	return strconv.Quote(t.value)
+}
+
+// joinConds combines conditions with sep, parenthesised so they nest safely.
+func joinConds(sep string, conds ...sqlCond) sqlCond {
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	parts := make([]string, len(conds))
+	var args []interface{}
+	for i, c := range conds {
+		parts[i] = c.SQL
+		args = append(args, c.Args...)
+	}
+	return sqlCond{"(" + strings.Join(parts, sep) + ")", args}
+}
+
+// libraryFilter is the set of conditions, all of which a version must meet,
+// built from a request's filter parameters.
+type libraryFilter struct {
+	conds []sqlCond
+}
+
+func (f *libraryFilter) add(c sqlCond) {
+	f.conds = append(f.conds, c)
+}
+
+// addField adds a field:value term built by the server, such as one of the
+// legacy query parameters.
+func (f *libraryFilter) addField(field, op, value string) error {
+	c, err := fieldCond(field, op, value)
+	if err == nil {
+		f.add(c)
+	}
+	return err
+}
+
+// addQuery parses a library query and adds it.
+func (f *libraryFilter) addQuery(s string) error {
+	c, ok, err := parseLibraryQuery(s)
+	if ok {
+		f.add(c)
+	}
+	return err
+}
+
+func (f libraryFilter) empty() bool {
+	return len(f.conds) == 0
+}
+
+// where returns the filter as a single condition, or a true condition when
+// the filter is empty.
+func (f libraryFilter) where() sqlCond {
+	if f.empty() {
+		return sqlCond{SQL: "1 = 1"}
+	}
+	return joinConds(" AND ", f.conds...)
+}
+
+// apply adds the filter to q, which must select from or join both versions
+// and models.
+func (f libraryFilter) apply(q *gorm.DB) *gorm.DB {
+	for _, c := range f.conds {
+		q = q.Where(c.SQL, c.Args...)
+	}
+	return q
+}
+
+// filterFromRequest builds the version filter shared by the model list,
+// count and collection endpoints: the structured q parameter plus the older
+// baseModel, modelType, nsfwFilter, tags and synced parameters.
+func filterFromRequest(c *gin.Context) (libraryFilter, error) {
+	var f libraryFilter
+	if v := c.Query("baseModel"); v != "" {
+		f.add(sqlCond{"versions.base_model = ?", []interface{}{v}})
+	}
+	if v := c.Query("modelType"); v != "" {
+		f.add(sqlCond{"versions.type = ?", []interface{}{v}})
+	}
+	onlySafe, onlyNSFW := resolveNSFWFilter(c)
+	if onlySafe {
+		f.add(sqlCond{"versions.nsfw = ?", []interface{}{false}})
+	} else if onlyNSFW {
+		f.add(sqlCond{"versions.nsfw = ?", []interface{}{true}})
+	}
+	// Each tag matches like tag: in q, so both filter the same way.
+	for _, t := range strings.Split(c.Query("tags"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			if err := f.addField("tag", "", t); err != nil {
+				return f, err
+			}
+		}
+	}
+	if c.Query("synced") == "1" {
+		f.addField("synced", "", "true")
+	}
+	if err := f.addQuery(c.Query("q")); err != nil {
+		return f, err
+	}
+	return f, nil
+}
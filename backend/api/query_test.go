@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func TestParseLibraryQueryErrors(t *testing.T) {
+	cases := map[string]string{
+		`type:LORA (tag:a`: "unclosed parenthesis",
+		`tag:a OR`:         "expected a term",
+		`color:red`:        `unknown field "color"`,
+		`size:<big`:        `invalid size "big"`,
+		`added:>yesterday`: `invalid date "yesterday", want YYYY-MM-DD`,
+		`base:"SDXL`:       "unterminated quote",
+		`tag:`:             "missing value for tag",
+		`name:>a`:          "text fields do not support >",
+		`nsfw:maybe`:       `expected true or false, got "maybe"`,
+		`a )`:              `unexpected ")"`,
+		`AND tag:a`:        "AND needs a term on both sides",
+	}
+	for q, want := range cases {
+		_, _, err := parseLibraryQuery(q)
+		var qe *QueryError
+		if !errors.As(err, &qe) || qe.Msg != want {
+			t.Errorf("parseLibraryQuery(%q) err = %v, want %q", q, err, want)
+		}
+	}
+	if _, ok, err := parseLibraryQuery("   "); ok || err != nil {
+		t.Errorf("blank query ok = %v, err = %v", ok, err)
+	}
+}
+
+func TestParseLibraryQuerySQL(t *testing.T) {
+	cond, _, err := parseLibraryQuery(`type:LORA (tag:anime OR tag:st*) -nsfw:true size:<=1.5GB`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tagSQL := `EXISTS (SELECT 1 FROM effective_version_tags evt JOIN tags ON tags.id = evt.tag_id WHERE evt.version_id = versions.id AND tags.name LIKE ? ESCAPE '\')`
+	want := `((LOWER(versions.type) = ?) AND (` + tagSQL + ` OR ` + tagSQL + `) AND NOT versions.nsfw = ? AND versions.size_kb <= ?)`
+	if cond.SQL != want {
+		t.Errorf("SQL =\n%s\nwant\n%s", cond.SQL, want)
+	}
+	wantArgs := []interface{}{"lora", "anime", "st%", true, 1.5 * 1024 * 1024}
+	if !reflect.DeepEqual(cond.Args, wantArgs) {
+		t.Errorf("args = %v, want %v", cond.Args, wantArgs)
+	}
+}
+
+func TestLibraryQueryFilters(t *testing.T) {
+	initTestDB(t)
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	add := func(civitID int, name, tags string, v models.Version) models.Version {
+		m := models.Model{CivitID: civitID, Name: name, Tags: tags, Weight: 1}
+		database.DB.Create(&m)
+		v.ModelID = m.ID
+		v.VersionID = civitID
+		database.DB.Create(&v)
+		database.SetSyncedTags(database.DB, v.ID, database.SplitTags(v.Tags+","+tags))
+		return v
+	}
+	anime := add(1, "Anime Lines", "", models.Version{Name: "v1", Type: "LORA", BaseModel: "SDXL 1.0", Tags: "anime, lineart", SizeKB: 100 * 1024})
+	add(2, "Anime Real", "", models.Version{Name: "v1", Type: "LORA", BaseModel: "SDXL 1.0", Tags: "anime,realistic", SizeKB: 150 * 1024})
+	style := add(3, "Oil Paint", "style", models.Version{Name: "v1", Type: "LORA", BaseModel: "SD 1.5", Tags: "painting", SizeKB: 300 * 1024, TrainedWords: "oilpaint, canvas", Nsfw: true})
+	add(4, "Big Checkpoint", "", models.Version{Name: "v1", Type: "Checkpoint", BaseModel: "SDXL 1.0", Tags: "anime", SizeKB: 6 * 1024 * 1024})
+	database.DB.Model(&models.Version{}).Where("id = ?", anime.ID).Update("created_at", day("2024-06-01"))
+	database.DB.Model(&models.Version{}).Where("id <> ?", anime.ID).Update("created_at", day("2025-03-01"))
+	database.DB.Create(&models.ClientFile{ClientID: "desktop1", ModelVersionID: style.ID, Status: "installed"})
+
+	cases := map[string][]string{
+		`type:LORA base:"SDXL 1.0" (tag:anime OR tag:style) -tag:realistic`: {"Anime Lines"},
+		`type:lora tag:style`:             {"Oil Paint"},
+		`size:<200MB`:                     {"Anime Lines", "Anime Real"},
+		`size:>=1g`:                       {"Big Checkpoint"},
+		`added:>2025-01-01 type:LORA`:     {"Anime Real", "Oil Paint"},
+		`added:2024-06-01`:                {"Anime Lines"},
+		`client:desktop1`:                 {"Oil Paint"},
+		`synced:false nsfw:false tag:an*`: {"Anime Lines", "Anime Real", "Big Checkpoint"},
+		`word:canvas OR name:checkpoint`:  {"Big Checkpoint", "Oil Paint"},
+		`NOT (tag:anime OR nsfw:true)`:    {},
+		`anime -real`:                     {"Anime Lines", "Big Checkpoint"},
+	}
+	for q, want := range cases {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/models?q="+url.QueryEscape(q), nil)
+		GetModels(c)
+		if rec.Code != http.StatusOK {
+			t.Errorf("q=%s: status %d: %s", q, rec.Code, rec.Body.String())
+			continue
+		}
+		var list []models.Model
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		got := []string{}
+		for _, m := range list {
+			got = append(got, m.Name)
+			for _, v := range m.Versions {
+				if v.ModelID != m.ID {
+					t.Errorf("q=%s: model %d preloaded version of model %d", q, m.ID, v.ModelID)
+				}
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("q=%s: got %v, want %v", q, got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/models/count?q="+url.QueryEscape("tag:(anime"), nil)
+	GetModelsCount(c)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad query status = %d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/stats?q="+url.QueryEscape("type:LORA")+"&category=anime", nil)
+	GetStats(c)
+	var stats struct {
+		TotalModels   int64 `json:"totalModels"`
+		TotalVersions int64 `json:"totalVersions"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	if stats.TotalModels != 2 || stats.TotalVersions != 2 {
+		t.Errorf("stats = %+v, want 2 models and versions", stats)
+	}
+
+	coll := models.Collection{Name: "anime loras"}
+	database.DB.Create(&coll)
+	body, _ := json.Marshal(map[string]string{"filter": "type:LORA tag:anime"})
+	rec = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(rec)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(coll.ID))}}
+	c.Request = httptest.NewRequest(http.MethodPost, "/collections/1/bulk-add", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	BulkAddVersions(c)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"added":2}` {
+		t.Errorf("bulk add = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(rec)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(coll.ID))}}
+	c.Request = httptest.NewRequest(http.MethodGet, "/collections/1/versions?q="+url.QueryEscape("-tag:realistic"), nil)
+	GetCollectionVersions(c)
+	var versions []models.Version
+	json.Unmarshal(rec.Body.Bytes(), &versions)
+	if len(versions) != 1 || versions[0].ID != anime.ID || rec.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("collection versions = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBulkAddLegacyMatching(t *testing.T) {
+	initTestDB(t)
+	for i, name := range []string{"Pony Mix", "Ponyverse", "My Pony XL"} {
+		m := models.Model{CivitID: i + 1, Name: name, Weight: 1}
+		database.DB.Create(&m)
+		database.DB.Create(&models.Version{ModelID: m.ID, VersionID: i + 1, Name: "v1", TrainedWords: "a, pony_style"})
+	}
+	coll := models.Collection{Name: "pony"}
+	database.DB.Create(&coll)
+
+	run := func(input map[string]interface{}) string {
+		body, _ := json.Marshal(input)
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(coll.ID))}}
+		c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		BulkAddVersions(c)
+		database.DB.Exec("DELETE FROM collection_versions")
+		return rec.Body.String()
+	}
+	if got := run(map[string]interface{}{"query": "pony", "searchModelName": true, "exactMatch": true}); got != `{"added":2}` {
+		t.Errorf("exact name = %s", got)
+	}
+	if got := run(map[string]interface{}{"query": "pony", "searchModelName": true}); got != `{"added":3}` {
+		t.Errorf("partial name = %s", got)
+	}
+	// _ is literal, not a LIKE wildcard.
+	if got := run(map[string]interface{}{"query": "pony_style", "searchTrainedWords": true, "exactMatch": true}); got != `{"added":3}` {
+		t.Errorf("exact words = %s", got)
+	}
+	if got := run(map[string]interface{}{"query": "ponyxstyle", "searchTrainedWords": true}); got != `{"added":0}` {
+		t.Errorf("escaped words = %s", got)
+	}
+}
@@ -67,7 +67,6 @@ func refreshVersionData(id int, fields string) error {
 		version.TrainedWords = strings.Join(verData.TrainedWords, ",")
 		version.Nsfw = modelData.Nsfw
 		version.Type = modelData.Type
-		version.Tags = strings.Join(modelData.Tags, ",")
 		version.Mode = modelData.Mode
 		version.ModelURL = fmt.Sprintf("https://civitai.com/models/%d?modelVersionId=%d", verData.ModelID, verData.ID)
 		version.CivitCreatedAt = verData.Created
@@ -146,5 +145,9 @@ func refreshVersionData(id int, fields string) error {
 
 	database.DB.Save(&model)
 	database.DB.Save(&version)
+	if updateMeta {
+		// Only the synced tags are replaced; user edits survive a refresh.
+		return database.SetSyncedTags(database.DB, version.ID, modelData.Tags)
+	}
 	return nil
 }
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxSafetensorsHeader bounds the JSON header we are willing to read. Real
+// headers are at most a few megabytes even with large tag frequency tables.
+const maxSafetensorsHeader = 100 << 20
+
+// maxTrainedWordsFromTags limits how many of the most frequent training tags
+// are used as trained words.
+const maxTrainedWordsFromTags = 5
+
+var errNotSafetensors = errors.New("not a safetensors file")
+
+// SafetensorsHeader is the parsed JSON header of a safetensors file.
+type SafetensorsHeader struct {
+	// Metadata is the optional __metadata__ string map.
+	Metadata    map[string]string
+	TensorCount int
+}
+
+// ReadSafetensorsHeader parses the header of the safetensors file at path.
+// Only the header is read; tensor data is skipped.
+func ReadSafetensorsHeader(path string) (SafetensorsHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SafetensorsHeader{}, err
+	}
+	defer f.Close()
+	return parseSafetensorsHeader(f)
+}
+
+// parseSafetensorsHeader reads the little-endian uint64 header length and the
+// JSON object that follows it from r.
+func parseSafetensorsHeader(r io.Reader) (SafetensorsHeader, error) {
+	var size uint64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return SafetensorsHeader{}, fmt.Errorf("%w: %v", errNotSafetensors, err)
+	}
+	if size < 2 || size > maxSafetensorsHeader {
+		return SafetensorsHeader{}, fmt.Errorf("%w: header length %d", errNotSafetensors, size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return SafetensorsHeader{}, fmt.Errorf("%w: %v", errNotSafetensors, err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return SafetensorsHeader{}, fmt.Errorf("%w: %v", errNotSafetensors, err)
+	}
+	header := SafetensorsHeader{Metadata: map[string]string{}}
+	for k, v := range raw {
+		if k != "__metadata__" {
+			header.TensorCount++
+			continue
+		}
+		// The format requires string values, but some trainers write numbers.
+		var meta map[string]interface{}
+		if err := json.Unmarshal(v, &meta); err != nil {
+			return SafetensorsHeader{}, fmt.Errorf("%w: invalid __metadata__: %v", errNotSafetensors, err)
+		}
+		for mk, mv := range meta {
+			if s, ok := mv.(string); ok {
+				header.Metadata[mk] = s
+			} else if b, err := json.Marshal(mv); err == nil {
+				header.Metadata[mk] = string(b)
+			}
+		}
+	}
+	return header, nil
+}
+
+// metadataBaseModel maps the base model recorded by kohya-ss
+// (ss_base_model_version) or the model spec (modelspec.architecture) to the
+// names CivitAI uses. Unrecognised values are returned unchanged.
+func metadataBaseModel(meta map[string]string) string {
+	raw := meta["ss_base_model_version"]
+	if raw == "" {
+		raw = meta["modelspec.architecture"]
+	}
+	if raw == "" {
+		if meta["ss_v2"] == "True" {
+			return "SD 2.1"
+		}
+		return ""
+	}
+	v := strings.ToLower(raw)
+	switch {
+	case strings.Contains(v, "sdxl") || strings.Contains(v, "stable-diffusion-xl"):
+		return "SDXL 1.0"
+	case strings.Contains(v, "sd3") || strings.Contains(v, "stable-diffusion-3") || strings.Contains(v, "stable-diffusion-v3"):
+		return "SD 3"
+	case strings.Contains(v, "flux"):
+		if strings.Contains(v, "schnell") {
+			return "Flux.1 S"
+		}
+		return "Flux.1 D"
+	case strings.HasPrefix(v, "sd_v2") || strings.HasPrefix(v, "stable-diffusion-v2"):
+		return "SD 2.1"
+	case strings.HasPrefix(v, "sd_v1") || strings.HasPrefix(v, "stable-diffusion-v1"):
+		return "SD 1.5"
+	}
+	return raw
+}
+
+// metadataTrainedWords returns the trigger words for a file: the model spec
+// trigger phrase when present, otherwise the most frequent tags from
+// ss_tag_frequency.
+func metadataTrainedWords(meta map[string]string) []string {
+	if phrase := strings.TrimSpace(meta["modelspec.trigger_phrase"]); phrase != "" {
+		var words []string
+		for _, w := range strings.Split(phrase, ",") {
+			if w = strings.TrimSpace(w); w != "" {
+				words = append(words, w)
+			}
+		}
+		return words
+	}
+
+	var datasets map[string]map[string]int
+	if json.Unmarshal([]byte(meta["ss_tag_frequency"]), &datasets) != nil {
+		return nil
+	}
+	counts := map[string]int{}
+	for _, tags := range datasets {
+		for tag, n := range tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				counts[tag] += n
+			}
+		}
+	}
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if counts[tags[i]] != counts[tags[j]] {
+			return counts[tags[i]] > counts[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	if len(tags) > maxTrainedWordsFromTags {
+		tags = tags[:maxTrainedWordsFromTags]
+	}
+	return tags
+}
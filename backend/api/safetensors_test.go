@@ -0,0 +1,187 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+// safetensorsBytes builds a minimal safetensors file with one tensor and the
+// given header metadata.
+func safetensorsBytes(t *testing.T, meta map[string]interface{}) []byte {
+	t.Helper()
+	header := map[string]interface{}{
+		"lora_up.weight": map[string]interface{}{"dtype": "F16", "shape": []int{1}, "data_offsets": []int{0, 2}},
+	}
+	if meta != nil {
+		header["__metadata__"] = meta
+	}
+	js, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("marshal header: %v", err)
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(len(js)))
+	buf.Write(js)
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
+
+func TestParseSafetensorsHeader(t *testing.T) {
+	data := safetensorsBytes(t, map[string]interface{}{
+		"ss_base_model_version": "sdxl_base_v1-0",
+		"ss_network_dim":        32,
+	})
+	h, err := parseSafetensorsHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if h.TensorCount != 1 || h.Metadata["ss_base_model_version"] != "sdxl_base_v1-0" || h.Metadata["ss_network_dim"] != "32" {
+		t.Errorf("header = %+v", h)
+	}
+
+	for name, data := range map[string][]byte{
+		"short":    {1, 2},
+		"too long": append(binary.LittleEndian.AppendUint64(nil, maxSafetensorsHeader+1), '{', '}'),
+		"not json": append(binary.LittleEndian.AppendUint64(nil, 4), []byte("nope")...),
+	} {
+		if _, err := parseSafetensorsHeader(bytes.NewReader(data)); !errors.Is(err, errNotSafetensors) {
+			t.Errorf("%s: err = %v, want errNotSafetensors", name, err)
+		}
+	}
+}
+
+func TestMetadataDerivedFields(t *testing.T) {
+	bases := map[string]string{
+		"sd_v1":                            "SD 1.5",
+		"sdxl_base_v1-0":                   "SDXL 1.0",
+		"sd_v2_768_v":                      "SD 2.1",
+		"stable-diffusion-xl-v1-base/lora": "SDXL 1.0",
+		"flux-1-dev/lora":                  "Flux.1 D",
+		"something-new":                    "something-new",
+	}
+	for raw, want := range bases {
+		if got := metadataBaseModel(map[string]string{"ss_base_model_version": raw}); got != want {
+			t.Errorf("metadataBaseModel(%q) = %q, want %q", raw, got, want)
+		}
+	}
+
+	meta := map[string]string{"ss_tag_frequency": `{"10_ohwx":{"ohwx":40,"1girl":30,"smile":5},"5_reg":{"1girl":20,"solo":12,"outdoors":8,"hat":1}}`}
+	want := []string{"1girl", "ohwx", "solo", "outdoors", "smile"}
+	if got := metadataTrainedWords(meta); !reflect.DeepEqual(got, want) {
+		t.Errorf("trained words = %v, want %v", got, want)
+	}
+	meta["modelspec.trigger_phrase"] = "ohwx person, red hat"
+	if got := metadataTrainedWords(meta); !reflect.DeepEqual(got, []string{"ohwx person", "red hat"}) {
+		t.Errorf("trigger phrase words = %v", got)
+	}
+}
+
+func TestUploadVersionFileReadsMetadata(t *testing.T) {
+	setupUploadTest(t)
+	m := models.Model{Name: "manual", Type: "LORA", Weight: 1}
+	database.DB.Create(&m)
+	v := models.Version{ModelID: m.ID, VersionID: -1, Name: "v1", Type: "LORA"}
+	database.DB.Create(&v)
+
+	data := safetensorsBytes(t, map[string]interface{}{
+		"ss_base_model_version": "sd_v1",
+		"ss_tag_frequency":      `{"img":{"ohwx":3}}`,
+		"ss_output_name":        "ohwx_lora",
+	})
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, _ := w.CreateFormFile("file", "ohwx.safetensors")
+	part.Write(data)
+	w.Close()
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(v.ID))}}
+	c.Request = httptest.NewRequest(http.MethodPost, "/versions/"+strconv.Itoa(int(v.ID))+"/upload", body)
+	c.Request.Header.Set("Content-Type", w.FormDataContentType())
+	UploadVersionFile(c)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	database.DB.First(&v, v.ID)
+	if v.BaseModel != "SD 1.5" || v.TrainedWords != "ohwx" {
+		t.Errorf("version = base %q, words %q", v.BaseModel, v.TrainedWords)
+	}
+
+	rec = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(rec)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(v.ID))}}
+	GetVersionFileMetadata(c)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("file-metadata status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Format       string            `json:"format"`
+		TensorCount  int               `json:"tensorCount"`
+		Metadata     map[string]string `json:"metadata"`
+		BaseModel    string            `json:"baseModel"`
+		TrainedWords []string          `json:"trainedWords"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Format != "safetensors" || resp.TensorCount != 1 || resp.Metadata["ss_output_name"] != "ohwx_lora" ||
+		resp.BaseModel != "SD 1.5" || !reflect.DeepEqual(resp.TrainedWords, []string{"ohwx"}) {
+		t.Errorf("file-metadata = %+v", resp)
+	}
+}
+
+func TestGetVersionFileMetadataReadsOnDemand(t *testing.T) {
+	initTestDB(t)
+	root := t.TempDir()
+	database.SetSettingValue("model_path", root)
+	writeFile := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(root, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("old.safetensors", safetensorsBytes(t, map[string]interface{}{"ss_network_dim": "16"}))
+	writeFile("old.pt", []byte("pickle"))
+
+	old := models.Version{VersionID: 1, FilePath: "old.safetensors"}
+	pt := models.Version{VersionID: 2, FilePath: "old.pt"}
+	none := models.Version{VersionID: 3}
+	database.DB.Create(&old)
+	database.DB.Create(&pt)
+	database.DB.Create(&none)
+
+	get := func(id uint) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(id))}}
+		GetVersionFileMetadata(c)
+		return rec
+	}
+	if rec := get(old.ID); rec.Code != http.StatusOK {
+		t.Errorf("safetensors status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var count int64
+	database.DB.Model(&models.FileMetadata{}).Where("version_id = ?", old.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("stored metadata rows = %d, want 1", count)
+	}
+	if rec := get(pt.ID); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf(".pt status = %d, want 422", rec.Code)
+	}
+	if rec := get(none.ID); rec.Code != http.StatusNotFound {
+		t.Errorf("no file status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+
+	"model-manager/backend/database"
+
+	"gorm.io/gorm"
+)
+
+// searchRankWeights are the bm25 weights of the search_index columns:
+// model name, version name, tags, trained words, description and prompts.
+const searchRankWeights = "10.0, 5.0, 3.0, 3.0, 1.0, 0.5"
+
+// searchTerm is one element of a search string: a word, which matches as a
+// prefix, or a quoted phrase, optionally excluded with a leading "-".
+type searchTerm struct {
+	Text    string
+	Phrase  bool
+	Exclude bool
+}
+
+// parseSearch splits a search string into terms. Words are separated by
+// whitespace, "double quotes" group a phrase and a leading - excludes the
+// word or phrase that follows.
+func parseSearch(s string) []searchTerm {
+	var terms []searchTerm
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+		var term searchTerm
+		if rs[i] == '-' {
+			term.Exclude = true
+			i++
+		}
+		start := i
+		if i < len(rs) && rs[i] == '"' {
+			term.Phrase = true
+			i++
+			start = i
+			for i < len(rs) && rs[i] != '"' {
+				i++
+			}
+			term.Text = string(rs[start:i])
+			i++ // closing quote, if any
+		} else {
+			for i < len(rs) && !unicode.IsSpace(rs[i]) {
+				i++
+			}
+			term.Text = string(rs[start:i])
+		}
+		term.Text = strings.ToLower(strings.Join(strings.Fields(term.Text), " "))
+		if term.Text != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// ftsString quotes text as an FTS5 string so operators and punctuation in
+// user input are matched literally.
+func ftsString(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
+
+// ftsMatch builds an FTS5 expression that matches any of terms (joined with
+// OR) or all of them (joined with AND). Words become prefix queries.
+func ftsMatch(terms []searchTerm, op string) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		p := ftsString(t.Text)
+		if !t.Phrase {
+			p += "*"
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, " "+op+" ")
+}
+
+// searchTermCond matches a single search term against the versions table,
+// through the full-text index when available and LIKE otherwise. The Exclude
+// flag is ignored; callers negate the condition themselves.
+func searchTermCond(t searchTerm) sqlCond {
+	if database.SearchIndexEnabled() {
+		t.Exclude = false
+		return sqlCond{"versions.id IN (SELECT rowid FROM search_index WHERE search_index MATCH ?)", []interface{}{ftsMatch([]searchTerm{t}, "AND")}}
+	}
+	like := "%" + likeEscaper.Replace(t.Text) + "%"
+	return sqlCond{
+		`(LOWER(models.name) LIKE ? ESCAPE '\' OR LOWER(versions.name) LIKE ? ESCAPE '\' OR LOWER(versions.trained_words) LIKE ? ESCAPE '\' OR LOWER(versions.tags) LIKE ? ESCAPE '\')`,
+		[]interface{}{like, like, like, like},
+	}
+}
+
+// applySearch restricts q, which must select from or join both versions and
+// models, to versions matching the search string. With the FTS index the
+// matches are joined as search_hits, and ranked reports that
+// search_hits.rank (lower is better) can be used for ordering.
+func applySearch(q *gorm.DB, search string) (db *gorm.DB, ranked bool) {
+	var include, exclude []searchTerm
+	for _, t := range parseSearch(search) {
+		if t.Exclude {
+			exclude = append(exclude, t)
+		} else {
+			include = append(include, t)
+		}
+	}
+	if len(include) == 0 && len(exclude) == 0 {
+		return q, false
+	}
+
+	if !database.SearchIndexEnabled() {
+		for _, t := range include {
+			c := searchTermCond(t)
+			q = q.Where(c.SQL, c.Args...)
+		}
+		for _, t := range exclude {
+			c := searchTermCond(t)
+			q = q.Where("NOT "+c.SQL, c.Args...)
+		}
+		return q, false
+	}
+
+	if len(include) > 0 {
+		// LIMIT -1 keeps SQLite from flattening the subquery into a grouped
+		// outer query, where bm25() cannot be evaluated.
+		q = q.Joins("JOIN (SELECT rowid AS version_id, bm25(search_index, "+searchRankWeights+") AS rank FROM search_index WHERE search_index MATCH ? LIMIT -1) AS search_hits ON search_hits.version_id = versions.id",
+			ftsMatch(include, "AND"))
+		ranked = true
+	}
+	if len(exclude) > 0 {
+		q = q.Where("versions.id NOT IN (SELECT rowid FROM search_index WHERE search_index MATCH ?)", ftsMatch(exclude, "OR"))
+	}
+	return q, ranked
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func TestParseSearch(t *testing.T) {
+	got := parseSearch(`  Anime  "Line  Art" -realistic -"photo style" "unclosed`)
+	want := []searchTerm{
+		{Text: "anime"},
+		{Text: "line art", Phrase: true},
+		{Text: "realistic", Exclude: true},
+		{Text: "photo style", Phrase: true, Exclude: true},
+		{Text: "unclosed", Phrase: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSearch = %+v, want %+v", got, want)
+	}
+	if terms := parseSearch(` - "" `); len(terms) != 0 {
+		t.Errorf("empty terms = %+v", terms)
+	}
+	if m := ftsMatch([]searchTerm{{Text: `a"b`}, {Text: "c d", Phrase: true}}, "AND"); m != `"a""b"* AND "c d"` {
+		t.Errorf("ftsMatch = %s", m)
+	}
+}
+
+// searchModelNames runs GetModels with the given search and returns the
+// model names in response order.
+func searchModelNames(t *testing.T, search string) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/models", nil)
+	q := c.Request.URL.Query()
+	q.Set("search", search)
+	c.Request.URL.RawQuery = q.Encode()
+	GetModels(c)
+	var list []models.Model
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	names := []string{}
+	for _, m := range list {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func createSearchModel(t *testing.T, name string, v models.Version) models.Version {
+	t.Helper()
+	m := models.Model{CivitID: v.VersionID, Name: name, Weight: 1}
+	database.DB.Create(&m)
+	v.ModelID = m.ID
+	database.DB.Create(&v)
+	return v
+}
+
+func TestSearchSyntax(t *testing.T) {
+	initTestDB(t)
+	createSearchModel(t, "Anime Lineart", models.Version{VersionID: 1, Name: "v1", Tags: "anime,lineart"})
+	createSearchModel(t, "Anime Realistic Mix", models.Version{VersionID: 2, Name: "v1", Tags: "anime,realistic"})
+	createSearchModel(t, "Photo Style", models.Version{VersionID: 3, Name: "v2", TrainedWords: "photo style"})
+
+	cases := map[string][]string{
+		"anim":             {"Anime Realistic Mix", "Anime Lineart"},
+		"anime -realistic": {"Anime Lineart"},
+		`"photo style"`:    {"Photo Style"},
+		`-"photo style"`:   {"Anime Realistic Mix", "Anime Lineart"},
+		"anime photo":      {},
+		`"style photo"`:    {},
+	}
+	for search, want := range cases {
+		// Ranking is covered separately; compare matches as sets.
+		got := map[string]bool{}
+		for _, n := range searchModelNames(t, search) {
+			got[n] = true
+		}
+		wantSet := map[string]bool{}
+		for _, n := range want {
+			wantSet[n] = true
+		}
+		if !reflect.DeepEqual(got, wantSet) {
+			t.Errorf("search %q = %v, want %v", search, got, want)
+		}
+	}
+}
+
+func TestSearchIndexRanksAndStaysInSync(t *testing.T) {
+	initTestDB(t)
+	if !database.SearchIndexEnabled() {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+	desc := createSearchModel(t, "Generic Pack", models.Version{VersionID: 1, Name: "v1", Description: "<p>great for dragons</p>"})
+	createSearchModel(t, "Dragon Scales", models.Version{VersionID: 2, Name: "v1"})
+
+	if got := searchModelNames(t, "dragon"); !reflect.DeepEqual(got, []string{"Dragon Scales", "Generic Pack"}) {
+		t.Errorf("ranked search = %v, want name match first", got)
+	}
+
+	database.DB.Create(&models.VersionImage{VersionID: desc.ID, Meta: `{"prompt":"a castle at dusk"}`})
+	database.DB.Create(&models.VersionImage{VersionID: desc.ID, Meta: `null`})
+	if got := searchModelNames(t, "castle"); !reflect.DeepEqual(got, []string{"Generic Pack"}) {
+		t.Errorf("prompt search = %v", got)
+	}
+
+	database.DB.Model(&models.Model{}).Where("id = ?", desc.ModelID).Update("name", "Castle Kit")
+	if got := searchModelNames(t, "kit"); !reflect.DeepEqual(got, []string{"Castle Kit"}) {
+		t.Errorf("search after rename = %v", got)
+	}
+
+	database.DB.Delete(&desc)
+	if got := searchModelNames(t, "castle"); len(got) != 0 {
+		t.Errorf("search after delete = %v", got)
+	}
+
+	var rows int64
+	database.DB.Raw("SELECT COUNT(*) FROM " + database.SearchIndexTable).Scan(&rows)
+	if err := database.RebuildSearchIndex(database.DB); err != nil {
+		t.Fatalf("RebuildSearchIndex: %v", err)
+	}
+	var rebuilt int64
+	database.DB.Raw("SELECT COUNT(*) FROM " + database.SearchIndexTable).Scan(&rebuilt)
+	if rows != 1 || rebuilt != rows {
+		t.Errorf("index rows = %d, after rebuild %d, want 1", rows, rebuilt)
+	}
+}
@@ -0,0 +1,603 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Background jobs that write sidecars for the library and read them back.
+const (
+	JobTypeWriteSidecars  = "write-sidecars"
+	JobTypeImportSidecars = "import-sidecars"
+)
+
+// Sidecar files kept next to a model file by A1111/Forge and the ComfyUI
+// model managers: <model>.civitai.info and <model>.preview.png.
+const (
+	sidecarInfoSuffix    = ".civitai.info"
+	sidecarPreviewSuffix = ".preview.png"
+)
+
+// Model file extensions looked for next to a sidecar on import.
+var sidecarModelExts = []string{".safetensors", ".ckpt", ".pt", ".pth", ".bin"}
+
+var (
+	errNoModelFile = errors.New("model version has no local file")
+	errNoPreview   = errors.New("model version has no image")
+)
+
+// CivitaiInfo is the content of a .civitai.info sidecar. The tools reading
+// it expect CivitAI's model-version response, so it reuses that shape.
+type CivitaiInfo struct {
+	VersionResponse
+	Description string           `json:"description,omitempty"`
+	DownloadURL string           `json:"downloadUrl,omitempty"`
+	Model       CivitaiInfoModel `json:"model"`
+}
+
+type CivitaiInfoModel struct {
+	Name string   `json:"name"`
+	Type string   `json:"type"`
+	Nsfw bool     `json:"nsfw"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// buildCivitaiInfo describes v, loaded with its ParentModel and Images, as a
+// .civitai.info document. The main image is listed first.
+func buildCivitaiInfo(v models.Version) CivitaiInfo {
+	info := CivitaiInfo{
+		VersionResponse: VersionResponse{
+			ID:                   v.VersionID,
+			ModelID:              v.ParentModel.CivitID,
+			Name:                 v.Name,
+			BaseModel:            v.BaseModel,
+			Created:              v.CivitCreatedAt,
+			Updated:              v.CivitUpdatedAt,
+			EarlyAccessTimeFrame: v.EarlyAccessTimeFrame,
+			TrainedWords:         splitCommaList(v.TrainedWords),
+			ModelFiles:           []ModelFile{},
+			Images:               []ModelImage{},
+		},
+		Description: v.Description,
+		DownloadURL: v.DownloadURL,
+		Model: CivitaiInfoModel{
+			Name: v.ParentModel.Name,
+			Type: versionModelType(v),
+			Nsfw: v.Nsfw,
+			Tags: splitCommaList(v.Tags),
+		},
+	}
+
+	if v.FilePath != "" {
+		file := ModelFile{Name: filepath.Base(v.FilePath), DownloadURL: v.DownloadURL, SizeKB: v.SizeKB}
+		// CivitAI publishes hashes in upper case.
+		file.Hashes.SHA256 = strings.ToUpper(v.SHA256)
+		info.ModelFiles = append(info.ModelFiles, file)
+	}
+
+	images := append([]models.VersionImage(nil), v.Images...)
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].Path == v.ImagePath && images[j].Path != v.ImagePath
+	})
+	for _, img := range images {
+		var meta map[string]interface{}
+		if img.Meta != "" {
+			json.Unmarshal([]byte(img.Meta), &meta)
+		}
+		// The library keeps local copies only, and a path on this server
+		// means nothing to the tools reading the file, so the URL is empty.
+		info.Images = append(info.Images, ModelImage{
+			Width:  img.Width,
+			Height: img.Height,
+			Hash:   img.Hash,
+			Meta:   meta,
+		})
+	}
+	return info
+}
+
+// splitCommaList splits a comma-joined field such as TrainedWords, dropping
+// empty entries. It never returns nil so the JSON holds an array.
+func splitCommaList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// sidecarPreviewSource is the image used for the preview sidecar: the
+// version's main image, or its first image.
+func sidecarPreviewSource(v models.Version) string {
+	if v.ImagePath != "" {
+		return v.ImagePath
+	}
+	if len(v.Images) > 0 {
+		return v.Images[0].Path
+	}
+	return ""
+}
+
+// encodeSidecarPreview returns the version's preview image as PNG.
+func encodeSidecarPreview(v models.Version) ([]byte, error) {
+	src := sidecarPreviewSource(v)
+	if src == "" {
+		return nil, errNoPreview
+	}
+	img, err := decodeImageFile(ResolveImagePath(src))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// sidecarBase strips the extension from a model file path; sidecar suffixes
+// are appended to the result.
+func sidecarBase(modelPath string) string {
+	return strings.TrimSuffix(modelPath, filepath.Ext(modelPath))
+}
+
+// writeSidecars writes the .civitai.info and, when the version has an
+// image, the .preview.png next to its model file, replacing earlier ones.
+// It returns the paths written.
+func writeSidecars(v models.Version) ([]string, error) {
+	if v.FilePath == "" {
+		return nil, errNoModelFile
+	}
+	modelPath := ResolveModelPath(v.FilePath)
+	if _, err := os.Stat(modelPath); err != nil {
+		return nil, err
+	}
+	base := sidecarBase(modelPath)
+
+	data, err := json.MarshalIndent(buildCivitaiInfo(v), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(base+sidecarInfoSuffix, data, 0o644); err != nil {
+		return nil, err
+	}
+	written := []string{base + sidecarInfoSuffix}
+
+	preview, err := encodeSidecarPreview(v)
+	if errors.Is(err, errNoPreview) {
+		return written, nil
+	} else if err != nil {
+		return written, err
+	}
+	if err := os.WriteFile(base+sidecarPreviewSuffix, preview, 0o644); err != nil {
+		return written, err
+	}
+	return append(written, base+sidecarPreviewSuffix), nil
+}
+
+// dispatchSidecars asks a connected client to fetch the sidecars of v and
+// store them next to its copy of the model file. URL carries the info
+// endpoint and ThumbnailURL the preview endpoint.
+func dispatchSidecars(client models.Client, v models.Version) error {
+	req := DispatchRequest{
+		Action:         "sidecar",
+		URL:            fmt.Sprintf("/api/versions/%d/sidecar/info", v.ID),
+		Filename:       clientRelativePath(v, client.Settings),
+		ModelVersionID: v.ID,
+		ClientID:       client.ClientID,
+	}
+	if sidecarPreviewSource(v) != "" {
+		req.ThumbnailURL = fmt.Sprintf("/api/versions/%d/sidecar/preview", v.ID)
+	}
+	return SendToClient(client.ClientID, req)
+}
+
+// sidecarVersion loads the version named by the :id parameter with what the
+// sidecars need, writing an error response if it cannot.
+func sidecarVersion(c *gin.Context) (models.Version, bool) {
+	var version models.Version
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version ID"})
+		return version, false
+	}
+	if err := database.DB.Preload("ParentModel").Preload("Images").First(&version, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return version, false
+	}
+	return version, true
+}
+
+// GetSidecarInfo serves the .civitai.info document of a version. Remote
+// clients fetch it when asked to write sidecars.
+func GetSidecarInfo(c *gin.Context) {
+	version, ok := sidecarVersion(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, buildCivitaiInfo(version))
+}
+
+// GetSidecarPreview serves the version's main image converted to PNG.
+func GetSidecarPreview(c *gin.Context) {
+	version, ok := sidecarVersion(c)
+	if !ok {
+		return
+	}
+	data, err := encodeSidecarPreview(version)
+	if errors.Is(err, errNoPreview) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version has no image"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", data)
+}
+
+type sidecarRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+// bindSidecarRequest reads the optional {"client_id": ...} body that sends
+// sidecars to a remote client instead of the local model directory.
+func bindSidecarRequest(c *gin.Context) (sidecarRequest, bool) {
+	var req sidecarRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// WriteVersionSidecars writes the sidecars of one version next to its model
+// file, or dispatches them to the client named in the body.
+func WriteVersionSidecars(c *gin.Context) {
+	req, ok := bindSidecarRequest(c)
+	if !ok {
+		return
+	}
+	version, ok := sidecarVersion(c)
+	if !ok {
+		return
+	}
+	if version.FilePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Model version has no local file"})
+		return
+	}
+
+	if req.ClientID != "" {
+		client, err := database.GetClient(req.ClientID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load client"})
+			return
+		}
+		if err := dispatchSidecars(client, version); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Client not connected", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "dispatched"})
+		return
+	}
+
+	written, err := writeSidecars(version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "files": written})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"files": written})
+}
+
+// WriteLibrarySidecars writes sidecars for every version with a model file
+// in a background job. With a client_id in the body the versions installed
+// on that client are dispatched to it instead.
+func WriteLibrarySidecars(c *gin.Context) {
+	req, ok := bindSidecarRequest(c)
+	if !ok {
+		return
+	}
+	if req.ClientID != "" {
+		if !clientConnected(req.ClientID) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Client not connected"})
+			return
+		}
+	}
+	startJob(c, JobTypeWriteSidecars, func(run *JobRun) error {
+		return writeLibrarySidecars(run, req.ClientID)
+	})
+}
+
+func writeLibrarySidecars(run *JobRun, clientID string) error {
+	q := database.DB.Preload("ParentModel").Preload("Images").Where("file_path <> ''")
+	if clientID != "" {
+		q = q.Where("id IN (?)", database.DB.Model(&models.ClientFile{}).
+			Select("model_version_id").Where("client_id = ? AND status = ?", clientID, "installed"))
+	}
+	var versions []models.Version
+	if err := q.Find(&versions).Error; err != nil {
+		return err
+	}
+	client, err := database.GetClient(clientID)
+	if err != nil {
+		return err
+	}
+	run.SetTotal(len(versions))
+
+	written := 0
+	for _, v := range versions {
+		if run.Cancelled() {
+			break
+		}
+		var err error
+		if clientID != "" {
+			err = dispatchSidecars(client, v)
+		} else {
+			_, err = writeSidecars(v)
+		}
+		if err != nil {
+			run.Errorf("sidecars for version %d: %v", v.VersionID, err)
+			continue
+		}
+		written++
+		run.Processed(true)
+	}
+
+	if clientID != "" {
+		run.SetMessage(fmt.Sprintf("Sent sidecars for %d versions to %s", written, clientID))
+	} else {
+		run.SetMessage(fmt.Sprintf("Wrote sidecars for %d versions", written))
+	}
+	return nil
+}
+
+// ImportSidecars scans the model directory for .civitai.info sidecars in a
+// background job, creating the versions they describe and filling in empty
+// fields of versions already in the library.
+func ImportSidecars(c *gin.Context) {
+	startJob(c, JobTypeImportSidecars, importSidecars)
+}
+
+func importSidecars(run *JobRun) error {
+	var paths []string
+	err := filepath.WalkDir(database.GetModelPath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("sidecar scan: %v", err)
+			return nil
+		}
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), sidecarInfoSuffix) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	run.SetTotal(len(paths))
+
+	var counts ImportCounts
+	for _, path := range paths {
+		if run.Cancelled() {
+			break
+		}
+		outcome, err := importSidecar(path)
+		switch {
+		case err != nil:
+			counts.Failed++
+			run.Errorf("import %s: %v", path, err)
+			continue
+		case outcome == importCreated:
+			counts.Created++
+		case outcome == importUpdated:
+			counts.Updated++
+		default:
+			counts.Skipped++
+		}
+		run.Processed(outcome != importSkipped)
+	}
+	run.SetResult(counts)
+	run.SetMessage(fmt.Sprintf("Imported %d sidecars: %d created, %d updated", len(paths)-counts.Failed, counts.Created, counts.Updated))
+	return nil
+}
+
+// sidecarMergeFields are the version fields a sidecar fills in on a version
+// already in the library.
+var sidecarMergeFields = []string{
+	"Name", "BaseModel", "EarlyAccessTimeFrame", "SizeKB", "TrainedWords", "Description",
+	"CivitCreatedAt", "CivitUpdatedAt", "SHA256", "DownloadURL", "FilePath",
+}
+
+// importSidecar records the version described by the .civitai.info at
+// infoPath. The model file next to it becomes the version's file and the
+// .preview.png its image. Existing versions only gain what they lack.
+func importSidecar(infoPath string) (importOutcome, error) {
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return importSkipped, err
+	}
+	var info CivitaiInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return importSkipped, err
+	}
+	if info.ID == 0 || info.ModelID == 0 {
+		return importSkipped, errors.New("missing CivitAI model or version id")
+	}
+	base := infoPath[:len(infoPath)-len(sidecarInfoSuffix)]
+	incoming := sidecarVersionRecord(info, findSidecarModelFile(base))
+
+	var existing models.Version
+	database.DB.Unscoped().Where("version_id = ?", info.ID).Limit(1).Find(&existing)
+	if existing.DeletedAt.Valid {
+		return importSkipped, nil
+	}
+
+	if existing.ID > 0 {
+		outcome := importSkipped
+		if fields := mergeFields(ImportFill, &existing, &incoming, sidecarMergeFields); len(fields) > 0 {
+			if err := database.DB.Model(&existing).Select(fields).Updates(&incoming).Error; err != nil {
+				return outcome, err
+			}
+			outcome = importUpdated
+		}
+		if existing.Tags == "" && len(info.Model.Tags) > 0 {
+			if err := database.SetSyncedTags(database.DB, existing.ID, info.Model.Tags); err != nil {
+				return outcome, err
+			}
+			outcome = importUpdated
+		}
+		if existing.ImagePath == "" {
+			added, err := importSidecarPreview(&existing, base, info)
+			if err != nil {
+				return outcome, err
+			}
+			if added {
+				outcome = importUpdated
+			}
+		}
+		return outcome, nil
+	}
+
+	model := ensureCivitModel(info.ModelID, CivitModel{
+		ID:   info.ModelID,
+		Name: info.Model.Name,
+		Type: info.Model.Type,
+		Nsfw: info.Model.Nsfw,
+	})
+	incoming.ModelID = model.ID
+	if err := database.DB.Create(&incoming).Error; err != nil {
+		return importSkipped, err
+	}
+	if err := database.SetSyncedTags(database.DB, incoming.ID, info.Model.Tags); err != nil {
+		return importCreated, err
+	}
+	if incoming.FilePath != "" {
+		recordFileMetadata(incoming.ID, ResolveModelPath(incoming.FilePath))
+		database.DB.Model(&models.Model{}).
+			Where("id = ? AND (file_path = '' OR file_path IS NULL)", model.ID).
+			Update("file_path", incoming.FilePath)
+	}
+	if _, err := importSidecarPreview(&incoming, base, info); err != nil {
+		return importCreated, err
+	}
+	return importCreated, nil
+}
+
+// sidecarVersionRecord is the version a sidecar describes, not yet attached
+// to a model. modelPath is the model file found next to it, if any.
+func sidecarVersionRecord(info CivitaiInfo, modelPath string) models.Version {
+	v := models.Version{
+		VersionID:            info.ID,
+		Name:                 info.Name,
+		BaseModel:            info.BaseModel,
+		EarlyAccessTimeFrame: info.EarlyAccessTimeFrame,
+		TrainedWords:         strings.Join(info.TrainedWords, ","),
+		Nsfw:                 info.Model.Nsfw,
+		Type:                 info.Model.Type,
+		Tags:                 strings.Join(info.Model.Tags, ","),
+		Description:          info.Description,
+		ModelURL:             fmt.Sprintf("https://civitai.com/models/%d?modelVersionId=%d", info.ModelID, info.ID),
+		CivitCreatedAt:       info.Created,
+		CivitUpdatedAt:       info.Updated,
+		DownloadURL:          info.DownloadURL,
+	}
+	if len(info.ModelFiles) > 0 {
+		file := info.ModelFiles[0]
+		for _, f := range info.ModelFiles {
+			if modelPath != "" && strings.EqualFold(f.Name, filepath.Base(modelPath)) {
+				file = f
+				break
+			}
+		}
+		v.SizeKB = file.SizeKB
+		v.SHA256 = strings.ToLower(file.Hashes.SHA256)
+		if v.DownloadURL == "" {
+			v.DownloadURL = file.DownloadURL
+		}
+	}
+	if modelPath != "" {
+		v.FilePath = MakeRelativePath(modelPath, database.GetModelPath())
+	}
+	return v
+}
+
+// findSidecarModelFile returns the model file sharing base with a sidecar,
+// or "" if there is none.
+func findSidecarModelFile(base string) string {
+	for _, ext := range sidecarModelExts {
+		if info, err := os.Stat(base + ext); err == nil && !info.IsDir() {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+// importSidecarPreview copies the .preview.png next to a sidecar into the
+// image directory and makes it the version's main image, keeping the
+// generation data of the first image listed in the info. It reports whether
+// there was a preview to import.
+func importSidecarPreview(v *models.Version, base string, info CivitaiInfo) (bool, error) {
+	data, err := os.ReadFile(base + sidecarPreviewSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	modelType := v.Type
+	if modelType == "" {
+		modelType = "Other"
+	}
+	dest := filepath.Join(database.GetImagePath(), modelType, fmt.Sprintf("%d_preview.png", v.VersionID))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(dest, data, 0o644); err != nil {
+		return false, err
+	}
+
+	w, h, _ := GetImageDimensions(dest)
+	hash, _ := FileHash(dest)
+	var meta []byte
+	if len(info.Images) > 0 && info.Images[0].Meta != nil {
+		meta, _ = json.Marshal(info.Images[0].Meta)
+	}
+	rel := MakeRelativePath(dest, database.GetImagePath())
+	if err := database.DB.Create(&models.VersionImage{
+		VersionID: v.ID,
+		Path:      rel,
+		Width:     w,
+		Height:    h,
+		Hash:      hash,
+		Meta:      string(meta),
+	}).Error; err != nil {
+		return false, err
+	}
+	if err := database.DB.Model(v).Update("image_path", rel).Error; err != nil {
+		return false, err
+	}
+	database.DB.Model(&models.Model{}).
+		Where("id = ? AND (image_path = '' OR image_path IS NULL)", v.ModelID).
+		Updates(map[string]interface{}{"image_path": rel, "image_width": w, "image_height": h})
+	if err := EnsureVersionThumbnail(v.ID, rel); err != nil {
+		log.Printf("Failed to generate version thumbnail: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func writeTestPNG(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSidecarRoundTrip(t *testing.T) {
+	initTestDB(t)
+	imageRoot, modelRoot := t.TempDir(), t.TempDir()
+	database.SetSettingValue("image_path", imageRoot)
+	database.SetSettingValue("model_path", modelRoot)
+
+	m := models.Model{CivitID: 1, Name: "Style", Type: "LORA", Weight: 1}
+	database.DB.Create(&m)
+	v := models.Version{ModelID: m.ID, VersionID: 101, Name: "v1", BaseModel: "SDXL 1.0", Type: "LORA",
+		TrainedWords: "foo, bar", SHA256: "abcd", FilePath: "LORA/style.safetensors", ImagePath: "LORA/101_0.jpg"}
+	database.DB.Create(&v)
+	database.SetSyncedTags(database.DB, v.ID, []string{"anime"})
+	database.DB.Create(&models.VersionImage{VersionID: v.ID, Path: "LORA/101_0.jpg", Meta: `{"prompt":"a cat"}`})
+	writeTestPNG(t, filepath.Join(imageRoot, "LORA", "101_0.jpg"))
+	writeTestFile(t, filepath.Join(modelRoot, "LORA", "style.safetensors"), "weights")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Request = httptest.NewRequest(http.MethodPost, "/versions/1/sidecars", nil)
+	WriteVersionSidecars(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("write = %d %s", w.Code, w.Body.String())
+	}
+
+	base := filepath.Join(modelRoot, "LORA", "style")
+	data, err := os.ReadFile(base + sidecarInfoSuffix)
+	if err != nil {
+		t.Fatalf("info not written: %v", err)
+	}
+	var info CivitaiInfo
+	json.Unmarshal(data, &info)
+	if info.ID != 101 || info.ModelID != 1 || info.Model.Type != "LORA" || len(info.TrainedWords) != 2 ||
+		len(info.ModelFiles) != 1 || info.ModelFiles[0].Hashes.SHA256 != "ABCD" ||
+		len(info.Images) != 1 || info.Images[0].URL != "" || info.Images[0].Meta["prompt"] != "a cat" || len(info.Model.Tags) != 1 {
+		t.Fatalf("info = %s", data)
+	}
+	if _, err := decodeImageFile(base + sidecarPreviewSuffix); err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+
+	// A fresh library picks the version up from the sidecars alone.
+	initTestDB(t)
+	database.SetSettingValue("image_path", imageRoot)
+	database.SetSettingValue("model_path", modelRoot)
+	writeTestFile(t, filepath.Join(modelRoot, "broken"+sidecarInfoSuffix), "{}")
+	if err := importSidecars(nil); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	var got models.Version
+	if err := database.DB.Preload("ParentModel").Preload("Images").Where("version_id = ?", 101).First(&got).Error; err != nil {
+		t.Fatalf("version not imported: %v", err)
+	}
+	if got.FilePath != "LORA/style.safetensors" || got.SHA256 != "abcd" || got.TrainedWords != "foo,bar" ||
+		got.Tags != "anime" || got.ParentModel.Name != "Style" || got.ParentModel.FilePath != got.FilePath {
+		t.Errorf("imported version = %+v", got)
+	}
+	if len(got.Images) != 1 || got.ImagePath != got.Images[0].Path || got.Images[0].Meta != `{"prompt":"a cat"}` {
+		t.Errorf("imported images = %+v, main %q", got.Images, got.ImagePath)
+	}
+
+	// Importing again only fills what is empty.
+	database.DB.Model(&got).Updates(map[string]interface{}{"name": "renamed", "base_model": ""})
+	if outcome, err := importSidecar(base + sidecarInfoSuffix); err != nil || outcome != importUpdated {
+		t.Fatalf("re-import = %v, %v", outcome, err)
+	}
+	got = models.Version{}
+	database.DB.Where("version_id = ?", 101).First(&got)
+	if got.Name != "renamed" || got.BaseModel != "SDXL 1.0" {
+		t.Errorf("after re-import = %q, %q", got.Name, got.BaseModel)
+	}
+	if outcome, _ := importSidecar(base + sidecarInfoSuffix); outcome != importSkipped {
+		t.Errorf("third import = %v", outcome)
+	}
+}
+
+func TestWriteVersionSidecarsRequiresFile(t *testing.T) {
+	initTestDB(t)
+	m := models.Model{CivitID: 1, Name: "M", Weight: 1}
+	database.DB.Create(&m)
+	database.DB.Create(&models.Version{ModelID: m.ID, VersionID: 101})
+
+	for id, want := range map[string]int{"1": http.StatusBadRequest, "2": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		c.Request = httptest.NewRequest(http.MethodPost, "/versions/"+id+"/sidecars", nil)
+		WriteVersionSidecars(c)
+		if w.Code != want {
+			t.Errorf("version %s = %d, want %d", id, w.Code, want)
+		}
+	}
+}
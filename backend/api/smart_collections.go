@@ -0,0 +1,169 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errSmartCollection = errors.New("smart collection members are defined by its filter")
+
+// smartCollectionFilter compiles a smart collection definition into the
+// library filter used by the list endpoints. Text and structured fields are
+// matched case-insensitively, like their library query equivalents.
+func smartCollectionFilter(def *models.CollectionFilter) (libraryFilter, error) {
+	var f libraryFilter
+	if def.BaseModel != "" {
+		if err := f.addField("base", "", def.BaseModel); err != nil {
+			return f, err
+		}
+	}
+	if def.Type != "" {
+		if err := f.addField("type", "", def.Type); err != nil {
+			return f, err
+		}
+	}
+	for _, tag := range def.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			if err := f.addField("tag", "", tag); err != nil {
+				return f, err
+			}
+		}
+	}
+	for _, t := range parseSearch(def.Text) {
+		c := searchTermCond(t)
+		if t.Exclude {
+			c.SQL = "NOT " + c.SQL
+		}
+		f.add(c)
+	}
+	if def.Nsfw != nil {
+		f.add(sqlCond{"versions.nsfw = ?", []interface{}{*def.Nsfw}})
+	}
+	if def.Client != "" {
+		if err := f.addField("client", "", def.Client); err != nil {
+			return f, err
+		}
+	}
+	if def.Installed != nil {
+		if err := f.addField("synced", "", strconv.FormatBool(*def.Installed)); err != nil {
+			return f, err
+		}
+	}
+	if err := f.addQuery(def.Query); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// collectionMembers restricts q, which must select from versions, to the
+// members of coll and joins models. Smart collections are evaluated live.
+func collectionMembers(q *gorm.DB, coll models.Collection) (*gorm.DB, error) {
+	q = q.Joins("JOIN models ON models.id = versions.model_id")
+	if coll.Filter == nil {
+		return q.Joins("JOIN collection_versions ON collection_versions.version_id = versions.id").
+			Where("collection_versions.collection_id = ?", coll.ID), nil
+	}
+	f, err := smartCollectionFilter(coll.Filter)
+	if err != nil {
+		return q, err
+	}
+	return f.apply(q), nil
+}
+
+// loadSmartVersions fills Versions of a smart collection, which cannot be
+// preloaded from the join table, and sets VersionCount of any collection.
+func loadSmartVersions(coll *models.Collection) {
+	if coll.Filter != nil {
+		versions := make([]models.Version, 0)
+		if q, err := collectionMembers(database.DB.Model(&models.Version{}).Select("versions.*"), *coll); err == nil {
+			q.Order("versions.id DESC").Find(&versions)
+		}
+		coll.Versions = versions
+	}
+	coll.VersionCount = int64(len(coll.Versions))
+}
+
+// countSmartVersions sets VersionCount of the collections in colls. Smart
+// collections are counted in the database and their Versions left empty, so
+// listing them does not load every matching version.
+func countSmartVersions(colls []models.Collection) {
+	for i := range colls {
+		if colls[i].Filter == nil {
+			colls[i].VersionCount = int64(len(colls[i].Versions))
+			continue
+		}
+		colls[i].Versions = make([]models.Version, 0)
+		if q, err := collectionMembers(database.DB.Model(&models.Version{}), colls[i]); err == nil {
+			q.Count(&colls[i].VersionCount)
+		}
+	}
+}
+
+// smartCollectionsContaining returns the smart collections whose filter
+// currently matches the version with the given ID.
+func smartCollectionsContaining(versionID uint) []models.Collection {
+	var smart []models.Collection
+	database.DB.Where("filter IS NOT NULL AND filter <> 'null'").Find(&smart)
+	matched := make([]models.Collection, 0)
+	for _, coll := range smart {
+		q, err := collectionMembers(database.DB.Model(&models.Version{}), coll)
+		if err != nil {
+			continue
+		}
+		var n int64
+		q.Where("versions.id = ?", versionID).Count(&n)
+		if n > 0 {
+			matched = append(matched, coll)
+		}
+	}
+	return matched
+}
+
+// ConvertSmartCollection turns a smart collection into a static one holding
+// the versions that match its filter right now.
+func ConvertSmartCollection(c *gin.Context) {
+	var coll models.Collection
+	if err := database.DB.First(&coll, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return
+	}
+	if coll.Filter == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Collection is not a smart collection"})
+		return
+	}
+	f, err := smartCollectionFilter(coll.Filter)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	where := f.where()
+	var added int64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Exec(`
+			INSERT OR IGNORE INTO collection_versions (collection_id, version_id)
+			SELECT ?, versions.id
+			FROM versions
+			JOIN models ON models.id = versions.model_id
+			WHERE versions.deleted_at IS NULL AND `+where.SQL,
+			append([]interface{}{coll.ID}, where.Args...)...)
+		if res.Error != nil {
+			return res.Error
+		}
+		added = res.RowsAffected
+		return tx.Model(&coll).Update("filter", nil).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert collection"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Converted to static collection", "added": added})
+}
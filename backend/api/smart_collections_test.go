@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func collectionRequest(t *testing.T, handler gin.HandlerFunc, method, id string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	if id != "" {
+		c.Params = gin.Params{{Key: "id", Value: id}}
+	}
+	c.Request = httptest.NewRequest(method, "/collections", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return rec
+}
+
+func collectionVersionNames(t *testing.T, id string) []string {
+	t.Helper()
+	rec := collectionRequest(t, GetCollectionVersions, http.MethodGet, id, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("collection versions: %d %s", rec.Code, rec.Body.String())
+	}
+	var versions []models.Version
+	json.Unmarshal(rec.Body.Bytes(), &versions)
+	names := []string{}
+	for _, v := range versions {
+		names = append(names, v.ParentModel.Name)
+	}
+	return names
+}
+
+func TestSmartCollections(t *testing.T) {
+	initTestDB(t)
+	add := func(civitID int, name string, v models.Version) models.Version {
+		m := models.Model{CivitID: civitID, Name: name, Weight: 1}
+		database.DB.Create(&m)
+		v.ModelID = m.ID
+		v.VersionID = civitID
+		database.DB.Create(&v)
+		database.SetSyncedTags(database.DB, v.ID, database.SplitTags(v.Tags))
+		return v
+	}
+	add(1, "Anime Lines", models.Version{Type: "LORA", BaseModel: "SDXL 1.0", Tags: "anime,lineart"})
+	add(2, "Oil Paint", models.Version{Type: "LORA", BaseModel: "SDXL 1.0", Tags: "painting"})
+	add(3, "Anime Checkpoint", models.Version{Type: "Checkpoint", BaseModel: "SDXL 1.0", Tags: "anime"})
+
+	rec := collectionRequest(t, CreateCollection, http.MethodPost, "", map[string]interface{}{
+		"name":   "sdxl anime loras",
+		"filter": map[string]interface{}{"baseModel": "sdxl 1.0", "type": "lora", "tags": []string{"anime"}},
+	})
+	var coll models.Collection
+	json.Unmarshal(rec.Body.Bytes(), &coll)
+	if rec.Code != http.StatusOK || coll.Filter == nil {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+	}
+	id := strconv.Itoa(int(coll.ID))
+
+	if got := collectionVersionNames(t, id); len(got) != 1 || got[0] != "Anime Lines" {
+		t.Errorf("members = %v", got)
+	}
+
+	// A new download that matches shows up without touching the collection.
+	later := add(4, "Anime Colors", models.Version{Type: "LORA", BaseModel: "SDXL 1.0", Tags: "Anime, color"})
+	if got := collectionVersionNames(t, id); len(got) != 2 || got[0] != "Anime Colors" {
+		t.Errorf("members after download = %v", got)
+	}
+
+	rec = collectionRequest(t, GetVersionCollections, http.MethodGet, strconv.Itoa(int(later.ID)), nil)
+	var colls []models.Collection
+	json.Unmarshal(rec.Body.Bytes(), &colls)
+	if len(colls) != 1 || colls[0].ID != coll.ID {
+		t.Errorf("version collections = %s", rec.Body.String())
+	}
+
+	rec = collectionRequest(t, GetCollections, http.MethodGet, "", nil)
+	json.Unmarshal(rec.Body.Bytes(), &colls)
+	if len(colls) != 1 || colls[0].VersionCount != 2 || len(colls[0].Versions) != 0 {
+		t.Errorf("collections = %s", rec.Body.String())
+	}
+
+	if rec := collectionRequest(t, AddVersionToCollection, http.MethodPost, id, map[string]uint{"versionId": 1}); rec.Code != http.StatusConflict {
+		t.Errorf("manual add to smart collection = %d", rec.Code)
+	}
+	if rec := collectionRequest(t, BulkAddVersions, http.MethodPost, id, map[string]string{"filter": "tag:anime"}); rec.Code != http.StatusConflict {
+		t.Errorf("bulk add to smart collection = %d", rec.Code)
+	}
+	if rec := collectionRequest(t, CreateCollection, http.MethodPost, "", map[string]interface{}{
+		"name": "broken", "filter": map[string]string{"query": "tag:(anime"},
+	}); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid filter = %d", rec.Code)
+	}
+
+	rec = collectionRequest(t, UpdateCollection, http.MethodPut, id, map[string]interface{}{
+		"name":   "sdxl anime",
+		"filter": map[string]interface{}{"baseModel": "SDXL 1.0", "text": "anime -colors"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", rec.Code, rec.Body.String())
+	}
+	if got := collectionVersionNames(t, id); len(got) != 2 || got[0] != "Anime Checkpoint" || got[1] != "Anime Lines" {
+		t.Errorf("members after update = %v", got)
+	}
+
+	rec = collectionRequest(t, ConvertSmartCollection, http.MethodPost, id, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"added":2,"message":"Converted to static collection"}` {
+		t.Fatalf("convert: %d %s", rec.Code, rec.Body.String())
+	}
+	add(5, "Anime Shading", models.Version{BaseModel: "SDXL 1.0", Tags: "anime"})
+	if got := collectionVersionNames(t, id); len(got) != 2 {
+		t.Errorf("static members = %v", got)
+	}
+	if rec := collectionRequest(t, ConvertSmartCollection, http.MethodPost, id, nil); rec.Code != http.StatusConflict {
+		t.Errorf("convert static = %d", rec.Code)
+	}
+	if rec := collectionRequest(t, UpdateCollection, http.MethodPut, id, map[string]interface{}{
+		"name": "x", "filter": map[string]string{"type": "LORA"},
+	}); rec.Code != http.StatusConflict {
+		t.Errorf("filter on static collection = %d", rec.Code)
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"model-manager/backend/database"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// modelSortKey describes one sort option of the model list. expr must be
+// non-NULL and evaluable per models row, so it can be used both in ORDER BY
+// and in keyset conditions.
+type modelSortKey struct {
+	expr string
+	desc bool // default direction
+}
+
+var modelSortKeys = map[string]modelSortKey{
+	// Models are numbered in the order they were added to the library.
+	"added":  {"models.id", true},
+	"name":   {"LOWER(COALESCE(models.name, ''))", false},
+	"weight": {"COALESCE(models.weight, 0)", true},
+	// Size of the largest version file.
+	"size":    {"COALESCE((SELECT MAX(sv.size_kb) FROM versions sv WHERE sv.model_id = models.id AND sv.deleted_at IS NULL), 0)", true},
+	"updated": {"COALESCE((SELECT MAX(sv.civit_updated_at) FROM versions sv WHERE sv.model_id = models.id AND sv.deleted_at IS NULL), '')", true},
+	"synced": {"COALESCE((SELECT MAX(scf.updated_at) FROM client_files scf JOIN versions sv ON sv.id = scf.model_version_id " +
+		"WHERE sv.model_id = models.id AND scf.status = 'installed' AND scf.deleted_at IS NULL), '')", true},
+}
+
+var errBadCursor = errors.New("invalid cursor")
+
+// modelOrder is the resolved sort of a model list request.
+type modelOrder struct {
+	Sort string
+	Desc bool
+}
+
+// modelCursor marks the last model of a page for keyset pagination.
+type modelCursor struct {
+	Sort string      `json:"s"`
+	Desc bool        `json:"d"`
+	Key  interface{} `json:"k"`
+	ID   uint        `json:"id"`
+}
+
+// modelOrderFromRequest reads the sort and order query parameters. Without a
+// sort, searches are ordered by relevance and everything else by date added.
+func modelOrderFromRequest(c *gin.Context, searching bool) (modelOrder, error) {
+	sort := strings.ToLower(c.Query("sort"))
+	if sort == "" {
+		sort = "added"
+		if searching {
+			sort = "relevance"
+		}
+	}
+	o := modelOrder{Sort: sort}
+	if sort != "relevance" {
+		key, ok := modelSortKeys[sort]
+		if !ok {
+			return o, fmt.Errorf("unknown sort %q", sort)
+		}
+		o.Desc = key.desc
+	}
+	switch strings.ToLower(c.Query("order")) {
+	case "":
+	case "asc":
+		o.Desc = false
+	case "desc":
+		o.Desc = true
+	default:
+		return o, fmt.Errorf("unknown order %q", c.Query("order"))
+	}
+	return o, nil
+}
+
+func (o modelOrder) dir() string {
+	if o.Desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// apply orders q, which must select from models, with models.id as the
+// tiebreaker so pages are stable. ranked reports that q joins search_hits.
+func (o modelOrder) apply(q *gorm.DB, ranked bool) *gorm.DB {
+	if o.Sort == "relevance" {
+		if ranked {
+			// Best matching version decides the model's position.
+			q = q.Order("MIN(search_hits.rank)")
+		}
+		return q.Order("models.id DESC")
+	}
+	return q.Order(modelSortKeys[o.Sort].expr + " " + o.dir()).Order("models.id " + o.dir())
+}
+
+// after restricts q to the models that follow cursor in this order.
+func (o modelOrder) after(q *gorm.DB, cursor modelCursor) (*gorm.DB, error) {
+	if o.Sort == "relevance" {
+		return q, errors.New("cursor pagination is not supported with relevance sort")
+	}
+	if cursor.Sort != o.Sort || cursor.Desc != o.Desc {
+		return q, errors.New("cursor does not match sort and order")
+	}
+	cmp := ">"
+	if o.Desc {
+		cmp = "<"
+	}
+	return q.Where("("+modelSortKeys[o.Sort].expr+", models.id) "+cmp+" (?, ?)", cursor.Key, cursor.ID), nil
+}
+
+// cursorFor returns the cursor positioned after the model with the given ID.
+func (o modelOrder) cursorFor(id uint) (string, error) {
+	var key interface{}
+	row := database.DB.Table("models").Select(modelSortKeys[o.Sort].expr).Where("models.id = ?", id).Row()
+	if err := row.Scan(&key); err != nil {
+		return "", err
+	}
+	switch v := key.(type) {
+	case []byte:
+		key = string(v)
+	case time.Time:
+		key = v.Format("2006-01-02 15:04:05.999999999-07:00")
+	case int64:
+		key = float64(v)
+	}
+	data, err := json.Marshal(modelCursor{Sort: o.Sort, Desc: o.Desc, Key: key, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeModelCursor(s string) (modelCursor, error) {
+	var cur modelCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cur, errBadCursor
+	}
+	if err := json.Unmarshal(data, &cur); err != nil || cur.ID == 0 {
+		return cur, errBadCursor
+	}
+	switch cur.Key.(type) {
+	case string, float64:
+	default:
+		return cur, errBadCursor
+	}
+	return cur, nil
+}
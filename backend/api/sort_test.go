@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func listModels(t *testing.T, query string) ([]string, string, int) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/models?includeVersions=0&"+query, nil)
+	GetModels(c)
+	var page struct {
+		Models     []models.Model `json:"models"`
+		NextCursor string         `json:"nextCursor"`
+	}
+	if c.Request.URL.Query().Has("cursor") {
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		if header := rec.Header().Get("X-Next-Cursor"); header != page.NextCursor {
+			t.Errorf("%s: nextCursor = %q, header = %q", query, page.NextCursor, header)
+		}
+	} else {
+		json.Unmarshal(rec.Body.Bytes(), &page.Models)
+	}
+	names := []string{}
+	for _, m := range page.Models {
+		names = append(names, m.Name)
+	}
+	return names, rec.Header().Get("X-Next-Cursor"), rec.Code
+}
+
+func TestGetModelsSortAndCursor(t *testing.T) {
+	initTestDB(t)
+	next := 0
+	add := func(name string, weight float64, sizeKB float64, updated string) models.Version {
+		next++
+		m := models.Model{CivitID: next, Name: name, Weight: weight}
+		database.DB.Create(&m)
+		v := models.Version{ModelID: m.ID, VersionID: m.CivitID, Name: "v1", SizeKB: sizeKB, CivitUpdatedAt: updated}
+		database.DB.Create(&v)
+		return v
+	}
+	add("delta", 2, 300, "2024-03-01T00:00:00Z")
+	b := add("Bravo", 1, 100, "2025-01-01T00:00:00Z")
+	add("alphabet", 2, 200, "")
+	e := add("echo!", 1, 400, "2023-05-01T00:00:00Z")
+	add("Charlie", 3, 50, "2024-07-01T00:00:00Z")
+	database.DB.Create(&models.ClientFile{ClientID: "pc", ModelVersionID: e.ID, Status: "installed"})
+	time.Sleep(10 * time.Millisecond)
+	database.DB.Create(&models.ClientFile{ClientID: "pc", ModelVersionID: b.ID, Status: "installed"})
+
+	cases := map[string][]string{
+		"":                         {"Charlie", "echo!", "alphabet", "Bravo", "delta"},
+		"sort=name":                {"alphabet", "Bravo", "Charlie", "delta", "echo!"},
+		"sort=name&order=desc":     {"echo!", "delta", "Charlie", "Bravo", "alphabet"},
+		"sort=size":                {"echo!", "delta", "alphabet", "Bravo", "Charlie"},
+		"sort=updated":             {"Bravo", "Charlie", "delta", "echo!", "alphabet"},
+		"sort=weight":              {"Charlie", "alphabet", "delta", "echo!", "Bravo"},
+		"sort=weight&order=asc":    {"Bravo", "echo!", "delta", "alphabet", "Charlie"},
+		"sort=synced":              {"Bravo", "echo!", "Charlie", "alphabet", "delta"},
+		"sort=added&order=asc":     {"delta", "Bravo", "alphabet", "echo!", "Charlie"},
+		"sort=size&page=2&limit=2": {"alphabet", "Bravo"},
+	}
+	for query, want := range cases {
+		got, _, code := listModels(t, query)
+		if code != http.StatusOK || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %d %v, want %v", query, code, got, want)
+		}
+		if query == "sort=size&page=2&limit=2" {
+			continue
+		}
+
+		// Walking the cursors two at a time visits the same order.
+		var walked []string
+		after := ""
+		for i := 0; i < 5; i++ {
+			page, cursor, code := listModels(t, query+"&limit=2&cursor="+after)
+			if code != http.StatusOK {
+				t.Fatalf("%s: cursor page status %d", query, code)
+			}
+			walked = append(walked, page...)
+			if cursor == "" {
+				break
+			}
+			after = cursor
+		}
+		if !reflect.DeepEqual(walked, want) {
+			t.Errorf("%s: cursor walk %v, want %v", query, walked, want)
+		}
+	}
+
+	_, cursor, _ := listModels(t, "sort=name&limit=2")
+	for _, query := range []string{
+		"sort=popularity",
+		"order=sideways",
+		"cursor=not-a-cursor",
+		"sort=weight&cursor=" + cursor,
+		"search=a&cursor=" + cursor,
+	} {
+		if _, _, code := listModels(t, query); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, code)
+		}
+	}
+}
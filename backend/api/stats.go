@@ -9,6 +9,7 @@ import (
 	"model-manager/backend/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type countResult struct {
@@ -18,45 +19,49 @@ type countResult struct {
 
 // GetStats aggregates counts about the stored models and their versions and
 // returns totals grouped by model type, base model, and NSFW flag. Optional
-// query parameters category, baseModel, modelType, and nsfw filter the
-// dataset before aggregating counts. The handler performs read-only queries and
-// responds with JSON metrics without mutating database state.
+// query parameters category, baseModel, modelType, nsfw, and q (a library
+// query, see parseLibraryQuery) filter the dataset before aggregating counts.
+// The handler performs read-only queries and responds with JSON metrics
+// without mutating database state.
 func GetStats(c *gin.Context) {
-	category := strings.ToLower(strings.TrimSpace(c.Query("category")))
-	baseModel := c.Query("baseModel")
-	modelType := c.Query("modelType")
-	nsfwFilter := strings.ToLower(strings.TrimSpace(c.Query("nsfw")))
-	if nsfwFilter != "" && nsfwFilter != "nsfw" && nsfwFilter != "non" {
-		nsfwFilter = ""
+	var filter libraryFilter
+	if category := strings.TrimSpace(c.Query("category")); category != "" {
+		cond, _ := tagCond("", category)
+		filter.add(cond)
 	}
-
-	versionFiltersActive := category != "" || baseModel != "" || modelType != "" || nsfwFilter != ""
-
-	query := database.DB.Model(&models.Version{}).
-		Joins("JOIN models ON models.id = versions.model_id").
-		Where("models.deleted_at IS NULL").
-		Where("versions.deleted_at IS NULL")
-
-	if nsfwFilter == "non" {
-		query = query.Where("versions.nsfw = ?", false)
+	if baseModel := c.Query("baseModel"); baseModel != "" {
+		filter.add(sqlCond{"versions.base_model = ?", []interface{}{baseModel}})
 	}
-	if nsfwFilter == "nsfw" {
-		query = query.Where("versions.nsfw = ?", true)
+	if modelType := c.Query("modelType"); modelType != "" {
+		filter.add(sqlCond{"versions.type = ?", []interface{}{modelType}})
 	}
-	if baseModel != "" {
-		query = query.Where("versions.base_model = ?", baseModel)
+	switch strings.ToLower(strings.TrimSpace(c.Query("nsfw"))) {
+	case "non":
+		filter.add(sqlCond{"versions.nsfw = ?", []interface{}{false}})
+	case "nsfw":
+		filter.add(sqlCond{"versions.nsfw = ?", []interface{}{true}})
 	}
-	if modelType != "" {
-		query = query.Where("versions.type = ?", modelType)
+	if err := filter.addQuery(c.Query("q")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	if category != "" {
-		query = query.Where("LOWER(versions.tags) LIKE ?", "%"+category+"%")
+
+	filtered := func() *gorm.DB {
+		return filter.apply(database.DB.Model(&models.Version{}).
+			Joins("JOIN models ON models.id = versions.model_id").
+			Where("models.deleted_at IS NULL").
+			Where("versions.deleted_at IS NULL"))
 	}
 
-	var versions []models.Version
-	if err := query.
-		Select([]string{"versions.id", "versions.model_id", "versions.base_model", "versions.nsfw", "versions.type", "versions.tags"}).
-		Find(&versions).Error; err != nil {
+	var filteredVersions []models.Version
+	if err := filtered().
+		Select([]string{"versions.id", "versions.model_id", "versions.base_model", "versions.nsfw", "versions.type"}).
+		Find(&filteredVersions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load stats"})
+		return
+	}
+	versionCategories, err := loadCategories(filtered().Select("versions.id"))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load stats"})
 		return
 	}
@@ -65,14 +70,6 @@ func GetStats(c *gin.Context) {
 	typeCounts := make(map[string]int64)
 	categoryCounts := make(map[string]int64)
 
-	filteredVersions := make([]models.Version, 0, len(versions))
-	for _, v := range versions {
-		if category != "" && !tagMatchesCategory(v.Tags, category) {
-			continue
-		}
-		filteredVersions = append(filteredVersions, v)
-	}
-
 	var nsfwCount int64
 	var safeCount int64
 	modelSeen := make(map[uint]struct{})
@@ -80,7 +77,7 @@ func GetStats(c *gin.Context) {
 		baseCounts[v.BaseModel]++
 		typeCounts[v.Type]++
 
-		cats := extractCategories(v.Tags)
+		cats := versionCategories[v.ID]
 		if len(cats) == 0 {
 			categoryCounts[uncategorizedLabel]++
 		} else {
@@ -100,7 +97,7 @@ func GetStats(c *gin.Context) {
 	totalVersions := int64(len(filteredVersions))
 
 	var totalModels int64
-	if versionFiltersActive {
+	if !filter.empty() {
 		totalModels = int64(len(modelSeen))
 	} else {
 		if err := database.DB.Model(&models.Model{}).Where("deleted_at IS NULL").Count(&totalModels).Error; err != nil {
@@ -144,18 +141,6 @@ func GetStats(c *gin.Context) {
 	})
 }
 
-func tagMatchesCategory(tags string, category string) bool {
-	if category == "" {
-		return true
-	}
-	for _, t := range strings.Split(tags, ",") {
-		if strings.TrimSpace(strings.ToLower(t)) == category {
-			return true
-		}
-	}
-	return false
-}
-
 const uncategorizedLabel = "Uncategorized"
 
 var categoryLookup = map[string]string{
@@ -175,23 +160,29 @@ var categoryLookup = map[string]string{
 	"action":     "Action",
 }
 
-func extractCategories(tags string) []string {
-	seen := make(map[string]struct{})
-	for _, t := range strings.Split(tags, ",") {
-		normalized := strings.TrimSpace(strings.ToLower(t))
-		if normalized == "" {
-			continue
-		}
-		if display, ok := categoryLookup[normalized]; ok {
-			seen[display] = struct{}{}
-		}
-	}
-	if len(seen) == 0 {
-		return nil
-	}
-	results := make([]string, 0, len(seen))
-	for cat := range seen {
-		results = append(results, cat)
-	}
-	return results
+// loadCategories returns the display categories of each version selected by
+// versionIDs, a query for version IDs.
+func loadCategories(versionIDs *gorm.DB) (map[uint][]string, error) {
+	names := make([]string, 0, len(categoryLookup))
+	for name := range categoryLookup {
+		names = append(names, name)
+	}
+	var rows []struct {
+		VersionID uint
+		Name      string
+	}
+	err := database.DB.Table(database.EffectiveTagsView+" AS evt").
+		Select("evt.version_id, tags.name").
+		Joins("JOIN tags ON tags.id = evt.tag_id").
+		Where("tags.name IN ?", names).
+		Where("evt.version_id IN (?)", versionIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	cats := make(map[uint][]string)
+	for _, r := range rows {
+		cats[r.VersionID] = append(cats[r.VersionID], categoryLookup[r.Name])
+	}
+	return cats, nil
 }
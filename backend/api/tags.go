@@ -0,0 +1,189 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TagCount is a tag with the number of versions tagged with it. UserCount is
+// how many of those tags were added by the user rather than synced.
+type TagCount struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Count     int64  `json:"count"`
+	UserCount int64  `json:"userCount"`
+}
+
+// queryTags lists tags, most used first. Aliases left behind by renames and
+// merges are not listed.
+func queryTags(prefix string, limit int) ([]TagCount, error) {
+	tags := make([]TagCount, 0)
+	q := database.DB.Table("tags").
+		Select("tags.id, tags.name, COUNT(DISTINCT evt.version_id) AS count, " +
+			"(SELECT COUNT(*) FROM version_tags ut WHERE ut.tag_id = tags.id AND ut.source = '" + models.TagSourceUser + "') AS user_count").
+		Joins("LEFT JOIN " + database.EffectiveTagsView + " evt ON evt.tag_id = tags.id").
+		Where("tags.alias_of_id IS NULL AND tags.deleted_at IS NULL").
+		Group("tags.id").
+		Order("count DESC, tags.name")
+	if prefix != "" {
+		q = q.Where("tags.name LIKE ? ESCAPE '\\'", likeEscaper.Replace(database.NormalizeTag(prefix))+"%")
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.Scan(&tags).Error
+	return tags, err
+}
+
+// GetTags lists all tags with their usage counts. The optional search query
+// parameter restricts the list to tags starting with it.
+func GetTags(c *gin.Context) {
+	tags, err := queryTags(c.Query("search"), 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// AutocompleteTags returns up to limit (default 10) of the most used tags
+// starting with the q query parameter.
+func AutocompleteTags(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit <= 0 || limit > 100 {
+		limit = 10
+	}
+	prefix := strings.TrimSpace(c.Query("q"))
+	if prefix == "" {
+		c.JSON(http.StatusOK, []TagCount{})
+		return
+	}
+	tags, err := queryTags(prefix, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+func tagIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// tagError responds to a failed tag operation.
+func tagError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "A tag with that name already exists; merge the tags instead"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// RenameTag renames the tag given by :id on every version. Synced tags using
+// the old name keep resolving to the renamed tag.
+func RenameTag(c *gin.Context) {
+	id, ok := tagIDParam(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || database.NormalizeTag(input.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if err := database.RenameTag(database.DB, id, input.Name); err != nil {
+		tagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tag renamed"})
+}
+
+// MergeTags merges the tags listed in sources into the tag given by :id.
+func MergeTags(c *gin.Context) {
+	id, ok := tagIDParam(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Sources []uint `json:"sources" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || len(input.Sources) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sources is required"})
+		return
+	}
+	if err := database.MergeTags(database.DB, id, input.Sources); err != nil {
+		tagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tags merged"})
+}
+
+// DeleteTag removes the tag given by :id from every version.
+func DeleteTag(c *gin.Context) {
+	id, ok := tagIDParam(c)
+	if !ok {
+		return
+	}
+	if err := database.DeleteTag(database.DB, id); err != nil {
+		tagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted"})
+}
+
+// tagChanges returns the tags in after but not before, and the reverse.
+func tagChanges(before, after []string) (added, removed []string) {
+	for _, t := range after {
+		if !slices.Contains(before, t) {
+			added = append(added, t)
+		}
+	}
+	for _, t := range before {
+		if !slices.Contains(after, t) {
+			removed = append(removed, t)
+		}
+	}
+	return added, removed
+}
+
+// applyModelTagChanges applies an edit of a model's tags to each of its
+// versions as user tag changes.
+func applyModelTagChanges(modelID uint, added, removed []string) error {
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	var versionIDs []uint
+	if err := database.DB.Model(&models.Version{}).Where("model_id = ?", modelID).Pluck("id", &versionIDs).Error; err != nil {
+		return err
+	}
+	for _, id := range versionIDs {
+		current, err := database.VersionTagNames(database.DB, id)
+		if err != nil {
+			return err
+		}
+		keep, _ := tagChanges(removed, current)
+		if err := database.SetUserTags(database.DB, id, append(keep, added...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func tagRequest(t *testing.T, handler gin.HandlerFunc, method, target string, params gin.Params, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Params = params
+	c.Request = httptest.NewRequest(method, target, bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return rec
+}
+
+func TestTagEndpoints(t *testing.T) {
+	initTestDB(t)
+	m := models.Model{CivitID: 1, Name: "m", Tags: "style,anime", Weight: 1}
+	database.DB.Create(&m)
+	var versions []models.Version
+	for i, tags := range []string{"style,anime", "anime,animal", "character"} {
+		v := models.Version{ModelID: m.ID, VersionID: i + 1, Tags: tags}
+		if i == 2 {
+			other := models.Model{CivitID: 2, Name: "other", Weight: 1}
+			database.DB.Create(&other)
+			v.ModelID = other.ID
+		}
+		database.DB.Create(&v)
+		database.SetSyncedTags(database.DB, v.ID, database.SplitTags(tags))
+		versions = append(versions, v)
+	}
+
+	var tags []TagCount
+	json.Unmarshal(tagRequest(t, AutocompleteTags, http.MethodGet, "/tags/autocomplete?q=AN", nil, nil).Body.Bytes(), &tags)
+	if len(tags) != 2 || tags[0].Name != "anime" || tags[0].Count != 2 || tags[1].Name != "animal" {
+		t.Errorf("autocomplete = %+v", tags)
+	}
+
+	// Editing a model's tags edits each of its versions as user tags.
+	body := models.Model{CivitID: 1, Name: "m", Tags: "anime,favorite", Weight: 1}
+	if rec := tagRequest(t, UpdateModel, http.MethodPut, "/models/1", gin.Params{{Key: "id", Value: strconv.Itoa(int(m.ID))}}, body); rec.Code != http.StatusOK {
+		t.Fatalf("update model = %d %s", rec.Code, rec.Body.String())
+	}
+	versionTags := func(i int) string {
+		var v models.Version
+		database.DB.First(&v, versions[i].ID)
+		return v.Tags
+	}
+	if got := versionTags(0); got != "anime,favorite" {
+		t.Errorf("version 1 tags = %q", got)
+	}
+	if got := versionTags(1); got != "anime,animal,favorite" {
+		t.Errorf("version 2 tags = %q", got)
+	}
+
+	json.Unmarshal(tagRequest(t, GetTags, http.MethodGet, "/tags", nil, nil).Body.Bytes(), &tags)
+	ids := map[string]uint{}
+	for _, tag := range tags {
+		ids[tag.Name] = tag.ID
+		if tag.Name == "favorite" && (tag.Count != 2 || tag.UserCount != 2) {
+			t.Errorf("favorite = %+v", tag)
+		}
+	}
+	if tags[0].Name != "anime" || tags[len(tags)-1].Name != "style" || tags[len(tags)-1].Count != 0 {
+		t.Errorf("tags = %+v", tags)
+	}
+
+	id := func(name string) gin.Params {
+		return gin.Params{{Key: "id", Value: strconv.Itoa(int(ids[name]))}}
+	}
+	if rec := tagRequest(t, RenameTag, http.MethodPut, "/tags", id("animal"), map[string]string{"name": "Anime"}); rec.Code != http.StatusConflict {
+		t.Errorf("rename onto existing = %d", rec.Code)
+	}
+	if rec := tagRequest(t, RenameTag, http.MethodPut, "/tags", id("character"), map[string]string{"name": "Characters"}); rec.Code != http.StatusOK {
+		t.Errorf("rename = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := tagRequest(t, MergeTags, http.MethodPost, "/tags", id("anime"), map[string][]uint{"sources": {ids["animal"]}}); rec.Code != http.StatusOK {
+		t.Errorf("merge = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := tagRequest(t, DeleteTag, http.MethodDelete, "/tags", id("favorite"), nil); rec.Code != http.StatusOK {
+		t.Errorf("delete = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := tagRequest(t, DeleteTag, http.MethodDelete, "/tags", id("favorite"), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("delete again = %d", rec.Code)
+	}
+	for i, want := range []string{"anime", "anime", "characters"} {
+		if got := versionTags(i); got != want {
+			t.Errorf("version %d tags = %q, want %q", i+1, got, want)
+		}
+	}
+
+	// Stats categories come from the tag tables.
+	rec := tagRequest(t, GetStats, http.MethodGet, "/stats?category=characters", nil, nil)
+	var stats struct {
+		TotalVersions  int64         `json:"totalVersions"`
+		CategoryCounts []countResult `json:"categoryCounts"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	if stats.TotalVersions != 1 || len(stats.CategoryCounts) != 1 || stats.CategoryCounts[0].Key != uncategorizedLabel {
+		t.Errorf("stats = %s", rec.Body.String())
+	}
+}
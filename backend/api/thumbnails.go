@@ -6,7 +6,6 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 
@@ -45,27 +44,9 @@ func generateThumbnail(fullSourcePath, fullThumbnailPath string) error {
 
 	log.Printf("Generating thumbnail from %s to %s", fullSourcePath, fullThumbnailPath)
 
-	// Open source
-	file, err := os.Open(fullSourcePath)
+	img, err := decodeImageFile(fullSourcePath)
 	if err != nil {
-		return fmt.Errorf("failed to open source image: %w", err)
-	}
-	defer file.Close()
-
-	// Decode
-	img, _, err := image.Decode(file)
-	if err != nil {
-		// Try WebP explicitly if generic decode fails
-		if _, errSeek := file.Seek(0, 0); errSeek == nil {
-			if imgWebp, errWebp := webp.Decode(file); errWebp == nil {
-				img = imgWebp
-				err = nil // success
-			} else {
-				return fmt.Errorf("failed to decode image (std: %v, webp: %v)", err, errWebp)
-			}
-		} else {
-			return fmt.Errorf("failed to decode image: %w", err)
-		}
+		return err
 	}
 
 	// Resize if necessary
@@ -90,6 +71,30 @@ func generateThumbnail(fullSourcePath, fullThumbnailPath string) error {
 	return nil
 }
 
+// decodeImageFile decodes a JPEG, PNG or WebP image. Images downloaded from
+// CivitAI are saved as .jpg whatever their format, so the content decides.
+func decodeImageFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source image: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err == nil {
+		return img, nil
+	}
+	// Try WebP explicitly if generic decode fails
+	if _, errSeek := file.Seek(0, 0); errSeek != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	imgWebp, errWebp := webp.Decode(file)
+	if errWebp != nil {
+		return nil, fmt.Errorf("failed to decode image (std: %v, webp: %v)", err, errWebp)
+	}
+	return imgWebp, nil
+}
+
 // DeleteVersionThumbnail removes the thumbnail for a version
 func DeleteVersionThumbnail(versionID uint) error {
 	thumbnailRelPath := filepath.Join("thumbnails", fmt.Sprintf("v_%d.webp", versionID))
@@ -102,36 +107,66 @@ func DeleteVersionThumbnail(versionID uint) error {
 	return nil
 }
 
-// GenerateMissingThumbnails API handler to generate missing thumbnails
+// ThumbnailsProgressEvent is the payload of thumbnails.progress events.
+type ThumbnailsProgressEvent struct {
+	Processed int  `json:"processed"`
+	Total     int  `json:"total"`
+	Created   int  `json:"created"`
+	Errors    int  `json:"errors"`
+	Done      bool `json:"done"`
+}
+
+// GenerateMissingThumbnails API handler to generate missing thumbnails in a
+// background job.
 func GenerateMissingThumbnails(c *gin.Context) {
+	startJob(c, JobTypeGenerateThumbnails, generateMissingThumbnails)
+}
+
+func generateMissingThumbnails(run *JobRun) error {
 	count := 0
 	errors := 0
 
-	go func() {
-		// Generate version thumbnails
-		var allVersions []models.Version
-		database.DB.Find(&allVersions)
+	// Generate version thumbnails
+	var allVersions []models.Version
+	if err := database.DB.Find(&allVersions).Error; err != nil {
+		return err
+	}
+	run.SetTotal(len(allVersions))
 
-		for _, v := range allVersions {
-			if v.ImagePath == "" {
-				continue
-			}
+	for i, v := range allVersions {
+		if run.Cancelled() {
+			return nil
+		}
+		if i%25 == 0 {
+			Events.Publish(EventThumbnailsProgress, ThumbnailsProgressEvent{
+				Processed: i, Total: len(allVersions), Created: count, Errors: errors,
+			})
+		}
+		if v.ImagePath == "" {
+			run.Processed(false)
+			continue
+		}
 
-			// Use filepath.Join for OS-correct separators
-			thumbPath := ResolveImagePath(filepath.Join("thumbnails", fmt.Sprintf("v_%d.webp", v.ID)))
+		// Use filepath.Join for OS-correct separators
+		thumbPath := ResolveImagePath(filepath.Join("thumbnails", fmt.Sprintf("v_%d.webp", v.ID)))
 
-			if _, err := os.Stat(thumbPath); os.IsNotExist(err) {
-				if err := EnsureVersionThumbnail(v.ID, v.ImagePath); err != nil {
-					log.Printf("Error generating thumbnail for version %d: %v", v.ID, err)
-					errors++
-				} else {
-					count++
-				}
+		created := false
+		if _, err := os.Stat(thumbPath); os.IsNotExist(err) {
+			if err := EnsureVersionThumbnail(v.ID, v.ImagePath); err != nil {
+				run.Errorf("Error generating thumbnail for version %d: %v", v.ID, err)
+				errors++
+				continue
 			}
+			count++
+			created = true
 		}
+		run.Processed(created)
+	}
 
-		log.Printf("Finished generating thumbnails. Created: %d, Errors: %d", count, errors)
-	}()
-
-	c.JSON(http.StatusOK, gin.H{"message": "Thumbnail generation started in background"})
+	log.Printf("Finished generating thumbnails. Created: %d, Errors: %d", count, errors)
+	Events.Publish(EventThumbnailsProgress, ThumbnailsProgressEvent{
+		Processed: len(allVersions), Total: len(allVersions), Created: count, Errors: errors, Done: true,
+	})
+	run.SetMessage(fmt.Sprintf("Created %d thumbnails", count))
+	return nil
 }
@@ -2,7 +2,6 @@ package api
 
 import (
 	"log"
-	"net/http"
 	"path/filepath"
 	"strings"
 
@@ -32,8 +31,13 @@ func isAbsolutePath(path string) bool {
 }
 
 // MigratePaths converts absolute paths in the database to relative paths based
-// on the current configured roots. It is triggered manually by the user.
+// on the current configured roots. It is triggered manually by the user and
+// runs as a background job.
 func MigratePaths(c *gin.Context) {
+	startJob(c, JobTypeMigratePaths, migratePaths)
+}
+
+func migratePaths(run *JobRun) error {
 	modelRoot := database.GetModelPath()
 	imageRoot := database.GetImagePath()
 
@@ -41,81 +45,110 @@ func MigratePaths(c *gin.Context) {
 
 	// Migrate Models
 	var modelsList []models.Model
-	if err := database.DB.Find(&modelsList).Error; err == nil {
-		for _, m := range modelsList {
-			updated := false
-			if m.FilePath != "" {
-				isAbs := isAbsolutePath(m.FilePath)
-				log.Printf("Model %d FilePath: %s (isAbs: %v)", m.ID, m.FilePath, isAbs)
-				if isAbs {
-					newPath := MakeRelativePath(m.FilePath, modelRoot)
-					log.Printf("  -> Converted to: %s", newPath)
-					if newPath != m.FilePath {
-						m.FilePath = newPath
-						updated = true
-					}
+	var versions []models.Version
+	var images []models.VersionImage
+	if err := database.DB.Find(&modelsList).Error; err != nil {
+		return err
+	}
+	if err := database.DB.Find(&versions).Error; err != nil {
+		return err
+	}
+	if err := database.DB.Find(&images).Error; err != nil {
+		return err
+	}
+	run.SetTotal(len(modelsList) + len(versions) + len(images))
+
+	for _, m := range modelsList {
+		if run.Cancelled() {
+			return nil
+		}
+		updated := false
+		if m.FilePath != "" {
+			isAbs := isAbsolutePath(m.FilePath)
+			log.Printf("Model %d FilePath: %s (isAbs: %v)", m.ID, m.FilePath, isAbs)
+			if isAbs {
+				newPath := MakeRelativePath(m.FilePath, modelRoot)
+				log.Printf("  -> Converted to: %s", newPath)
+				if newPath != m.FilePath {
+					m.FilePath = newPath
+					updated = true
 				}
 			}
-			if m.ImagePath != "" {
-				isAbs := isAbsolutePath(m.ImagePath)
-				log.Printf("Model %d ImagePath: %s (isAbs: %v)", m.ID, m.ImagePath, isAbs)
-				if isAbs {
-					newPath := MakeRelativePath(m.ImagePath, imageRoot)
-					log.Printf("  -> Converted to: %s", newPath)
-					if newPath != m.ImagePath {
-						m.ImagePath = newPath
-						updated = true
-					}
+		}
+		if m.ImagePath != "" {
+			isAbs := isAbsolutePath(m.ImagePath)
+			log.Printf("Model %d ImagePath: %s (isAbs: %v)", m.ID, m.ImagePath, isAbs)
+			if isAbs {
+				newPath := MakeRelativePath(m.ImagePath, imageRoot)
+				log.Printf("  -> Converted to: %s", newPath)
+				if newPath != m.ImagePath {
+					m.ImagePath = newPath
+					updated = true
 				}
 			}
-			if updated {
-				log.Printf("Saving updated model %d", m.ID)
-				database.DB.Save(&m)
+		}
+		if updated {
+			log.Printf("Saving updated model %d", m.ID)
+			if err := database.DB.Save(&m).Error; err != nil {
+				run.Errorf("failed to save model %d: %v", m.ID, err)
+				continue
 			}
 		}
+		run.Processed(updated)
 	}
 
 	// Migrate Versions
-	var versions []models.Version
-	if err := database.DB.Find(&versions).Error; err == nil {
-		for _, v := range versions {
-			updated := false
-			if v.FilePath != "" && isAbsolutePath(v.FilePath) {
-				newPath := MakeRelativePath(v.FilePath, modelRoot)
-				if newPath != v.FilePath {
-					v.FilePath = newPath
-					updated = true
-				}
+	for _, v := range versions {
+		if run.Cancelled() {
+			return nil
+		}
+		updated := false
+		if v.FilePath != "" && isAbsolutePath(v.FilePath) {
+			newPath := MakeRelativePath(v.FilePath, modelRoot)
+			if newPath != v.FilePath {
+				v.FilePath = newPath
+				updated = true
 			}
-			if v.ImagePath != "" && isAbsolutePath(v.ImagePath) {
-				newPath := MakeRelativePath(v.ImagePath, imageRoot)
-				if newPath != v.ImagePath {
-					v.ImagePath = newPath
-					updated = true
-				}
+		}
+		if v.ImagePath != "" && isAbsolutePath(v.ImagePath) {
+			newPath := MakeRelativePath(v.ImagePath, imageRoot)
+			if newPath != v.ImagePath {
+				v.ImagePath = newPath
+				updated = true
 			}
-			if updated {
-				database.DB.Save(&v)
+		}
+		if updated {
+			if err := database.DB.Save(&v).Error; err != nil {
+				run.Errorf("failed to save version %d: %v", v.ID, err)
+				continue
 			}
 		}
+		run.Processed(updated)
 	}
 
 	// Migrate VersionImages
-	var images []models.VersionImage
-	if err := database.DB.Find(&images).Error; err == nil {
-		for _, img := range images {
-			if img.Path != "" && isAbsolutePath(img.Path) {
-				newPath := MakeRelativePath(img.Path, imageRoot)
-				if newPath != img.Path {
-					img.Path = newPath
-					database.DB.Save(&img)
+	for _, img := range images {
+		if run.Cancelled() {
+			return nil
+		}
+		updated := false
+		if img.Path != "" && isAbsolutePath(img.Path) {
+			newPath := MakeRelativePath(img.Path, imageRoot)
+			if newPath != img.Path {
+				img.Path = newPath
+				if err := database.DB.Save(&img).Error; err != nil {
+					run.Errorf("failed to save image %d: %v", img.ID, err)
+					continue
 				}
+				updated = true
 			}
 		}
+		run.Processed(updated)
 	}
 
 	log.Println("Path migration complete")
-	c.JSON(http.StatusOK, gin.H{"message": "Path migration complete"})
+	run.SetMessage("Path migration complete")
+	return nil
 }
 
 // NormalizeSlashes is a helper to ensure we store forward slashes in DB
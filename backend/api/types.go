@@ -13,6 +13,19 @@ type CivitModel struct {
 	Updated       string           `json:"updatedAt"`
 }
 
+// CivitModelPage is one page of the /models listing. CivitAI paginates with
+// an opaque cursor; nextPage is the full URL of the following page.
+type CivitModelPage struct {
+	Items    []CivitModel `json:"items"`
+	Metadata struct {
+		NextCursor  civitCursor `json:"nextCursor"`
+		NextPage    string      `json:"nextPage"`
+		TotalItems  int         `json:"totalItems"`
+		CurrentPage int         `json:"currentPage"`
+		TotalPages  int         `json:"totalPages"`
+	} `json:"metadata"`
+}
+
 type VersionSummary struct {
 	ID                   int          `json:"id"`
 	Name                 string       `json:"name"`
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// JobTypeCheckUpdates is the background job that looks for new versions.
+const JobTypeCheckUpdates = "check-updates"
+
+// updateSchedulerPoll is how often the scheduler compares the last check
+// with the configured interval.
+var updateSchedulerPoll = 10 * time.Minute
+
+// StartUpdateChecker runs the update check whenever the interval configured
+// by the update_check_interval_hours setting has passed since the last one.
+// The last run is read from the jobs table, so the schedule survives restarts.
+func StartUpdateChecker() {
+	go func() {
+		for {
+			maybeScheduleUpdateCheck()
+			time.Sleep(updateSchedulerPoll)
+		}
+	}()
+}
+
+func maybeScheduleUpdateCheck() {
+	interval := database.GetUpdateCheckInterval()
+	if interval <= 0 {
+		return
+	}
+	last, err := Jobs.List(JobTypeCheckUpdates, "", 1)
+	if err != nil {
+		return
+	}
+	if len(last) > 0 && last[0].StartedAt != nil && time.Since(*last[0].StartedAt) < interval {
+		return
+	}
+	var running *JobRunningError
+	if _, err := Jobs.Start(JobTypeCheckUpdates, checkForUpdates); err != nil && !errors.As(err, &running) {
+		log.Printf("failed to start scheduled update check: %v", err)
+	}
+}
+
+// checkForUpdates fetches every tracked model from CivitAI and records the
+// versions missing locally. Locally created models (CivitID <= 0) are skipped.
+func checkForUpdates(run *JobRun) error {
+	var tracked []models.Model
+	if err := database.DB.Where("civit_id > 0").Order("id").Find(&tracked).Error; err != nil {
+		return fmt.Errorf("failed to load models: %w", err)
+	}
+	run.SetTotal(len(tracked))
+
+	client := Civit.WithAPIKey(getCivitaiAPIKey())
+	found := 0
+	for _, m := range tracked {
+		if run.Cancelled() {
+			return nil
+		}
+		remote, err := client.GetModel(run.Context(), m.CivitID)
+		if err != nil {
+			run.Errorf("update check for model %d (%s): %v", m.CivitID, m.Name, err)
+			continue
+		}
+		n, err := recordModelUpdates(m, remote)
+		if err != nil {
+			run.Errorf("failed to store updates for model %d: %v", m.CivitID, err)
+			continue
+		}
+		found += n
+		run.Processed(n > 0)
+	}
+	run.SetMessage(fmt.Sprintf("Found %d new versions", found))
+	return nil
+}
+
+// recordModelUpdates replaces the stored updates for m with the remote
+// versions that have no local Version row, including soft-deleted ones so
+// versions the user removed are not offered again. It returns how many
+// updates were stored.
+func recordModelUpdates(m models.Model, remote CivitModel) (int, error) {
+	remoteIDs := make([]int, 0, len(remote.ModelVersions))
+	for _, v := range remote.ModelVersions {
+		remoteIDs = append(remoteIDs, v.ID)
+	}
+	var localIDs []int
+	if len(remoteIDs) > 0 {
+		if err := database.DB.Unscoped().Model(&models.Version{}).
+			Where("version_id IN ?", remoteIDs).Pluck("version_id", &localIDs).Error; err != nil {
+			return 0, err
+		}
+	}
+	local := make(map[int]bool, len(localIDs))
+	for _, id := range localIDs {
+		local[id] = true
+	}
+
+	count := 0
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("model_id = ?", m.ID).Delete(&models.AvailableUpdate{}).Error; err != nil {
+			return err
+		}
+		for _, v := range remote.ModelVersions {
+			if local[v.ID] {
+				continue
+			}
+			update := models.AvailableUpdate{
+				ModelID:        m.ID,
+				CivitModelID:   m.CivitID,
+				ModelName:      m.Name,
+				VersionID:      v.ID,
+				Name:           v.Name,
+				BaseModel:      v.BaseModel,
+				CivitCreatedAt: v.Created,
+			}
+			if err := tx.Create(&update).Error; err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// clearAvailableUpdate removes the update entry for a version once it has been
+// synced into the library.
+func clearAvailableUpdate(versionID int) {
+	database.DB.Unscoped().Where("version_id = ?", versionID).Delete(&models.AvailableUpdate{})
+}
+
+// GetUpdates lists new CivitAI versions of tracked models, newest first. The
+// optional modelId query parameter restricts the list to one local model.
+func GetUpdates(c *gin.Context) {
+	q := database.DB.Order("civit_created_at DESC, id DESC")
+	if modelID := c.Query("modelId"); modelID != "" {
+		q = q.Where("model_id = ?", modelID)
+	}
+	var updates []models.AvailableUpdate
+	if err := q.Find(&updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load updates"})
+		return
+	}
+	c.JSON(http.StatusOK, updates)
+}
+
+// CheckForUpdates starts an update check in the background.
+func CheckForUpdates(c *gin.Context) {
+	startJob(c, JobTypeCheckUpdates, checkForUpdates)
+}
+
+// DownloadUpdate syncs the version named by the :versionId path parameter
+// through SyncVersionByID, passing the parent model as the fallback so early
+// access versions still resolve.
+func DownloadUpdate(c *gin.Context) {
+	versionID, err := strconv.Atoi(c.Param("versionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version ID"})
+		return
+	}
+	var update models.AvailableUpdate
+	if err := database.DB.Where("version_id = ?", versionID).First(&update).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Update not found"})
+		return
+	}
+
+	q := c.Request.URL.Query()
+	q.Set("modelId", strconv.Itoa(update.CivitModelID))
+	c.Request.URL.RawQuery = q.Encode()
+	SyncVersionByID(c)
+}
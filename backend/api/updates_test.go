@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func TestCheckForUpdates(t *testing.T) {
+	initTestDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/models/10" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(CivitModel{ID: 10, ModelVersions: []VersionSummary{
+			{ID: 100, Name: "v1"},
+			{ID: 101, Name: "v2", BaseModel: "Illustrious", Created: "2026-01-02T00:00:00Z"},
+			{ID: 102, Name: "removed"},
+		}})
+	}))
+	defer srv.Close()
+	useCivitServer(t, srv)
+
+	tracked := models.Model{CivitID: 10, Name: "tracked", Weight: 1}
+	database.DB.Create(&tracked)
+	database.DB.Create(&models.Model{CivitID: -5, Name: "local upload", Weight: 1})
+	database.DB.Create(&models.Version{ModelID: tracked.ID, VersionID: 100})
+	removed := models.Version{ModelID: tracked.ID, VersionID: 102}
+	database.DB.Create(&removed)
+	database.DB.Delete(&removed)
+	// A stale entry for a version that has since been synced is dropped.
+	database.DB.Create(&models.AvailableUpdate{ModelID: tracked.ID, VersionID: 100})
+
+	if err := checkForUpdates(nil); err != nil {
+		t.Fatalf("checkForUpdates: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/updates", nil)
+	GetUpdates(c)
+	var updates []models.AvailableUpdate
+	if err := json.Unmarshal(rec.Body.Bytes(), &updates); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("updates = %+v, want only version 101", updates)
+	}
+	u := updates[0]
+	if u.VersionID != 101 || u.ModelID != tracked.ID || u.CivitModelID != 10 || u.ModelName != "tracked" ||
+		u.Name != "v2" || u.BaseModel != "Illustrious" || u.CivitCreatedAt != "2026-01-02T00:00:00Z" {
+		t.Errorf("update = %+v", u)
+	}
+
+	clearAvailableUpdate(101)
+	var count int64
+	database.DB.Model(&models.AvailableUpdate{}).Count(&count)
+	if count != 0 {
+		t.Errorf("updates after clear = %d, want 0", count)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -147,9 +148,27 @@ func getProgress(t *testing.T) int64 {
 	return p
 }
 
+// setActiveProgress registers a fake running job with the given counters and
+// removes it when the test finishes.
+func setActiveProgress(t *testing.T, id uint, downloaded, total int64) *activeDownload {
+	t.Helper()
+	ad := &activeDownload{cancel: func() {}, startedAt: time.Now()}
+	ad.downloaded.Store(downloaded)
+	ad.total.Store(total)
+	Downloads.mu.Lock()
+	Downloads.active[id] = ad
+	Downloads.mu.Unlock()
+	t.Cleanup(func() {
+		Downloads.mu.Lock()
+		delete(Downloads.active, id)
+		Downloads.mu.Unlock()
+	})
+	return ad
+}
+
 func TestGetDownloadProgress(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	CurrentDownloadProgress = 42
+	setActiveProgress(t, 1, 42, 100)
 	rec := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(rec)
 	c.Request = httptest.NewRequest(http.MethodGet, "/progress", nil)
@@ -170,16 +189,16 @@ func TestGetDownloadProgress(t *testing.T) {
 }
 
 // TestGetDownloadProgressUpdates verifies the endpoint reflects sequential updates
-// to CurrentDownloadProgress and that values persist or reset appropriately.
+// to the running job and that values persist or reset appropriately.
 func TestGetDownloadProgressUpdates(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
-	CurrentDownloadProgress = 0
 	if p := getProgress(t); p != 0 {
 		t.Errorf("progress = %d, want 0", p)
 	}
 
-	CurrentDownloadProgress = 50
+	ad := setActiveProgress(t, 1, 0, 200)
+	ad.downloaded.Store(100)
 	if p := getProgress(t); p != 50 {
 		t.Errorf("progress = %d, want 50", p)
 	}
@@ -188,33 +207,28 @@ func TestGetDownloadProgressUpdates(t *testing.T) {
 		t.Errorf("progress persisted = %d, want 50", p)
 	}
 
-	CurrentDownloadProgress = 100
+	ad.downloaded.Store(200)
 	if p := getProgress(t); p != 100 {
 		t.Errorf("progress = %d, want 100", p)
 	}
-
-	// Reset back to 0 and confirm.
-	CurrentDownloadProgress = 0
-	if p := getProgress(t); p != 0 {
-		t.Errorf("progress after reset = %d, want 0", p)
-	}
 }
 
-// TestGetDownloadProgressOutOfRange verifies negative or >100 values are returned as-is.
-func TestGetDownloadProgressOutOfRange(t *testing.T) {
+// TestGetDownloadProgressLatestJob verifies the legacy endpoint follows the most
+// recently started job when several are running.
+func TestGetDownloadProgressLatestJob(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	for _, v := range []int64{-10, 150} {
-		CurrentDownloadProgress = v
-		if p := getProgress(t); p != v {
-			t.Errorf("progress = %d, want %d", p, v)
-		}
+	older := setActiveProgress(t, 1, 10, 100)
+	older.startedAt = time.Now().Add(-time.Minute)
+	setActiveProgress(t, 2, 75, 100)
+	if p := getProgress(t); p != 75 {
+		t.Errorf("progress = %d, want 75", p)
 	}
 }
 
 // TestGetDownloadProgressConcurrent ensures simultaneous requests return the current value.
 func TestGetDownloadProgressConcurrent(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	CurrentDownloadProgress = 66
+	setActiveProgress(t, 1, 66, 100)
 
 	const n = 10
 	var wg sync.WaitGroup
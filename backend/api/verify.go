@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HashIssue describes a library file that is missing or whose contents do not
+// match the SHA256 recorded from CivitAI.
+type HashIssue struct {
+	VersionID int    `json:"versionId"`
+	ModelID   uint   `json:"modelId"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual,omitempty"`
+}
+
+// HashReport is the result of a library verification run, stored as the
+// verify-hashes job result.
+type HashReport struct {
+	Checked  int         `json:"checked"`
+	Verified int         `json:"verified"`
+	Skipped  int         `json:"skipped"`
+	Corrupt  []HashIssue `json:"corrupt"`
+	Missing  []HashIssue `json:"missing"`
+}
+
+// VerifyHashes starts a background job re-hashing every version file. The
+// report is available from GetHashReport once the job finishes.
+func VerifyHashes(c *gin.Context) {
+	startJob(c, JobTypeVerifyHashes, func(run *JobRun) error {
+		report, err := verifyLibraryHashes(run)
+		if err != nil {
+			return err
+		}
+		run.SetResult(report)
+		run.SetMessage(fmt.Sprintf("Verified %d, corrupt %d, missing %d, skipped %d",
+			report.Verified, len(report.Corrupt), len(report.Missing), report.Skipped))
+		return nil
+	})
+}
+
+// GetHashReport returns the most recent verify-hashes job together with its
+// decoded report, if it has finished.
+func GetHashReport(c *gin.Context) {
+	jobs, err := Jobs.List(JobTypeVerifyHashes, "", 1)
+	if err != nil || len(jobs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No hash verification has been run"})
+		return
+	}
+	var report *HashReport
+	if jobs[0].Result != "" {
+		report = &HashReport{}
+		json.Unmarshal([]byte(jobs[0].Result), report)
+	}
+	c.JSON(http.StatusOK, gin.H{"job": jobs[0], "report": report})
+}
+
+// verifyLibraryHashes hashes the file of every version that has one and
+// records the outcome in Version.FileStatus. Files already in the library are
+// only reported, never moved, so a user can inspect them before deleting.
+func verifyLibraryHashes(run *JobRun) (HashReport, error) {
+	report := HashReport{Corrupt: []HashIssue{}, Missing: []HashIssue{}}
+
+	var versions []models.Version
+	if err := database.DB.Where("file_path <> ''").Find(&versions).Error; err != nil {
+		return report, fmt.Errorf("failed to load versions: %w", err)
+	}
+	run.SetTotal(len(versions))
+
+	for _, v := range versions {
+		if run.Cancelled() {
+			break
+		}
+		if v.SHA256 == "" {
+			report.Skipped++
+			run.Processed(false)
+			continue
+		}
+		report.Checked++
+		path := ResolveModelPath(v.FilePath)
+		issue := HashIssue{VersionID: v.VersionID, ModelID: v.ModelID, Name: v.Name, Path: path, Expected: v.SHA256}
+
+		status := models.FileStatusVerified
+		if _, err := os.Stat(path); err != nil {
+			status = models.FileStatusMissing
+			report.Missing = append(report.Missing, issue)
+		} else if sum, err := FileHash(path); err != nil {
+			run.Errorf("hash verification: failed to hash %s: %v", path, err)
+			report.Checked--
+			report.Skipped++
+			continue
+		} else if !strings.EqualFold(sum, v.SHA256) {
+			status = models.FileStatusHashMismatch
+			issue.Actual = sum
+			report.Corrupt = append(report.Corrupt, issue)
+		} else {
+			report.Verified++
+		}
+
+		database.DB.Model(&models.Version{}).Where("id = ?", v.ID).UpdateColumns(map[string]interface{}{
+			"file_status":     status,
+			"file_checked_at": time.Now(),
+		})
+		run.Processed(status != v.FileStatus)
+	}
+	return report, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"model-manager/backend/database"
+	"model-manager/backend/models"
+)
+
+func TestVerifyLibraryHashes(t *testing.T) {
+	initTestDB(t)
+	root := t.TempDir()
+	database.SetSettingValue("model_path", root)
+
+	good := []byte("good model")
+	sum := sha256.Sum256(good)
+	os.WriteFile(filepath.Join(root, "good.bin"), good, 0o644)
+	os.WriteFile(filepath.Join(root, "bad.bin"), []byte("bit rot"), 0o644)
+
+	m := models.Model{CivitID: 1, Name: "m", Weight: 1}
+	database.DB.Create(&m)
+	database.DB.Create(&models.Version{ModelID: m.ID, VersionID: 1, FilePath: "good.bin", SHA256: hex.EncodeToString(sum[:])})
+	database.DB.Create(&models.Version{ModelID: m.ID, VersionID: 2, FilePath: "bad.bin", SHA256: hex.EncodeToString(sum[:])})
+	database.DB.Create(&models.Version{ModelID: m.ID, VersionID: 3, FilePath: "gone.bin", SHA256: hex.EncodeToString(sum[:])})
+	database.DB.Create(&models.Version{ModelID: m.ID, VersionID: 4, FilePath: "good.bin"})
+
+	report, err := verifyLibraryHashes(nil)
+	if err != nil {
+		t.Fatalf("verifyLibraryHashes: %v", err)
+	}
+	if report.Checked != 3 || report.Verified != 1 || report.Skipped != 1 {
+		t.Errorf("report counts = %+v", report)
+	}
+	if len(report.Corrupt) != 1 || report.Corrupt[0].VersionID != 2 {
+		t.Errorf("corrupt = %+v", report.Corrupt)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].VersionID != 3 {
+		t.Errorf("missing = %+v", report.Missing)
+	}
+	if _, err := os.Stat(filepath.Join(root, "bad.bin")); err != nil {
+		t.Errorf("corrupt library file should be left in place: %v", err)
+	}
+
+	want := map[int]string{
+		1: models.FileStatusVerified,
+		2: models.FileStatusHashMismatch,
+		3: models.FileStatusMissing,
+		4: "",
+	}
+	for id, status := range want {
+		var v models.Version
+		database.DB.Where("version_id = ?", id).First(&v)
+		if v.FileStatus != status {
+			t.Errorf("version %d status = %q, want %q", id, v.FileStatus, status)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"model-manager/backend/database"
 	"model-manager/backend/models"
@@ -26,8 +28,24 @@ var (
 )
 
 type ClientConnection struct {
-	Conn *websocket.Conn
-	mu   sync.Mutex
+	Conn     *websocket.Conn
+	clientID string
+	mu       sync.Mutex
+
+	// state guards what the hello handshake agreed and the commands
+	// waiting for a reply. protocol is 0 until the first message arrives.
+	state        sync.Mutex
+	protocol     int
+	capabilities map[string]bool
+	sent         map[string]sentCommand
+	settled      bool
+	// negotiated is closed once the first message showed which protocol
+	// the client speaks.
+	negotiated chan struct{}
+}
+
+func newClientConnection(clientID string, conn *websocket.Conn) *ClientConnection {
+	return &ClientConnection{Conn: conn, clientID: clientID, negotiated: make(chan struct{})}
 }
 
 func (c *ClientConnection) WriteJSON(v interface{}) error {
@@ -36,27 +54,34 @@ func (c *ClientConnection) WriteJSON(v interface{}) error {
 	return c.Conn.WriteJSON(v)
 }
 
+// ClientMessage is a message from a client: the whole message in protocol
+// version 1, the payload of an Envelope from version 2 on.
 type ClientMessage struct {
-	Type           string `json:"type"` // "complete", "deleted", "error"
+	Type           string `json:"type"` // "complete", "deleted", "error", "progress", "status", "inventory"
 	ModelVersionID uint   `json:"model_version_id"`
 	ClientID       string `json:"-"` // Added server-side
-}
+	// Action is the command a reply answers, taken from the command the
+	// reply refers to if the client leaves it out.
+	Action string `json:"action,omitempty"`
 
-func HandleWebSocket(c *gin.Context) {
-	// Authentication
-	secret := c.GetHeader("Authorization")
-	if secret != os.Getenv("CLIENT_SECRET") {
-		// Try query param for easier testing if needed, or strict header
-		if secret == "" {
-			secret = c.Query("key")
-		}
-	}
+	// Sent with "progress" messages
+	BytesDone  int64 `json:"bytes_done,omitempty"`
+	BytesTotal int64 `json:"bytes_total,omitempty"`
 
-	if secret != os.Getenv("CLIENT_SECRET") {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
+	// Sent with "error" messages
+	Reason string `json:"reason,omitempty"`
+
+	// Sent with "status" messages
+	Name          string `json:"name,omitempty"`
+	Version       string `json:"version,omitempty"`
+	RootPath      string `json:"root_path,omitempty"`
+	FreeDiskBytes int64  `json:"free_disk_bytes,omitempty"`
 
+	// Sent with "inventory" messages
+	Files []InventoryFile `json:"files,omitempty"`
+}
+
+func HandleWebSocket(c *gin.Context) {
 	clientID := c.GetHeader("X-Client-ID")
 	if clientID == "" {
 		clientID = c.Query("client_id")
@@ -66,6 +91,19 @@ func HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	// Authentication: the client's own token, or the shared CLIENT_SECRET
+	// for clients that have none unless REQUIRE_CLIENT_TOKENS is set
+	key := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if key == "" {
+		key = c.Query("key")
+	}
+	if err := database.AuthenticateClient(clientID, key, sharedClientSecret()); err != nil {
+		log.Printf("Rejected connection from %s as client %s: %v", c.ClientIP(), clientID, err)
+		Events.Publish(EventClientAuthFailed, gin.H{"clientId": clientID, "remoteAddr": c.ClientIP(), "reason": err.Error()})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
 	// Upgrade connection
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -74,7 +112,7 @@ func HandleWebSocket(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	clientConn := &ClientConnection{Conn: conn}
+	clientConn := newClientConnection(clientID, conn)
 
 	// Register client
 	ClientsMutex.Lock()
@@ -82,12 +120,37 @@ func HandleWebSocket(c *gin.Context) {
 	ClientsMutex.Unlock()
 
 	log.Printf("Client connected: %s", clientID)
+	presenceID, err := database.ClientConnected(clientID, c.ClientIP())
+	if err != nil {
+		log.Printf("Error registering client %s: %v", clientID, err)
+	}
+
+	// Send what was dispatched while the client was away
+	go replayClientCommands(clientID)
+	Events.Publish(EventClientConnected, gin.H{"clientId": clientID})
 
 	defer func() {
+		// A client that reconnected before this socket timed out already
+		// has a newer connection; leave that one and its state alone.
 		ClientsMutex.Lock()
+		current := Clients[clientID] == clientConn
+		if !current {
+			ClientsMutex.Unlock()
+			log.Printf("Replaced connection of client %s closed", clientID)
+			if err := database.CloseClientPresence(presenceID); err != nil {
+				log.Printf("Error recording disconnect of client %s: %v", clientID, err)
+			}
+			return
+		}
 		delete(Clients, clientID)
+		// Recorded under the lock so a reconnect cannot be marked offline.
+		err := database.ClientDisconnected(clientID, presenceID)
 		ClientsMutex.Unlock()
 		log.Printf("Client disconnected: %s", clientID)
+		if err != nil {
+			log.Printf("Error recording disconnect of client %s: %v", clientID, err)
+		}
+		Events.Publish(EventClientDisconnected, gin.H{"clientId": clientID})
 
 		// Reset any pending downloads for this client to prevent stuck state
 		if err := database.ResetPendingClientFilesForClient(clientID); err != nil {
@@ -95,30 +158,76 @@ func HandleWebSocket(c *gin.Context) {
 		}
 	}()
 
+	// Heartbeat: any message or pong within heartbeatTimeout keeps the
+	// connection alive
+	conn.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go clientConn.heartbeat(clientID, done)
+
 	// Listen loop
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("Client %s missed its heartbeat", clientID)
+			}
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+		clientConn.handleMessage(clientID, message)
+	}
+}
 
-		var msg ClientMessage
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("Error parsing message from %s: %v", clientID, err)
-			continue
+func handleClientMessage(msg ClientMessage) {
+	if msg.Type == "status" {
+		err := database.UpdateClientStatus(msg.ClientID, database.ClientStatus{
+			Name:          msg.Name,
+			Version:       msg.Version,
+			RootPath:      msg.RootPath,
+			FreeDiskBytes: msg.FreeDiskBytes,
+		})
+		if err != nil {
+			log.Printf("Error updating status of client %s: %v", msg.ClientID, err)
 		}
+		return
+	}
+	database.TouchClient(msg.ClientID)
 
-		msg.ClientID = clientID
-		handleClientMessage(msg)
+	if msg.Type == "inventory" {
+		handleInventory(msg.ClientID, msg.Files)
+		return
 	}
-}
 
-func handleClientMessage(msg ClientMessage) {
+	if msg.Type == "error" {
+		handleClientError(msg)
+		return
+	}
 	if msg.ModelVersionID == 0 {
 		return
 	}
+	// Replies to sidecar and inventory commands change no ClientFile
+	if msg.Action != "" && msg.Action != "download" && msg.Action != "delete" {
+		return
+	}
 
 	switch msg.Type {
+	case "progress":
+		if err := database.UpdateClientFileProgress(msg.ClientID, msg.ModelVersionID, msg.BytesDone, msg.BytesTotal); err != nil {
+			log.Printf("Error recording progress of model %d on client %s: %v", msg.ModelVersionID, msg.ClientID, err)
+			return
+		}
+		Events.Publish(EventClientFileProgress, gin.H{
+			"clientId":       msg.ClientID,
+			"modelVersionId": msg.ModelVersionID,
+			"bytesDone":      msg.BytesDone,
+			"bytesTotal":     msg.BytesTotal,
+		})
+
 	case "complete":
 		// Update/Create ClientFile record
 		var cf models.ClientFile
@@ -130,18 +239,55 @@ func handleClientMessage(msg ClientMessage) {
 			}
 		}
 		cf.Status = "installed"
+		cf.Error = ""
 		database.DB.Save(&cf)
 		log.Printf("Updated status 'installed' for model %d on client %s", msg.ModelVersionID, msg.ClientID)
+		publishClientFileStatus(msg.ClientID, msg.ModelVersionID, cf.Status)
 
 	case "deleted":
 		// Remove record or set to something else? Requirement says "Delete Update ClientFile record to remove the entry"
 		database.DB.Delete(&models.ClientFile{}, "client_id = ? AND model_version_id = ?", msg.ClientID, msg.ModelVersionID)
 		log.Printf("Removed record for model %d on client %s", msg.ModelVersionID, msg.ClientID)
+		publishClientFileStatus(msg.ClientID, msg.ModelVersionID, "deleted")
+	}
+}
+
+// handleClientError records a command the client could not carry out. A
+// failed download marks the ClientFile failed with the client's reason.
+func handleClientError(msg ClientMessage) {
+	reason := msg.Reason
+	if reason == "" {
+		reason = "unknown error"
+	}
+	log.Printf("Client %s reported an error for %s of model %d: %s", msg.ClientID, msg.Action, msg.ModelVersionID, reason)
+	if msg.ModelVersionID == 0 || (msg.Action != "" && msg.Action != "download") {
+		return
 	}
+	if err := database.MarkClientFileFailed(msg.ClientID, msg.ModelVersionID, reason); err != nil {
+		log.Printf("Error marking model %d failed on client %s: %v", msg.ModelVersionID, msg.ClientID, err)
+		return
+	}
+	publishClientFileFailed(msg.ClientID, msg.ModelVersionID, reason)
+}
+
+// publishClientFileStatus announces a ClientFile status change to browsers.
+// A status of "deleted" means the record was removed.
+func publishClientFileStatus(clientID string, versionID uint, status string) {
+	Events.Publish(EventClientFileStatus, gin.H{
+		"clientId":       clientID,
+		"modelVersionId": versionID,
+		"status":         status,
+	})
+}
+
+// SendToClient sends a command to a connected client in the protocol it
+// speaks.
+func SendToClient(clientID string, req DispatchRequest) error {
+	return sendClientCommand(clientID, req, 0)
 }
 
-// Helper to send to specific client
-func SendToClient(clientID string, payload interface{}) error {
+// sendClientCommand is SendToClient for a queued ClientCommand.
+func sendClientCommand(clientID string, req DispatchRequest, commandID uint) error {
 	ClientsMutex.Lock()
 	clientConn, ok := Clients[clientID]
 	ClientsMutex.Unlock()
@@ -150,7 +296,7 @@ func SendToClient(clientID string, payload interface{}) error {
 		return &ClientNotFoundError{ClientID: clientID}
 	}
 
-	return clientConn.WriteJSON(payload)
+	return clientConn.sendCommand(req, commandID)
 }
 
 type ClientNotFoundError struct {
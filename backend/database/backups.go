@@ -0,0 +1,263 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"model-manager/backend/models"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	backupPrefix = "models-"
+	backupExt    = ".db"
+)
+
+var (
+	// ErrBackupNotFound is returned for an unknown backup ID.
+	ErrBackupNotFound = errors.New("backup not found")
+	// ErrInvalidBackup wraps the reason a file cannot be restored.
+	ErrInvalidBackup = errors.New("invalid backup")
+)
+
+// Backup is a snapshot of the database in the backup directory. ID is its
+// file name.
+type Backup struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// GetBackupPath returns the directory backups are written to.
+// Defaults to "./backend/backups" if not set.
+func GetBackupPath() string {
+	if val := GetSettingValue("backup_path"); val != "" {
+		return val
+	}
+	return "./backend/backups"
+}
+
+// GetBackupInterval returns how often a backup is taken. It defaults to a day;
+// setting backup_interval_hours to 0 disables scheduled backups.
+func GetBackupInterval() time.Duration {
+	if val := GetSettingValue("backup_interval_hours"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+			return time.Duration(n) * time.Hour
+		}
+	}
+	return 24 * time.Hour
+}
+
+// GetBackupRetention returns how many backups are kept when rotating.
+// Defaults to 7 if not set or invalid.
+func GetBackupRetention() int {
+	if val := GetSettingValue("backup_retention"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 7
+}
+
+// databaseFile returns the path of the main database file, or "" for an
+// in-memory database.
+func databaseFile(db *gorm.DB) (string, error) {
+	var file string
+	row := db.Raw("SELECT file FROM pragma_database_list WHERE name = 'main'").Row()
+	err := row.Scan(&file)
+	return file, err
+}
+
+// CreateBackup writes a consistent copy of db into dir with VACUUM INTO,
+// which does not block other connections for longer than a read.
+func CreateBackup(db *gorm.DB, dir string) (Backup, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Backup{}, err
+	}
+	stamp := backupPrefix + time.Now().Format("20060102-150405")
+	id := stamp + backupExt
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, id)); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d%s", stamp, n, backupExt)
+	}
+	path := filepath.Join(dir, id)
+	if err := db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return Backup{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Backup{}, err
+	}
+	log.Printf("Backed up database to %s", path)
+	return Backup{ID: id, Size: info.Size(), CreatedAt: info.ModTime()}, nil
+}
+
+func isBackupName(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupExt) && filepath.Base(name) == name
+}
+
+// ListBackups returns the backups in dir, newest first. A missing directory
+// has no backups.
+func ListBackups(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := []Backup{}
+	for _, e := range entries {
+		if e.IsDir() || !isBackupName(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{ID: e.Name(), Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].CreatedAt.Equal(backups[j].CreatedAt) {
+			return backups[i].CreatedAt.After(backups[j].CreatedAt)
+		}
+		return backups[i].ID > backups[j].ID
+	})
+	return backups, nil
+}
+
+// BackupFile returns the path of the backup with the given ID in dir.
+func BackupFile(dir, id string) (string, error) {
+	if !isBackupName(id) {
+		return "", ErrBackupNotFound
+	}
+	path := filepath.Join(dir, id)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", ErrBackupNotFound
+	}
+	return path, nil
+}
+
+// PruneBackups deletes all but the newest keep backups in dir and returns
+// the IDs it removed.
+func PruneBackups(dir string, keep int) ([]string, error) {
+	backups, err := ListBackups(dir)
+	if err != nil || len(backups) <= keep {
+		return nil, err
+	}
+	var removed []string
+	for _, b := range backups[keep:] {
+		if err := os.Remove(filepath.Join(dir, b.ID)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.ID)
+	}
+	return removed, nil
+}
+
+// ValidateBackup checks that path is an intact model manager database this
+// build can migrate: it passes SQLite's integrity check, has the core
+// tables, and was not written by a newer schema.
+func ValidateBackup(path string) error {
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	var result string
+	if err := db.Raw("PRAGMA integrity_check").Row().Scan(&result); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, result)
+	}
+	for _, table := range []interface{}{&models.Model{}, &models.Version{}, &models.Setting{}} {
+		if !db.Migrator().HasTable(table) {
+			return fmt.Errorf("%w: not a model manager database", ErrInvalidBackup)
+		}
+	}
+	if db.Migrator().HasTable(&models.SchemaMigration{}) {
+		var version int
+		db.Model(&models.SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version)
+		if latest := migrations[len(migrations)-1].Version; version > latest {
+			return fmt.Errorf("%w: schema version %d is newer than this build supports (%d)", ErrInvalidBackup, version, latest)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup replaces the contents of db with the backup at path, then
+// migrates the restored data to the current schema. The copy goes through
+// SQLite's online backup API on a connection of db, so the pool and anyone
+// holding DB keep working against the restored data.
+func RestoreBackup(db *gorm.DB, path string) error {
+	if err := ValidateBackup(path); err != nil {
+		return err
+	}
+	if err := copyDatabase(db, path); err != nil {
+		return err
+	}
+	if _, err := Migrate(db, MigrateOptions{NoBackup: true}); err != nil {
+		return err
+	}
+	return setupSearchIndex(db)
+}
+
+func copyDatabase(dst *gorm.DB, srcPath string) error {
+	ctx := context.Background()
+	sqlDB, err := dst.DB()
+	if err != nil {
+		return err
+	}
+	dstConn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	src, err := sql.Open("sqlite3", "file:"+srcPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			to, ok := d.(*sqlite3.SQLiteConn)
+			from, ok2 := s.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("restore needs the sqlite3 driver")
+			}
+			b, err := to.Backup("main", from, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
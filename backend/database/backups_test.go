@@ -0,0 +1,53 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"model-manager/backend/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestPruneBackupsKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"models-a.db", "models-b.db", "models-c.db", "other.db"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, nil, 0o644)
+		stamp := base.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, stamp, stamp)
+	}
+
+	removed, err := PruneBackups(dir, 2)
+	if err != nil || len(removed) != 1 || removed[0] != "models-a.db" {
+		t.Fatalf("PruneBackups = %v, %v", removed, err)
+	}
+	backups, _ := ListBackups(dir)
+	if len(backups) != 2 || backups[0].ID != "models-c.db" {
+		t.Errorf("backups = %+v", backups)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.db")); err != nil {
+		t.Errorf("unrelated files must be left alone: %v", err)
+	}
+}
+
+func TestValidateBackupRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models-new.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.AutoMigrate(&models.Model{}, &models.Version{}, &models.Setting{}, &models.SchemaMigration{})
+	if err := ValidateBackup(path); err != nil {
+		t.Fatalf("ValidateBackup: %v", err)
+	}
+	db.Create(&models.SchemaMigration{Version: migrations[len(migrations)-1].Version + 1, Name: "future"})
+	if err := ValidateBackup(path); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("ValidateBackup = %v, want ErrInvalidBackup", err)
+	}
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"model-manager/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrCommandNotFound is returned when cancelling a command that is not
+// queued for the client.
+var ErrCommandNotFound = errors.New("no queued command with that ID")
+
+// commandSupersedes lists, per action, the queued actions for the same
+// version a new command cancels. A repeated command replaces the queued
+// one; a delete cancels a queued download and the other way round.
+var commandSupersedes = map[string][]string{
+	"download": {"download", "delete"},
+	"delete":   {"download", "delete"},
+}
+
+// EnqueueClientCommand queues payload for a disconnected client, cancelling
+// the queued commands it supersedes. The command expires after
+// GetClientCommandTTL.
+func EnqueueClientCommand(clientID string, versionID uint, action string, payload []byte) (models.ClientCommand, error) {
+	cmd := models.ClientCommand{
+		ClientID:       clientID,
+		ModelVersionID: versionID,
+		Action:         action,
+		Payload:        string(payload),
+		Status:         models.ClientCommandQueued,
+	}
+	if ttl := GetClientCommandTTL(); ttl > 0 {
+		expires := time.Now().Add(ttl)
+		cmd.ExpiresAt = &expires
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if superseded := commandSupersedes[action]; len(superseded) > 0 {
+			err := tx.Model(&models.ClientCommand{}).
+				Where("client_id = ? AND model_version_id = ? AND status = ? AND action IN ?",
+					clientID, versionID, models.ClientCommandQueued, superseded).
+				Update("status", models.ClientCommandCancelled).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(&cmd).Error
+	})
+	return cmd, err
+}
+
+// ExpireClientCommands marks queued commands past their expiry as expired
+// and removes the pending ClientFile records of expired downloads. It
+// returns the expired commands.
+func ExpireClientCommands() ([]models.ClientCommand, error) {
+	var expired []models.ClientCommand
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", models.ClientCommandQueued, time.Now()).
+			Order("id").Find(&expired).Error; err != nil {
+			return err
+		}
+		for _, cmd := range expired {
+			if err := finishQueuedCommand(tx, cmd, models.ClientCommandExpired); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// finishQueuedCommand ends a command that will not be sent, dropping the
+// pending install a queued download stood for.
+func finishQueuedCommand(tx *gorm.DB, cmd models.ClientCommand, status string) error {
+	if err := tx.Model(&cmd).Update("status", status).Error; err != nil {
+		return err
+	}
+	if cmd.Action != "download" {
+		return nil
+	}
+	return tx.Unscoped().
+		Where("client_id = ? AND model_version_id = ? AND status = ?", cmd.ClientID, cmd.ModelVersionID, "pending").
+		Delete(&models.ClientFile{}).Error
+}
+
+// CancelClientCommand cancels a queued command of a client.
+func CancelClientCommand(clientID string, id uint) (models.ClientCommand, error) {
+	var cmd models.ClientCommand
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND client_id = ? AND status = ?", id, clientID, models.ClientCommandQueued).
+			Limit(1).Find(&cmd).Error; err != nil {
+			return err
+		}
+		if cmd.ID == 0 {
+			return ErrCommandNotFound
+		}
+		return finishQueuedCommand(tx, cmd, models.ClientCommandCancelled)
+	})
+	if err == nil {
+		cmd.Status = models.ClientCommandCancelled
+	}
+	return cmd, err
+}
+
+// ListClientCommands returns a client's commands in queue order. Unless all
+// is set only queued commands are returned.
+func ListClientCommands(clientID string, all bool) ([]models.ClientCommand, error) {
+	cmds := []models.ClientCommand{}
+	q := DB.Where("client_id = ?", clientID)
+	if !all {
+		q = q.Where("status = ?", models.ClientCommandQueued)
+	}
+	err := q.Order("id").Find(&cmds).Error
+	return cmds, err
+}
+
+// MarkClientCommandSent records that a queued command reached its client.
+func MarkClientCommandSent(id uint) error {
+	now := time.Now()
+	return DB.Model(&models.ClientCommand{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": models.ClientCommandSent, "sent_at": &now}).Error
+}
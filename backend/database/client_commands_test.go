@@ -0,0 +1,92 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"model-manager/backend/models"
+)
+
+func TestClientCommandQueue(t *testing.T) {
+	db := openMigrationTestDB(t)
+	db.AutoMigrate(&models.Setting{}, &models.ClientFile{}, &models.ClientCommand{})
+
+	first, err := EnqueueClientCommand("desk", 1, "download", []byte(`{"action":"download"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ExpiresAt == nil {
+		t.Error("command should expire with the default TTL")
+	}
+	EnqueueClientCommand("desk", 2, "download", []byte(`{}`))
+	EnqueueClientCommand("laptop", 1, "download", []byte(`{}`))
+	// A delete of the same version supersedes the queued download.
+	if _, err := EnqueueClientCommand("desk", 1, "delete", []byte(`{"action":"delete"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, _ := ListClientCommands("desk", false)
+	if len(queued) != 2 || queued[0].ModelVersionID != 2 || queued[1].Action != "delete" {
+		t.Fatalf("queued = %+v", queued)
+	}
+	all, _ := ListClientCommands("desk", true)
+	if len(all) != 3 || all[0].Status != models.ClientCommandCancelled {
+		t.Fatalf("all = %+v", all)
+	}
+
+	if _, err := CancelClientCommand("laptop", queued[0].ID); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("cancel of another client's command = %v", err)
+	}
+	db.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: 2, Status: "pending"})
+	if _, err := CancelClientCommand("desk", queued[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&models.ClientFile{}).Where("client_id = ? AND model_version_id = ?", "desk", 2).Count(&count)
+	if count != 0 {
+		t.Error("cancelling a download should drop its pending install")
+	}
+
+	if err := MarkClientCommandSent(queued[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if queued, _ := ListClientCommands("desk", false); len(queued) != 0 {
+		t.Errorf("sent command still queued: %+v", queued)
+	}
+}
+
+func TestExpireClientCommands(t *testing.T) {
+	db := openMigrationTestDB(t)
+	db.AutoMigrate(&models.Setting{}, &models.ClientFile{}, &models.ClientCommand{})
+
+	stale, _ := EnqueueClientCommand("desk", 1, "download", []byte(`{}`))
+	past := time.Now().Add(-time.Hour)
+	db.Model(&stale).Update("expires_at", &past)
+	EnqueueClientCommand("desk", 2, "download", []byte(`{}`))
+	db.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: 1, Status: "pending"})
+	db.Create(&models.ClientFile{ClientID: "desk", ModelVersionID: 2, Status: "pending"})
+
+	// Pending installs of queued downloads survive a reset.
+	if err := ResetPendingClientFilesForClient("desk"); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&models.ClientFile{}).Count(&count)
+	if count != 2 {
+		t.Fatalf("pending files after reset = %d, want 2", count)
+	}
+
+	expired, err := ExpireClientCommands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].ID != stale.ID {
+		t.Fatalf("expired = %+v", expired)
+	}
+	var left []models.ClientFile
+	db.Find(&left)
+	if len(left) != 1 || left[0].ModelVersionID != 2 {
+		t.Errorf("pending files after expiry = %+v", left)
+	}
+}
@@ -2,14 +2,44 @@ package database
 
 import "model-manager/backend/models"
 
+// notQueued excludes pending ClientFile records that stand for a download
+// still queued for a disconnected client.
+const notQueued = `NOT EXISTS (SELECT 1 FROM client_commands cc
+	WHERE cc.client_id = client_files.client_id AND cc.model_version_id = client_files.model_version_id
+	AND cc.action = 'download' AND cc.status = 'queued' AND cc.deleted_at IS NULL)`
+
 // ResetAllPendingClientFiles deletes all ClientFile records with status 'pending'.
 // This is used at startup to clear any stuck states from previous runs or crashes.
+// Downloads queued for a disconnected client stay pending.
 func ResetAllPendingClientFiles() error {
-	return DB.Unscoped().Where("status = ?", "pending").Delete(&models.ClientFile{}).Error
+	return DB.Unscoped().Where("status = ?", "pending").Where(notQueued).Delete(&models.ClientFile{}).Error
 }
 
 // ResetPendingClientFilesForClient deletes ClientFile records with status 'pending'
 // for a specific client. This is used when a client disconnects to prevent stuck models.
+// Downloads queued for the client stay pending.
 func ResetPendingClientFilesForClient(clientID string) error {
-	return DB.Unscoped().Where("client_id = ? AND status = ?", clientID, "pending").Delete(&models.ClientFile{}).Error
+	return DB.Unscoped().Where("client_id = ? AND status = ?", clientID, "pending").Where(notQueued).Delete(&models.ClientFile{}).Error
+}
+
+// UpdateClientFileProgress records the download progress a client reported
+// for a pending ClientFile.
+func UpdateClientFileProgress(clientID string, versionID uint, done, total int64) error {
+	return DB.Model(&models.ClientFile{}).
+		Where("client_id = ? AND model_version_id = ? AND status = ?", clientID, versionID, "pending").
+		Updates(map[string]interface{}{"bytes_done": done, "bytes_total": total}).Error
+}
+
+// MarkClientFileFailed sets a ClientFile to 'failed' with the reason the
+// client gave, creating the record if needed.
+func MarkClientFileFailed(clientID string, versionID uint, reason string) error {
+	var cf models.ClientFile
+	if err := DB.Where("client_id = ? AND model_version_id = ?", clientID, versionID).Limit(1).Find(&cf).Error; err != nil {
+		return err
+	}
+	cf.ClientID = clientID
+	cf.ModelVersionID = versionID
+	cf.Status = "failed"
+	cf.Error = reason
+	return DB.Save(&cf).Error
 }
@@ -13,7 +13,7 @@ func setupClientFileTestDB() *gorm.DB {
 	if err != nil {
 		panic("failed to connect database")
 	}
-	db.AutoMigrate(&models.ClientFile{})
+	db.AutoMigrate(&models.ClientFile{}, &models.ClientCommand{})
 	DB = db
 	return db
 }
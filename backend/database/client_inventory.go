@@ -0,0 +1,66 @@
+package database
+
+import (
+	"time"
+
+	"model-manager/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// SaveClientInventory replaces the stored inventory of a client and applies
+// what reconciling it found: the versions in installed are marked installed,
+// the ClientFile records of the versions in removed are deleted, and those of
+// the versions in failed are marked failed with the given reason.
+func SaveClientInventory(clientID string, files []models.ClientInventoryFile, installed, removed []uint, failed map[uint]string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		client, err := ensureClient(tx, clientID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", clientID).Delete(&models.ClientInventoryFile{}).Error; err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			if err := tx.CreateInBatches(files, 200).Error; err != nil {
+				return err
+			}
+		}
+		for _, id := range installed {
+			var cf models.ClientFile
+			if err := tx.Where("client_id = ? AND model_version_id = ?", clientID, id).Limit(1).Find(&cf).Error; err != nil {
+				return err
+			}
+			cf.ClientID = clientID
+			cf.ModelVersionID = id
+			cf.Status = "installed"
+			if err := tx.Save(&cf).Error; err != nil {
+				return err
+			}
+		}
+		if len(removed) > 0 {
+			if err := tx.Where("client_id = ? AND model_version_id IN ?", clientID, removed).Delete(&models.ClientFile{}).Error; err != nil {
+				return err
+			}
+		}
+		for id, reason := range failed {
+			if err := tx.Model(&models.ClientFile{}).Where("client_id = ? AND model_version_id = ?", clientID, id).
+				Updates(map[string]interface{}{"status": "failed", "error": reason}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&client).Update("inventory_at", time.Now()).Error
+	})
+}
+
+// ListClientInventory returns the files a client last reported, by path.
+// A non-empty status limits the list to files with that status.
+func ListClientInventory(clientID, status string) ([]models.ClientInventoryFile, error) {
+	files := []models.ClientInventoryFile{}
+	q := DB.Where("client_id = ?", clientID)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	err := q.Order("path").Find(&files).Error
+	return files, err
+}
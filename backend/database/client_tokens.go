@@ -0,0 +1,206 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"model-manager/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// clientTokenPrefix marks per-client tokens so they are recognisable in a
+// config file.
+const clientTokenPrefix = "mmc_"
+
+var (
+	// ErrClientPaired is returned when pairing is requested for a client
+	// that already has a token.
+	ErrClientPaired = errors.New("client is already paired")
+	// ErrNoPairingRequest is returned when there is no matching pairing
+	// request to approve or claim.
+	ErrNoPairingRequest = errors.New("no pairing request for client")
+	// ErrPairingRejected is returned when claiming a rejected request.
+	ErrPairingRejected = errors.New("pairing request was rejected")
+	// ErrPairingPending is returned when pairing is requested again while
+	// an earlier request is still waiting for approval.
+	ErrPairingPending = errors.New("pairing is already requested")
+
+	// Authentication failures returned by AuthenticateClient.
+	ErrClientRevoked    = errors.New("client access is revoked")
+	ErrClientNotPaired  = errors.New("client is not paired")
+	ErrInvalidClientKey = errors.New("invalid client key")
+)
+
+// HashClientToken returns the stored form of a token or pairing secret.
+func HashClientToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+func tokenMatches(token, hash string) bool {
+	return hash != "" && subtle.ConstantTimeCompare([]byte(HashClientToken(token)), []byte(hash)) == 1
+}
+
+// IssueClientToken gives a client a new token, registering the client if
+// needed, and approves it. Any previous token stops working. The token is
+// returned once and only its hash is kept.
+func IssueClientToken(clientID string) (string, error) {
+	token, err := randomToken(clientTokenPrefix)
+	if err != nil {
+		return "", err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		client, err := ensureClient(tx, clientID)
+		if err != nil {
+			return err
+		}
+		return issueToken(tx, client, token)
+	})
+	return token, err
+}
+
+func issueToken(tx *gorm.DB, client models.Client, token string) error {
+	now := time.Now()
+	return tx.Model(&client).Updates(map[string]interface{}{
+		"access_status":   models.ClientAccessApproved,
+		"token_hash":      HashClientToken(token),
+		"token_issued_at": &now,
+		"pairing_hash":    "",
+	}).Error
+}
+
+// RevokeClientAccess removes a client's token and rejects a pending pairing
+// request. The client can no longer connect, not even with the shared
+// secret, until a new token is issued.
+func RevokeClientAccess(clientID string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		client, err := ensureClient(tx, clientID)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&client).Updates(map[string]interface{}{
+			"access_status": models.ClientAccessRevoked,
+			"token_hash":    "",
+			"pairing_hash":  "",
+		}).Error
+	})
+}
+
+// pairingRequestTTL is how long a pending pairing request is protected from
+// being replaced.
+const pairingRequestTTL = 10 * time.Minute
+
+// RequestClientPairing records a pairing request from a new client and
+// returns the secret the client claims its token with once approved. A new
+// request replaces a pending one only once that is pairingRequestTTL old, so
+// nobody can swap their own secret into a request an admin is about to
+// approve.
+func RequestClientPairing(clientID, name string) (string, error) {
+	secret, err := randomToken("")
+	if err != nil {
+		return "", err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		client, err := ensureClient(tx, clientID)
+		if err != nil {
+			return err
+		}
+		if client.TokenHash != "" || client.AccessStatus == models.ClientAccessApproved {
+			return ErrClientPaired
+		}
+		if client.AccessStatus == models.ClientAccessPending && client.PairingHash != "" &&
+			client.PairingRequestedAt != nil && time.Since(*client.PairingRequestedAt) < pairingRequestTTL {
+			return ErrPairingPending
+		}
+		updates := map[string]interface{}{
+			"access_status":        models.ClientAccessPending,
+			"pairing_hash":         HashClientToken(secret),
+			"pairing_requested_at": time.Now(),
+		}
+		if name != "" {
+			updates["name"] = name
+		}
+		return tx.Model(&client).Updates(updates).Error
+	})
+	return secret, err
+}
+
+// ApproveClientPairing approves a pending pairing request. The client picks
+// up its token with ClaimClientToken.
+func ApproveClientPairing(clientID string) error {
+	res := DB.Model(&models.Client{}).
+		Where("client_id = ? AND access_status = ? AND pairing_hash <> ''", clientID, models.ClientAccessPending).
+		Update("access_status", models.ClientAccessApproved)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoPairingRequest
+	}
+	return nil
+}
+
+// ClaimClientToken exchanges a pairing secret for a token once the request
+// is approved. While it is pending, the returned token is empty.
+func ClaimClientToken(clientID, secret string) (string, error) {
+	var token string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var client models.Client
+		if err := tx.Where("client_id = ?", clientID).Limit(1).Find(&client).Error; err != nil {
+			return err
+		}
+		if client.AccessStatus == models.ClientAccessRevoked && client.PairingHash == "" {
+			return ErrPairingRejected
+		}
+		if !tokenMatches(secret, client.PairingHash) {
+			return ErrNoPairingRequest
+		}
+		if client.AccessStatus != models.ClientAccessApproved {
+			return nil
+		}
+		var err error
+		if token, err = randomToken(clientTokenPrefix); err != nil {
+			return err
+		}
+		return issueToken(tx, client, token)
+	})
+	return token, err
+}
+
+// AuthenticateClient checks the key a client connects with. A client with a
+// token must present it. Clients without one may use sharedSecret, the
+// legacy CLIENT_SECRET, when it is set.
+func AuthenticateClient(clientID, key, sharedSecret string) error {
+	client, err := GetClient(clientID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case client.AccessStatus == models.ClientAccessRevoked:
+		return ErrClientRevoked
+	case client.TokenHash != "":
+		if client.AccessStatus == models.ClientAccessApproved && tokenMatches(key, client.TokenHash) {
+			return nil
+		}
+		return ErrInvalidClientKey
+	case sharedSecret != "" && subtle.ConstantTimeCompare([]byte(key), []byte(sharedSecret)) == 1:
+		return nil
+	case client.AccessStatus == models.ClientAccessPending:
+		return ErrClientNotPaired
+	default:
+		return ErrInvalidClientKey
+	}
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"model-manager/backend/models"
+)
+
+func TestClientTokenLifecycle(t *testing.T) {
+	db := openMigrationTestDB(t)
+	db.AutoMigrate(&models.Client{})
+
+	// Legacy clients without a token use the shared secret, if one is set.
+	if err := AuthenticateClient("desk", "shared", "shared"); err != nil {
+		t.Errorf("shared secret rejected: %v", err)
+	}
+	if err := AuthenticateClient("desk", "", ""); !errors.Is(err, ErrInvalidClientKey) {
+		t.Errorf("empty key with no shared secret = %v", err)
+	}
+
+	token, err := IssueClientToken("desk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored models.Client
+	db.Where("client_id = ?", "desk").First(&stored)
+	if stored.TokenHash == token || stored.TokenHash != HashClientToken(token) {
+		t.Fatal("token must be stored hashed")
+	}
+	if err := AuthenticateClient("desk", token, "shared"); err != nil {
+		t.Errorf("token rejected: %v", err)
+	}
+	if err := AuthenticateClient("desk", "shared", "shared"); !errors.Is(err, ErrInvalidClientKey) {
+		t.Errorf("shared secret accepted for a client with a token: %v", err)
+	}
+	if err := AuthenticateClient("other", token, ""); err == nil {
+		t.Error("token accepted for another client ID")
+	}
+
+	rotated, _ := IssueClientToken("desk")
+	if err := AuthenticateClient("desk", token, ""); err == nil {
+		t.Error("old token still works after rotation")
+	}
+	if err := AuthenticateClient("desk", rotated, ""); err != nil {
+		t.Errorf("rotated token rejected: %v", err)
+	}
+
+	RevokeClientAccess("desk")
+	if err := AuthenticateClient("desk", rotated, "shared"); !errors.Is(err, ErrClientRevoked) {
+		t.Errorf("revoked client = %v", err)
+	}
+	if err := AuthenticateClient("desk", "shared", "shared"); !errors.Is(err, ErrClientRevoked) {
+		t.Errorf("revoked client with shared secret = %v", err)
+	}
+}
+
+func TestClientPairing(t *testing.T) {
+	db := openMigrationTestDB(t)
+	db.AutoMigrate(&models.Client{})
+
+	secret, err := RequestClientPairing("laptop", "Laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ClaimClientToken("laptop", secret); err != nil || token != "" {
+		t.Fatalf("claim while pending = %q, %v", token, err)
+	}
+	if _, err := ClaimClientToken("laptop", "guess"); !errors.Is(err, ErrNoPairingRequest) {
+		t.Errorf("claim with wrong secret = %v", err)
+	}
+	if _, err := RequestClientPairing("laptop", "Imposter"); !errors.Is(err, ErrPairingPending) {
+		t.Errorf("second request while pending = %v", err)
+	}
+	if err := AuthenticateClient("laptop", secret, ""); err == nil {
+		t.Error("pending client authenticated")
+	}
+
+	if err := ApproveClientPairing("laptop"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ClaimClientToken("laptop", secret)
+	if err != nil || token == "" {
+		t.Fatalf("claim after approval = %q, %v", token, err)
+	}
+	if err := AuthenticateClient("laptop", token, ""); err != nil {
+		t.Errorf("paired token rejected: %v", err)
+	}
+	if _, err := ClaimClientToken("laptop", secret); err == nil {
+		t.Error("pairing secret reusable after claim")
+	}
+	if _, err := RequestClientPairing("laptop", ""); !errors.Is(err, ErrClientPaired) {
+		t.Errorf("re-pairing a paired client = %v", err)
+	}
+
+	secret, _ = RequestClientPairing("intruder", "")
+	RevokeClientAccess("intruder")
+	if _, err := ClaimClientToken("intruder", secret); !errors.Is(err, ErrPairingRejected) {
+		t.Errorf("claim after rejection = %v", err)
+	}
+	if err := ApproveClientPairing("intruder"); !errors.Is(err, ErrNoPairingRequest) {
+		t.Errorf("approve after rejection = %v", err)
+	}
+
+	// An abandoned request can be replaced once it has expired.
+	RequestClientPairing("tablet", "")
+	db.Model(&models.Client{}).Where("client_id = ?", "tablet").
+		Update("pairing_requested_at", time.Now().Add(-pairingRequestTTL-time.Minute))
+	if _, err := RequestClientPairing("tablet", ""); err != nil {
+		t.Errorf("request after expiry = %v", err)
+	}
+}
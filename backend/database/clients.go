@@ -0,0 +1,157 @@
+package database
+
+import (
+	"time"
+
+	"model-manager/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// ClientStatus is what a client reports about itself. Empty fields leave the
+// stored values unchanged.
+type ClientStatus struct {
+	Name          string
+	Version       string
+	RootPath      string
+	FreeDiskBytes int64
+}
+
+// GetClient returns the registered client with the given ID. A client only
+// known from its ClientFile records is returned unsaved, with just its ID.
+func GetClient(clientID string) (models.Client, error) {
+	var client models.Client
+	err := DB.Where("client_id = ?", clientID).Limit(1).Find(&client).Error
+	if client.ID == 0 {
+		client.ClientID = clientID
+	}
+	return client, err
+}
+
+// ensureClient returns the client with the given ID, registering it first if
+// needed.
+func ensureClient(tx *gorm.DB, clientID string) (models.Client, error) {
+	var client models.Client
+	err := tx.Where(models.Client{ClientID: clientID}).
+		Attrs(models.Client{Name: clientID}).
+		FirstOrCreate(&client).Error
+	return client, err
+}
+
+// ClientConnected registers a client if needed, marks it connected and opens
+// a presence record for the connection. It returns the presence record's ID.
+func ClientConnected(clientID, remoteAddr string) (uint, error) {
+	var presence models.ClientPresence
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		client, err := ensureClient(tx, clientID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := tx.Model(&client).Updates(map[string]interface{}{"connected": true, "last_seen_at": &now}).Error; err != nil {
+			return err
+		}
+		presence = models.ClientPresence{ClientID: clientID, RemoteAddr: remoteAddr, ConnectedAt: now}
+		return tx.Create(&presence).Error
+	})
+	return presence.ID, err
+}
+
+// ClientDisconnected marks a client offline and closes the presence record
+// with the given ID.
+func ClientDisconnected(clientID string, presenceID uint) error {
+	now := time.Now()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Client{}).Where("client_id = ?", clientID).
+			Updates(map[string]interface{}{"connected": false, "last_seen_at": &now}).Error; err != nil {
+			return err
+		}
+		return closePresence(tx, presenceID, now)
+	})
+}
+
+// CloseClientPresence closes the presence record of a connection that was
+// replaced by a newer one from the same client, which stays connected.
+func CloseClientPresence(presenceID uint) error {
+	return closePresence(DB, presenceID, time.Now())
+}
+
+func closePresence(tx *gorm.DB, presenceID uint, now time.Time) error {
+	return tx.Model(&models.ClientPresence{}).
+		Where("id = ? AND disconnected_at IS NULL", presenceID).
+		Update("disconnected_at", &now).Error
+}
+
+// DisconnectAllClients marks every client offline. This is used at startup,
+// when no connection from a previous run can still be open.
+func DisconnectAllClients() error {
+	now := time.Now()
+	if err := DB.Model(&models.Client{}).Where("connected = ?", true).Update("connected", false).Error; err != nil {
+		return err
+	}
+	return DB.Model(&models.ClientPresence{}).Where("disconnected_at IS NULL").Update("disconnected_at", &now).Error
+}
+
+// TouchClient records that a message arrived from a client.
+func TouchClient(clientID string) error {
+	return DB.Model(&models.Client{}).Where("client_id = ?", clientID).Update("last_seen_at", time.Now()).Error
+}
+
+// UpdateClientStatus stores what a client reported about itself.
+func UpdateClientStatus(clientID string, status ClientStatus) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		client, err := ensureClient(tx, clientID)
+		if err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"last_seen_at": time.Now(), "free_disk_bytes": status.FreeDiskBytes}
+		if status.Name != "" {
+			updates["name"] = status.Name
+		}
+		if status.Version != "" {
+			updates["version"] = status.Version
+		}
+		if status.RootPath != "" {
+			updates["root_path"] = status.RootPath
+		}
+		return tx.Model(&client).Updates(updates).Error
+	})
+}
+
+// UpdateClientProtocol stores the protocol version and capabilities agreed
+// with a client.
+func UpdateClientProtocol(clientID string, protocol int, capabilities []string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		client, err := ensureClient(tx, clientID)
+		if err != nil {
+			return err
+		}
+		client.Protocol = protocol
+		client.Capabilities = capabilities
+		return tx.Model(&client).Select("protocol", "capabilities").Updates(&client).Error
+	})
+}
+
+// UpdateClientConfig sets the name and settings of a client, registering it
+// first if needed.
+func UpdateClientConfig(clientID, name string, settings models.ClientSettings) (models.Client, error) {
+	var client models.Client
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if client, err = ensureClient(tx, clientID); err != nil {
+			return err
+		}
+		client.Name = name
+		client.Settings = settings
+		return tx.Model(&client).Select("name", "settings").Updates(&client).Error
+	})
+	return client, err
+}
+
+// ClientPresenceHistory returns the latest connections of a client, newest
+// first.
+func ClientPresenceHistory(clientID string, limit int) ([]models.ClientPresence, error) {
+	history := []models.ClientPresence{}
+	err := DB.Where("client_id = ?", clientID).Order("connected_at DESC, id DESC").Limit(limit).Find(&history).Error
+	return history, err
+}
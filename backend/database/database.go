@@ -4,15 +4,15 @@ import (
 	"log"
 	"os"
 
-	"model-manager/backend/models"
-
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
-func ConnectDatabase() {
+// OpenDatabase opens the SQLite database at MODELS_DB_PATH (default
+// backend/models.db) and sets DB without migrating it.
+func OpenDatabase() *gorm.DB {
 	path := os.Getenv("MODELS_DB_PATH")
 	if path == "" {
 		path = "backend/models.db"
@@ -22,11 +22,22 @@ func ConnectDatabase() {
 	if err != nil {
 		panic("Failed to connect to database")
 	}
-	database.AutoMigrate(&models.Model{}, &models.Version{}, &models.VersionImage{}, &models.Setting{}, &models.ClientFile{}, &models.Collection{})
 	DB = database
+	return database
+}
 
-	if err := applyMigrations(database); err != nil {
-		log.Printf("failed to run database migrations: %v", err)
+// ConnectDatabase opens the database, migrates it to the current schema and
+// sets up the search index.
+func ConnectDatabase() {
+	database := OpenDatabase()
+
+	// Serving a half-migrated schema corrupts data in ways a restart cannot
+	// fix, so a failed migration stops the server.
+	if _, err := Migrate(database, MigrateOptions{}); err != nil {
+		log.Fatalf("failed to run database migrations: %v", err)
+	}
+	if err := setupSearchIndex(database); err != nil {
+		log.Printf("failed to set up search index: %v", err)
 	}
 
 	// Log configured paths at startup for debugging
@@ -0,0 +1,11 @@
+package database
+
+import "model-manager/backend/models"
+
+// RequeueInterruptedDownloads moves download jobs that were running when the
+// server stopped back into the queued state so they are picked up again.
+func RequeueInterruptedDownloads() error {
+	return DB.Model(&models.DownloadJob{}).
+		Where("status = ?", models.DownloadStatusRunning).
+		Update("status", models.DownloadStatusQueued).Error
+}
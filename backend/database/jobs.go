@@ -0,0 +1,14 @@
+package database
+
+import "model-manager/backend/models"
+
+// FailInterruptedJobs marks background jobs that were running when the server
+// stopped as failed. Unlike downloads they cannot be resumed.
+func FailInterruptedJobs() error {
+	return DB.Model(&models.Job{}).
+		Where("status = ?", models.JobStatusRunning).
+		Updates(map[string]interface{}{
+			"status":  models.JobStatusFailed,
+			"message": "interrupted by server restart",
+		}).Error
+}
@@ -1,33 +1,483 @@
 package database
 
 import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	"model-manager/backend/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-const modelWeightMigrationKey = "migration:model-weight-defaulted"
+// Migration is one numbered step of the schema history. Up and Down run in a
+// transaction; a nil Down marks a step that cannot be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+	// LegacyKey is the settings key that recorded this step before
+	// schema_migrations existed. A database with the key set counts the step
+	// as applied.
+	LegacyKey string
+}
 
-func applyMigrations(db *gorm.DB) error {
-	if err := backfillModelWeights(db); err != nil {
-		return err
+// migrations is the schema history in order. Append new steps with the next
+// version number; never renumber or edit a released step. New tables and
+// columns ship as steps too, so they are recorded and shown by a dry run.
+var migrations = []Migration{
+	{
+		Version:   1,
+		Name:      "default model weights",
+		Up:        backfillModelWeights,
+		LegacyKey: "migration:model-weight-defaulted",
+	},
+	{
+		Version:   2,
+		Name:      "normalize tags",
+		Up:        normalizeTags,
+		Down:      dropTagLinks,
+		LegacyKey: "migration:tags-normalized",
+	},
+	{
+		Version: 3,
+		Name:    "client registry",
+		Up: func(tx *gorm.DB) error {
+			return createMissingTables(tx, &clientV3{}, &clientPresenceV3{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&clientPresenceV3{}, &clientV3{})
+		},
+	},
+	{
+		Version: 4,
+		Name:    "client tokens",
+		Up: func(tx *gorm.DB) error {
+			return addMissingColumns(tx, &clientV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &clientV4{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "client command queue",
+		Up: func(tx *gorm.DB) error {
+			return createMissingTables(tx, &clientCommandV5{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&clientCommandV5{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "client inventory",
+		Up: func(tx *gorm.DB) error {
+			if err := createMissingTables(tx, &clientInventoryFileV6{}); err != nil {
+				return err
+			}
+			return addMissingColumns(tx, &clientV6{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, &clientV6{}); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&clientInventoryFileV6{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "client protocol",
+		Up: func(tx *gorm.DB) error {
+			return addMissingColumns(tx, &clientV7{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &clientV7{})
+		},
+	},
+}
+
+// autoMigrateModels are kept in sync with their structs by AutoMigrate
+// before the numbered migrations run, followed by SetupTagTables for the
+// effective tags view. These tables are not versioned: a dry run reports
+// what would be added, but schema_migrations does not record it and a
+// rollback does not remove it; restore the pre-migration backup for that.
+// Prefer a numbered step for schema changes.
+var autoMigrateModels = []interface{}{
+	&models.Model{}, &models.Version{}, &models.VersionImage{}, &models.Setting{}, &models.ClientFile{},
+	&models.Collection{}, &models.DownloadJob{}, &models.Job{}, &models.AvailableUpdate{}, &models.FileHash{},
+	&models.FileMetadata{}, &models.Tag{}, &models.VersionTag{},
+}
+
+// MigrateOptions controls Migrate.
+type MigrateOptions struct {
+	// DryRun reports the pending migrations without changing the database.
+	DryRun bool
+	// NoBackup skips the copy of the database file taken before migrating.
+	NoBackup bool
+}
+
+// MigrationResult describes what Migrate did or, in a dry run, would do.
+type MigrationResult struct {
+	Pending []Migration
+	// SchemaChanges lists the tables, columns, indexes and views that the
+	// unversioned setup adds.
+	SchemaChanges []string
+	// Backup is the path of the copy taken before migrating, if any.
+	Backup string
+}
+
+// Migrate brings db up to date: it backs up the database file when there are
+// pending migrations or schema changes, syncs the tables with their models,
+// and applies each pending migration in its own transaction.
+func Migrate(db *gorm.DB, opts MigrateOptions) (MigrationResult, error) {
+	return runMigrations(db, migrations, opts)
+}
+
+func runMigrations(db *gorm.DB, steps []Migration, opts MigrateOptions) (MigrationResult, error) {
+	var result MigrationResult
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return result, err
 	}
-	return nil
+	var adopted []Migration
+	for _, m := range steps {
+		switch {
+		case applied[m.Version]:
+		case m.LegacyKey != "" && legacySettingSet(db, m.LegacyKey):
+			adopted = append(adopted, m)
+		default:
+			result.Pending = append(result.Pending, m)
+		}
+	}
+	synced := append(autoMigrateModels, &models.SchemaMigration{})
+	if result.SchemaChanges, err = schemaChanges(db, synced); err != nil {
+		return result, err
+	}
+	if !hasView(db, EffectiveTagsView) {
+		result.SchemaChanges = append(result.SchemaChanges, "create view "+EffectiveTagsView)
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	changed := len(result.Pending) > 0 || len(result.SchemaChanges) > 0
+	if changed && !opts.NoBackup && db.Migrator().HasTable(&models.Model{}) {
+		label := "schema"
+		if len(result.Pending) > 0 {
+			label = strconv.Itoa(result.Pending[0].Version)
+		}
+		if result.Backup, err = backupBeforeMigrate(db, label); err != nil {
+			return result, fmt.Errorf("backup before migrating: %w", err)
+		}
+	}
+
+	if err := db.AutoMigrate(synced...); err != nil {
+		return result, err
+	}
+	if err := SetupTagTables(db); err != nil {
+		return result, err
+	}
+
+	for _, m := range adopted {
+		if err := db.Create(&models.SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error; err != nil {
+			return result, err
+		}
+	}
+	for _, m := range result.Pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&models.SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return result, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %d: %s", m.Version, m.Name)
+	}
+	return result, nil
+}
+
+// Rollback reverts the applied migrations newer than version, newest first.
+// It stops at the first step that has no Down.
+func Rollback(db *gorm.DB, version int) ([]Migration, error) {
+	return rollbackMigrations(db, migrations, version)
+}
+
+func rollbackMigrations(db *gorm.DB, steps []Migration, version int) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(steps) - 1; i >= 0; i-- {
+		m := steps[i]
+		if m.Version <= version || !applied[m.Version] {
+			continue
+		}
+		if m.Down == nil {
+			return reverted, fmt.Errorf("migration %d (%s) cannot be reverted", m.Version, m.Name)
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Where("version = ?", m.Version).Delete(&models.SchemaMigration{}).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		log.Printf("Reverted migration %d: %s", m.Version, m.Name)
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// schemaChanges lists what AutoMigrate would add for models: missing
+// tables, columns and indexes. Changes to existing columns are not detected.
+func schemaChanges(db *gorm.DB, models []interface{}) ([]string, error) {
+	var changes []string
+	migrator := db.Migrator()
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		table := stmt.Schema.Table
+		if !migrator.HasTable(model) {
+			changes = append(changes, "create table "+table)
+			continue
+		}
+		for _, name := range stmt.Schema.DBNames {
+			if !migrator.HasColumn(model, name) {
+				changes = append(changes, fmt.Sprintf("add column %s.%s", table, name))
+			}
+		}
+		for _, idx := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(model, idx.Name) {
+				changes = append(changes, fmt.Sprintf("add index %s on %s", idx.Name, table))
+			}
+		}
+	}
+	return changes, nil
+}
+
+func hasView(db *gorm.DB, name string) bool {
+	var n int64
+	db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'view' AND name = ?", name).Scan(&n)
+	return n > 0
 }
 
-func backfillModelWeights(db *gorm.DB) error {
-	if GetSettingValue(modelWeightMigrationKey) == "1" {
-		return nil
+func appliedMigrations(db *gorm.DB) (map[int]bool, error) {
+	applied := make(map[int]bool)
+	if !db.Migrator().HasTable(&models.SchemaMigration{}) {
+		return applied, nil
 	}
+	var rows []models.SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		applied[r.Version] = true
+	}
+	return applied, nil
+}
 
-	if err := db.Model(&models.Model{}).
+func legacySettingSet(db *gorm.DB, key string) bool {
+	if !db.Migrator().HasTable(&models.Setting{}) {
+		return false
+	}
+	var n int64
+	db.Model(&models.Setting{}).Where(&models.Setting{Key: key, Value: "1"}).Count(&n)
+	return n > 0
+}
+
+// backupBeforeMigrate copies the database file next to itself with VACUUM
+// INTO, which gives a consistent copy of a database in use. In-memory
+// databases are not backed up.
+func backupBeforeMigrate(db *gorm.DB, label string) (string, error) {
+	file, err := databaseFile(db)
+	if err != nil || file == "" {
+		return "", err
+	}
+	path := fmt.Sprintf("%s.pre-migration-%s-%s%s",
+		strings.TrimSuffix(file, filepath.Ext(file)), label, time.Now().Format("20060102-150405"), filepath.Ext(file))
+	if err := db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return "", err
+	}
+	log.Printf("Backed up database to %s before migrating", path)
+	return path, nil
+}
+
+func backfillModelWeights(tx *gorm.DB) error {
+	return tx.Model(&models.Model{}).
 		Where("weight <= 0 OR weight IS NULL").
-		Update("weight", 1).Error; err != nil {
+		Update("weight", 1).Error
+}
+
+// normalizeTags fills the tag tables from the comma separated Version.Tags
+// and Model.Tags columns. Existing tags count as synced from CivitAI, where
+// they came from, so a refresh replaces them as it always has.
+func normalizeTags(tx *gorm.DB) error {
+	var rows []struct {
+		ID        uint
+		Tags      string
+		ModelTags string
+	}
+	if err := tx.Model(&models.Version{}).
+		Select("versions.id, versions.tags, models.tags AS model_tags").
+		Joins("LEFT JOIN models ON models.id = versions.model_id").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, r := range rows {
+		names := append(SplitTags(r.Tags), SplitTags(r.ModelTags)...)
+		for _, name := range uniqueTags(names) {
+			if err := addVersionTag(tx, r.ID, name, models.TagSourceCivitai); err != nil {
+				return err
+			}
+		}
+	}
+	return RefreshVersionTagCache(tx)
+}
+
+// dropTagLinks empties the tag tables. Version.Tags keeps the last cached
+// lists, which is what the versions held before normalizeTags.
+func dropTagLinks(tx *gorm.DB) error {
+	if err := tx.Exec("DELETE FROM version_tags").Error; err != nil {
+		return err
+	}
+	return tx.Exec("DELETE FROM tags").Error
+}
+
+// createMissingTables creates the tables of snapshots that do not exist yet.
+// Databases set up by a build that created them with AutoMigrate keep theirs.
+func createMissingTables(tx *gorm.DB, snapshots ...interface{}) error {
+	for _, snapshot := range snapshots {
+		if tx.Migrator().HasTable(snapshot) {
+			continue
+		}
+		if err := tx.Migrator().CreateTable(snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMissingColumns adds the columns of snapshot its table does not have yet.
+func addMissingColumns(tx *gorm.DB, snapshot interface{}) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(snapshot); err != nil {
 		return err
 	}
+	for _, name := range stmt.Schema.DBNames {
+		if tx.Migrator().HasColumn(snapshot, name) {
+			continue
+		}
+		if err := tx.Migrator().AddColumn(snapshot, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if err := SetSettingValue(modelWeightMigrationKey, "1"); err != nil {
+// dropColumns drops the columns of snapshot. It uses ALTER TABLE DROP
+// COLUMN rather than the migrator, which rebuilds SQLite tables without
+// their indexes.
+func dropColumns(tx *gorm.DB, snapshot interface{}) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(snapshot); err != nil {
 		return err
 	}
+	for _, name := range stmt.Schema.DBNames {
+		if !tx.Migrator().HasColumn(snapshot, name) {
+			continue
+		}
+		err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: stmt.Schema.Table}, clause.Column{Name: name}).Error
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// The structs below freeze the client tables as each step created them, so
+// later changes to the models do not change what an old step does.
+
+type clientV3 struct {
+	gorm.Model
+	ClientID      string `gorm:"uniqueIndex"`
+	Name          string
+	Version       string
+	RootPath      string
+	FreeDiskBytes int64
+	Connected     bool
+	LastSeenAt    *time.Time
+	Settings      string // models.ClientSettings as JSON
+}
+
+func (clientV3) TableName() string { return "clients" }
+
+type clientPresenceV3 struct {
+	ID             uint   `gorm:"primaryKey"`
+	ClientID       string `gorm:"index"`
+	RemoteAddr     string
+	ConnectedAt    time.Time
+	DisconnectedAt *time.Time
+}
+
+func (clientPresenceV3) TableName() string { return "client_presences" }
+
+type clientV4 struct {
+	AccessStatus       string
+	TokenHash          string
+	TokenIssuedAt      *time.Time
+	PairingHash        string
+	PairingRequestedAt *time.Time
+}
+
+func (clientV4) TableName() string { return "clients" }
+
+type clientCommandV5 struct {
+	gorm.Model
+	ClientID       string `gorm:"index"`
+	ModelVersionID uint   `gorm:"index"`
+	Action         string
+	Payload        string
+	Status         string `gorm:"index"`
+	ExpiresAt      *time.Time
+	SentAt         *time.Time
+}
+
+func (clientCommandV5) TableName() string { return "client_commands" }
+
+type clientInventoryFileV6 struct {
+	ID             uint   `gorm:"primaryKey"`
+	ClientID       string `gorm:"index"`
+	Path           string
+	Size           int64
+	SHA256         string
+	Status         string `gorm:"index"`
+	ModelVersionID uint
+}
+
+func (clientInventoryFileV6) TableName() string { return "client_inventory_files" }
+
+type clientV6 struct {
+	InventoryAt *time.Time
+}
+
+func (clientV6) TableName() string { return "clients" }
+
+type clientV7 struct {
+	Protocol     int
+	Capabilities string // []string as JSON
+}
+
+func (clientV7) TableName() string { return "clients" }
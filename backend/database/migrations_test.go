@@ -0,0 +1,276 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"model-manager/backend/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func openMigrationTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	DB = db
+	return db
+}
+
+func appliedVersions(t *testing.T, db *gorm.DB) []int {
+	t.Helper()
+	var versions []int
+	db.Model(&models.SchemaMigration{}).Order("version").Pluck("version", &versions)
+	return versions
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := openMigrationTestDB(t)
+
+	dry, err := Migrate(db, MigrateOptions{DryRun: true})
+	if err != nil || len(dry.Pending) != len(migrations) {
+		t.Fatalf("dry run = %+v, %v", dry, err)
+	}
+	if db.Migrator().HasTable(&models.Model{}) || db.Migrator().HasTable(&models.SchemaMigration{}) {
+		t.Fatal("dry run changed the database")
+	}
+
+	if _, err := Migrate(db, MigrateOptions{}); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if got := appliedVersions(t, db); len(got) != len(migrations) {
+		t.Errorf("applied = %v", got)
+	}
+	again, err := Migrate(db, MigrateOptions{})
+	if err != nil || len(again.Pending) != 0 {
+		t.Errorf("second run = %+v, %v", again, err)
+	}
+}
+
+func TestMigrateAdoptsLegacySettingKeys(t *testing.T) {
+	db := openMigrationTestDB(t)
+	db.AutoMigrate(&models.Setting{}, &models.Model{})
+	db.Create(&models.Setting{Key: "migration:model-weight-defaulted", Value: "1"})
+	m := models.Model{CivitID: 1, Weight: 1}
+	db.Create(&m)
+	db.Model(&m).UpdateColumn("weight", 0)
+
+	result, err := Migrate(db, MigrateOptions{NoBackup: true})
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(result.Pending) != len(migrations)-1 || result.Pending[0].Version != 2 {
+		t.Errorf("pending = %+v", result.Pending)
+	}
+	var got models.Model
+	db.First(&got, m.ID)
+	if got.Weight != 0 {
+		t.Errorf("legacy migration ran again: weight = %v", got.Weight)
+	}
+	if versions := appliedVersions(t, db); len(versions) != len(migrations) || versions[0] != 1 {
+		t.Errorf("applied = %v", versions)
+	}
+}
+
+func TestMigrationStepsAreTransactional(t *testing.T) {
+	db := openMigrationTestDB(t)
+	steps := []Migration{
+		{Version: 1, Name: "ok", Up: func(tx *gorm.DB) error {
+			return tx.Create(&models.Setting{Key: "one", Value: "1"}).Error
+		}},
+		{Version: 2, Name: "fails", Up: func(tx *gorm.DB) error {
+			if err := tx.Create(&models.Setting{Key: "two", Value: "2"}).Error; err != nil {
+				return err
+			}
+			return errors.New("boom")
+		}},
+	}
+	if _, err := runMigrations(db, steps, MigrateOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+	var keys []string
+	db.Model(&models.Setting{}).Pluck("key", &keys)
+	if len(keys) != 1 || keys[0] != "one" {
+		t.Errorf("settings = %v, want only the first step's", keys)
+	}
+	if got := appliedVersions(t, db); len(got) != 1 || got[0] != 1 {
+		t.Errorf("applied = %v", got)
+	}
+
+	// Fixing the step lets the next run pick up where it failed.
+	steps[1].Up = func(tx *gorm.DB) error { return nil }
+	if _, err := runMigrations(db, steps, MigrateOptions{}); err != nil {
+		t.Fatalf("rerun: %v", err)
+	}
+	if got := appliedVersions(t, db); len(got) != 2 {
+		t.Errorf("applied after rerun = %v", got)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	db := openMigrationTestDB(t)
+	if _, err := Migrate(db, MigrateOptions{}); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	v := models.Version{VersionID: 1}
+	db.Create(&v)
+	SetSyncedTags(db, v.ID, []string{"anime"})
+
+	reverted, err := Rollback(db, 1)
+	if err != nil || len(reverted) != len(migrations)-1 || reverted[len(reverted)-1].Version != 2 {
+		t.Fatalf("Rollback(1) = %v, %v", reverted, err)
+	}
+	var links int64
+	db.Model(&models.VersionTag{}).Count(&links)
+	if links != 0 {
+		t.Errorf("tag links left after rollback: %d", links)
+	}
+	if got := appliedVersions(t, db); len(got) != 1 {
+		t.Errorf("applied = %v", got)
+	}
+
+	if _, err := Rollback(db, 0); err == nil {
+		t.Error("reverting an irreversible migration should fail")
+	}
+}
+
+func TestMigrateBacksUpFileDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "models.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	DB = db
+	db.AutoMigrate(&models.Model{})
+	db.Create(&models.Model{CivitID: 1, Name: "kept"})
+
+	result, err := Migrate(db, MigrateOptions{})
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if filepath.Dir(result.Backup) != dir {
+		t.Fatalf("backup = %q", result.Backup)
+	}
+	if _, err := os.Stat(result.Backup); err != nil {
+		t.Fatalf("backup missing: %v", err)
+	}
+	backup, _ := gorm.Open(sqlite.Open(result.Backup), &gorm.Config{})
+	var m models.Model
+	if err := backup.First(&m).Error; err != nil || m.Name != "kept" {
+		t.Errorf("backup contents = %+v, %v", m, err)
+	}
+	if backup.Migrator().HasTable(&models.SchemaMigration{}) {
+		t.Error("backup should be taken before migrating")
+	}
+
+	if again, _ := Migrate(db, MigrateOptions{}); again.Backup != "" {
+		t.Errorf("no backup expected without pending migrations, got %q", again.Backup)
+	}
+}
+
+func TestMigrateBacksUpSchemaChanges(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "models.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	DB = db
+	if _, err := Migrate(db, MigrateOptions{NoBackup: true}); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	// A table added with no numbered step.
+	if err := db.Migrator().DropTable(&models.FileMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dry, err := Migrate(db, MigrateOptions{DryRun: true})
+	if err != nil || len(dry.Pending) != 0 || len(dry.SchemaChanges) != 1 || dry.SchemaChanges[0] != "create table file_metadata" {
+		t.Fatalf("dry run = %+v, %v", dry, err)
+	}
+	result, err := Migrate(db, MigrateOptions{})
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if _, err := os.Stat(result.Backup); err != nil {
+		t.Fatalf("backup missing: %v", err)
+	}
+	backup, _ := gorm.Open(sqlite.Open(result.Backup), &gorm.Config{})
+	if backup.Migrator().HasTable(&models.FileMetadata{}) {
+		t.Error("backup should be taken before the table is created")
+	}
+	if !db.Migrator().HasTable(&models.FileMetadata{}) {
+		t.Error("table not created")
+	}
+
+	// The tag view is set up outside the numbered steps as well.
+	if err := db.Exec("DROP VIEW " + EffectiveTagsView).Error; err != nil {
+		t.Fatal(err)
+	}
+	dry, err = Migrate(db, MigrateOptions{DryRun: true})
+	if err != nil || len(dry.SchemaChanges) != 1 || dry.SchemaChanges[0] != "create view "+EffectiveTagsView {
+		t.Fatalf("dry run = %+v, %v", dry, err)
+	}
+	if _, err := Migrate(db, MigrateOptions{NoBackup: true}); err != nil || !hasView(db, EffectiveTagsView) {
+		t.Errorf("view not recreated: %v", err)
+	}
+}
+
+// stepModels are the models whose tables are created by numbered steps
+// instead of AutoMigrate.
+var stepModels = []interface{}{
+	&models.Client{}, &models.ClientPresence{}, &models.ClientCommand{}, &models.ClientInventoryFile{},
+}
+
+func TestMigrationStepsMatchModels(t *testing.T) {
+	db := openMigrationTestDB(t)
+	if _, err := Migrate(db, MigrateOptions{}); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if changes, err := schemaChanges(db, stepModels); err != nil || len(changes) != 0 {
+		t.Errorf("models differ from the migrated schema: %v, %v", changes, err)
+	}
+
+	// Dropped columns leave the table's indexes alone.
+	if _, err := Rollback(db, 3); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if db.Migrator().HasColumn(&models.Client{}, "token_hash") || !db.Migrator().HasIndex(&models.Client{}, "idx_clients_client_id") {
+		t.Error("clients after reverting client tokens")
+	}
+
+	// Reverted steps are applied again on the next run.
+	if _, err := Rollback(db, 2); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if _, err := Migrate(db, MigrateOptions{}); err != nil {
+		t.Fatalf("Migrate after rollback: %v", err)
+	}
+	if changes, _ := schemaChanges(db, stepModels); len(changes) != 0 {
+		t.Errorf("models differ after rollback and migrate: %v", changes)
+	}
+}
+
+func TestMigrationStepsKeepAutoMigratedTables(t *testing.T) {
+	db := openMigrationTestDB(t)
+	// Builds before the client steps created these tables with AutoMigrate.
+	db.AutoMigrate(stepModels...)
+	db.Create(&models.Client{ClientID: "desk", Protocol: 2, Capabilities: []string{"ack"}})
+
+	if _, err := Migrate(db, MigrateOptions{}); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	var client models.Client
+	if err := db.Where("client_id = ?", "desk").First(&client).Error; err != nil || client.Protocol != 2 {
+		t.Errorf("client after migrating = %+v, %v", client, err)
+	}
+	if got := appliedVersions(t, db); len(got) != len(migrations) {
+		t.Errorf("applied = %v", got)
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// SearchIndexTable is the FTS5 table holding one row per version, keyed by
+// versions.id, with the text the library search matches against.
+const SearchIndexTable = "search_index"
+
+var searchIndexEnabled bool
+
+// SearchIndexEnabled reports whether the full-text index is available. It is
+// false when SQLite was built without FTS5 (go build without -tags
+// sqlite_fts5), in which case search falls back to LIKE matching.
+func SearchIndexEnabled() bool {
+	return searchIndexEnabled
+}
+
+const createSearchIndexSQL = `CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+	model_name, version_name, tags, trained_words, description, prompts,
+	tokenize = 'unicode61 remove_diacritics 2',
+	prefix = '2 3'
+)`
+
+// searchIndexRowsSQL inserts the index rows for the versions matched by the
+// %s condition. Image prompts come from the "prompt" key of the stored
+// generation metadata.
+const searchIndexRowsSQL = `INSERT INTO search_index(rowid, model_name, version_name, tags, trained_words, description, prompts)
+SELECT v.id, COALESCE(m.name, ''), COALESCE(v.name, ''), COALESCE(v.tags, ''), COALESCE(v.trained_words, ''), COALESCE(v.description, ''),
+	COALESCE((SELECT group_concat(json_extract(i.meta, '$.prompt'), ' ') FROM version_images i
+		WHERE i.version_id = v.id AND i.deleted_at IS NULL AND json_valid(i.meta)), '')
+FROM versions v LEFT JOIN models m ON m.id = v.model_id
+WHERE v.deleted_at IS NULL AND %s`
+
+func searchRows(cond string) string {
+	return fmt.Sprintf(searchIndexRowsSQL, cond)
+}
+
+// searchTriggers keep search_index in step with every write to the indexed
+// tables, whichever code path makes it.
+var searchTriggers = []struct{ name, body string }{
+	{"search_index_versions_insert", `AFTER INSERT ON versions BEGIN
+		` + searchRows("v.id = new.id") + `;
+	END`},
+	{"search_index_versions_update", `AFTER UPDATE OF model_id, name, tags, trained_words, description, deleted_at ON versions BEGIN
+		DELETE FROM search_index WHERE rowid = old.id;
+		` + searchRows("v.id = new.id") + `;
+	END`},
+	{"search_index_versions_delete", `AFTER DELETE ON versions BEGIN
+		DELETE FROM search_index WHERE rowid = old.id;
+	END`},
+	{"search_index_models_update", `AFTER UPDATE OF name ON models WHEN old.name IS NOT new.name BEGIN
+		DELETE FROM search_index WHERE rowid IN (SELECT id FROM versions WHERE model_id = new.id);
+		` + searchRows("v.model_id = new.id") + `;
+	END`},
+	{"search_index_images_insert", `AFTER INSERT ON version_images BEGIN
+		DELETE FROM search_index WHERE rowid = new.version_id;
+		` + searchRows("v.id = new.version_id") + `;
+	END`},
+	{"search_index_images_update", `AFTER UPDATE OF version_id, meta, deleted_at ON version_images BEGIN
+		DELETE FROM search_index WHERE rowid IN (old.version_id, new.version_id);
+		` + searchRows("v.id IN (old.version_id, new.version_id)") + `;
+	END`},
+	{"search_index_images_delete", `AFTER DELETE ON version_images BEGIN
+		DELETE FROM search_index WHERE rowid = old.version_id;
+		` + searchRows("v.id = old.version_id") + `;
+	END`},
+}
+
+// setupSearchIndex creates the FTS5 index and its triggers, rebuilding the
+// index when the triggers were not in place before. Without FTS5 the triggers
+// are dropped, since a database opened earlier by an FTS5 build would
+// otherwise fail every write to the indexed tables.
+func setupSearchIndex(db *gorm.DB) error {
+	searchIndexEnabled = false
+	var fts5 int
+	db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+	if fts5 != 1 {
+		for _, t := range searchTriggers {
+			db.Exec("DROP TRIGGER IF EXISTS " + t.name)
+		}
+		log.Printf("SQLite was built without FTS5, library search uses LIKE matching; build with -tags sqlite_fts5 to enable the search index")
+		return nil
+	}
+
+	var existing int64
+	db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?", searchTriggers[0].name).Scan(&existing)
+
+	if err := db.Exec(createSearchIndexSQL).Error; err != nil {
+		return err
+	}
+	for _, t := range searchTriggers {
+		if err := db.Exec("DROP TRIGGER IF EXISTS " + t.name).Error; err != nil {
+			return err
+		}
+		if err := db.Exec("CREATE TRIGGER " + t.name + " " + t.body).Error; err != nil {
+			return fmt.Errorf("create trigger %s: %w", t.name, err)
+		}
+	}
+	if existing == 0 {
+		if err := RebuildSearchIndex(db); err != nil {
+			return err
+		}
+	}
+	searchIndexEnabled = true
+	return nil
+}
+
+// RebuildSearchIndex repopulates the search index from scratch.
+func RebuildSearchIndex(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM search_index").Error; err != nil {
+			return err
+		}
+		return tx.Exec(searchRows("1 = 1")).Error
+	})
+}
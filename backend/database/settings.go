@@ -1,6 +1,9 @@
 package database
 
 import (
+	"strconv"
+	"time"
+
 	"model-manager/backend/models"
 
 	"gorm.io/gorm"
@@ -57,3 +60,36 @@ func GetImagePath() string {
 	}
 	return "./backend/images"
 }
+
+// GetDownloadConcurrency returns how many model downloads may run at once.
+// Defaults to 2 if not set or invalid.
+func GetDownloadConcurrency() int {
+	if val := GetSettingValue("download_concurrency"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 2
+}
+
+// GetUpdateCheckInterval returns how often tracked models are checked for new
+// CivitAI versions. Zero, the default, disables the scheduled check.
+func GetUpdateCheckInterval() time.Duration {
+	if val := GetSettingValue("update_check_interval_hours"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			return time.Duration(n) * time.Hour
+		}
+	}
+	return 0
+}
+
+// GetClientCommandTTL returns how long dispatches queued for a disconnected
+// client are kept. Defaults to 72 hours; 0 keeps them until sent.
+func GetClientCommandTTL() time.Duration {
+	if val := GetSettingValue("client_command_ttl_hours"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+			return time.Duration(n) * time.Hour
+		}
+	}
+	return 72 * time.Hour
+}
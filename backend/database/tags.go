@@ -0,0 +1,284 @@
+package database
+
+import (
+	"strings"
+
+	"model-manager/backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EffectiveTagsView lists the (version_id, tag_id) pairs a version is tagged
+// with: synced and user tags, minus synced tags the user hid. pos keeps the
+// order tags were added in.
+const EffectiveTagsView = "effective_version_tags"
+
+// SetupTagTables migrates the tag tables and creates EffectiveTagsView.
+func SetupTagTables(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.Tag{}, &models.VersionTag{}); err != nil {
+		return err
+	}
+	return db.Exec(`CREATE VIEW IF NOT EXISTS ` + EffectiveTagsView + ` AS
+		SELECT vt.version_id, vt.tag_id, MIN(vt.rowid) AS pos
+		FROM version_tags vt
+		WHERE vt.source <> '` + models.TagSourceHidden + `'
+		AND NOT EXISTS (SELECT 1 FROM version_tags h WHERE h.version_id = vt.version_id AND h.tag_id = vt.tag_id AND h.source = '` + models.TagSourceHidden + `')
+		GROUP BY vt.version_id, vt.tag_id`).Error
+}
+
+// NormalizeTag returns the stored form of a tag name.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// SplitTags splits a comma separated tag list into normalized, unique names.
+func SplitTags(s string) []string {
+	return uniqueTags(strings.Split(s, ","))
+}
+
+func uniqueTags(names []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		n = NormalizeTag(n)
+		if n != "" && !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// resolveTagID returns the ID of the tag called name, following an alias and
+// creating the tag when it does not exist yet.
+func resolveTagID(db *gorm.DB, name string) (uint, error) {
+	var tag models.Tag
+	err := db.Where(models.Tag{Name: name}).FirstOrCreate(&tag).Error
+	if err != nil {
+		return 0, err
+	}
+	if tag.AliasOfID != nil {
+		return *tag.AliasOfID, nil
+	}
+	return tag.ID, nil
+}
+
+// VersionTagNames returns the tags of a version in the order they were added.
+func VersionTagNames(db *gorm.DB, versionID uint) ([]string, error) {
+	var names []string
+	err := db.Table(EffectiveTagsView+" AS evt").
+		Joins("JOIN tags ON tags.id = evt.tag_id").
+		Where("evt.version_id = ?", versionID).
+		Order("evt.pos").
+		Pluck("tags.name", &names).Error
+	return names, err
+}
+
+// SetSyncedTags replaces the CivitAI tags of a version. User tags and tags
+// the user hid are kept.
+func SetSyncedTags(db *gorm.DB, versionID uint, names []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("version_id = ? AND source = ?", versionID, models.TagSourceCivitai).
+			Delete(&models.VersionTag{}).Error; err != nil {
+			return err
+		}
+		for _, name := range uniqueTags(names) {
+			if err := addVersionTag(tx, versionID, name, models.TagSourceCivitai); err != nil {
+				return err
+			}
+		}
+		return RefreshVersionTagCache(tx, versionID)
+	})
+}
+
+// SetUserTags makes names the tags of a version. Tags not already present
+// are added as user tags; removed user tags are deleted and removed synced
+// tags are hidden, so a later refresh does not bring them back.
+func SetUserTags(db *gorm.DB, versionID uint, names []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		current, err := VersionTagNames(tx, versionID)
+		if err != nil {
+			return err
+		}
+		want := make(map[string]bool)
+		for _, name := range uniqueTags(names) {
+			want[name] = true
+		}
+		have := make(map[string]bool)
+		for _, name := range current {
+			have[name] = true
+			if want[name] {
+				continue
+			}
+			id, err := resolveTagID(tx, name)
+			if err != nil {
+				return err
+			}
+			if err := tx.Where("version_id = ? AND tag_id = ? AND source = ?", versionID, id, models.TagSourceUser).
+				Delete(&models.VersionTag{}).Error; err != nil {
+				return err
+			}
+			var synced int64
+			tx.Model(&models.VersionTag{}).Where("version_id = ? AND tag_id = ? AND source = ?", versionID, id, models.TagSourceCivitai).Count(&synced)
+			if synced == 0 {
+				continue
+			}
+			if err := addVersionTag(tx, versionID, name, models.TagSourceHidden); err != nil {
+				return err
+			}
+		}
+		for _, name := range uniqueTags(names) {
+			if have[name] {
+				continue
+			}
+			id, err := resolveTagID(tx, name)
+			if err != nil {
+				return err
+			}
+			if err := tx.Where("version_id = ? AND tag_id = ? AND source = ?", versionID, id, models.TagSourceHidden).
+				Delete(&models.VersionTag{}).Error; err != nil {
+				return err
+			}
+			if err := addVersionTag(tx, versionID, name, models.TagSourceUser); err != nil {
+				return err
+			}
+		}
+		return RefreshVersionTagCache(tx, versionID)
+	})
+}
+
+func addVersionTag(tx *gorm.DB, versionID uint, name, source string) error {
+	id, err := resolveTagID(tx, name)
+	if err != nil {
+		return err
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.VersionTag{VersionID: versionID, TagID: id, Source: source}).Error
+}
+
+// RefreshVersionTagCache rewrites Version.Tags from the tag tables for the
+// given versions, or for every version when none are given.
+func RefreshVersionTagCache(db *gorm.DB, versionIDs ...uint) error {
+	q := db.Model(&models.Version{}).Unscoped()
+	if len(versionIDs) > 0 {
+		q = q.Where("id IN ?", versionIDs)
+	} else {
+		q = q.Where("1 = 1")
+	}
+	return q.UpdateColumn("tags", gorm.Expr(`COALESCE((SELECT GROUP_CONCAT(name, ',') FROM (
+		SELECT tags.name FROM `+EffectiveTagsView+` evt JOIN tags ON tags.id = evt.tag_id
+		WHERE evt.version_id = versions.id ORDER BY evt.pos)), '')`)).Error
+}
+
+// taggedVersionIDs returns the versions that have any row for the given tags.
+func taggedVersionIDs(db *gorm.DB, tagIDs ...uint) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&models.VersionTag{}).Distinct().Where("tag_id IN ?", tagIDs).Pluck("version_id", &ids).Error
+	return ids, err
+}
+
+// RenameTag renames a tag, keeping the old name as an alias. It fails with
+// gorm.ErrDuplicatedKey when another tag already has the new name.
+func RenameTag(db *gorm.DB, id uint, name string) error {
+	name = NormalizeTag(name)
+	return db.Transaction(func(tx *gorm.DB) error {
+		var tag models.Tag
+		if err := tx.Where("alias_of_id IS NULL").First(&tag, id).Error; err != nil {
+			return err
+		}
+		if tag.Name == name {
+			return nil
+		}
+		var existing models.Tag
+		err := tx.Where("name = ?", name).Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing.ID != 0 {
+			if existing.AliasOfID == nil || *existing.AliasOfID != tag.ID {
+				return gorm.ErrDuplicatedKey
+			}
+			// Renaming back to a former name.
+			if err := tx.Unscoped().Delete(&existing).Error; err != nil {
+				return err
+			}
+		}
+		oldName := tag.Name
+		if err := tx.Model(&tag).Update("name", name).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.Tag{Name: oldName, AliasOfID: &tag.ID}).Error; err != nil {
+			return err
+		}
+		ids, err := taggedVersionIDs(tx, tag.ID)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		return RefreshVersionTagCache(tx, ids...)
+	})
+}
+
+// MergeTags moves every use of the source tags to target and turns the
+// sources into aliases of it.
+func MergeTags(db *gorm.DB, target uint, sources []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var into models.Tag
+		if err := tx.Where("alias_of_id IS NULL").First(&into, target).Error; err != nil {
+			return err
+		}
+		var from []models.Tag
+		if err := tx.Where("id IN ? AND id <> ? AND alias_of_id IS NULL", sources, target).Find(&from).Error; err != nil {
+			return err
+		}
+		if len(from) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(from))
+		for i, t := range from {
+			ids[i] = t.ID
+		}
+		versionIDs, err := taggedVersionIDs(tx, ids...)
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE OR IGNORE version_tags SET tag_id = ? WHERE tag_id IN ?", target, ids).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tag_id IN ?", ids).Delete(&models.VersionTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Tag{}).Where("id IN ? OR alias_of_id IN ?", ids, ids).Update("alias_of_id", target).Error; err != nil {
+			return err
+		}
+		if len(versionIDs) == 0 {
+			return nil
+		}
+		return RefreshVersionTagCache(tx, versionIDs...)
+	})
+}
+
+// DeleteTag removes a tag and its aliases from every version. A later
+// CivitAI refresh may add it again.
+func DeleteTag(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var tag models.Tag
+		if err := tx.Where("alias_of_id IS NULL").First(&tag, id).Error; err != nil {
+			return err
+		}
+		versionIDs, err := taggedVersionIDs(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("tag_id = ?", id).Delete(&models.VersionTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("id = ? OR alias_of_id = ?", id, id).Delete(&models.Tag{}).Error; err != nil {
+			return err
+		}
+		if len(versionIDs) == 0 {
+			return nil
+		}
+		return RefreshVersionTagCache(tx, versionIDs...)
+	})
+}
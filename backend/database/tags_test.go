@@ -0,0 +1,147 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"model-manager/backend/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTagTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "tags.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.AutoMigrate(&models.Setting{}, &models.Model{}, &models.Version{})
+	if err := SetupTagTables(db); err != nil {
+		t.Fatalf("SetupTagTables: %v", err)
+	}
+	DB = db
+	return db
+}
+
+func versionTags(t *testing.T, db *gorm.DB, id uint) string {
+	t.Helper()
+	var v models.Version
+	db.First(&v, id)
+	names, _ := VersionTagNames(db, id)
+	if joined := strings.Join(names, ","); joined != v.Tags {
+		t.Errorf("version %d cache %q, tables %q", id, v.Tags, joined)
+	}
+	return v.Tags
+}
+
+func TestUserTagsSurviveSync(t *testing.T) {
+	db := setupTagTestDB(t)
+	v := models.Version{VersionID: 1}
+	db.Create(&v)
+
+	if err := SetSyncedTags(db, v.ID, []string{"Anime", " style ", "anime", ""}); err != nil {
+		t.Fatalf("SetSyncedTags: %v", err)
+	}
+	if got := versionTags(t, db, v.ID); got != "anime,style" {
+		t.Fatalf("synced = %q", got)
+	}
+
+	// Hide a synced tag and add a user tag.
+	if err := SetUserTags(db, v.ID, []string{"anime", "favorite"}); err != nil {
+		t.Fatalf("SetUserTags: %v", err)
+	}
+	if got := versionTags(t, db, v.ID); got != "anime,favorite" {
+		t.Fatalf("after edit = %q", got)
+	}
+
+	if err := SetSyncedTags(db, v.ID, []string{"anime", "style", "character"}); err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	if got := versionTags(t, db, v.ID); got != "favorite,anime,character" {
+		t.Errorf("after resync = %q", got)
+	}
+
+	// Adding a hidden tag back unhides it.
+	if err := SetUserTags(db, v.ID, []string{"anime", "character", "favorite", "style"}); err != nil {
+		t.Fatalf("SetUserTags: %v", err)
+	}
+	if got := versionTags(t, db, v.ID); got != "favorite,anime,style,character" {
+		t.Errorf("after unhide = %q", got)
+	}
+}
+
+func TestRenameMergeDeleteTags(t *testing.T) {
+	db := setupTagTestDB(t)
+	v1 := models.Version{VersionID: 1}
+	v2 := models.Version{VersionID: 2}
+	db.Create(&v1)
+	db.Create(&v2)
+	SetSyncedTags(db, v1.ID, []string{"anime", "girl"})
+	SetSyncedTags(db, v2.ID, []string{"animation", "woman", "girl"})
+	tagID := func(name string) uint {
+		var tag models.Tag
+		db.Where("name = ?", name).First(&tag)
+		return tag.ID
+	}
+
+	if err := RenameTag(db, tagID("girl"), "Woman"); err != gorm.ErrDuplicatedKey {
+		t.Errorf("rename onto existing = %v", err)
+	}
+	if err := RenameTag(db, tagID("anime"), "anime style"); err != nil {
+		t.Fatalf("RenameTag: %v", err)
+	}
+	if err := MergeTags(db, tagID("woman"), []uint{tagID("girl")}); err != nil {
+		t.Fatalf("MergeTags: %v", err)
+	}
+	if got := versionTags(t, db, v1.ID); got != "anime style,woman" {
+		t.Errorf("v1 = %q", got)
+	}
+	if got := versionTags(t, db, v2.ID); got != "animation,woman" {
+		t.Errorf("v2 = %q", got)
+	}
+
+	// A sync using the old names resolves through the aliases.
+	SetSyncedTags(db, v1.ID, []string{"anime", "girl", "outdoors"})
+	if got := versionTags(t, db, v1.ID); got != "anime style,woman,outdoors" {
+		t.Errorf("resynced v1 = %q", got)
+	}
+
+	if err := DeleteTag(db, tagID("woman")); err != nil {
+		t.Fatalf("DeleteTag: %v", err)
+	}
+	if got := versionTags(t, db, v2.ID); got != "animation" {
+		t.Errorf("v2 after delete = %q", got)
+	}
+	var aliases int64
+	db.Model(&models.Tag{}).Where("name = ?", "girl").Count(&aliases)
+	if aliases != 0 {
+		t.Errorf("aliases of a deleted tag should go with it")
+	}
+}
+
+func TestNormalizeTagsMigration(t *testing.T) {
+	db := setupTagTestDB(t)
+	m := models.Model{CivitID: 1, Tags: "Style, anime"}
+	db.Create(&m)
+	v := models.Version{ModelID: m.ID, VersionID: 1, Tags: "anime,  Character Design ,"}
+	db.Create(&v)
+
+	if err := normalizeTags(db); err != nil {
+		t.Fatalf("normalizeTags: %v", err)
+	}
+	if got := versionTags(t, db, v.ID); got != "anime,character design,style" {
+		t.Errorf("migrated = %q", got)
+	}
+	var rows []models.VersionTag
+	db.Find(&rows)
+	sources := map[string]int{}
+	for _, r := range rows {
+		sources[r.Source]++
+	}
+	if !reflect.DeepEqual(sources, map[string]int{models.TagSourceCivitai: 3}) {
+		t.Errorf("sources = %v", sources)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -14,15 +15,47 @@ import (
 )
 
 func main() {
+	migrateOnly := flag.Bool("migrate-only", false, "apply pending database migrations and exit")
+	dryRun := flag.Bool("dry-run", false, "with -migrate-only, list pending migrations without applying them")
+	rollbackTo := flag.Int("rollback-to", -1, "revert database migrations newer than this version and exit")
+	flag.Parse()
+
 	log.Println("=== MODEL MANAGER STARTING (build 2024-12-09-v2) ===")
 	godotenv.Load()
+	if *migrateOnly || *rollbackTo >= 0 {
+		os.Exit(runMigrations(*dryRun, *rollbackTo))
+	}
 	database.ConnectDatabase()
 
+	// Point the CivitAI client at a mirror or local stub when configured
+	if base := os.Getenv("CIVITAI_BASE_URL"); base != "" {
+		api.Civit = api.NewCivitClient(base)
+	}
+
 	// Reset any pending client files from previous runs
 	if err := database.ResetAllPendingClientFiles(); err != nil {
 		log.Printf("Warning: Failed to reset pending client files on startup: %v", err)
 	}
 
+	// No client connection survives a restart
+	if err := database.DisconnectAllClients(); err != nil {
+		log.Printf("Warning: Failed to reset client connection state on startup: %v", err)
+	}
+
+	// Background jobs cannot resume, so mark leftovers from a previous run failed
+	if err := database.FailInterruptedJobs(); err != nil {
+		log.Printf("Warning: Failed to update interrupted jobs on startup: %v", err)
+	}
+
+	// Resume model downloads left in the queue by a previous run
+	api.StartDownloadQueue()
+
+	// Check tracked models for new CivitAI versions on the configured schedule
+	api.StartUpdateChecker()
+
+	// Back up the database on the configured schedule
+	api.StartBackupScheduler()
+
 	r := gin.Default()
 	r.SetTrustedProxies(nil) // safe for local dev
 
@@ -64,8 +97,17 @@ func main() {
 		apiGroup.POST("/sync", api.SyncCivitModels)
 		apiGroup.POST("/sync/:id", api.SyncCivitModelByID)
 		apiGroup.POST("/sync/version/:versionId", api.SyncVersionByID)
+		apiGroup.GET("/events", api.StreamEvents)
 		apiGroup.GET("/download/progress", api.GetDownloadProgress)
 		apiGroup.POST("/download/cancel", api.CancelDownload)
+		apiGroup.GET("/downloads", api.ListDownloads)
+		apiGroup.POST("/downloads/clear", api.ClearDownloads)
+		apiGroup.GET("/downloads/:id", api.GetDownload)
+		apiGroup.POST("/downloads/:id/cancel", api.CancelDownloadJob)
+		apiGroup.POST("/downloads/:id/pause", api.PauseDownloadJob)
+		apiGroup.POST("/downloads/:id/resume", api.ResumeDownloadJob)
+		apiGroup.POST("/downloads/:id/retry", api.RetryDownloadJob)
+		apiGroup.POST("/downloads/:id/move", api.MoveDownloadJob)
 		apiGroup.GET("/model/:id/versions", api.GetModelVersions)
 		apiGroup.GET("/versions/:id", api.GetVersion)
 		apiGroup.PUT("/versions/:id", api.UpdateVersion)
@@ -74,12 +116,19 @@ func main() {
 		apiGroup.POST("/versions/:id/images", api.UploadVersionImage)
 		apiGroup.DELETE("/versions/:id/images/:imgId", api.DeleteVersionImage)
 		apiGroup.POST("/versions/:id/upload", api.UploadVersionFile)
+		apiGroup.GET("/versions/:id/file-metadata", api.GetVersionFileMetadata)
+		apiGroup.POST("/versions/:id/sidecars", api.WriteVersionSidecars)
+		apiGroup.GET("/versions/:id/sidecar/info", api.GetSidecarInfo)
+		apiGroup.GET("/versions/:id/sidecar/preview", api.GetSidecarPreview)
 		apiGroup.DELETE("/versions/:id", api.DeleteVersion)
 		apiGroup.POST("/import", api.ImportModels)
 		apiGroup.POST("/import-db", api.ImportDatabase)
 		apiGroup.GET("/export", api.ExportModels)
+		apiGroup.GET("/bundle/export", api.ExportBundle)
+		apiGroup.POST("/bundle/import", api.ImportBundle)
 		apiGroup.GET("/stats", api.GetStats)
 		apiGroup.GET("/orphaned-files", api.GetOrphanedFiles)
+		apiGroup.POST("/orphaned-files/identify", api.IdentifyOrphanedFiles)
 		apiGroup.GET("/duplicate-file-paths", api.GetDuplicateFilePaths)
 		apiGroup.GET("/settings", api.GetSettings)
 		apiGroup.POST("/settings", api.UpdateSetting)
@@ -88,9 +137,35 @@ func main() {
 		apiGroup.POST("/tools/archive-images", api.ArchiveImages)
 		apiGroup.POST("/tools/reset-pending", api.ResetPendingStatus)
 		apiGroup.POST("/tools/generate-thumbnails", api.GenerateMissingThumbnails)
+		apiGroup.POST("/tools/verify-hashes", api.VerifyHashes)
+		apiGroup.GET("/tools/verify-hashes", api.GetHashReport)
+		apiGroup.POST("/tools/write-sidecars", api.WriteLibrarySidecars)
+		apiGroup.POST("/tools/import-sidecars", api.ImportSidecars)
+
+		// Model updates
+		apiGroup.GET("/updates", api.GetUpdates)
+		apiGroup.POST("/updates/check", api.CheckForUpdates)
+		apiGroup.POST("/updates/:versionId/download", api.DownloadUpdate)
+
+		// Background jobs
+		apiGroup.GET("/jobs", api.ListJobs)
+		apiGroup.GET("/jobs/:id", api.GetJob)
+		apiGroup.POST("/jobs/:id/cancel", api.CancelJob)
 
 		// Remote Management
 		apiGroup.POST("/remote/dispatch", api.DispatchRemote)
+		apiGroup.GET("/clients", api.ListClients)
+		apiGroup.GET("/clients/:id", api.GetClient)
+		apiGroup.PUT("/clients/:id", api.RequireAdmin, api.UpdateClient)
+		apiGroup.POST("/clients/pair", api.RequestClientPairing)
+		apiGroup.POST("/clients/pair/claim", api.ClaimClientPairing)
+		apiGroup.POST("/clients/:id/approve", api.RequireAdmin, api.ApproveClient)
+		apiGroup.POST("/clients/:id/revoke", api.RequireAdmin, api.RevokeClient)
+		apiGroup.POST("/clients/:id/token", api.RequireAdmin, api.RotateClientToken)
+		apiGroup.GET("/clients/:id/commands", api.ListClientCommands)
+		apiGroup.DELETE("/clients/:id/commands/:commandId", api.CancelClientCommand)
+		apiGroup.GET("/clients/:id/inventory", api.GetClientInventory)
+		apiGroup.POST("/clients/:id/inventory", api.RequestClientInventory)
 
 		// Collections
 		apiGroup.GET("/collections", api.GetCollections)
@@ -103,6 +178,20 @@ func main() {
 		apiGroup.DELETE("/collections/:id/versions/:versionId", api.RemoveVersionFromCollection)
 		apiGroup.GET("/versions/:id/collections", api.GetVersionCollections)
 		apiGroup.POST("/collections/:id/bulk-add", api.BulkAddVersions)
+		apiGroup.POST("/collections/:id/convert", api.ConvertSmartCollection)
+
+		// Tags
+		apiGroup.GET("/tags", api.GetTags)
+		apiGroup.GET("/tags/autocomplete", api.AutocompleteTags)
+		apiGroup.PUT("/tags/:id", api.RenameTag)
+		apiGroup.POST("/tags/:id/merge", api.MergeTags)
+		apiGroup.DELETE("/tags/:id", api.DeleteTag)
+
+		// Database backups
+		apiGroup.GET("/backups", api.ListBackups)
+		apiGroup.POST("/backups", api.CreateBackup)
+		apiGroup.GET("/backups/:id/download", api.DownloadBackup)
+		apiGroup.POST("/backups/:id/restore", api.RestoreBackup)
 	}
 
 	// WebSocket
@@ -124,3 +213,37 @@ func main() {
 	log.Printf("Server started on port %s", port)
 	r.Run(":" + port)
 }
+
+// runMigrations handles the -migrate-only and -rollback-to flags and returns
+// the process exit code.
+func runMigrations(dryRun bool, rollbackTo int) int {
+	db := database.OpenDatabase()
+	if rollbackTo >= 0 {
+		reverted, err := database.Rollback(db, rollbackTo)
+		for _, m := range reverted {
+			log.Printf("reverted %d: %s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Printf("rollback failed: %v", err)
+			return 1
+		}
+		return 0
+	}
+
+	result, err := database.Migrate(db, database.MigrateOptions{DryRun: dryRun})
+	if err != nil {
+		log.Printf("migration failed: %v", err)
+		return 1
+	}
+	if len(result.Pending) == 0 && len(result.SchemaChanges) == 0 {
+		log.Println("database is up to date")
+	} else if dryRun {
+		for _, change := range result.SchemaChanges {
+			log.Printf("pending schema change: %s", change)
+		}
+		for _, m := range result.Pending {
+			log.Printf("pending %d: %s", m.Version, m.Name)
+		}
+	}
+	return 0
+}
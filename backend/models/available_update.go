@@ -0,0 +1,16 @@
+package models
+
+import "gorm.io/gorm"
+
+// AvailableUpdate is a CivitAI version of a tracked model that is not in the
+// local library yet. Rows are refreshed by the update checker.
+type AvailableUpdate struct {
+	gorm.Model
+	ModelID        uint   `gorm:"index" json:"modelId"`
+	CivitModelID   int    `json:"civitModelId"`
+	ModelName      string `json:"modelName"`
+	VersionID      int    `gorm:"uniqueIndex" json:"versionId"`
+	Name           string `json:"name"`
+	BaseModel      string `json:"baseModel"`
+	CivitCreatedAt string `json:"createdAt"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Folder layouts a client can use below its root path.
+const (
+	FolderLayoutType      = "type"       // <type>/<file>, the default
+	FolderLayoutBaseModel = "base-model" // <type>/<base model>/<file>
+	FolderLayoutFlat      = "flat"       // <file>
+)
+
+// Access states of a client. A client without one predates per-client
+// tokens and may still use the shared CLIENT_SECRET.
+const (
+	ClientAccessPending  = "pending"  // pairing requested, waiting for approval
+	ClientAccessApproved = "approved" // may connect with its token
+	ClientAccessRevoked  = "revoked"  // token revoked or pairing rejected
+)
+
+// Client is a remote desktop client. Clients are registered on their first
+// connection; what they report about themselves is updated as they send it.
+type Client struct {
+	gorm.Model
+	ClientID string `gorm:"uniqueIndex" json:"clientId"`
+	Name     string `json:"name"`
+	// Reported by the client.
+	Version       string `json:"version"`
+	RootPath      string `json:"rootPath"`
+	FreeDiskBytes int64  `json:"freeDiskBytes"`
+	// Protocol is the WebSocket protocol version agreed in the hello
+	// handshake, 1 for clients that never sent one. Capabilities are the
+	// features both sides support.
+	Protocol     int      `json:"protocol"`
+	Capabilities []string `json:"capabilities" gorm:"serializer:json"`
+
+	Connected  bool       `json:"connected"`
+	LastSeenAt *time.Time `json:"lastSeenAt"`
+	// InventoryAt is when the client last reported its files.
+	InventoryAt *time.Time `json:"inventoryAt"`
+
+	Settings ClientSettings `json:"settings" gorm:"serializer:json"`
+
+	// AccessStatus is one of the ClientAccess constants. Tokens and pairing
+	// secrets are only stored as SHA-256 hashes.
+	AccessStatus       string     `json:"accessStatus"`
+	TokenHash          string     `json:"-"`
+	TokenIssuedAt      *time.Time `json:"tokenIssuedAt"`
+	PairingHash        string     `json:"-"`
+	PairingRequestedAt *time.Time `json:"pairingRequestedAt"`
+}
+
+// ClientSettings configures what the server sends to a client.
+type ClientSettings struct {
+	// FolderLayout is one of the FolderLayout constants; empty means "type".
+	FolderLayout string `json:"folderLayout,omitempty"`
+	// AllowedTypes limits dispatches to these model types. Empty allows all.
+	AllowedTypes []string `json:"allowedTypes,omitempty"`
+}
+
+// ClientPresence is one connection of a client. DisconnectedAt is nil while
+// the connection is open.
+type ClientPresence struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ClientID       string     `gorm:"index" json:"clientId"`
+	RemoteAddr     string     `json:"remoteAddr"`
+	ConnectedAt    time.Time  `json:"connectedAt"`
+	DisconnectedAt *time.Time `json:"disconnectedAt"`
+}
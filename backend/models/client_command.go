@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ClientCommand states.
+const (
+	ClientCommandQueued    = "queued"
+	ClientCommandSent      = "sent"
+	ClientCommandCancelled = "cancelled"
+	ClientCommandExpired   = "expired"
+)
+
+// ClientCommand is a dispatch for a client that was not connected. Queued
+// commands are sent in order when the client reconnects.
+type ClientCommand struct {
+	gorm.Model
+	ClientID       string `gorm:"index" json:"clientId"`
+	ModelVersionID uint   `gorm:"index" json:"modelVersionId"`
+	Action         string `json:"action"`
+	// Payload is the message sent to the client, encoded as JSON.
+	Payload string `json:"payload"`
+	Status  string `gorm:"index" json:"status"`
+	// ExpiresAt is nil for commands that never expire.
+	ExpiresAt *time.Time `json:"expiresAt"`
+	SentAt    *time.Time `json:"sentAt"`
+}
@@ -6,5 +6,10 @@ type ClientFile struct {
 	gorm.Model
 	ClientID       string `gorm:"index" json:"clientId"`
 	ModelVersionID uint   `gorm:"index" json:"modelVersionId"`
-	Status         string `json:"status"` // "pending", "installed"
+	Status         string `json:"status"` // "pending", "installed", "failed"
+	// Download progress reported by the client while pending.
+	BytesDone  int64 `json:"bytesDone"`
+	BytesTotal int64 `json:"bytesTotal"`
+	// Error is the reason the client gave for a failed download.
+	Error string `json:"error,omitempty"`
 }
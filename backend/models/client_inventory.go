@@ -0,0 +1,24 @@
+package models
+
+// Outcomes of matching a reported client file against the library.
+const (
+	InventoryMatched    = "matched"    // a library version at its expected path
+	InventoryIncomplete = "incomplete" // at a version's path, but the size or hash differs
+	InventoryMisplaced  = "misplaced"  // a library version by hash, at another path
+	InventoryUnknown    = "unknown"    // not in the library
+)
+
+// ClientInventoryFile is one model file a client reported in its last
+// inventory. The rows of a client are replaced on every report.
+type ClientInventoryFile struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	ClientID string `gorm:"index" json:"clientId"`
+	// Path is relative to the client's root, with forward slashes.
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Status is one of the Inventory constants. ModelVersionID is set for
+	// every status but unknown.
+	Status         string `gorm:"index" json:"status"`
+	ModelVersionID uint   `json:"modelVersionId,omitempty"`
+}
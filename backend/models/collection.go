@@ -7,4 +7,28 @@ type Collection struct {
 	Name        string    `json:"name" gorm:"index"`
 	Description string    `json:"description"`
 	Versions    []Version `json:"versions" gorm:"many2many:collection_versions;"`
+	// Filter makes this a smart collection whose members are the versions
+	// currently matching it. Versions of a smart collection are not stored
+	// in collection_versions. Nil for a manually curated collection.
+	Filter *CollectionFilter `json:"filter,omitempty" gorm:"serializer:json"`
+	// VersionCount is the number of member versions. The collection list
+	// reports it without loading the members of smart collections.
+	VersionCount int64 `json:"versionCount" gorm:"-"`
+}
+
+// CollectionFilter is the stored definition of a smart collection. Empty
+// fields match every version; set fields must all match.
+type CollectionFilter struct {
+	BaseModel string   `json:"baseModel,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	// Text uses the search syntax of the model list.
+	Text string `json:"text,omitempty"`
+	Nsfw *bool  `json:"nsfw,omitempty"`
+	// Client matches versions installed on the named client, Installed those
+	// installed (or not) on any client.
+	Client    string `json:"client,omitempty"`
+	Installed *bool  `json:"installed,omitempty"`
+	// Query is a library query for anything the other fields cannot express.
+	Query string `json:"query,omitempty"`
 }
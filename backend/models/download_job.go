@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Download job states persisted in DownloadJob.Status.
+const (
+	DownloadStatusQueued    = "queued"
+	DownloadStatusRunning   = "running"
+	DownloadStatusPaused    = "paused"
+	DownloadStatusCompleted = "completed"
+	DownloadStatusFailed    = "failed"
+	DownloadStatusCancelled = "cancelled"
+)
+
+// DownloadJob is a single model file download tracked by the download queue.
+// Rows are kept after completion so the queue history survives restarts.
+type DownloadJob struct {
+	gorm.Model
+	URL        string `json:"url"`
+	DestDir    string `json:"destDir"`
+	Filename   string `json:"filename"`
+	FilePath   string `json:"filePath"`
+	Name       string `json:"name"`
+	VersionID  int    `gorm:"index" json:"versionId"`
+	Status     string `gorm:"index" json:"status"`
+	Position   int    `json:"position"`
+	Progress   int64  `json:"progress"`
+	Downloaded int64  `json:"downloaded"`
+	Total      int64  `json:"total"`
+	Attempts   int    `json:"attempts"`
+	// ExpectedSHA256 is the digest reported by CivitAI; SHA256 is what was
+	// actually written. A mismatch fails the job and trashes the file.
+	ExpectedSHA256 string     `json:"expectedSha256"`
+	SHA256         string     `json:"sha256"`
+	Error          string     `json:"error"`
+	StartedAt      *time.Time `json:"startedAt"`
+	FinishedAt     *time.Time `json:"finishedAt"`
+}
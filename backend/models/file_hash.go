@@ -0,0 +1,13 @@
+package models
+
+import "gorm.io/gorm"
+
+// FileHash caches the SHA256 of a file on disk. An entry is only reused while
+// the file's size and modification time still match.
+type FileHash struct {
+	gorm.Model
+	Path    string `gorm:"uniqueIndex" json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	SHA256  string `gorm:"index" json:"sha256"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "gorm.io/gorm"
+
+// FileMetadata holds the metadata embedded in a version's model file, such as
+// the __metadata__ block of a safetensors header. It lives in its own table
+// because training metadata (tag frequencies in particular) can be large.
+type FileMetadata struct {
+	gorm.Model
+	VersionID uint `gorm:"uniqueIndex" json:"versionId"`
+	// Format is the file format the metadata was read from, e.g. "safetensors".
+	Format      string `json:"format"`
+	TensorCount int    `json:"tensorCount"`
+	// Metadata is the embedded key/value metadata encoded as a JSON object.
+	Metadata string `json:"metadata"`
+}
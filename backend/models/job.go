@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Background job states persisted in Job.Status.
+const (
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// Job is a long-running maintenance task such as a library sync or thumbnail
+// generation. Counters are updated while the job runs so its progress can be
+// inspected after the fact.
+type Job struct {
+	gorm.Model
+	Type       string     `gorm:"index" json:"type"`
+	Status     string     `gorm:"index" json:"status"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Updated    int        `json:"updated"`
+	Errors     int        `json:"errors"`
+	Message    string     `json:"message"`
+	ErrorLog   string     `json:"errorLog"`
+	Result     string     `json:"result"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// SchemaMigration records a numbered database migration that has been
+// applied.
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
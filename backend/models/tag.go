@@ -0,0 +1,29 @@
+package models
+
+import "gorm.io/gorm"
+
+// VersionTag sources. Synced tags are replaced on every CivitAI refresh,
+// while user tags and hidden markers survive it.
+const (
+	TagSourceCivitai = "civitai"
+	TagSourceUser    = "user"
+	// TagSourceHidden marks a synced tag the user removed from a version.
+	TagSourceHidden = "hidden"
+)
+
+// Tag is a normalized (trimmed, lower case) tag name. A tag renamed or merged
+// into another keeps its old name as an alias, so tags synced under the old
+// name later resolve to AliasOfID instead of reappearing.
+type Tag struct {
+	gorm.Model
+	Name      string `gorm:"uniqueIndex" json:"name"`
+	AliasOfID *uint  `gorm:"index" json:"aliasOfId,omitempty"`
+}
+
+// VersionTag links a version to a tag. Version.Tags caches the resulting
+// comma separated list for display and the search index.
+type VersionTag struct {
+	VersionID uint   `gorm:"primaryKey;autoIncrement:false" json:"versionId"`
+	TagID     uint   `gorm:"primaryKey;autoIncrement:false;index" json:"tagId"`
+	Source    string `gorm:"primaryKey" json:"source"`
+}
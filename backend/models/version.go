@@ -1,6 +1,17 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Version file verification states stored in Version.FileStatus.
+const (
+	FileStatusVerified     = "verified"
+	FileStatusHashMismatch = "hash_mismatch"
+	FileStatusMissing      = "missing"
+)
 
 type Version struct {
 	gorm.Model
@@ -21,9 +32,13 @@ type Version struct {
 	CivitCreatedAt       string  `json:"createdAt"`
 	CivitUpdatedAt       string  `json:"updatedAt"`
 	SHA256               string  `json:"sha256"`
-	DownloadURL          string  `json:"downloadUrl"`
-	ImagePath            string  `json:"imagePath"`
-	FilePath             string  `json:"filePath"`
+	// FileStatus records the outcome of the last SHA256 check of FilePath:
+	// "verified", "hash_mismatch", "missing", or empty if never checked.
+	FileStatus    string     `json:"fileStatus"`
+	FileCheckedAt *time.Time `json:"fileCheckedAt"`
+	DownloadURL   string     `json:"downloadUrl"`
+	ImagePath     string     `json:"imagePath"`
+	FilePath      string     `json:"filePath"`
 
 	Images []VersionImage `json:"images"`
 
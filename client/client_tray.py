@@ -3,6 +3,10 @@ import json
 import os
 import threading
 import time
+import shutil
+import socket
+import hashlib
+import uuid
 import requests
 import websocket
 from PIL import Image, ImageDraw
@@ -33,11 +37,54 @@ SERVER_URL = CONFIG.get('server_url')
 API_KEY = CONFIG.get('api_key')
 ROOT_PATH = CONFIG.get('root_path')
 CLIENT_ID = CONFIG.get('client_id')
+CLIENT_NAME = CONFIG.get('name') or socket.gethostname()
+CLIENT_VERSION = "1.3.0"
+PROTOCOL_VERSION = 2
+CAPABILITIES = ["ack", "progress", "sidecar", "inventory"]
+HEARTBEAT_SECONDS = 30
+INVENTORY_HASHES = bool(CONFIG.get('inventory_hashes'))
+MODEL_EXTENSIONS = ('.safetensors', '.ckpt', '.pt', '.pth', '.bin', '.gguf')
 
-if not all([SERVER_URL, API_KEY, ROOT_PATH, CLIENT_ID]):
+if not all([SERVER_URL, ROOT_PATH, CLIENT_ID]):
     print("Missing configuration values in config.json")
     sys.exit(1)
 
+def http_base_url():
+    u = urlparse(SERVER_URL)
+    scheme = 'https' if u.scheme == 'wss' else 'http'
+    return f"{scheme}://{u.netloc}"
+
+def pair_client():
+    # Ask the server for access and wait until an admin approves it
+    resp = requests.post(http_base_url() + "/api/clients/pair",
+                         json={"client_id": CLIENT_ID, "name": CONFIG.get('name', '')})
+    if resp.status_code in (409, 429):
+        print(f"Pairing refused: {resp.json().get('error')}. Try again in a few minutes.")
+        sys.exit(1)
+    resp.raise_for_status()
+    secret = resp.json()['pairing_secret']
+    print(f"Pairing requested for {CLIENT_ID}. Approve it on the Clients page of the web UI...")
+
+    while True:
+        resp = requests.post(http_base_url() + "/api/clients/pair/claim",
+                             json={"client_id": CLIENT_ID, "pairing_secret": secret})
+        if resp.status_code == 200:
+            break
+        if resp.status_code != 202:
+            print(f"Pairing failed: {resp.text}")
+            sys.exit(1)
+        time.sleep(5)
+
+    # Keep the token for later runs
+    CONFIG['api_key'] = resp.json()['token']
+    with open(CONFIG_FILE, 'w') as f:
+        json.dump(CONFIG, f, indent=4)
+    print("Pairing approved.")
+    return CONFIG['api_key']
+
+if not API_KEY:
+    API_KEY = pair_client()
+
 # Ensure root path exists
 if not os.path.exists(ROOT_PATH):
     os.makedirs(ROOT_PATH)
@@ -52,19 +99,51 @@ def create_icon():
     dc.rectangle((16, 16, 48, 48), fill=(255, 255, 255))
     return image
 
+def send_message(ws_app, msg_type, payload=None, reply_to=None):
+    # Every message is wrapped in a protocol version 2 envelope
+    envelope = {"v": PROTOCOL_VERSION, "type": msg_type, "id": uuid.uuid4().hex}
+    if reply_to:
+        envelope["reply_to"] = reply_to
+    if payload is not None:
+        envelope["payload"] = payload
+    ws_app.send(json.dumps(envelope))
+
 def on_message(ws_app, message):
     print(f"Received: {message}")
     try:
         data = json.loads(message)
-        action = data.get('action')
-        
-        if action == 'download':
-            handle_download(ws_app, data)
-        elif action == 'delete':
-            handle_delete(ws_app, data)
+        if data.get('v', 0) >= 2:
+            action = data.get('type')
+            payload = data.get('payload') or {}
+            msg_id = data.get('id')
+            if action == 'welcome':
+                print(f"Server agreed on protocol {payload.get('protocol')} with {payload.get('capabilities')}")
+                return
+            if action in COMMAND_HANDLERS:
+                send_message(ws_app, 'ack', reply_to=msg_id)
+        else:
+            # Servers before protocol version 2 send bare commands
+            action = data.get('action')
+            payload = data
+            msg_id = None
+
+        handler = COMMAND_HANDLERS.get(action)
+        if handler:
+            # Run commands off the socket thread so heartbeats keep flowing
+            threading.Thread(target=handler, args=(ws_app, payload, msg_id), daemon=True).start()
     except Exception as e:
         print(f"Error processing message: {e}")
 
+def send_error(ws_app, action, model_version_id, reason, reply_to):
+    try:
+        send_message(ws_app, 'error', {
+            "action": action,
+            "model_version_id": model_version_id,
+            "reason": reason
+        }, reply_to)
+    except Exception as e:
+        print(f"Error report failed: {e}")
+
 def sanitize_path(subdirectory, filename):
     # Security check: Ensure path is within ROOT_PATH
     # Strip dangerous characters
@@ -79,7 +158,7 @@ def sanitize_path(subdirectory, filename):
         
     return full_path
 
-def handle_download(ws_app, data):
+def handle_download(ws_app, data, reply_to=None):
     url = data.get('url')
     model_version_id = data.get('model_version_id')
 
@@ -91,13 +170,11 @@ def handle_download(ws_app, data):
         url = base_url + url
 
     try:
-        # Determine target path from URL (remove /downloads/ prefix)
-        rel_path = data.get('url', '').replace('/downloads/', '', 1)
-        rel_path = rel_path.lstrip('/').lstrip('\\')
-        
-        from urllib.parse import unquote
-        rel_path = unquote(rel_path)
-        
+        # Target path below the root; the URL names the file on the server
+        rel_path = (data.get('filename') or '').lstrip('/').lstrip('\\')
+        if not rel_path:
+            raise ValueError("No target filename")
+
         # Security check - basic
         if '..' in rel_path:
              raise ValueError("Invalid path components")
@@ -111,14 +188,23 @@ def handle_download(ws_app, data):
         os.makedirs(os.path.dirname(target_path), exist_ok=True)
         print(f"Downloading {url} to {target_path}...")
         
-        # Download
+        # Download, reporting progress about once a second
         with requests.get(url, stream=True) as r:
             r.raise_for_status()
+            total = int(r.headers.get('content-length') or 0)
+            done = 0
+            last_report = 0
             with open(target_path, 'wb') as f:
                 for chunk in r.iter_content(chunk_size=8192): 
                     f.write(chunk)
-                    
-                    f.write(chunk)
+                    done += len(chunk)
+                    if time.time() - last_report >= 1:
+                        last_report = time.time()
+                        send_message(ws_app, 'progress', {
+                            "model_version_id": model_version_id,
+                            "bytes_done": done,
+                            "bytes_total": total
+                        }, reply_to)
                     
         print("Download complete.")
 
@@ -155,16 +241,14 @@ def handle_download(ws_app, data):
         
         
         # Send confirmation
-        response = {
-            "type": "complete",
-            "model_version_id": model_version_id
-        }
-        ws_app.send(json.dumps(response))
+        send_message(ws_app, 'complete', {"model_version_id": model_version_id}, reply_to)
+        send_status(ws_app)
         
     except Exception as e:
         print(f"Download invalid: {e}")
+        send_error(ws_app, 'download', model_version_id, str(e), reply_to)
 
-def handle_delete(ws_app, data):
+def handle_delete(ws_app, data, reply_to=None):
     filename = data.get('filename') # Now contains relative path
     model_version_id = data.get('model_version_id')
     
@@ -180,7 +264,6 @@ def handle_delete(ws_app, data):
             raise ValueError("Path traversal attempt detected")
 
         if os.path.exists(target_path):
-            os.remove(target_path)
             os.remove(target_path)
             print(f"Deleted {target_path}")
 
@@ -196,26 +279,114 @@ def handle_delete(ws_app, data):
                         print(f"Failed to delete thumbnail: {te}")
             
             
-            response = {
-                "type": "deleted",
-                "model_version_id": model_version_id
-            }
-            ws_app.send(json.dumps(response))
+            send_message(ws_app, 'deleted', {"model_version_id": model_version_id}, reply_to)
+            send_status(ws_app)
         else:
             print(f"File not found: {target_path}")
+            send_error(ws_app, 'delete', model_version_id, "File not found", reply_to)
             
     except Exception as e:
         print(f"Delete failed: {e}")
+        send_error(ws_app, 'delete', model_version_id, str(e), reply_to)
 
 
+def handle_sidecar(ws_app, data, reply_to=None):
+    filename = data.get('filename') # Relative path of the model file
+    info_url = data.get('url')
+    preview_url = data.get('thumbnail_url')
+    model_version_id = data.get('model_version_id')
+
+    try:
+        # Security check
+        if '..' in filename:
+             raise ValueError("Invalid path components")
+
+        target_path = os.path.abspath(os.path.join(ROOT_PATH, filename))
+        if not target_path.startswith(os.path.abspath(ROOT_PATH)):
+            raise ValueError("Path traversal attempt detected")
+        if not os.path.exists(target_path):
+            print(f"File not found: {target_path}")
+            send_error(ws_app, 'sidecar', model_version_id, "File not found", reply_to)
+            return
+
+        u = urlparse(SERVER_URL)
+        scheme = 'https' if u.scheme == 'wss' else 'http'
+        base_url = f"{scheme}://{u.netloc}"
+        base_path = os.path.splitext(target_path)[0]
+
+        # Write <model>.civitai.info and <model>.preview.png next to the model
+        for url, suffix in ((info_url, '.civitai.info'), (preview_url, '.preview.png')):
+            if not url:
+                continue
+            resp = requests.get(base_url + url)
+            resp.raise_for_status()
+            with open(base_path + suffix, 'wb') as f:
+                f.write(resp.content)
+            print(f"Wrote {base_path + suffix}")
+
+    except Exception as e:
+        print(f"Sidecar failed: {e}")
+        send_error(ws_app, 'sidecar', model_version_id, str(e), reply_to)
+
 def on_error(ws_app, error):
     print(f"WebSocket Error: {error}")
 
 def on_close(ws_app, close_status_code, close_msg):
     print("WebSocket Closed")
 
+def send_status(ws_app):
+    # Report who we are and how much space is left
+    try:
+        send_message(ws_app, 'status', {
+            "name": CLIENT_NAME,
+            "version": CLIENT_VERSION,
+            "root_path": os.path.abspath(ROOT_PATH),
+            "free_disk_bytes": shutil.disk_usage(ROOT_PATH).free
+        })
+    except Exception as e:
+        print(f"Status report failed: {e}")
+
+def file_sha256(path):
+    h = hashlib.sha256()
+    with open(path, 'rb') as f:
+        for chunk in iter(lambda: f.read(1024 * 1024), b''):
+            h.update(chunk)
+    return h.hexdigest()
+
+def send_inventory(ws_app, data=None, reply_to=None):
+    # Report every model file below ROOT_PATH so the server can reconcile
+    try:
+        files = []
+        root = os.path.abspath(ROOT_PATH)
+        for dirpath, _, filenames in os.walk(root):
+            for name in filenames:
+                if not name.lower().endswith(MODEL_EXTENSIONS):
+                    continue
+                path = os.path.join(dirpath, name)
+                entry = {
+                    "path": os.path.relpath(path, root).replace(os.sep, '/'),
+                    "size": os.path.getsize(path)
+                }
+                if INVENTORY_HASHES:
+                    entry["sha256"] = file_sha256(path)
+                files.append(entry)
+        send_message(ws_app, 'inventory', {"files": files}, reply_to)
+        print(f"Reported {len(files)} files")
+    except Exception as e:
+        print(f"Inventory report failed: {e}")
+
+COMMAND_HANDLERS = {
+    'download': handle_download,
+    'delete': handle_delete,
+    'sidecar': handle_sidecar,
+    'inventory': send_inventory,
+}
+
 def on_open(ws_app):
     print("WebSocket Connected")
+    send_message(ws_app, 'hello', {"protocol": PROTOCOL_VERSION, "capabilities": CAPABILITIES})
+    send_status(ws_app)
+    threading.Thread(target=send_inventory, args=(ws_app,), daemon=True).start()
 
 def run_websocket():
     global ws
@@ -232,7 +403,8 @@ def run_websocket():
                                       on_message=on_message,
                                       on_error=on_error,
                                       on_close=on_close)
-            ws.run_forever()
+            # Ping the server so a dead connection is noticed and replaced
+            ws.run_forever(ping_interval=HEARTBEAT_SECONDS, ping_timeout=10)
         except Exception as e:
             print(f"Connection failed: {e}")
         
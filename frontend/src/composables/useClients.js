@@ -0,0 +1,73 @@
+import axios from "axios";
+import { ref } from "vue";
+
+// The server's ADMIN_TOKEN, kept in this browser once entered. Admin routes
+// (approve, revoke, new token) need it unless the page is opened on the
+// server's own machine and no token is configured.
+const ADMIN_TOKEN_KEY = "adminToken";
+const adminToken = ref(localStorage.getItem(ADMIN_TOKEN_KEY) || "");
+
+const setAdminToken = (token) => {
+    adminToken.value = token.trim();
+    if (adminToken.value) localStorage.setItem(ADMIN_TOKEN_KEY, adminToken.value);
+    else localStorage.removeItem(ADMIN_TOKEN_KEY);
+};
+
+const adminHeaders = () =>
+    adminToken.value ? { Authorization: `Bearer ${adminToken.value}` } : {};
+
+// adminError explains a rejected admin request: 401 means the token is
+// missing or wrong, 403 that the server only trusts its own machine.
+const adminError = (e) => {
+    const status = e.response?.status;
+    if (status === 401) return "The server needs its admin token. Enter it above.";
+    if (status === 403) return "Only allowed from the server's machine. Set ADMIN_TOKEN on the server to manage clients from here.";
+    return e.response?.data?.error || e.message;
+};
+
+export function useClients() {
+    const clients = ref([]);
+    const loading = ref(false);
+
+    const fetchClients = async () => {
+        loading.value = true;
+        try {
+            const res = await axios.get("/api/clients");
+            clients.value = Array.isArray(res.data) ? res.data : [];
+        } finally {
+            loading.value = false;
+        }
+    };
+
+    const adminPost = (clientId, action) =>
+        axios.post(`/api/clients/${encodeURIComponent(clientId)}/${action}`, null, { headers: adminHeaders() });
+
+    const approveClient = async (clientId) => {
+        await adminPost(clientId, "approve");
+        await fetchClients();
+    };
+
+    const revokeClient = async (clientId) => {
+        await adminPost(clientId, "revoke");
+        await fetchClients();
+    };
+
+    // Returns the new token, which the server shows only this once.
+    const rotateToken = async (clientId) => {
+        const res = await adminPost(clientId, "token");
+        await fetchClients();
+        return res.data.token;
+    };
+
+    return {
+        clients,
+        loading,
+        fetchClients,
+        approveClient,
+        revokeClient,
+        rotateToken,
+        adminToken,
+        setAdminToken,
+        adminError,
+    };
+}
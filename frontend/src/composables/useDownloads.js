@@ -7,7 +7,7 @@ export function useDownloads() {
     const downloadProgress = ref(0);
     const canceling = ref(false);
     const cancelledByUser = ref(false);
-    let progressInterval = null;
+    let events = null;
 
     const startDownload = async (versionId, modelId) => {
         downloading.value = true;
@@ -15,17 +15,18 @@ export function useDownloads() {
         cancelledByUser.value = false;
         canceling.value = false;
 
-        // Start polling progress
-        progressInterval = setInterval(async () => {
+        // Follow progress for this version on the server event stream
+        events = new EventSource("/api/events");
+        events.addEventListener("download.progress", (e) => {
             try {
-                const res = await axios.get("/api/download/progress");
-                if (res.data && typeof res.data.progress === "number") {
-                    downloadProgress.value = Math.round(res.data.progress);
+                const { data } = JSON.parse(e.data);
+                if (data && data.versionId === Number(versionId)) {
+                    downloadProgress.value = Math.round(data.progress);
                 }
             } catch {
                 // ignore
             }
-        }, 1000);
+        });
 
         try {
             const buildSyncVersionUrl = (vid, { download, modelId } = {}) => {
@@ -47,7 +48,10 @@ export function useDownloads() {
                 showToast("Download failed", "danger");
             }
         } finally {
-            clearInterval(progressInterval);
+            if (events) {
+                events.close();
+                events = null;
+            }
             downloading.value = false;
             downloadProgress.value = 0;
         }
@@ -1,9 +1,71 @@
 import axios from "axios";
-import { ref } from "vue";
-
-export function useRemote() {
+import { computed, onUnmounted, reactive, ref, watch } from "vue";
+
+// Latest clientfile.progress per version ID. One event stream is shared by
+// every component showing a pending version and closed when none is left.
+const clientProgress = reactive({});
+let progressEvents = null;
+let progressFollowers = 0;
+
+const followClientProgress = () => {
+    progressFollowers++;
+    if (progressEvents) return;
+    progressEvents = new EventSource("/api/events");
+    progressEvents.addEventListener("clientfile.progress", (e) => {
+        try {
+            const { data } = JSON.parse(e.data);
+            if (data && data.modelVersionId) {
+                clientProgress[data.modelVersionId] = { bytesDone: data.bytesDone, bytesTotal: data.bytesTotal };
+            }
+        } catch {
+            // ignore malformed events
+        }
+    });
+    progressEvents.addEventListener("clientfile.status", (e) => {
+        try {
+            const { data } = JSON.parse(e.data);
+            if (data && data.status !== "pending") delete clientProgress[data.modelVersionId];
+        } catch {
+            // ignore malformed events
+        }
+    });
+};
+
+const unfollowClientProgress = () => {
+    progressFollowers--;
+    if (progressFollowers <= 0 && progressEvents) {
+        progressEvents.close();
+        progressEvents = null;
+        progressFollowers = 0;
+    }
+};
+
+// getVersion, if given, returns the version a component shows. While it is
+// pending on a client, syncProgress is its download progress in percent, or
+// null until the client reports any.
+export function useRemote(getVersion) {
     const isDispatching = ref(false);
 
+    let following = false;
+    if (getVersion) {
+        watch(() => getVersion()?.clientStatus === "pending", (pending) => {
+            if (pending && !following) followClientProgress();
+            else if (!pending && following) unfollowClientProgress();
+            following = pending;
+        }, { immediate: true });
+        onUnmounted(() => {
+            if (following) unfollowClientProgress();
+            following = false;
+        });
+    }
+
+    const syncProgress = computed(() => {
+        const version = getVersion ? getVersion() : null;
+        const p = version && version.clientStatus === "pending" ? clientProgress[version.ID] : null;
+        if (!p || !p.bytesTotal) return null;
+        return Math.min(100, Math.floor((p.bytesDone * 100) / p.bytesTotal));
+    });
+
     const dispatchAction = async (action, model, version, clientId) => {
         if (!clientId) {
             clientId = "My-Desktop-PC"; // Default
@@ -32,12 +94,15 @@ export function useRemote() {
                 client_id: clientId
             };
 
-            await axios.post("/api/remote/dispatch", payload);
+            const res = await axios.post("/api/remote/dispatch", payload);
+            // 202 means the client is offline and the command was queued.
+            const queued = res.status === 202;
 
             // Optimistic Update
             if (action === 'download') {
+                delete clientProgress[version.ID];
                 version.clientStatus = 'pending';
-                pollStatus(version);
+                if (!queued) pollStatus(version);
             } else if (action === 'delete') {
                 version.clientStatus = null;
                 // We could poll to confirm deletion, but null is fine for now
@@ -88,5 +153,5 @@ export function useRemote() {
     };
 
 
-    return { dispatchAction, isDispatching };
+    return { dispatchAction, isDispatching, syncProgress };
 }
@@ -5,11 +5,13 @@ import AppSettings from "./components/AppSettings.vue";
 import Utilities from "./components/UtilitiesPage.vue";
 import CollectionsPage from "./components/CollectionsPage.vue";
 import CollectionDetail from "./components/CollectionDetail.vue";
+import ClientsPage from "./components/ClientsPage.vue";
 
 const routes = [
   { path: "/", component: ModelList },
   { path: "/settings", component: AppSettings },
   { path: "/utilities", component: Utilities },
+  { path: "/clients", component: ClientsPage },
   { path: "/collections", component: CollectionsPage },
   { path: "/collections/:id", name: "CollectionDetail", component: CollectionDetail },
   {
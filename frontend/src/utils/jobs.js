@@ -0,0 +1,35 @@
+import axios from "axios";
+
+/**
+ * Resolve with the finished job once the background job with the given ID
+ * leaves the "running" state. Listens on the server event stream and checks
+ * the job once after subscribing in case it finished first.
+ */
+export function waitForJob(jobId) {
+  return new Promise((resolve, reject) => {
+    const events = new EventSource("/api/events");
+    const done = (job) => {
+      events.close();
+      resolve(job);
+    };
+
+    events.addEventListener("job.finished", (e) => {
+      try {
+        const { data } = JSON.parse(e.data);
+        if (data && data.ID === jobId) done(data);
+      } catch {
+        // ignore malformed events
+      }
+    });
+
+    events.onopen = async () => {
+      try {
+        const res = await axios.get(`/api/jobs/${jobId}`);
+        if (res.data.status !== "running") done(res.data);
+      } catch (err) {
+        events.close();
+        reject(err);
+      }
+    };
+  });
+}